@@ -1,84 +1,97 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"sort"
+	"strconv"
+	"time"
+	"ustack/internal/config"
 	"ustack/internal/utils"
-	"ustack/pkg/tcp"
+	"ustack/pkg/http"
 )
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: ustack-client <host> <port>")
-		fmt.Println("Example: ustack-client localhost 8080")
-		os.Exit(1)
+	cfg := config.Default()
+	cfg.MAC = "02:00:00:00:00:02"
+	cfg.RegisterFlags(flag.CommandLine)
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout for the whole request")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ustack-client [options] <host> <port> [path]")
+		fmt.Fprintln(os.Stderr, "Example: ustack-client -link tap -iface tap0 -addr 192.168.100.3/24 192.168.100.2 8080 /health")
+		fmt.Fprintln(os.Stderr, "         ustack-client -link tap -iface tap0 -addr dhcp example.com 80 /")
+		flag.PrintDefaults()
+	}
+	if err := cfg.Parse(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ustack-client: %v\n", err)
+		os.Exit(2)
 	}
 
-	host := os.Args[1]
-	port := os.Args[2]
-
-	logger := utils.DefaultLogger
-	logger.Info("Starting ustack HTTP client...")
-	logger.Info("Connecting to %s:%s", host, port)
+	if flag.NArg() < 2 || flag.NArg() > 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	// 解析目标地址
-	remoteIP := net.ParseIP(host)
-	if remoteIP == nil {
-		logger.Error("Invalid host address: %s", host)
+	path := "/"
+	if flag.NArg() > 2 {
+		path = flag.Arg(2)
+	}
+	if err := run(&cfg, flag.Arg(0), flag.Arg(1), path, *timeout); err != nil {
+		utils.DefaultLogger.Error("%v", err)
 		os.Exit(1)
 	}
+}
 
-	var remoteIPBytes [4]byte
-	copy(remoteIPBytes[:], remoteIP.To4())
-
-	// 创建本地IP（简化处理）
-	var localIP [4]byte
-	copy(localIP[:], net.ParseIP("127.0.0.1").To4())
-
-	// 创建TCP连接
-	conn := tcp.NewConnection(localIP, 12345, remoteIPBytes, 8080)
-
-	// 设置回调函数
-	conn.OnStateChanged = func(state string) {
-		logger.Info("Connection state changed: %s", state)
-	}
+// run 打开协议栈，向host:port请求path并把响应打印到标准输出
+//
+// 错误在main中报告并退出，此前run中延迟的Close都已执行。
+func run(cfg *config.Config, host, port, path string, timeout time.Duration) error {
+	logger := utils.DefaultLogger
 
-	conn.OnDataReceived = func(data []byte) {
-		logger.Info("Received data: %d bytes", len(data))
-		fmt.Printf("Response:\n%s\n", string(data))
+	remotePort, err := strconv.Atoi(port)
+	if err != nil || remotePort <= 0 || remotePort > 65535 {
+		return fmt.Errorf("invalid port: %s", port)
 	}
 
-	// 建立连接
-	err := conn.Connect()
+	n, err := cfg.Open(logger)
 	if err != nil {
-		logger.Error("Failed to connect: %v", err)
-		os.Exit(1)
+		return err
 	}
+	defer n.Close()
+	logger.Info("Starting ustack HTTP client on %s (%s link)...", net.IP(n.LocalIP[:]), cfg.Link)
 
-	logger.Info("Connection established successfully")
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(host, strconv.Itoa(remotePort)), path)
+	logger.Info("GET %s", url)
 
-	// 发送HTTP GET请求
-	httpRequest := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s:%s\r\nConnection: close\r\n\r\n", host, port)
-
-	err = conn.Send([]byte(httpRequest))
+	// 主机名由DNS解析，localhost解析为127.0.0.1
+	client := &http.Client{Stack: n.Stack, Timeout: timeout, Control: cfg.ControlTCP, Resolver: n.Resolver}
+	resp, err := client.Get(url)
 	if err != nil {
-		logger.Error("Failed to send HTTP request: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("request failed: %w", err)
 	}
+	defer resp.Body.Close()
 
-	logger.Info("HTTP request sent")
-
-	// 等待响应（简化处理）
-	fmt.Println("Press Enter to close connection...")
-	bufio.NewReader(os.Stdin).ReadString('\n')
+	// 打印状态行、头部和消息体，读完消息体即退出
+	fmt.Printf("%s %s\n", resp.Proto, resp.Status)
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range resp.Header[k] {
+			fmt.Printf("%s: %s\n", k, v)
+		}
+	}
+	fmt.Println()
 
-	// 关闭连接
-	err = conn.Close()
+	written, err := io.Copy(os.Stdout, resp.Body)
 	if err != nil {
-		logger.Error("Failed to close connection: %v", err)
+		return fmt.Errorf("failed to read response body: %w", err)
 	}
-
-	logger.Info("Connection closed")
+	logger.Info("Response complete: %d bytes", written)
+	return nil
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/ping"
+	"ustack/pkg/stack"
+)
+
+func main() {
+	count := flag.Int("c", 0, "stop after sending count requests (0 = until interrupted)")
+	interval := flag.Float64("i", 1, "seconds between requests")
+	size := flag.Int("s", ping.DefaultSize, "number of data bytes to send")
+	ttl := flag.Int("t", 0, "IP time to live (0 = default)")
+	timeout := flag.Float64("W", ping.DefaultTimeout.Seconds(), "seconds to wait for replies after the last request")
+	tap := flag.String("tap", "", "TAP device to use (default: in-process pipe to a simulated host)")
+	addr := flag.String("addr", "10.0.0.1/24", "local address and prefix length")
+	gateway := flag.String("gw", "", "default gateway")
+	mac := flag.String("mac", "02:00:00:00:00:01", "local MAC address")
+	verbose := flag.Bool("v", false, "verbose stack logging")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ustack-ping [options] <destination>")
+		fmt.Fprintln(os.Stderr, "Example: ustack-ping -tap tap0 -addr 192.168.100.2/24 -c 4 192.168.100.1")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *size < 0 || *size > ping.MaxPayloadLength {
+		fatalf("invalid packet size: %d", *size)
+	}
+	if *ttl < 0 || *ttl > 255 {
+		fatalf("invalid TTL: %d", *ttl)
+	}
+
+	dst, err := parseIPv4(flag.Arg(0))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	localIP, prefix, err := parseCIDR(*addr)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	hw, err := net.ParseMAC(*mac)
+	if err != nil || len(hw) != 6 {
+		fatalf("invalid MAC address: %s", *mac)
+	}
+	var localMAC [6]byte
+	copy(localMAC[:], hw)
+
+	logger := utils.NewLogger(utils.WARN)
+	if *verbose {
+		logger = utils.NewLogger(utils.DEBUG)
+	}
+
+	// 创建协议栈
+	s := stack.New()
+	s.SetLogger(logger)
+
+	var ep link.Endpoint
+	if *tap != "" {
+		ep, err = link.NewTAP(*tap, localMAC, link.DefaultMTU)
+		if err != nil {
+			fatalf("failed to open TAP device: %v", err)
+		}
+	} else {
+		ep, err = newSimulatedHost(logger, localMAC, dst, prefix, localIP)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	defer s.Close()
+
+	if err := s.AddNIC(1, ep); err != nil {
+		fatalf("failed to create NIC: %v", err)
+	}
+	if err := s.AddAddress(1, localIP, prefix); err != nil {
+		fatalf("failed to add address: %v", err)
+	}
+	if *gateway != "" {
+		gw, err := parseIPv4(*gateway)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		s.AddRoute(stack.Route{Gateway: gw, NIC: 1})
+	}
+
+	p := ping.NewPinger(s)
+	p.Count = *count
+	p.Interval = time.Duration(*interval * float64(time.Second))
+	p.Size = *size
+	p.TTL = uint8(*ttl)
+	p.Timeout = time.Duration(*timeout * float64(time.Second))
+	p.OnReply = printReply
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	fmt.Printf("PING %s (%s) %d(%d) bytes of data.\n", net.IP(dst[:]), net.IP(dst[:]),
+		*size, *size+8+ip.IPHeaderLength)
+
+	st, err := p.Run(ctx, dst)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	fmt.Println()
+	fmt.Println(st)
+
+	if st.Received == 0 {
+		os.Exit(1)
+	}
+}
+
+// printReply 按iputils ping的格式输出一条回复
+func printReply(r *ping.Reply) {
+	from := net.IP(r.From[:])
+	if r.Err != nil {
+		fmt.Printf("From %s icmp_seq=%d %s\n", from, r.Sequence, r.ErrorString())
+		return
+	}
+
+	line := fmt.Sprintf("%d bytes from %s: icmp_seq=%d ttl=%d time=%.3f ms",
+		r.Size, from, r.Sequence, r.TTL, float64(r.RTT)/float64(time.Millisecond))
+	if r.Duplicate {
+		line += " (DUP!)"
+	}
+	fmt.Println(line)
+}
+
+// newSimulatedHost 创建管道链路，另一端是配置了目标地址的进程内协议栈
+func newSimulatedHost(logger *utils.Logger, localMAC [6]byte, dst [4]byte, prefix int, localIP [4]byte) (link.Endpoint, error) {
+	local := stack.Address{IP: localIP, PrefixLength: prefix}
+	if !local.Contains(dst) || dst == localIP {
+		return nil, fmt.Errorf("without -tap the destination must be another host in %s", local)
+	}
+
+	peerMAC := localMAC
+	peerMAC[5] ^= 0xff
+	a, b := link.NewPipe(localMAC, peerMAC, link.DefaultMTU)
+
+	peer := stack.New()
+	peer.SetLogger(logger)
+	if err := peer.AddNIC(1, b); err != nil {
+		return nil, err
+	}
+	if err := peer.AddAddress(1, dst, prefix); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// parseIPv4 解析IPv4地址
+func parseIPv4(s string) ([4]byte, error) {
+	var addr [4]byte
+	parsed := net.ParseIP(s).To4()
+	if parsed == nil {
+		return addr, fmt.Errorf("invalid IPv4 address: %s", s)
+	}
+	copy(addr[:], parsed)
+	return addr, nil
+}
+
+// parseCIDR 解析带前缀长度的IPv4地址
+func parseCIDR(s string) ([4]byte, int, error) {
+	var addr [4]byte
+	parsed, network, err := net.ParseCIDR(s)
+	if err != nil || parsed.To4() == nil {
+		return addr, 0, fmt.Errorf("invalid address: %s", s)
+	}
+	copy(addr[:], parsed.To4())
+	prefix, _ := network.Mask.Size()
+	return addr, prefix, nil
+}
+
+func fatalf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ustack-ping: "+format+"\n", v...)
+	os.Exit(2)
+}
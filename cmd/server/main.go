@@ -1,89 +1,160 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strconv"
+	"strings"
+	"ustack/internal/config"
 	"ustack/internal/utils"
-	"ustack/pkg/tcp"
+	"ustack/pkg/dns"
+	"ustack/pkg/gonet"
+	"ustack/pkg/http"
+	"ustack/pkg/stack"
 )
 
+// indexPage 首页内容
+const indexPage = `<!DOCTYPE html>
+<html>
+<head><title>ustack HTTP Server</title></head>
+<body>
+<h1>Hello from ustack!</h1>
+<p>This is a response from the user-space TCP/IP stack.</p>
+</body>
+</html>
+`
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: ustack-server <port>")
-		fmt.Println("Example: ustack-server 8080")
-		os.Exit(1)
+	cfg := config.Default()
+	cfg.RegisterFlags(flag.CommandLine)
+	root := flag.String("root", "", "serve static files from this directory instead of the demo page")
+	zoneFiles := flag.String("zones", "", "comma-separated zone files to serve as an authoritative DNS server on port 53")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ustack-server [options] <port>")
+		fmt.Fprintln(os.Stderr, "Example: ustack-server -link tap -iface tap0 -addr 192.168.100.2/24 -root ./www 8080")
+		flag.PrintDefaults()
 	}
-
-	portStr := os.Args[1]
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		fmt.Printf("Invalid port number: %s\n", portStr)
-		os.Exit(1)
+	if err := cfg.Parse(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "ustack-server: %v\n", err)
+		os.Exit(2)
 	}
 
-	logger := utils.DefaultLogger
-	logger.Info("Starting ustack HTTP server on port %d...", port)
-
-	// 创建本地IP
-	var localIP [4]byte
-	copy(localIP[:], net.ParseIP("127.0.0.1").To4())
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	// 创建TCP连接（监听模式）
-	conn := tcp.NewConnection(localIP, uint16(port), [4]byte{}, 0)
+	if err := run(&cfg, flag.Arg(0), *root, *zoneFiles); err != nil {
+		utils.DefaultLogger.Error("%v", err)
+		os.Exit(1)
+	}
+}
 
-	// 设置回调函数
-	conn.OnStateChanged = func(state string) {
-		logger.Info("Server state changed: %s", state)
+// run 打开协议栈并运行HTTP服务器（以及可选的权威DNS服务器），直到出错
+//
+// 错误在main中报告并退出，此前run中延迟的Close都已执行。
+func run(cfg *config.Config, portStr, root, zoneFiles string) error {
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port number: %s", portStr)
 	}
 
-	conn.OnDataReceived = func(data []byte) {
-		logger.Info("Received HTTP request: %d bytes", len(data))
+	if root != "" {
+		if fi, err := os.Stat(root); err != nil || !fi.IsDir() {
+			return fmt.Errorf("invalid root directory: %s", root)
+		}
+	}
 
-		// 解析HTTP请求
-		request := string(data)
-		logger.Info("HTTP Request:\n%s", request)
+	var zones []*dns.Zone
+	if zoneFiles != "" {
+		for _, path := range strings.Split(zoneFiles, ",") {
+			z, err := dns.LoadZone(path, "")
+			if err != nil {
+				return fmt.Errorf("invalid zone file: %w", err)
+			}
+			zones = append(zones, z)
+		}
+	}
 
-		// 生成HTTP响应
-		response := generateHTTPResponse()
+	logger := utils.DefaultLogger
+	n, err := cfg.Open(logger)
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+	logger.Info("Starting ustack HTTP server on %s:%d (%s link)...", net.IP(n.LocalIP[:]), port, cfg.Link)
 
-		// 发送响应
-		err := conn.Send([]byte(response))
-		if err != nil {
-			logger.Error("Failed to send HTTP response: %v", err)
-		} else {
-			logger.Info("HTTP response sent: %d bytes", len(response))
+	if len(zones) > 0 {
+		dnsServer := dns.NewServer(n.Stack)
+		for _, z := range zones {
+			dnsServer.AddZone(z)
 		}
+		if err := dnsServer.Start(stack.FullAddress{}); err != nil {
+			return fmt.Errorf("failed to start DNS server: %w", err)
+		}
+		defer dnsServer.Close()
 	}
 
-	// 开始监听
-	err = conn.Listen()
+	lc := &gonet.ListenConfig{Control: cfg.ControlTCP}
+	l, err := lc.ListenTCP(n.Stack, stack.FullAddress{Port: uint16(port)})
 	if err != nil {
-		logger.Error("Failed to start listening: %v", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to listen: %w", err)
 	}
 
+	srv := &http.Server{Handler: newMux(logger, root), Logger: logger}
 	logger.Info("Server is listening on port %d", port)
 	logger.Info("Press Ctrl+C to stop the server")
-
-	// 保持服务器运行（简化处理）
-	select {}
+	if err := srv.Serve(l); err != nil {
+		return fmt.Errorf("server stopped: %w", err)
+	}
+	return nil
 }
 
-// generateHTTPResponse 生成HTTP响应
-func generateHTTPResponse() string {
-	return `HTTP/1.1 200 OK
-Content-Type: text/html; charset=utf-8
-Content-Length: 89
-Connection: close
+// newMux 注册演示页面（或root目录下的静态文件）、健康检查和回显接口
+func newMux(logger *utils.Logger, root string) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	if root != "" {
+		logger.Info("Serving files from %s", root)
+		files := http.FileServer(root)
+		mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+			logger.Info("%s %s from %s", r.Method, r.Target, r.RemoteAddr)
+			files.ServeHTTP(w, r)
+		})
+	} else {
+		mux.HandleFunc("GET /", serveIndex(logger))
+	}
 
-<!DOCTYPE html>
-<html>
-<head><title>ustack HTTP Server</title></head>
-<body>
-<h1>Hello from ustack!</h1>
-<p>This is a response from the user-space TCP/IP stack.</p>
-</body>
-</html>`
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, "ok\n")
+	})
+
+	mux.HandleFunc("POST /echo", func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("%s %s from %s", r.Method, r.Target, r.RemoteAddr)
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		if _, err := io.Copy(w, r.Body); err != nil {
+			logger.Warn("Failed to echo request body: %v", err)
+		}
+	})
+
+	return mux
+}
+
+// serveIndex 返回演示页面
+func serveIndex(logger *utils.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("%s %s from %s", r.Method, r.Target, r.RemoteAddr)
+		if r.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, indexPage)
+	}
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/traceroute"
+)
+
+func main() {
+	useICMP := flag.Bool("I", false, "use ICMP Echo probes instead of UDP")
+	firstTTL := flag.Int("f", 1, "first TTL")
+	maxHops := flag.Int("m", traceroute.DefaultMaxHops, "maximum number of hops")
+	probes := flag.Int("q", traceroute.DefaultProbes, "number of probes per hop")
+	wait := flag.Float64("w", traceroute.DefaultTimeout.Seconds(), "seconds to wait for each probe")
+	port := flag.Int("p", traceroute.DefaultPort, "base destination port for UDP probes")
+	tap := flag.String("tap", "", "TAP device to use (default: in-process chain of simulated routers)")
+	routers := flag.Int("routers", 3, "number of simulated routers when no TAP device is given")
+	addr := flag.String("addr", "10.0.0.1/24", "local address and prefix length")
+	gateway := flag.String("gw", "", "default gateway (required with -tap)")
+	mac := flag.String("mac", "02:00:00:00:00:01", "local MAC address")
+	verbose := flag.Bool("v", false, "verbose stack logging")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ustack-traceroute [options] <destination>")
+		fmt.Fprintln(os.Stderr, "Example: ustack-traceroute -routers 4 192.168.50.2")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *port <= 0 || *port > 65535 {
+		fatalf("invalid port: %d", *port)
+	}
+
+	dst, err := parseIPv4(flag.Arg(0))
+	if err != nil {
+		fatalf("%v", err)
+	}
+	localIP, prefix, err := parseCIDR(*addr)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	hw, err := net.ParseMAC(*mac)
+	if err != nil || len(hw) != 6 {
+		fatalf("invalid MAC address: %s", *mac)
+	}
+	var localMAC [6]byte
+	copy(localMAC[:], hw)
+
+	logger := utils.NewLogger(utils.WARN)
+	if *verbose {
+		logger = utils.NewLogger(utils.DEBUG)
+	}
+
+	s := stack.New()
+	s.SetLogger(logger)
+
+	var ep link.Endpoint
+	var gw [4]byte
+	if *tap != "" {
+		ep, err = link.NewTAP(*tap, localMAC, link.DefaultMTU)
+		if err != nil {
+			fatalf("failed to open TAP device: %v", err)
+		}
+	} else {
+		local := stack.Address{IP: localIP, PrefixLength: prefix}
+		ep, gw, err = simulatedPath(logger, local, localMAC, dst, *routers)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	}
+	defer s.Close()
+
+	if err := s.AddNIC(1, ep); err != nil {
+		fatalf("failed to create NIC: %v", err)
+	}
+	if err := s.AddAddress(1, localIP, prefix); err != nil {
+		fatalf("failed to add address: %v", err)
+	}
+	if *gateway != "" {
+		if gw, err = parseIPv4(*gateway); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if gw != [4]byte{} {
+		s.AddRoute(stack.Route{Gateway: gw, NIC: 1})
+	}
+
+	t := traceroute.NewTracer(s)
+	if *useICMP {
+		t.Method = traceroute.MethodICMP
+	}
+	t.FirstTTL = *firstTTL
+	t.MaxHops = *maxHops
+	t.Probes = *probes
+	t.Timeout = time.Duration(*wait * float64(time.Second))
+	t.Port = uint16(*port)
+	t.OnHop = func(h *traceroute.Hop) {
+		fmt.Println(h)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	// 探测报文长度：IP头部 + UDP/ICMP头部 + 32字节数据
+	fmt.Printf("traceroute to %s (%s), %d hops max, %d byte packets\n",
+		net.IP(dst[:]), net.IP(dst[:]), *maxHops, ip.IPHeaderLength+8+32)
+
+	if _, err := t.Run(ctx, dst); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// parseIPv4 解析IPv4地址
+func parseIPv4(s string) ([4]byte, error) {
+	var addr [4]byte
+	parsed := net.ParseIP(s).To4()
+	if parsed == nil {
+		return addr, fmt.Errorf("invalid IPv4 address: %s", s)
+	}
+	copy(addr[:], parsed)
+	return addr, nil
+}
+
+// parseCIDR 解析带前缀长度的IPv4地址
+func parseCIDR(s string) ([4]byte, int, error) {
+	var addr [4]byte
+	parsed, network, err := net.ParseCIDR(s)
+	if err != nil || parsed.To4() == nil {
+		return addr, 0, fmt.Errorf("invalid address: %s", s)
+	}
+	copy(addr[:], parsed.To4())
+	prefix, _ := network.Mask.Size()
+	return addr, prefix, nil
+}
+
+func fatalf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ustack-traceroute: "+format+"\n", v...)
+	os.Exit(2)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"ustack/internal/utils"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+)
+
+// subnet 模拟拓扑中的一个/24网段
+type subnet [4]byte
+
+// host 返回网段内第n个地址
+func (s subnet) host(n byte) [4]byte {
+	a := s
+	a[3] = n
+	return a
+}
+
+// simulatedPath 创建一条经过hops个路由器到达dst的进程内路径，返回本机一端的链路
+//
+// 本机所在网段为local，路由器之间使用172.16.i.0/24，最后一个网段为dst所在的/24。
+// 每台路由器有两块网卡，左侧地址为.254，右侧地址为.1，各自配置静态路由。
+func simulatedPath(logger *utils.Logger, local stack.Address, localMAC [6]byte, dst [4]byte, hops int) (link.Endpoint, [4]byte, error) {
+	if hops < 1 || hops > 200 {
+		return nil, [4]byte{}, fmt.Errorf("invalid number of simulated routers: %d", hops)
+	}
+	if local.PrefixLength != 24 || local.IP[3] == 254 || dst[3] <= 1 || dst[3] == 255 {
+		return nil, [4]byte{}, fmt.Errorf("simulation needs a /24 local address other than .254 and a destination host above .1")
+	}
+	if local.Contains(dst) || (dst[0] == 172 && dst[1] == 16) {
+		return nil, [4]byte{}, fmt.Errorf("destination %s must be outside the local and 172.16.0.0/16 networks", net.IP(dst[:]))
+	}
+
+	// 网段0为本机网段，网段hops为目标网段
+	subnets := make([]subnet, hops+1)
+	copy(subnets[0][:], local.IP[:])
+	subnets[0][3] = 0
+	for i := 1; i < hops; i++ {
+		subnets[i] = subnet{172, 16, byte(i), 0}
+	}
+	subnets[hops] = subnet{dst[0], dst[1], dst[2], 0}
+
+	newMAC := func(router, nic int) [6]byte {
+		var mac [6]byte
+		mac[0] = 0x02
+		binary.BigEndian.PutUint16(mac[2:4], uint16(router))
+		mac[5] = byte(nic)
+		return mac
+	}
+
+	newStack := func(nics map[int]link.Endpoint, addrs map[int][4]byte) (*stack.Stack, error) {
+		s := stack.New()
+		s.SetLogger(logger)
+		for id := 1; id <= len(nics); id++ {
+			if err := s.AddNIC(id, nics[id]); err != nil {
+				return nil, err
+			}
+			if err := s.AddAddress(id, addrs[id], 24); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+
+	// 本机到第一台路由器
+	localEnd, left := link.NewPipe(localMAC, newMAC(1, 1), link.DefaultMTU)
+	for i := 1; i <= hops; i++ {
+		right, next := link.NewPipe(newMAC(i, 2), newMAC(i+1, 1), link.DefaultMTU)
+
+		r, err := newStack(
+			map[int]link.Endpoint{1: left, 2: right},
+			map[int][4]byte{1: subnets[i-1].host(254), 2: subnets[i].host(1)},
+		)
+		if err != nil {
+			return nil, [4]byte{}, err
+		}
+		r.SetForwarding(true)
+
+		// 返回方向：更早的网段经上一台路由器
+		for j := 0; j < i-1; j++ {
+			r.AddRoute(stack.Route{Destination: subnets[j], PrefixLength: 24, Gateway: subnets[i-1].host(1), NIC: 1})
+		}
+		// 前进方向：默认路由经下一台路由器
+		if i < hops {
+			r.AddRoute(stack.Route{Gateway: subnets[i].host(254), NIC: 2})
+		}
+
+		left = next
+	}
+
+	// 目标主机
+	target, err := newStack(map[int]link.Endpoint{1: left}, map[int][4]byte{1: dst})
+	if err != nil {
+		return nil, [4]byte{}, err
+	}
+	target.AddRoute(stack.Route{Gateway: subnets[hops].host(1), NIC: 1})
+
+	return localEnd, subnets[0].host(254), nil
+}
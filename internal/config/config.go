@@ -0,0 +1,502 @@
+// Package config 命令行工具共用的协议栈配置
+//
+// 配置可以来自YAML或JSON文件（-config），命令行参数覆盖文件中的值。例如：
+//
+//	link: tap
+//	interface: tap0
+//	address: 192.168.100.2/24
+//	gateway: 192.168.100.1
+//	dns: [192.168.100.1]
+//	mtu: 1500
+//	log_level: warn
+//	tcp:
+//	  congestion: cubic
+//	  receive_window: 65535
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/dhcp"
+	"ustack/pkg/dns"
+	"ustack/pkg/link"
+	"ustack/pkg/ndp"
+	"ustack/pkg/pcap"
+	"ustack/pkg/sniff"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 链路类型
+const (
+	LinkLoopback = "loopback" // 环回链路，只能访问本机地址
+	LinkPipe     = "pipe"     // 进程内管道，另一端是配置了Peer地址的模拟主机
+	LinkTAP      = "tap"      // Linux TAP设备，Interface为设备名
+	LinkPcap     = "pcap"     // 回放抓包文件，Interface为文件路径
+)
+
+const (
+	// AddressDHCP 作为Address时通过DHCP获取地址、默认路由和DNS服务器
+	AddressDHCP = "dhcp"
+
+	// 启动时等待DHCP租约的时间
+	dhcpTimeout = 30 * time.Second
+)
+
+// Config 协议栈和TCP的配置
+type Config struct {
+	File string `json:"-" yaml:"-"` // 配置文件路径
+
+	Link      string   `json:"link" yaml:"link"`
+	Interface string   `json:"interface" yaml:"interface"`
+	Address   string   `json:"address" yaml:"address"` // 本地地址/前缀长度，或AddressDHCP
+	Gateway   string   `json:"gateway" yaml:"gateway"`
+	Peer      string   `json:"peer" yaml:"peer"` // pipe链路另一端模拟主机的地址，可带前缀长度
+	DNS       []string `json:"dns" yaml:"dns"`   // DNS服务器，"IPv4地址"或"IPv4地址:端口"；为空时使用DHCP获得的服务器
+	MAC       string   `json:"mac" yaml:"mac"`
+	MTU       int      `json:"mtu" yaml:"mtu"`
+	LogLevel  string   `json:"log_level" yaml:"log_level"`
+	Capture   string   `json:"capture" yaml:"capture"` // 记录收发帧的抓包文件，扩展名为.pcapng时使用pcapng格式
+	IPv6      bool     `json:"ipv6" yaml:"ipv6"`       // 启用IPv6邻居发现和无状态地址自动配置
+
+	Sniff       bool   `json:"sniff" yaml:"sniff"`               // 在日志中逐帧记录解码后的摘要
+	SniffFilter string `json:"sniff_filter" yaml:"sniff_filter"` // 只记录匹配的帧，tcpdump风格表达式，非空时隐含Sniff
+
+	TCP TCPConfig `json:"tcp" yaml:"tcp"`
+}
+
+// TCPConfig 新建TCP连接使用的选项
+type TCPConfig struct {
+	Congestion    string `json:"congestion" yaml:"congestion"`         // 拥塞控制算法
+	ReceiveWindow int    `json:"receive_window" yaml:"receive_window"` // 接收窗口，0表示默认值
+	MTUProbing    bool   `json:"mtu_probing" yaml:"mtu_probing"`       // 分组层路径MTU探测
+}
+
+// Default 返回默认配置：环回链路上的127.0.0.1/8
+func Default() Config {
+	return Config{
+		Link:     LinkLoopback,
+		Address:  "127.0.0.1/8",
+		MAC:      "02:00:00:00:00:01",
+		MTU:      link.DefaultMTU,
+		LogLevel: "info",
+		TCP: TCPConfig{
+			Congestion: tcp.CongestionReno,
+			MTUProbing: true,
+		},
+	}
+}
+
+// RegisterFlags 在fs上注册配置对应的命令行参数，参数默认值为c中的当前值
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.File, "config", c.File, "load settings from a YAML or JSON file (flags override it)")
+	fs.StringVar(&c.Link, "link", c.Link, "link type: loopback, pipe, tap or pcap")
+	fs.StringVar(&c.Interface, "iface", c.Interface, "TAP device name (tap) or capture file to replay (pcap)")
+	fs.StringVar(&c.Address, "addr", c.Address, "local address and prefix length, or \"dhcp\"")
+	fs.StringVar(&c.Gateway, "gw", c.Gateway, "default gateway")
+	fs.StringVar(&c.Peer, "peer", c.Peer, "address of the simulated host at the other end of the pipe link (with prefix length when -addr is dhcp)")
+	fs.Func("dns", "comma-separated DNS servers, each IPv4 or IPv4:port (default from DHCP)", func(v string) error {
+		c.DNS = nil
+		for _, server := range strings.Split(v, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				c.DNS = append(c.DNS, server)
+			}
+		}
+		return nil
+	})
+	fs.StringVar(&c.MAC, "mac", c.MAC, "local MAC address")
+	fs.IntVar(&c.MTU, "mtu", c.MTU, "link MTU")
+	fs.StringVar(&c.LogLevel, "log", c.LogLevel, "log level: debug, info, warn or error")
+	fs.BoolVar(&c.IPv6, "ipv6", c.IPv6, "enable IPv6 neighbor discovery and stateless address autoconfiguration")
+	fs.StringVar(&c.Capture, "capture", c.Capture, "record every frame sent and received to a pcap or pcapng (by extension) file")
+	fs.BoolVar(&c.Sniff, "sniff", c.Sniff, "log a one-line summary of every frame sent and received")
+	fs.StringVar(&c.SniffFilter, "sniff-filter", c.SniffFilter, "only log frames matching a tcpdump-style filter, e.g. \"tcp port 80\" (implies -sniff)")
+	fs.StringVar(&c.TCP.Congestion, "cc", c.TCP.Congestion, "TCP congestion control: reno or cubic")
+	fs.IntVar(&c.TCP.ReceiveWindow, "rcvwnd", c.TCP.ReceiveWindow, "TCP receive window in bytes (0 = default)")
+	fs.BoolVar(&c.TCP.MTUProbing, "mtu-probing", c.TCP.MTUProbing, "TCP packetization layer path MTU probing")
+}
+
+// Parse 解析命令行参数；指定了配置文件时先加载文件，再以命令行中出现的参数覆盖
+func (c *Config) Parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if c.File != "" {
+		if err := c.Load(c.File); err != nil {
+			return err
+		}
+		// 再次解析，使显式给出的参数优先于文件
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+	}
+	return c.Validate()
+}
+
+// Load 从文件加载配置，扩展名为.json时按JSON解析，否则按YAML解析；文件中没有的字段保持原值
+func (c *Config) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(c); err != nil && len(bytes.TrimSpace(data)) == 0 {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate 检查配置是否有效
+func (c *Config) Validate() error {
+	switch c.Link {
+	case LinkLoopback:
+	case LinkPipe:
+		if c.Peer == "" {
+			return fmt.Errorf("pipe link requires a peer address")
+		}
+	case LinkTAP, LinkPcap:
+		if c.Interface == "" {
+			return fmt.Errorf("%s link requires an interface", c.Link)
+		}
+	default:
+		return fmt.Errorf("unknown link type %q", c.Link)
+	}
+
+	if c.Address == AddressDHCP {
+		if c.Link != LinkTAP && c.Link != LinkPipe {
+			return fmt.Errorf("DHCP requires a tap or pipe link")
+		}
+	} else if _, _, err := parseCIDR(c.Address); err != nil {
+		return err
+	}
+	if c.Gateway != "" {
+		if _, err := parseIPv4(c.Gateway); err != nil {
+			return err
+		}
+	}
+	if c.Link == LinkPipe {
+		if _, err := c.peer(); err != nil {
+			return err
+		}
+	}
+	if _, err := c.dnsServers(); err != nil {
+		return err
+	}
+	if _, err := c.hardwareAddr(); err != nil {
+		return err
+	}
+	if c.MTU < 68 || c.MTU > 65535 {
+		return fmt.Errorf("invalid MTU %d", c.MTU)
+	}
+	if _, err := utils.ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if _, err := sniff.ParseFilter(c.SniffFilter); err != nil {
+		return err
+	}
+	if !tcp.ValidCongestionControl(c.TCP.Congestion) {
+		return fmt.Errorf("unknown congestion control algorithm %q", c.TCP.Congestion)
+	}
+	if c.TCP.ReceiveWindow < 0 || c.TCP.ReceiveWindow > tcp.DefaultWindowSize {
+		return fmt.Errorf("invalid TCP receive window %d", c.TCP.ReceiveWindow)
+	}
+	return nil
+}
+
+// ControlTCP 将TCP选项应用到连接，可用作gonet.Dialer和gonet.ListenConfig的Control
+func (c *Config) ControlTCP(ep *tcp.Connection) {
+	ep.Congestion = c.TCP.Congestion
+	ep.ReceiveWindow = c.TCP.ReceiveWindow
+	ep.MTUProbing = c.TCP.MTUProbing
+}
+
+// Network 按配置创建的协议栈
+type Network struct {
+	Stack   *stack.Stack
+	Link    link.Endpoint
+	LocalIP [4]byte
+
+	// Peer pipe链路另一端的模拟主机，其他链路类型为nil
+	Peer *stack.Stack
+
+	// DHCP 使用DHCP时在后台续租的客户端，否则为nil
+	DHCP *dhcp.Client
+	// PeerDHCP pipe链路使用DHCP时模拟主机上的DHCP服务器
+	PeerDHCP *dhcp.Server
+
+	// Resolver 查询配置的DNS服务器，未配置时查询DHCP获得的服务器
+	Resolver *dns.Resolver
+
+	stopDHCP context.CancelFunc
+}
+
+// Open 设置日志级别，创建链路和协议栈并配置地址与默认路由；pcap链路在配置完成后开始回放
+//
+// 地址为AddressDHCP时等待获得租约后返回，并在后台续租直到Close；pipe链路的模拟主机此时运行DHCP服务器，
+// 分配其所在子网的其他地址并以自身为网关。
+func (c *Config) Open(logger *utils.Logger) (*Network, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	level, _ := utils.ParseLevel(c.LogLevel)
+	logger.SetLevel(level)
+
+	localIP, prefix, _ := parseCIDR(c.Address)
+	mac, _ := c.hardwareAddr()
+	n := &Network{LocalIP: localIP}
+
+	var err error
+	switch c.Link {
+	case LinkLoopback:
+		n.Link = link.NewLoopback(mac, c.MTU)
+	case LinkPipe:
+		var peerLink link.Endpoint
+		if n.Link, peerLink, err = c.newPipe(mac); err != nil {
+			return nil, err
+		}
+		peer, _ := c.peer()
+		n.Peer = stack.New()
+		n.Peer.SetLogger(logger)
+		if err := n.Peer.AddNIC(1, peerLink); err != nil {
+			peerLink.Close()
+			n.Close()
+			return nil, err
+		}
+		if err := n.Peer.AddAddress(1, peer.IP, peer.PrefixLength); err != nil {
+			n.Close()
+			return nil, err
+		}
+		if c.Address == AddressDHCP {
+			if err := n.startPeerDHCP(peer); err != nil {
+				n.Close()
+				return nil, err
+			}
+		}
+	case LinkTAP:
+		if n.Link, err = link.NewTAP(c.Interface, mac, c.MTU); err != nil {
+			return nil, fmt.Errorf("failed to open TAP device: %w", err)
+		}
+	case LinkPcap:
+		if n.Link, err = pcap.OpenReplay(c.Interface, mac, c.MTU); err != nil {
+			return nil, fmt.Errorf("failed to open capture: %w", err)
+		}
+	}
+
+	replay, _ := n.Link.(*pcap.ReplayEndpoint)
+	if c.Capture != "" {
+		capture, err := pcap.CreateCapture(n.Link, c.Capture)
+		if err != nil {
+			n.Close()
+			return nil, fmt.Errorf("failed to create capture: %w", err)
+		}
+		n.Link = capture
+	}
+	if c.Sniff || c.SniffFilter != "" {
+		filter, _ := sniff.ParseFilter(c.SniffFilter)
+		sniffer := sniff.NewEndpoint(n.Link, filter)
+		sniffer.SetLogger(logger)
+		n.Link = sniffer
+	}
+
+	n.Stack = stack.New()
+	n.Stack.SetLogger(logger)
+	if err := n.Stack.AddNIC(1, n.Link); err != nil {
+		n.Close()
+		return nil, fmt.Errorf("failed to create NIC: %w", err)
+	}
+	if c.Address == AddressDHCP {
+		if err := n.startDHCP(); err != nil {
+			n.Close()
+			return nil, err
+		}
+	} else if err := n.Stack.AddAddress(1, localIP, prefix); err != nil {
+		n.Close()
+		return nil, fmt.Errorf("failed to add address: %w", err)
+	}
+	if c.IPv6 {
+		if err := n.Stack.EnableIPv6(1, ndp.Config{
+			DupAddrDetectTransmits: ndp.DefaultDupAddrDetectTransmits,
+			MaxRtrSolicitations:    ndp.DefaultMaxRtrSolicitations,
+		}); err != nil {
+			n.Close()
+			return nil, err
+		}
+	}
+	if c.Gateway != "" {
+		gw, _ := parseIPv4(c.Gateway)
+		n.Stack.AddRoute(stack.Route{Gateway: gw, NIC: 1})
+	}
+
+	n.Resolver = dns.NewResolver(n.Stack)
+	n.Resolver.Servers, _ = c.dnsServers()
+
+	if replay != nil {
+		replay.Start()
+	}
+	return n, nil
+}
+
+// startDHCP 获取DHCP租约并在后台续租
+func (n *Network) startDHCP() error {
+	n.DHCP = dhcp.NewClient(n.Stack, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), dhcpTimeout)
+	lease, err := n.DHCP.Acquire(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("DHCP failed: %w", err)
+	}
+	n.LocalIP = lease.Address
+
+	ctx, n.stopDHCP = context.WithCancel(context.Background())
+	go n.DHCP.Run(ctx)
+	return nil
+}
+
+// startPeerDHCP 在模拟主机上启动DHCP服务器，地址池为子网中除网络地址和广播地址外的地址
+func (n *Network) startPeerDHCP(peer stack.Address) error {
+	if peer.PrefixLength > 30 {
+		return fmt.Errorf("peer subnet %s too small for DHCP", peer)
+	}
+	var first [4]byte
+	copy(first[:], net.IP(peer.IP[:]).Mask(net.CIDRMask(peer.PrefixLength, 32)))
+	last := peer.Broadcast()
+	first[3]++
+	last[3]--
+
+	n.PeerDHCP = dhcp.NewServer(n.Peer, 1, first, last)
+	n.PeerDHCP.Routers = [][4]byte{peer.IP}
+	return n.PeerDHCP.Start()
+}
+
+// Close 关闭协议栈和模拟主机
+func (n *Network) Close() {
+	if n.stopDHCP != nil {
+		n.stopDHCP()
+	}
+	if n.PeerDHCP != nil {
+		n.PeerDHCP.Close()
+	}
+	if n.Stack != nil {
+		n.Stack.Close()
+	}
+	if n.Link != nil {
+		n.Link.Close()
+	}
+	if n.Peer != nil {
+		n.Peer.Close()
+	}
+}
+
+// newPipe 创建管道链路，对端MAC为本地MAC最后一个字节取反
+func (c *Config) newPipe(mac [6]byte) (link.Endpoint, link.Endpoint, error) {
+	if c.Address != AddressDHCP {
+		local := stack.Address{}
+		local.IP, local.PrefixLength, _ = parseCIDR(c.Address)
+		peer, _ := c.peer()
+		if !local.Contains(peer.IP) || peer.IP == local.IP {
+			return nil, nil, fmt.Errorf("peer %s must be another host in %s", c.Peer, local)
+		}
+	}
+
+	peerMAC := mac
+	peerMAC[5] ^= 0xff
+	a, b := link.NewPipe(mac, peerMAC, c.MTU)
+	return a, b, nil
+}
+
+// peer 返回模拟主机的地址，没有前缀长度时使用本地地址的前缀长度
+func (c *Config) peer() (stack.Address, error) {
+	var a stack.Address
+	var err error
+	if strings.Contains(c.Peer, "/") {
+		a.IP, a.PrefixLength, err = parseCIDR(c.Peer)
+		return a, err
+	}
+	if c.Address == AddressDHCP {
+		return a, fmt.Errorf("peer %s requires a prefix length when using DHCP", c.Peer)
+	}
+	if a.IP, err = parseIPv4(c.Peer); err != nil {
+		return a, err
+	}
+	_, a.PrefixLength, _ = parseCIDR(c.Address)
+	return a, nil
+}
+
+// dnsServers 解析DNS服务器地址，没有端口时为0（即dns.Port）
+func (c *Config) dnsServers() ([]stack.FullAddress, error) {
+	var servers []stack.FullAddress
+	for _, server := range c.DNS {
+		var a stack.FullAddress
+		host := server
+		if h, port, err := net.SplitHostPort(server); err == nil {
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil || p == 0 {
+				return nil, fmt.Errorf("invalid DNS server: %s", server)
+			}
+			host, a.Port = h, uint16(p)
+		}
+		ip, err := parseIPv4(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS server: %s", server)
+		}
+		a.IP = ip
+		servers = append(servers, a)
+	}
+	return servers, nil
+}
+
+func (c *Config) hardwareAddr() ([6]byte, error) {
+	var mac [6]byte
+	hw, err := net.ParseMAC(c.MAC)
+	if err != nil || len(hw) != 6 {
+		return mac, fmt.Errorf("invalid MAC address: %s", c.MAC)
+	}
+	copy(mac[:], hw)
+	return mac, nil
+}
+
+// parseIPv4 解析IPv4地址
+func parseIPv4(s string) ([4]byte, error) {
+	var addr [4]byte
+	parsed := net.ParseIP(s).To4()
+	if parsed == nil {
+		return addr, fmt.Errorf("invalid IPv4 address: %s", s)
+	}
+	copy(addr[:], parsed)
+	return addr, nil
+}
+
+// parseCIDR 解析带前缀长度的IPv4地址
+func parseCIDR(s string) ([4]byte, int, error) {
+	var addr [4]byte
+	parsed, network, err := net.ParseCIDR(s)
+	if err != nil || parsed.To4() == nil {
+		return addr, 0, fmt.Errorf("invalid address: %s", s)
+	}
+	copy(addr[:], parsed.To4())
+	prefix, _ := network.Mask.Size()
+	return addr, prefix, nil
+}
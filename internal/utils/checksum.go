@@ -29,19 +29,49 @@ func CalculateChecksum(data []byte) uint16 {
 
 // CalculateTCPChecksum 计算TCP校验和
 func CalculateTCPChecksum(tcpHeader, payload []byte, srcIP, dstIP []byte) uint16 {
+	segment := make([]byte, 0, len(tcpHeader)+len(payload))
+	segment = append(segment, tcpHeader...)
+	segment = append(segment, payload...)
+
+	return pseudoHeaderChecksum(6, segment, srcIP, dstIP)
+}
+
+// CalculateUDPChecksum 计算UDP校验和（包含IPv4伪头部）
+func CalculateUDPChecksum(datagram []byte, srcIP, dstIP []byte) uint16 {
+	return pseudoHeaderChecksum(17, datagram, srcIP, dstIP)
+}
+
+// pseudoHeaderChecksum 计算带IPv4伪头部的传输层校验和
+func pseudoHeaderChecksum(protocol uint8, segment []byte, srcIP, dstIP []byte) uint16 {
 	// 伪头部
 	pseudoHeader := make([]byte, 12)
 	copy(pseudoHeader[0:4], srcIP)
 	copy(pseudoHeader[4:8], dstIP)
 	pseudoHeader[8] = 0 // 保留字段
-	pseudoHeader[9] = 6 // TCP协议号
-	binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(tcpHeader)+len(payload)))
+	pseudoHeader[9] = protocol
+	binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(segment)))
+
+	// 组合数据
+	data := make([]byte, 0, len(pseudoHeader)+len(segment))
+	data = append(data, pseudoHeader...)
+	data = append(data, segment...)
+
+	return CalculateChecksum(data)
+}
+
+// CalculateICMPv6Checksum 计算ICMPv6校验和（包含IPv6伪头部）
+func CalculateICMPv6Checksum(message []byte, srcIP, dstIP []byte) uint16 {
+	// 伪头部：源地址、目标地址、上层长度、3字节0、下一头部
+	pseudoHeader := make([]byte, 40)
+	copy(pseudoHeader[0:16], srcIP)
+	copy(pseudoHeader[16:32], dstIP)
+	binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(message)))
+	pseudoHeader[39] = 58 // ICMPv6协议号
 
 	// 组合数据
-	data := make([]byte, 0, len(pseudoHeader)+len(tcpHeader)+len(payload))
+	data := make([]byte, 0, len(pseudoHeader)+len(message))
 	data = append(data, pseudoHeader...)
-	data = append(data, tcpHeader...)
-	data = append(data, payload...)
+	data = append(data, message...)
 
 	return CalculateChecksum(data)
 }
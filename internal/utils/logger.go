@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 // Logger 日志记录器
@@ -30,6 +32,26 @@ func NewLogger(level int) *Logger {
 	}
 }
 
+// ParseLevel 解析日志级别名称（debug、info、warn、error，不区分大小写）
+func ParseLevel(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DEBUG, nil
+	case "info":
+		return INFO, nil
+	case "warn", "warning":
+		return WARN, nil
+	case "error":
+		return ERROR, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// SetLevel 设置日志级别，应在开始记录日志前调用
+func (l *Logger) SetLevel(level int) {
+	l.level = level
+}
+
 // Debug 调试日志
 func (l *Logger) Debug(format string, v ...interface{}) {
 	if l.level <= DEBUG {
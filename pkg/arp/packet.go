@@ -0,0 +1,116 @@
+package arp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	// ARP报文长度（以太网 + IPv4）
+	PacketLength = 28
+
+	// 硬件类型
+	HardwareEthernet = 1
+
+	// 协议类型
+	ProtocolIPv4 = 0x0800
+
+	// 操作码
+	OperationRequest = 1
+	OperationReply   = 2
+)
+
+// Packet ARP报文（RFC 826），仅支持以太网上的IPv4
+type Packet struct {
+	HardwareType uint16  // 硬件类型
+	ProtocolType uint16  // 协议类型
+	Operation    uint16  // 操作码
+	SenderMAC    [6]byte // 发送方MAC地址
+	SenderIP     [4]byte // 发送方IP地址
+	TargetMAC    [6]byte // 目标MAC地址
+	TargetIP     [4]byte // 目标IP地址
+}
+
+// Marshal 将ARP报文序列化为字节数组
+func (p *Packet) Marshal() ([]byte, error) {
+	data := make([]byte, PacketLength)
+
+	binary.BigEndian.PutUint16(data[0:2], p.HardwareType)
+	binary.BigEndian.PutUint16(data[2:4], p.ProtocolType)
+	data[4] = 6 // 硬件地址长度
+	data[5] = 4 // 协议地址长度
+	binary.BigEndian.PutUint16(data[6:8], p.Operation)
+	copy(data[8:14], p.SenderMAC[:])
+	copy(data[14:18], p.SenderIP[:])
+	copy(data[18:24], p.TargetMAC[:])
+	copy(data[24:28], p.TargetIP[:])
+
+	return data, nil
+}
+
+// Unmarshal 从字节数组解析ARP报文
+func (p *Packet) Unmarshal(data []byte) error {
+	if len(data) < PacketLength {
+		return fmt.Errorf("ARP packet too short: %d bytes", len(data))
+	}
+
+	p.HardwareType = binary.BigEndian.Uint16(data[0:2])
+	p.ProtocolType = binary.BigEndian.Uint16(data[2:4])
+	if p.HardwareType != HardwareEthernet || p.ProtocolType != ProtocolIPv4 || data[4] != 6 || data[5] != 4 {
+		return fmt.Errorf("unsupported ARP hardware/protocol: %d/0x%04x", p.HardwareType, p.ProtocolType)
+	}
+
+	p.Operation = binary.BigEndian.Uint16(data[6:8])
+	copy(p.SenderMAC[:], data[8:14])
+	copy(p.SenderIP[:], data[14:18])
+	copy(p.TargetMAC[:], data[18:24])
+	copy(p.TargetIP[:], data[24:28])
+
+	return nil
+}
+
+// IsRequest 检查是否为请求
+func (p *Packet) IsRequest() bool {
+	return p.Operation == OperationRequest
+}
+
+// IsReply 检查是否为应答
+func (p *Packet) IsReply() bool {
+	return p.Operation == OperationReply
+}
+
+// String 返回ARP报文的字符串表示
+func (p *Packet) String() string {
+	if p.IsRequest() {
+		return fmt.Sprintf("ARP Request: who-has %s tell %s (%s)",
+			net.IP(p.TargetIP[:]), net.IP(p.SenderIP[:]), net.HardwareAddr(p.SenderMAC[:]))
+	}
+	return fmt.Sprintf("ARP Reply: %s is-at %s",
+		net.IP(p.SenderIP[:]), net.HardwareAddr(p.SenderMAC[:]))
+}
+
+// NewRequest 创建查询targetIP的ARP请求
+func NewRequest(senderMAC [6]byte, senderIP, targetIP [4]byte) *Packet {
+	return &Packet{
+		HardwareType: HardwareEthernet,
+		ProtocolType: ProtocolIPv4,
+		Operation:    OperationRequest,
+		SenderMAC:    senderMAC,
+		SenderIP:     senderIP,
+		TargetIP:     targetIP,
+	}
+}
+
+// CreateReply 用本机MAC地址创建对请求的应答
+func (p *Packet) CreateReply(mac [6]byte) *Packet {
+	return &Packet{
+		HardwareType: HardwareEthernet,
+		ProtocolType: ProtocolIPv4,
+		Operation:    OperationReply,
+		SenderMAC:    mac,
+		SenderIP:     p.TargetIP,
+		TargetMAC:    p.SenderMAC,
+		TargetIP:     p.SenderIP,
+	}
+}
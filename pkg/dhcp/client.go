@@ -0,0 +1,481 @@
+package dhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+const (
+	// 初始重传间隔，之后每次加倍直到MaxRetransmitTimeout（RFC 2131 4.1）
+	DefaultRetransmitTimeout = 4 * time.Second
+	MaxRetransmitTimeout     = 64 * time.Second
+
+	// REQUESTING状态发送REQUEST的次数，均无回复时重新DISCOVER
+	requestAttempts = 4
+)
+
+var (
+	// ErrNak 服务器拒绝了请求
+	ErrNak = errors.New("DHCP request rejected by server")
+
+	// errTimeout 在截止时间前没有收到回复
+	errTimeout = errors.New("DHCP request timed out")
+
+	// limitedBroadcast 受限广播地址
+	limitedBroadcast = [4]byte{255, 255, 255, 255}
+)
+
+// Lease 从服务器获得的租约
+type Lease struct {
+	Address       [4]byte       // 分配的地址
+	PrefixLength  int           // 子网前缀长度
+	Routers       [][4]byte     // 路由器，第一个用作默认网关
+	DNSServers    [][4]byte     // DNS服务器
+	DomainName    string        // 域名
+	Server        [4]byte       // 服务器标识
+	Duration      time.Duration // 租期，0表示永久
+	RenewalTime   time.Duration // T1：开始向原服务器续租的时间
+	RebindingTime time.Duration // T2：开始向所有服务器广播续租的时间
+	Acquired      time.Time     // 获得（或最近一次续租）的时间
+}
+
+// Renew 返回进入RENEWING状态的时间
+func (l *Lease) Renew() time.Time {
+	return l.Acquired.Add(l.RenewalTime)
+}
+
+// Rebind 返回进入REBINDING状态的时间
+func (l *Lease) Rebind() time.Time {
+	return l.Acquired.Add(l.RebindingTime)
+}
+
+// Expiry 返回租约到期时间
+func (l *Lease) Expiry() time.Time {
+	return l.Acquired.Add(l.Duration)
+}
+
+// String 返回租约的字符串表示
+func (l *Lease) String() string {
+	s := fmt.Sprintf("%s/%d from %s", net.IP(l.Address[:]), l.PrefixLength, net.IP(l.Server[:]))
+	if len(l.Routers) > 0 {
+		s += fmt.Sprintf(" via %s", net.IP(l.Routers[0][:]))
+	}
+	if l.Duration == 0 {
+		return s + ", infinite lease"
+	}
+	return s + fmt.Sprintf(", lease %s", l.Duration)
+}
+
+// serverOf 返回消息中的服务器标识，缺少时返回fallback
+func serverOf(msg *Message, fallback [4]byte) [4]byte {
+	if id, ok := msg.IPOption(OptionServerID); ok {
+		return id
+	}
+	return fallback
+}
+
+// newLease 从ACK构造租约，缺少服务器标识时使用server；缺少T1/T2时按租期的1/2和7/8计算（RFC 2131 4.4.5）
+func newLease(ack *Message, server [4]byte) *Lease {
+	l := &Lease{
+		Address:    ack.YourIP,
+		Routers:    ack.IPListOption(OptionRouter),
+		DNSServers: ack.IPListOption(OptionDNSServer),
+		DomainName: string(ack.Option(OptionDomainName)),
+		Server:     serverOf(ack, server),
+		Acquired:   time.Now(),
+	}
+
+	// 没有子网掩码或掩码不连续时使用地址类别的默认掩码
+	addr := net.IP(l.Address[:])
+	l.PrefixLength, _ = addr.DefaultMask().Size()
+	if mask, ok := ack.IPOption(OptionSubnetMask); ok {
+		if ones, bits := net.IPMask(mask[:]).Size(); bits == 32 {
+			l.PrefixLength = ones
+		}
+	}
+
+	seconds, ok := ack.Uint32Option(OptionLeaseTime)
+	if !ok || seconds == 0xffffffff {
+		return l
+	}
+	l.Duration = time.Duration(seconds) * time.Second
+	l.RenewalTime = l.Duration / 2
+	l.RebindingTime = l.Duration * 7 / 8
+	if t1, ok := ack.Uint32Option(OptionRenewalTime); ok && time.Duration(t1)*time.Second < l.Duration {
+		l.RenewalTime = time.Duration(t1) * time.Second
+	}
+	if t2, ok := ack.Uint32Option(OptionRebindingTime); ok && time.Duration(t2)*time.Second < l.Duration {
+		l.RebindingTime = time.Duration(t2) * time.Second
+	}
+	if l.RebindingTime < l.RenewalTime {
+		l.RebindingTime = l.RenewalTime
+	}
+	return l
+}
+
+// Client DHCP客户端，获得租约后将地址、直连路由、默认路由和DNS服务器配置到协议栈
+//
+// 地址到期或被服务器拒绝时撤销配置并重新获取。不对提供的地址做ARP冲突检测。
+type Client struct {
+	HostName          string        // 主机名选项，空串表示不发送
+	RetransmitTimeout time.Duration // 初始重传间隔，0表示DefaultRetransmitTimeout；也是续租重传间隔的下限
+
+	// OnBound 获得或续租成功时调用，OnLost 租约到期、被拒绝或释放时调用（在调用方的goroutine中）
+	OnBound func(l *Lease)
+	OnLost  func(l *Lease)
+
+	stack *stack.Stack
+	nicID int
+
+	mu    sync.Mutex
+	lease *Lease
+
+	logger *utils.Logger
+}
+
+// NewClient 创建在指定网卡上获取地址的DHCP客户端
+func NewClient(s *stack.Stack, nicID int) *Client {
+	return &Client{
+		RetransmitTimeout: DefaultRetransmitTimeout,
+		stack:             s,
+		nicID:             nicID,
+		logger:            utils.DefaultLogger,
+	}
+}
+
+// Lease 返回当前租约，没有租约时返回nil
+func (c *Client) Lease() *Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lease
+}
+
+// Acquire 执行DISCOVER/OFFER/REQUEST/ACK获得租约并配置协议栈，直到成功或ctx取消
+func (c *Client) Acquire(ctx context.Context) (*Lease, error) {
+	ep, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	defer ep.Close()
+	return c.acquire(ctx, ep)
+}
+
+// Run 获得租约（已有租约时沿用）并在T1续租、T2重新绑定，租约失效时重新获取，直到ctx取消
+//
+// ctx取消时保留当前配置并返回ctx.Err()；需要归还地址时调用Release。
+func (c *Client) Run(ctx context.Context) error {
+	ep, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer ep.Close()
+
+	for {
+		lease := c.Lease()
+		if lease == nil {
+			if _, err := c.acquire(ctx, ep); err != nil {
+				return err
+			}
+			continue
+		}
+		if lease.Duration == 0 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		// BOUND：等到T1
+		if err := sleepUntil(ctx, lease.Renew()); err != nil {
+			return err
+		}
+
+		// RENEWING：向原服务器单播续租，直到T2
+		ack, err := c.extend(ctx, ep, lease, lease.Server, lease.Rebind())
+		if errors.Is(err, errTimeout) {
+			// REBINDING：向所有服务器广播续租，直到租约到期
+			c.logger.Info("DHCP: no reply from %s, rebinding", net.IP(lease.Server[:]))
+			ack, err = c.extend(ctx, ep, lease, limitedBroadcast, lease.Expiry())
+		}
+
+		switch {
+		case err == nil:
+			// REBINDING时的ACK可能来自其他服务器，之后向它续租
+			c.bind(ack, serverOf(ack, lease.Server))
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			c.logger.Warn("DHCP: lease %s lost: %v", lease, err)
+			c.unbind(lease)
+		}
+	}
+}
+
+// Release 向服务器发送RELEASE归还地址，并撤销协议栈上的配置
+func (c *Client) Release() error {
+	lease := c.Lease()
+	if lease == nil {
+		return nil
+	}
+
+	ep, err := c.open()
+	if err != nil {
+		return err
+	}
+	defer ep.Close()
+
+	msg := c.newMessage(TypeRelease, rand.Uint32())
+	msg.ClientIP = lease.Address
+	msg.SetIPOption(OptionServerID, lease.Server)
+	err = c.send(ep, msg, lease.Server)
+
+	c.unbind(lease)
+	return err
+}
+
+// open 创建绑定到客户端端口的UDP端点；与Release等并发使用时依赖ReuseAddr
+func (c *Client) open() (*udp.Endpoint, error) {
+	ep := udp.NewEndpoint(c.stack, 0)
+	ep.Broadcast = true
+	ep.BroadcastNIC = c.nicID
+	ep.ReuseAddr = true
+	if err := ep.Bind(stack.FullAddress{Port: ClientPort}); err != nil {
+		ep.Close()
+		return nil, err
+	}
+	return ep, nil
+}
+
+// acquire 从INIT状态获得租约；REQUEST被拒绝或无回复时重新DISCOVER
+func (c *Client) acquire(ctx context.Context, ep *udp.Endpoint) (*Lease, error) {
+	for {
+		xid := rand.Uint32()
+		discover := c.newMessage(TypeDiscover, xid)
+		discover.Flags = FlagBroadcast
+		offer, err := c.exchange(ctx, ep, discover, limitedBroadcast, 0, time.Time{}, TypeOffer)
+		if err != nil {
+			return nil, err
+		}
+		serverID, ok := offer.IPOption(OptionServerID)
+		if !ok {
+			c.logger.Debug("DHCP: ignoring offer without server identifier")
+			continue
+		}
+		c.logger.Debug("DHCP: offer %s from %s", net.IP(offer.YourIP[:]), net.IP(serverID[:]))
+
+		request := c.newMessage(TypeRequest, xid)
+		request.Flags = FlagBroadcast
+		request.SetIPOption(OptionRequestedIP, offer.YourIP)
+		request.SetIPOption(OptionServerID, serverID)
+		ack, err := c.exchange(ctx, ep, request, limitedBroadcast, requestAttempts, time.Time{}, TypeAck)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			c.logger.Info("DHCP: request for %s failed: %v", net.IP(offer.YourIP[:]), err)
+			continue
+		}
+		return c.bind(ack, serverID), nil
+	}
+}
+
+// extend 在RENEWING或REBINDING状态续租，until之前没有回复时返回errTimeout
+func (c *Client) extend(ctx context.Context, ep *udp.Endpoint, lease *Lease, dst [4]byte, until time.Time) (*Message, error) {
+	request := c.newMessage(TypeRequest, rand.Uint32())
+	request.ClientIP = lease.Address
+	return c.exchange(ctx, ep, request, dst, 0, until, TypeAck)
+}
+
+// exchange 发送消息并等待事务ID和类型匹配的回复，收到NAK时返回ErrNak
+//
+// until为零值时按指数退避重传，最多attempts次（0表示不限）；否则每次等待到until剩余时间的一半，
+// 不少于RetransmitTimeout（RFC 2131 4.4.5），到达until时返回errTimeout。
+func (c *Client) exchange(ctx context.Context, ep *udp.Endpoint, msg *Message, dst [4]byte, attempts int, until time.Time, want uint8) (*Message, error) {
+	base := c.RetransmitTimeout
+	if base <= 0 {
+		base = DefaultRetransmitTimeout
+	}
+
+	backoff := base
+	for attempt := 0; attempts == 0 || attempt < attempts; attempt++ {
+		var wait time.Duration
+		if until.IsZero() {
+			// 加入±1/4的随机扰动，避免多个客户端同步重传
+			wait = backoff + time.Duration(rand.Int63n(int64(backoff/2)+1)) - backoff/4
+			backoff = min(backoff*2, MaxRetransmitTimeout)
+		} else {
+			remaining := time.Until(until)
+			if remaining <= 0 {
+				break
+			}
+			wait = min(max(remaining/2, base), remaining)
+		}
+
+		if err := c.send(ep, msg, dst); err != nil {
+			c.logger.Debug("DHCP: failed to send %s: %v", TypeName(msg.Type()), err)
+		}
+
+		reply, err := c.wait(ctx, ep, msg, wait, want)
+		if reply != nil || err != nil {
+			return reply, err
+		}
+	}
+	return nil, errTimeout
+}
+
+// wait 在timeout内接收与msg匹配的回复，超时返回(nil, nil)
+func (c *Client) wait(ctx context.Context, ep *udp.Endpoint, msg *Message, timeout time.Duration, want uint8) (*Message, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		data, from, err := ep.RecvFromUntil(waitCtx.Done())
+		if errors.Is(err, udp.ErrCanceled) {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, udp.ErrClosed) {
+			return nil, err
+		}
+		if err != nil {
+			// 单播续租可能收到ICMP差错，继续等待直到超时
+			continue
+		}
+
+		reply := &Message{}
+		if err := reply.Unmarshal(data); err != nil {
+			c.logger.Debug("DHCP: dropping message from %s: %v", from, err)
+			continue
+		}
+		if reply.Op != OpReply || reply.XID != msg.XID || reply.ClientMAC != msg.ClientMAC {
+			continue
+		}
+		c.logger.Debug("DHCP: received %s", reply)
+
+		switch reply.Type() {
+		case want:
+			return reply, nil
+		case TypeNak:
+			if msg.Type() == TypeRequest {
+				return nil, fmt.Errorf("%w: %s", ErrNak, reply.Option(OptionMessage))
+			}
+		}
+	}
+}
+
+// send 发送DHCP消息到服务器端口
+func (c *Client) send(ep *udp.Endpoint, msg *Message, dst [4]byte) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	c.logger.Debug("DHCP: sending %s to %s", msg, net.IP(dst[:]))
+	_, err = ep.SendTo(data, &stack.FullAddress{IP: dst, Port: ServerPort})
+	return err
+}
+
+// newMessage 创建带客户端标识和参数请求列表的消息
+func (c *Client) newMessage(msgType uint8, xid uint32) *Message {
+	mac := c.macAddress()
+	msg := NewMessage(msgType, xid, mac)
+	msg.SetOption(OptionClientID, append([]byte{HardwareTypeEthernet}, mac[:]...))
+	if msgType == TypeDiscover || msgType == TypeRequest {
+		msg.SetOption(OptionParameterList, []byte{
+			OptionSubnetMask, OptionRouter, OptionDNSServer, OptionDomainName,
+			OptionLeaseTime, OptionRenewalTime, OptionRebindingTime,
+		})
+		if c.HostName != "" {
+			msg.SetOption(OptionHostName, []byte(c.HostName))
+		}
+	}
+	return msg
+}
+
+func (c *Client) macAddress() [6]byte {
+	r, err := c.stack.FindMulticastRoute(c.nicID, limitedBroadcast)
+	if err != nil {
+		return [6]byte{}
+	}
+	return r.NIC.MACAddress()
+}
+
+// bind 按ACK配置协议栈并保存租约，地址或网关变化时先撤销旧配置
+func (c *Client) bind(ack *Message, server [4]byte) *Lease {
+	c.mu.Lock()
+	old := c.lease
+	lease := newLease(ack, server)
+	c.lease = lease
+	c.mu.Unlock()
+
+	if old != nil && (old.Address != lease.Address || old.PrefixLength != lease.PrefixLength) {
+		c.deconfigure(old)
+		old = nil
+	}
+	if old == nil {
+		if err := c.stack.AddAddress(c.nicID, lease.Address, lease.PrefixLength); err != nil {
+			c.logger.Warn("DHCP: %v", err)
+		}
+	} else if gateway(old) != gateway(lease) {
+		c.stack.RemoveRoute(stack.Route{Gateway: gateway(old), NIC: c.nicID})
+	}
+	if gw := gateway(lease); gw != [4]byte{} {
+		c.stack.AddRoute(stack.Route{Gateway: gw, NIC: c.nicID})
+	}
+	c.stack.SetDNSServers(lease.DNSServers)
+
+	c.logger.Info("DHCP: bound to %s", lease)
+	if c.OnBound != nil {
+		c.OnBound(lease)
+	}
+	return lease
+}
+
+// unbind 撤销租约的配置并回到INIT状态
+func (c *Client) unbind(lease *Lease) {
+	c.mu.Lock()
+	if c.lease == lease {
+		c.lease = nil
+	}
+	c.mu.Unlock()
+
+	c.deconfigure(lease)
+	if c.OnLost != nil {
+		c.OnLost(lease)
+	}
+}
+
+// deconfigure 删除租约对应的默认路由、地址和DNS服务器
+func (c *Client) deconfigure(lease *Lease) {
+	if gw := gateway(lease); gw != [4]byte{} {
+		c.stack.RemoveRoute(stack.Route{Gateway: gw, NIC: c.nicID})
+	}
+	if err := c.stack.RemoveAddress(c.nicID, lease.Address); err != nil {
+		c.logger.Debug("DHCP: %v", err)
+	}
+	c.stack.SetDNSServers(nil)
+}
+
+// gateway 返回租约的默认网关，没有路由器选项时为零值
+func gateway(l *Lease) [4]byte {
+	if len(l.Routers) == 0 {
+		return [4]byte{}
+	}
+	return l.Routers[0]
+}
+
+// sleepUntil 等待到指定时间或ctx取消
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,328 @@
+// Package dhcp 在ustack UDP上实现DHCPv4（RFC 2131、RFC 2132）
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// DHCP端口
+	ServerPort = 67
+	ClientPort = 68
+
+	// BOOTP操作码
+	OpRequest = 1
+	OpReply   = 2
+
+	// 以太网硬件类型
+	HardwareTypeEthernet = 1
+
+	// Flags中的广播位：客户端尚无地址，要求服务器以广播回复
+	FlagBroadcast = 0x8000
+
+	// 固定部分长度（不含magic cookie）
+	headerLength = 236
+	// 固定部分之后、选项之前的magic cookie
+	magicCookie = 0x63825363
+	// 单个选项的最大数据长度
+	maxOptionDataLength = 255
+	// BOOTP报文的最小长度（RFC 1542），不足时以Pad填充
+	minMessageLength = 300
+)
+
+// DHCP消息类型（选项53）
+const (
+	TypeDiscover = 1
+	TypeOffer    = 2
+	TypeRequest  = 3
+	TypeDecline  = 4
+	TypeAck      = 5
+	TypeNak      = 6
+	TypeRelease  = 7
+	TypeInform   = 8
+)
+
+// DHCP选项代码
+const (
+	OptionPad            = 0
+	OptionSubnetMask     = 1
+	OptionRouter         = 3
+	OptionDNSServer      = 6
+	OptionHostName       = 12
+	OptionDomainName     = 15
+	OptionRequestedIP    = 50
+	OptionLeaseTime      = 51
+	OptionMessageType    = 53
+	OptionServerID       = 54
+	OptionParameterList  = 55
+	OptionMessage        = 56
+	OptionMaxMessageSize = 57
+	OptionRenewalTime    = 58
+	OptionRebindingTime  = 59
+	OptionClientID       = 61
+	OptionEnd            = 255
+)
+
+var (
+	// ErrBadCookie 缺少DHCP magic cookie（BOOTP报文）
+	ErrBadCookie = errors.New("DHCP magic cookie missing")
+)
+
+// Option DHCP选项
+type Option struct {
+	Code uint8  // 选项代码
+	Data []byte // 选项数据（不含代码和长度字段）
+}
+
+// Message DHCP消息结构
+//
+// 不支持选项过载（选项52），sname和file字段按原样保留。
+type Message struct {
+	Op           uint8     // 操作码
+	HardwareType uint8     // 硬件类型
+	HardwareLen  uint8     // 硬件地址长度
+	Hops         uint8     // 中继跳数
+	XID          uint32    // 事务ID
+	Secs         uint16    // 客户端开始获取地址后经过的秒数
+	Flags        uint16    // 标志
+	ClientIP     [4]byte   // ciaddr：客户端当前地址（续租时）
+	YourIP       [4]byte   // yiaddr：分配给客户端的地址
+	ServerIP     [4]byte   // siaddr：下一个服务器地址
+	GatewayIP    [4]byte   // giaddr：中继代理地址
+	ClientMAC    [6]byte   // chaddr：客户端硬件地址
+	ServerName   [64]byte  // sname：服务器主机名
+	File         [128]byte // file：引导文件名
+	Options      []Option  // 选项，按序列化顺序
+}
+
+// NewMessage 创建以太网客户端发出的DHCP消息
+func NewMessage(msgType uint8, xid uint32, mac [6]byte) *Message {
+	m := &Message{
+		Op:           OpRequest,
+		HardwareType: HardwareTypeEthernet,
+		HardwareLen:  6,
+		XID:          xid,
+		ClientMAC:    mac,
+	}
+	m.SetOption(OptionMessageType, []byte{msgType})
+	return m
+}
+
+// Type 返回消息类型，没有选项53（BOOTP报文）时返回0
+func (m *Message) Type() uint8 {
+	data := m.Option(OptionMessageType)
+	if len(data) != 1 {
+		return 0
+	}
+	return data[0]
+}
+
+// Option 返回选项数据，不存在时返回nil
+func (m *Message) Option(code uint8) []byte {
+	for _, o := range m.Options {
+		if o.Code == code {
+			return o.Data
+		}
+	}
+	return nil
+}
+
+// SetOption 设置选项，已存在时替换
+func (m *Message) SetOption(code uint8, data []byte) {
+	for i := range m.Options {
+		if m.Options[i].Code == code {
+			m.Options[i].Data = data
+			return
+		}
+	}
+	m.Options = append(m.Options, Option{Code: code, Data: data})
+}
+
+// IPOption 返回地址选项（如子网掩码、服务器标识）
+func (m *Message) IPOption(code uint8) ([4]byte, bool) {
+	var addr [4]byte
+	data := m.Option(code)
+	if len(data) != 4 {
+		return addr, false
+	}
+	copy(addr[:], data)
+	return addr, true
+}
+
+// SetIPOption 设置地址选项
+func (m *Message) SetIPOption(code uint8, addr [4]byte) {
+	m.SetOption(code, append([]byte(nil), addr[:]...))
+}
+
+// IPListOption 返回地址列表选项（如路由器、DNS服务器）
+func (m *Message) IPListOption(code uint8) [][4]byte {
+	data := m.Option(code)
+	addrs := make([][4]byte, 0, len(data)/4)
+	for i := 0; i+4 <= len(data); i += 4 {
+		var addr [4]byte
+		copy(addr[:], data[i:i+4])
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// SetIPListOption 设置地址列表选项
+func (m *Message) SetIPListOption(code uint8, addrs [][4]byte) {
+	data := make([]byte, 0, len(addrs)*4)
+	for _, addr := range addrs {
+		data = append(data, addr[:]...)
+	}
+	m.SetOption(code, data)
+}
+
+// Uint32Option 返回32位整数选项（如租期，单位秒）
+func (m *Message) Uint32Option(code uint8) (uint32, bool) {
+	data := m.Option(code)
+	if len(data) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data), true
+}
+
+// SetUint32Option 设置32位整数选项
+func (m *Message) SetUint32Option(code uint8, v uint32) {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, v)
+	m.SetOption(code, data)
+}
+
+// Marshal 将DHCP消息序列化为字节数组，不足300字节时填充
+func (m *Message) Marshal() ([]byte, error) {
+	length := headerLength + 4 + 1
+	for _, o := range m.Options {
+		if o.Code == OptionPad || o.Code == OptionEnd {
+			return nil, fmt.Errorf("DHCP option %d cannot carry data", o.Code)
+		}
+		if len(o.Data) > maxOptionDataLength {
+			return nil, fmt.Errorf("DHCP option %d too long: %d bytes", o.Code, len(o.Data))
+		}
+		length += 2 + len(o.Data)
+	}
+
+	data := make([]byte, max(length, minMessageLength))
+	data[0] = m.Op
+	data[1] = m.HardwareType
+	data[2] = m.HardwareLen
+	data[3] = m.Hops
+	binary.BigEndian.PutUint32(data[4:8], m.XID)
+	binary.BigEndian.PutUint16(data[8:10], m.Secs)
+	binary.BigEndian.PutUint16(data[10:12], m.Flags)
+	copy(data[12:16], m.ClientIP[:])
+	copy(data[16:20], m.YourIP[:])
+	copy(data[20:24], m.ServerIP[:])
+	copy(data[24:28], m.GatewayIP[:])
+	copy(data[28:34], m.ClientMAC[:])
+	copy(data[44:108], m.ServerName[:])
+	copy(data[108:236], m.File[:])
+	binary.BigEndian.PutUint32(data[236:240], magicCookie)
+
+	offset := headerLength + 4
+	for _, o := range m.Options {
+		data[offset] = o.Code
+		data[offset+1] = uint8(len(o.Data))
+		copy(data[offset+2:], o.Data)
+		offset += 2 + len(o.Data)
+	}
+	data[offset] = OptionEnd
+
+	return data, nil
+}
+
+// Unmarshal 从字节数组解析DHCP消息
+func (m *Message) Unmarshal(data []byte) error {
+	if len(data) < headerLength+4 {
+		return fmt.Errorf("DHCP message too short: %d bytes", len(data))
+	}
+
+	m.Op = data[0]
+	m.HardwareType = data[1]
+	m.HardwareLen = data[2]
+	m.Hops = data[3]
+	m.XID = binary.BigEndian.Uint32(data[4:8])
+	m.Secs = binary.BigEndian.Uint16(data[8:10])
+	m.Flags = binary.BigEndian.Uint16(data[10:12])
+	copy(m.ClientIP[:], data[12:16])
+	copy(m.YourIP[:], data[16:20])
+	copy(m.ServerIP[:], data[20:24])
+	copy(m.GatewayIP[:], data[24:28])
+	copy(m.ClientMAC[:], data[28:34])
+	copy(m.ServerName[:], data[44:108])
+	copy(m.File[:], data[108:236])
+
+	if binary.BigEndian.Uint32(data[236:240]) != magicCookie {
+		return ErrBadCookie
+	}
+
+	// 解析选项，同一代码多次出现时按RFC 3396拼接
+	m.Options = nil
+	for offset := headerLength + 4; offset < len(data); {
+		code := data[offset]
+		if code == OptionEnd {
+			return nil
+		}
+		if code == OptionPad {
+			offset++
+			continue
+		}
+		if offset+2 > len(data) || offset+2+int(data[offset+1]) > len(data) {
+			return fmt.Errorf("DHCP option %d truncated", code)
+		}
+		value := data[offset+2 : offset+2+int(data[offset+1])]
+		offset += 2 + len(value)
+
+		if existing := m.Option(code); existing != nil {
+			m.SetOption(code, append(existing, value...))
+		} else {
+			m.SetOption(code, append([]byte{}, value...))
+		}
+	}
+	return fmt.Errorf("DHCP options not terminated")
+}
+
+// TypeName 返回消息类型的名称
+func TypeName(msgType uint8) string {
+	switch msgType {
+	case TypeDiscover:
+		return "DISCOVER"
+	case TypeOffer:
+		return "OFFER"
+	case TypeRequest:
+		return "REQUEST"
+	case TypeDecline:
+		return "DECLINE"
+	case TypeAck:
+		return "ACK"
+	case TypeNak:
+		return "NAK"
+	case TypeRelease:
+		return "RELEASE"
+	case TypeInform:
+		return "INFORM"
+	}
+	return fmt.Sprintf("TYPE%d", msgType)
+}
+
+// String 返回DHCP消息的字符串表示
+func (m *Message) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "DHCP %s xid=0x%08x chaddr=%s", TypeName(m.Type()), m.XID, net.HardwareAddr(m.ClientMAC[:]))
+	if m.ClientIP != [4]byte{} {
+		fmt.Fprintf(&b, " ciaddr=%s", net.IP(m.ClientIP[:]))
+	}
+	if m.YourIP != [4]byte{} {
+		fmt.Fprintf(&b, " yiaddr=%s", net.IP(m.YourIP[:]))
+	}
+	if id, ok := m.IPOption(OptionServerID); ok {
+		fmt.Fprintf(&b, " server=%s", net.IP(id[:]))
+	}
+	return b.String()
+}
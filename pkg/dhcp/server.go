@@ -0,0 +1,500 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+const (
+	// 默认租期
+	DefaultLeaseTime = time.Hour
+
+	// 提供的地址等待REQUEST期间保留的时间
+	offerTimeout = time.Minute
+
+	// 被客户端DECLINE（地址冲突）的地址暂停分配的时间
+	declineTimeout = 10 * time.Minute
+)
+
+// Binding 租约数据库中的一条记录
+type Binding struct {
+	MAC      [6]byte   // 客户端硬件地址
+	Address  [4]byte   // 分配的地址
+	HostName string    // 客户端主机名选项
+	Expiry   time.Time // 到期时间，已提供但未确认的地址为保留截止时间
+	Offered  bool      // 已提供但尚未收到REQUEST
+}
+
+// bindingRecord 租约文件中的记录
+type bindingRecord struct {
+	MAC      string    `json:"mac"`
+	Address  string    `json:"address"`
+	HostName string    `json:"hostname,omitempty"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+// Server DHCP服务器，从地址池为客户端分配地址
+//
+// 客户端按硬件地址识别；尚无地址的客户端总是以广播回复，不支持中继代理。服务器网卡所在的
+// 协议栈不应有其他网卡连接到别的DHCP客户端，因为端点无法区分报文的接收网卡。
+type Server struct {
+	PoolStart    [4]byte             // 地址池起始地址（含）
+	PoolEnd      [4]byte             // 地址池结束地址（含）
+	PrefixLength int                 // 子网前缀长度，0表示使用服务器地址的前缀
+	Routers      [][4]byte           // 路由器选项
+	DNSServers   [][4]byte           // DNS服务器选项
+	DomainName   string              // 域名选项
+	LeaseTime    time.Duration       // 租期，0表示DefaultLeaseTime
+	Reservations map[[6]byte][4]byte // 按硬件地址固定分配的地址，可以在地址池之外
+	LeaseFile    string              // 租约数据库文件，空串表示只保存在内存中
+
+	stack *stack.Stack
+	nicID int
+
+	mu       sync.Mutex
+	ep       *udp.Endpoint
+	done     chan struct{}
+	address  [4]byte              // 服务器地址，用作服务器标识
+	prefix   int                  // 生效的前缀长度
+	bindings map[[4]byte]*Binding // 按地址索引的租约
+	declined map[[4]byte]time.Time
+
+	logger *utils.Logger
+}
+
+// NewServer 创建在指定网卡上分配start到end之间地址的DHCP服务器
+func NewServer(s *stack.Stack, nicID int, start, end [4]byte) *Server {
+	return &Server{
+		PoolStart: start,
+		PoolEnd:   end,
+		LeaseTime: DefaultLeaseTime,
+		stack:     s,
+		nicID:     nicID,
+		bindings:  make(map[[4]byte]*Binding),
+		declined:  make(map[[4]byte]time.Time),
+		logger:    utils.DefaultLogger,
+	}
+}
+
+// Bindings 返回租约数据库的快照，按地址排序
+func (srv *Server) Bindings() []Binding {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	bindings := make([]Binding, 0, len(srv.bindings))
+	for _, b := range srv.bindings {
+		bindings = append(bindings, *b)
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bytes.Compare(bindings[i].Address[:], bindings[j].Address[:]) < 0
+	})
+	return bindings
+}
+
+// Start 绑定UDP 67端口并在后台处理客户端请求，直到Close
+//
+// 服务器地址取网卡上包含地址池的地址；设置了LeaseFile时先从文件加载租约，租约变化后写回。
+func (srv *Server) Start() error {
+	if err := srv.init(); err != nil {
+		return err
+	}
+
+	ep := udp.NewEndpoint(srv.stack, 0)
+	ep.Broadcast = true
+	ep.BroadcastNIC = srv.nicID
+	if err := ep.Bind(stack.FullAddress{Port: ServerPort}); err != nil {
+		ep.Close()
+		return err
+	}
+
+	srv.mu.Lock()
+	srv.ep = ep
+	srv.done = make(chan struct{})
+	srv.mu.Unlock()
+	srv.logger.Info("DHCP server on %s, pool %s-%s", net.IP(srv.address[:]),
+		net.IP(srv.PoolStart[:]), net.IP(srv.PoolEnd[:]))
+
+	go srv.serve(ep)
+	return nil
+}
+
+// Close 停止服务器
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	ep, done := srv.ep, srv.done
+	srv.ep = nil
+	srv.mu.Unlock()
+
+	if ep == nil {
+		return nil
+	}
+	err := ep.Close()
+	<-done
+	return err
+}
+
+// serve 接收并回复客户端消息，端点关闭时返回
+func (srv *Server) serve(ep *udp.Endpoint) {
+	defer close(srv.done)
+
+	for {
+		data, from, err := ep.RecvFrom()
+		if errors.Is(err, udp.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		req := &Message{}
+		if err := req.Unmarshal(data); err != nil {
+			srv.logger.Debug("DHCP server: dropping message from %s: %v", from, err)
+			continue
+		}
+		if req.Op != OpRequest || req.HardwareType != HardwareTypeEthernet || req.HardwareLen != 6 {
+			continue
+		}
+		srv.logger.Debug("DHCP server: received %s", req)
+
+		reply := srv.handle(req, time.Now())
+		if reply == nil {
+			continue
+		}
+
+		// 续租和INFORM单播到客户端地址，其余（含NAK）广播
+		dst := limitedBroadcast
+		if req.ClientIP != [4]byte{} && reply.Type() != TypeNak {
+			dst = req.ClientIP
+		}
+		out, err := reply.Marshal()
+		if err != nil {
+			srv.logger.Warn("DHCP server: %v", err)
+			continue
+		}
+		srv.logger.Debug("DHCP server: sending %s to %s", reply, net.IP(dst[:]))
+		if _, err := ep.SendTo(out, &stack.FullAddress{IP: dst, Port: ClientPort}); err != nil {
+			srv.logger.Debug("DHCP server: failed to send %s: %v", TypeName(reply.Type()), err)
+		}
+	}
+}
+
+// init 确定服务器地址和前缀，检查地址池并加载租约文件
+func (srv *Server) init() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	found := false
+	for _, a := range srv.stack.Addresses(srv.nicID) {
+		if a.Contains(srv.PoolStart) {
+			srv.address, srv.prefix = a.IP, a.PrefixLength
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("NIC %d has no address in the subnet of %s", srv.nicID, net.IP(srv.PoolStart[:]))
+	}
+	if srv.PrefixLength > 0 {
+		srv.prefix = srv.PrefixLength
+	}
+
+	subnet := stack.Address{IP: srv.address, PrefixLength: srv.prefix}
+	if !subnet.Contains(srv.PoolEnd) || ipToUint32(srv.PoolStart) > ipToUint32(srv.PoolEnd) {
+		return fmt.Errorf("invalid address pool %s-%s for %s",
+			net.IP(srv.PoolStart[:]), net.IP(srv.PoolEnd[:]), subnet)
+	}
+
+	if srv.LeaseFile != "" {
+		if err := srv.loadLocked(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle 处理一个客户端消息，返回需要发送的回复
+func (srv *Server) handle(req *Message, now time.Time) *Message {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	mac := req.ClientMAC
+	switch req.Type() {
+	case TypeDiscover:
+		requested, _ := req.IPOption(OptionRequestedIP)
+		addr, ok := srv.allocateLocked(mac, requested, now)
+		if !ok {
+			srv.logger.Warn("DHCP server: no free address for %s", net.HardwareAddr(mac[:]))
+			return nil
+		}
+		srv.bindings[addr] = &Binding{
+			MAC:      mac,
+			Address:  addr,
+			HostName: string(req.Option(OptionHostName)),
+			Expiry:   now.Add(offerTimeout),
+			Offered:  true,
+		}
+		return srv.replyLocked(req, TypeOffer, addr)
+
+	case TypeRequest:
+		// SELECTING：客户端选择了服务器标识对应的服务器
+		if id, ok := req.IPOption(OptionServerID); ok {
+			if id != srv.address {
+				srv.forgetOfferLocked(mac)
+				return nil
+			}
+			requested, _ := req.IPOption(OptionRequestedIP)
+			return srv.commitLocked(req, requested, now)
+		}
+		// RENEWING/REBINDING带ciaddr，INIT-REBOOT带请求地址选项
+		addr := req.ClientIP
+		if addr == [4]byte{} {
+			var ok bool
+			if addr, ok = req.IPOption(OptionRequestedIP); !ok {
+				return nil
+			}
+		}
+		return srv.commitLocked(req, addr, now)
+
+	case TypeDecline:
+		addr, _ := req.IPOption(OptionRequestedIP)
+		if b := srv.bindings[addr]; b != nil && b.MAC == mac {
+			srv.logger.Warn("DHCP server: %s declined by %s", net.IP(addr[:]), net.HardwareAddr(mac[:]))
+			delete(srv.bindings, addr)
+			srv.declined[addr] = now.Add(declineTimeout)
+			srv.saveLocked()
+		}
+
+	case TypeRelease:
+		if b := srv.bindings[req.ClientIP]; b != nil && b.MAC == mac && !b.Offered {
+			srv.logger.Info("DHCP server: %s released by %s", net.IP(req.ClientIP[:]), net.HardwareAddr(mac[:]))
+			b.Expiry = now
+			srv.saveLocked()
+		}
+
+	case TypeInform:
+		if req.ClientIP != [4]byte{} {
+			return srv.replyLocked(req, TypeAck, [4]byte{})
+		}
+	}
+	return nil
+}
+
+// commitLocked 确认客户端请求的地址，地址不可用时回复NAK
+func (srv *Server) commitLocked(req *Message, addr [4]byte, now time.Time) *Message {
+	mac := req.ClientMAC
+	if !srv.availableLocked(mac, addr, now) {
+		srv.logger.Info("DHCP server: NAK %s for %s", net.IP(addr[:]), net.HardwareAddr(mac[:]))
+		nak := srv.replyLocked(req, TypeNak, [4]byte{})
+		nak.SetOption(OptionMessage, []byte("requested address not available"))
+		return nak
+	}
+
+	// 同一客户端只保留一个地址
+	for a, b := range srv.bindings {
+		if b.MAC == mac && a != addr {
+			delete(srv.bindings, a)
+		}
+	}
+	hostName := string(req.Option(OptionHostName))
+	if b := srv.bindings[addr]; b != nil && hostName == "" {
+		hostName = b.HostName
+	}
+	srv.bindings[addr] = &Binding{
+		MAC:      mac,
+		Address:  addr,
+		HostName: hostName,
+		Expiry:   now.Add(srv.leaseTime()),
+	}
+	srv.saveLocked()
+
+	srv.logger.Info("DHCP server: %s bound to %s", net.IP(addr[:]), net.HardwareAddr(mac[:]))
+	return srv.replyLocked(req, TypeAck, addr)
+}
+
+// allocateLocked 为DISCOVER选择地址：固定分配、客户端原有地址、请求的地址、从未分配的地址、已过期的地址
+func (srv *Server) allocateLocked(mac [6]byte, requested [4]byte, now time.Time) ([4]byte, bool) {
+	if addr, ok := srv.Reservations[mac]; ok {
+		return addr, srv.availableLocked(mac, addr, now)
+	}
+	for addr, b := range srv.bindings {
+		if b.MAC == mac && srv.availableLocked(mac, addr, now) {
+			return addr, true
+		}
+	}
+	if requested != [4]byte{} && srv.availableLocked(mac, requested, now) {
+		return requested, true
+	}
+
+	start, end := ipToUint32(srv.PoolStart), ipToUint32(srv.PoolEnd)
+	var expired [4]byte
+	var oldest time.Time
+	for i := start; i <= end && i >= start; i++ {
+		addr := uint32ToIP(i)
+		if !srv.availableLocked(mac, addr, now) {
+			continue
+		}
+		b := srv.bindings[addr]
+		if b == nil {
+			return addr, true
+		}
+		if oldest.IsZero() || b.Expiry.Before(oldest) {
+			expired, oldest = addr, b.Expiry
+		}
+	}
+	return expired, !oldest.IsZero()
+}
+
+// availableLocked 检查地址能否分配给客户端
+func (srv *Server) availableLocked(mac [6]byte, addr [4]byte, now time.Time) bool {
+	if reserved, ok := srv.Reservations[mac]; ok {
+		return addr == reserved
+	}
+	for other, reserved := range srv.Reservations {
+		if reserved == addr && other != mac {
+			return false
+		}
+	}
+
+	v := ipToUint32(addr)
+	if v < ipToUint32(srv.PoolStart) || v > ipToUint32(srv.PoolEnd) {
+		return false
+	}
+	if srv.stack.IsLocalAddress(addr) || srv.stack.IsBroadcastAddress(addr) {
+		return false
+	}
+	if until, ok := srv.declined[addr]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(srv.declined, addr)
+	}
+	b := srv.bindings[addr]
+	return b == nil || b.MAC == mac || !now.Before(b.Expiry)
+}
+
+// forgetOfferLocked 客户端选择了其他服务器，释放为其保留的地址
+func (srv *Server) forgetOfferLocked(mac [6]byte) {
+	for addr, b := range srv.bindings {
+		if b.MAC == mac && b.Offered {
+			delete(srv.bindings, addr)
+		}
+	}
+}
+
+// replyLocked 构造回复；NAK只带服务器标识，INFORM的ACK不带地址和租期
+func (srv *Server) replyLocked(req *Message, msgType uint8, addr [4]byte) *Message {
+	m := NewMessage(msgType, req.XID, req.ClientMAC)
+	m.Op = OpReply
+	m.Flags = req.Flags
+	m.SetIPOption(OptionServerID, srv.address)
+	if msgType == TypeNak {
+		return m
+	}
+
+	m.YourIP = addr
+	if req.Type() == TypeInform {
+		m.ClientIP = req.ClientIP
+	} else {
+		lease := srv.leaseTime()
+		m.SetUint32Option(OptionLeaseTime, uint32(lease/time.Second))
+		m.SetUint32Option(OptionRenewalTime, uint32(lease/2/time.Second))
+		m.SetUint32Option(OptionRebindingTime, uint32(lease*7/8/time.Second))
+	}
+	mask := net.CIDRMask(srv.prefix, 32)
+	m.SetOption(OptionSubnetMask, mask)
+	if len(srv.Routers) > 0 {
+		m.SetIPListOption(OptionRouter, srv.Routers)
+	}
+	if len(srv.DNSServers) > 0 {
+		m.SetIPListOption(OptionDNSServer, srv.DNSServers)
+	}
+	if srv.DomainName != "" {
+		m.SetOption(OptionDomainName, []byte(srv.DomainName))
+	}
+	return m
+}
+
+func (srv *Server) leaseTime() time.Duration {
+	if srv.LeaseTime <= 0 {
+		return DefaultLeaseTime
+	}
+	return max(srv.LeaseTime, time.Second)
+}
+
+// saveLocked 将已确认的租约写入LeaseFile（先写临时文件再改名）
+func (srv *Server) saveLocked() {
+	if srv.LeaseFile == "" {
+		return
+	}
+
+	records := make([]bindingRecord, 0, len(srv.bindings))
+	for _, b := range srv.bindings {
+		if b.Offered {
+			continue
+		}
+		records = append(records, bindingRecord{
+			MAC:      net.HardwareAddr(b.MAC[:]).String(),
+			Address:  net.IP(b.Address[:]).String(),
+			HostName: b.HostName,
+			Expiry:   b.Expiry,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Address < records[j].Address })
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		tmp := srv.LeaseFile + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, srv.LeaseFile)
+		}
+	}
+	if err != nil {
+		srv.logger.Warn("DHCP server: failed to save leases: %v", err)
+	}
+}
+
+// loadLocked 从LeaseFile加载租约
+func (srv *Server) loadLocked() error {
+	data, err := os.ReadFile(srv.LeaseFile)
+	if err != nil {
+		return err
+	}
+	var records []bindingRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("%s: %w", srv.LeaseFile, err)
+	}
+
+	for _, r := range records {
+		hw, err := net.ParseMAC(r.MAC)
+		ip := net.ParseIP(r.Address).To4()
+		if err != nil || len(hw) != 6 || ip == nil {
+			return fmt.Errorf("%s: invalid lease %s %s", srv.LeaseFile, r.MAC, r.Address)
+		}
+		b := &Binding{HostName: r.HostName, Expiry: r.Expiry}
+		copy(b.MAC[:], hw)
+		copy(b.Address[:], ip)
+		srv.bindings[b.Address] = b
+	}
+	return nil
+}
+
+func ipToUint32(addr [4]byte) uint32 {
+	return binary.BigEndian.Uint32(addr[:])
+}
+
+func uint32ToIP(v uint32) [4]byte {
+	var addr [4]byte
+	binary.BigEndian.PutUint32(addr[:], v)
+	return addr
+}
@@ -0,0 +1,607 @@
+// Package dns 在ustack UDP/TCP上实现DNS消息编解码、存根解析器和权威服务器（RFC 1035）
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// DNS端口
+	Port = 53
+
+	// UDP消息的最大长度（RFC 1035 4.2.1），更长的回复被截断并设置TC标志
+	MaxUDPMessageLength = 512
+
+	// 头部长度
+	headerLength = 12
+
+	// 域名和标签的最大长度
+	maxNameLength  = 255
+	maxLabelLength = 63
+)
+
+// 资源记录类型
+const (
+	TypeA     = 1
+	TypeNS    = 2
+	TypeCNAME = 5
+	TypeSOA   = 6
+	TypePTR   = 12
+	TypeMX    = 15
+	TypeTXT   = 16
+	TypeAAAA  = 28
+	TypeSRV   = 33
+	TypeANY   = 255
+)
+
+// ClassINET 互联网类
+const ClassINET = 1
+
+// 操作码与响应码
+const (
+	OpcodeQuery = 0
+
+	RCodeSuccess        = 0 // NOERROR
+	RCodeFormatError    = 1 // FORMERR
+	RCodeServerFailure  = 2 // SERVFAIL
+	RCodeNameError      = 3 // NXDOMAIN
+	RCodeNotImplemented = 4 // NOTIMP
+	RCodeRefused        = 5 // REFUSED
+)
+
+var (
+	// ErrTruncatedMessage 消息在字段中间结束
+	ErrTruncatedMessage = errors.New("DNS message truncated")
+)
+
+// Question 问题段中的一项
+type Question struct {
+	Name  string // 完全限定域名，以"."结尾
+	Type  uint16
+	Class uint16
+}
+
+// Resource 资源记录，按类型使用对应的数据字段
+type Resource struct {
+	Name  string // 完全限定域名，以"."结尾
+	Type  uint16
+	Class uint16
+	TTL   uint32 // 生存时间（秒）
+
+	A    [4]byte  // A记录地址
+	AAAA [16]byte // AAAA记录地址
+
+	Target   string   // CNAME、PTR、NS的目标域名，SRV的目标主机，MX的邮件交换主机
+	Priority uint16   // SRV优先级，MX优先级
+	Weight   uint16   // SRV权重
+	Port     uint16   // SRV端口
+	Text     []string // TXT字符串
+
+	// SOA字段
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32 // 否定应答的缓存时间（RFC 2308）
+
+	Data []byte // 其他类型的原始数据
+}
+
+// Message DNS消息
+type Message struct {
+	ID                 uint16
+	Response           bool // QR
+	Opcode             uint8
+	Authoritative      bool // AA
+	Truncated          bool // TC
+	RecursionDesired   bool // RD
+	RecursionAvailable bool // RA
+	RCode              uint8
+
+	Questions   []Question
+	Answers     []Resource
+	Authorities []Resource
+	Additionals []Resource
+}
+
+// NewQuery 创建期望递归的查询
+func NewQuery(id uint16, name string, qtype uint16) *Message {
+	return &Message{
+		ID:               id,
+		RecursionDesired: true,
+		Questions:        []Question{{Name: Fqdn(name), Type: qtype, Class: ClassINET}},
+	}
+}
+
+// Fqdn 返回以"."结尾的小写完全限定域名
+func Fqdn(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// Marshal 将消息序列化为字节数组，域名使用压缩指针
+func (m *Message) Marshal() ([]byte, error) {
+	b := &builder{data: make([]byte, headerLength, 512), names: make(map[string]int)}
+
+	binary.BigEndian.PutUint16(b.data[0:2], m.ID)
+	flags := uint16(m.Opcode&0x0f)<<11 | uint16(m.RCode&0x0f)
+	if m.Response {
+		flags |= 1 << 15
+	}
+	if m.Authoritative {
+		flags |= 1 << 10
+	}
+	if m.Truncated {
+		flags |= 1 << 9
+	}
+	if m.RecursionDesired {
+		flags |= 1 << 8
+	}
+	if m.RecursionAvailable {
+		flags |= 1 << 7
+	}
+	binary.BigEndian.PutUint16(b.data[2:4], flags)
+	binary.BigEndian.PutUint16(b.data[4:6], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b.data[6:8], uint16(len(m.Answers)))
+	binary.BigEndian.PutUint16(b.data[8:10], uint16(len(m.Authorities)))
+	binary.BigEndian.PutUint16(b.data[10:12], uint16(len(m.Additionals)))
+
+	for _, q := range m.Questions {
+		if err := b.name(q.Name); err != nil {
+			return nil, err
+		}
+		b.uint16(q.Type)
+		b.uint16(q.Class)
+	}
+	for _, section := range [][]Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range section {
+			if err := b.resource(&section[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(b.data) > 0xffff {
+		return nil, fmt.Errorf("DNS message too large: %d bytes", len(b.data))
+	}
+	return b.data, nil
+}
+
+// Unmarshal 从字节数组解析消息
+func (m *Message) Unmarshal(data []byte) error {
+	if len(data) < headerLength {
+		return ErrTruncatedMessage
+	}
+
+	m.ID = binary.BigEndian.Uint16(data[0:2])
+	flags := binary.BigEndian.Uint16(data[2:4])
+	m.Response = flags&(1<<15) != 0
+	m.Opcode = uint8(flags>>11) & 0x0f
+	m.Authoritative = flags&(1<<10) != 0
+	m.Truncated = flags&(1<<9) != 0
+	m.RecursionDesired = flags&(1<<8) != 0
+	m.RecursionAvailable = flags&(1<<7) != 0
+	m.RCode = uint8(flags & 0x0f)
+
+	p := &parser{data: data, offset: headerLength}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(data[4+2*i:]))
+	}
+
+	m.Questions = nil
+	for i := 0; i < counts[0]; i++ {
+		var q Question
+		var err error
+		if q.Name, err = p.name(); err != nil {
+			return err
+		}
+		if q.Type, err = p.uint16(); err != nil {
+			return err
+		}
+		if q.Class, err = p.uint16(); err != nil {
+			return err
+		}
+		m.Questions = append(m.Questions, q)
+	}
+
+	sections := []*[]Resource{&m.Answers, &m.Authorities, &m.Additionals}
+	for i, section := range sections {
+		*section = nil
+		for j := 0; j < counts[i+1]; j++ {
+			r, err := p.resource()
+			if err != nil {
+				return err
+			}
+			*section = append(*section, r)
+		}
+	}
+	return nil
+}
+
+// String 返回消息的字符串表示（同dig的摘要）
+func (m *Message) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "DNS id=%d %s", m.ID, RCodeName(m.RCode))
+	if m.Response {
+		b.WriteString(" qr")
+	}
+	if m.Authoritative {
+		b.WriteString(" aa")
+	}
+	if m.Truncated {
+		b.WriteString(" tc")
+	}
+	if m.RecursionDesired {
+		b.WriteString(" rd")
+	}
+	if m.RecursionAvailable {
+		b.WriteString(" ra")
+	}
+	for _, q := range m.Questions {
+		fmt.Fprintf(&b, " ? %s %s", q.Name, TypeName(q.Type))
+	}
+	for _, r := range m.Answers {
+		fmt.Fprintf(&b, "; %s", r.String())
+	}
+	return b.String()
+}
+
+// String 返回资源记录的区域文件格式表示
+func (r *Resource) String() string {
+	prefix := fmt.Sprintf("%s %d %s", r.Name, r.TTL, TypeName(r.Type))
+	switch r.Type {
+	case TypeA:
+		return prefix + " " + net.IP(r.A[:]).String()
+	case TypeAAAA:
+		return prefix + " " + net.IP(r.AAAA[:]).String()
+	case TypeCNAME, TypePTR, TypeNS:
+		return prefix + " " + r.Target
+	case TypeMX:
+		return fmt.Sprintf("%s %d %s", prefix, r.Priority, r.Target)
+	case TypeSRV:
+		return fmt.Sprintf("%s %d %d %d %s", prefix, r.Priority, r.Weight, r.Port, r.Target)
+	case TypeTXT:
+		quoted := make([]string, len(r.Text))
+		for i, s := range r.Text {
+			quoted[i] = fmt.Sprintf("%q", s)
+		}
+		return prefix + " " + strings.Join(quoted, " ")
+	case TypeSOA:
+		return fmt.Sprintf("%s %s %s %d %d %d %d %d", prefix, r.MName, r.RName,
+			r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum)
+	}
+	return fmt.Sprintf("%s \\# %d %x", prefix, len(r.Data), r.Data)
+}
+
+// TypeName 返回记录类型的助记符
+func TypeName(t uint16) string {
+	switch t {
+	case TypeA:
+		return "A"
+	case TypeNS:
+		return "NS"
+	case TypeCNAME:
+		return "CNAME"
+	case TypeSOA:
+		return "SOA"
+	case TypePTR:
+		return "PTR"
+	case TypeMX:
+		return "MX"
+	case TypeTXT:
+		return "TXT"
+	case TypeAAAA:
+		return "AAAA"
+	case TypeSRV:
+		return "SRV"
+	case TypeANY:
+		return "ANY"
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// RCodeName 返回响应码的助记符
+func RCodeName(rcode uint8) string {
+	switch rcode {
+	case RCodeSuccess:
+		return "NOERROR"
+	case RCodeFormatError:
+		return "FORMERR"
+	case RCodeServerFailure:
+		return "SERVFAIL"
+	case RCodeNameError:
+		return "NXDOMAIN"
+	case RCodeNotImplemented:
+		return "NOTIMP"
+	case RCodeRefused:
+		return "REFUSED"
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// builder 序列化消息，记录已写出域名的偏移用于压缩
+type builder struct {
+	data  []byte
+	names map[string]int
+}
+
+func (b *builder) uint16(v uint16) {
+	b.data = binary.BigEndian.AppendUint16(b.data, v)
+}
+
+func (b *builder) uint32(v uint32) {
+	b.data = binary.BigEndian.AppendUint32(b.data, v)
+}
+
+// name 写出域名，已写出的后缀用压缩指针代替（RFC 1035 4.1.4）
+func (b *builder) name(name string) error {
+	name = Fqdn(name)
+	if len(name) > maxNameLength {
+		return fmt.Errorf("DNS name too long: %s", name)
+	}
+
+	for name != "." {
+		if offset, ok := b.names[name]; ok {
+			b.uint16(0xc000 | uint16(offset))
+			return nil
+		}
+		if len(b.data) < 0x4000 {
+			b.names[name] = len(b.data)
+		}
+
+		label, rest, _ := strings.Cut(name, ".")
+		if label == "" || len(label) > maxLabelLength {
+			return fmt.Errorf("invalid DNS label in %s", name)
+		}
+		b.data = append(b.data, byte(len(label)))
+		b.data = append(b.data, label...)
+		name = rest
+		if name == "" {
+			name = "."
+		}
+	}
+	b.data = append(b.data, 0)
+	return nil
+}
+
+// resource 写出资源记录，数据长度在写完数据后回填
+func (b *builder) resource(r *Resource) error {
+	if err := b.name(r.Name); err != nil {
+		return err
+	}
+	b.uint16(r.Type)
+	b.uint16(r.Class)
+	b.uint32(r.TTL)
+	lengthOffset := len(b.data)
+	b.uint16(0)
+
+	switch r.Type {
+	case TypeA:
+		b.data = append(b.data, r.A[:]...)
+	case TypeAAAA:
+		b.data = append(b.data, r.AAAA[:]...)
+	case TypeCNAME, TypePTR, TypeNS:
+		if err := b.name(r.Target); err != nil {
+			return err
+		}
+	case TypeMX:
+		b.uint16(r.Priority)
+		if err := b.name(r.Target); err != nil {
+			return err
+		}
+	case TypeSRV:
+		b.uint16(r.Priority)
+		b.uint16(r.Weight)
+		b.uint16(r.Port)
+		// SRV目标不压缩（RFC 2782）
+		names := b.names
+		b.names = map[string]int{}
+		err := b.name(r.Target)
+		b.names = names
+		if err != nil {
+			return err
+		}
+	case TypeTXT:
+		for _, s := range r.Text {
+			if len(s) > 255 {
+				return fmt.Errorf("TXT string too long: %d bytes", len(s))
+			}
+			b.data = append(b.data, byte(len(s)))
+			b.data = append(b.data, s...)
+		}
+	case TypeSOA:
+		if err := b.name(r.MName); err != nil {
+			return err
+		}
+		if err := b.name(r.RName); err != nil {
+			return err
+		}
+		b.uint32(r.Serial)
+		b.uint32(r.Refresh)
+		b.uint32(r.Retry)
+		b.uint32(r.Expire)
+		b.uint32(r.Minimum)
+	default:
+		b.data = append(b.data, r.Data...)
+	}
+
+	length := len(b.data) - lengthOffset - 2
+	if length > 0xffff {
+		return fmt.Errorf("DNS record data too long: %d bytes", length)
+	}
+	binary.BigEndian.PutUint16(b.data[lengthOffset:], uint16(length))
+	return nil
+}
+
+// parser 解析消息
+type parser struct {
+	data   []byte
+	offset int
+}
+
+func (p *parser) uint16() (uint16, error) {
+	if p.offset+2 > len(p.data) {
+		return 0, ErrTruncatedMessage
+	}
+	v := binary.BigEndian.Uint16(p.data[p.offset:])
+	p.offset += 2
+	return v, nil
+}
+
+func (p *parser) uint32() (uint32, error) {
+	if p.offset+4 > len(p.data) {
+		return 0, ErrTruncatedMessage
+	}
+	v := binary.BigEndian.Uint32(p.data[p.offset:])
+	p.offset += 4
+	return v, nil
+}
+
+// name 解析域名，跟随压缩指针；指针只能指向更前面的位置以避免循环
+func (p *parser) name() (string, error) {
+	var b strings.Builder
+	offset := p.offset
+	jumped := false
+
+	for {
+		if offset >= len(p.data) {
+			return "", ErrTruncatedMessage
+		}
+		length := int(p.data[offset])
+		switch {
+		case length == 0:
+			if !jumped {
+				p.offset = offset + 1
+			}
+			if b.Len() == 0 {
+				return ".", nil
+			}
+			return strings.ToLower(b.String()), nil
+
+		case length&0xc0 == 0xc0:
+			if offset+2 > len(p.data) {
+				return "", ErrTruncatedMessage
+			}
+			target := int(binary.BigEndian.Uint16(p.data[offset:]) & 0x3fff)
+			if target >= offset {
+				return "", fmt.Errorf("invalid DNS compression pointer")
+			}
+			if !jumped {
+				p.offset = offset + 2
+				jumped = true
+			}
+			offset = target
+
+		case length > maxLabelLength:
+			return "", fmt.Errorf("invalid DNS label length %d", length)
+
+		default:
+			if offset+1+length > len(p.data) {
+				return "", ErrTruncatedMessage
+			}
+			b.Write(p.data[offset+1 : offset+1+length])
+			b.WriteByte('.')
+			if b.Len() > maxNameLength {
+				return "", fmt.Errorf("DNS name too long")
+			}
+			offset += 1 + length
+		}
+	}
+}
+
+// resource 解析资源记录
+func (p *parser) resource() (Resource, error) {
+	var r Resource
+	var err error
+	if r.Name, err = p.name(); err != nil {
+		return r, err
+	}
+	if r.Type, err = p.uint16(); err != nil {
+		return r, err
+	}
+	if r.Class, err = p.uint16(); err != nil {
+		return r, err
+	}
+	if r.TTL, err = p.uint32(); err != nil {
+		return r, err
+	}
+	length, err := p.uint16()
+	if err != nil {
+		return r, err
+	}
+	end := p.offset + int(length)
+	if end > len(p.data) {
+		return r, ErrTruncatedMessage
+	}
+
+	// 数据中的域名可能指向消息中的其他位置，因此在完整消息上解析，之后检查没有越过数据长度
+	switch r.Type {
+	case TypeA:
+		if length != 4 {
+			return r, fmt.Errorf("invalid A record length %d", length)
+		}
+		copy(r.A[:], p.data[p.offset:end])
+	case TypeAAAA:
+		if length != 16 {
+			return r, fmt.Errorf("invalid AAAA record length %d", length)
+		}
+		copy(r.AAAA[:], p.data[p.offset:end])
+	case TypeCNAME, TypePTR, TypeNS:
+		r.Target, err = p.name()
+	case TypeMX:
+		if r.Priority, err = p.uint16(); err == nil {
+			r.Target, err = p.name()
+		}
+	case TypeSRV:
+		if r.Priority, err = p.uint16(); err != nil {
+			return r, err
+		}
+		if r.Weight, err = p.uint16(); err != nil {
+			return r, err
+		}
+		if r.Port, err = p.uint16(); err != nil {
+			return r, err
+		}
+		r.Target, err = p.name()
+	case TypeTXT:
+		for i := p.offset; i < end; {
+			n := int(p.data[i])
+			if i+1+n > end {
+				return r, ErrTruncatedMessage
+			}
+			r.Text = append(r.Text, string(p.data[i+1:i+1+n]))
+			i += 1 + n
+		}
+	case TypeSOA:
+		if r.MName, err = p.name(); err != nil {
+			return r, err
+		}
+		if r.RName, err = p.name(); err != nil {
+			return r, err
+		}
+		for _, v := range []*uint32{&r.Serial, &r.Refresh, &r.Retry, &r.Expire, &r.Minimum} {
+			if *v, err = p.uint32(); err != nil {
+				return r, err
+			}
+		}
+	default:
+		r.Data = append([]byte(nil), p.data[p.offset:end]...)
+	}
+	if err != nil {
+		return r, err
+	}
+	if p.offset > end {
+		return r, fmt.Errorf("%s record overruns its data", TypeName(r.Type))
+	}
+	p.offset = end
+	return r, nil
+}
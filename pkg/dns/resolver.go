@@ -0,0 +1,493 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/gonet"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+const (
+	// 默认每次查询等待回复的时间与每个服务器的尝试次数（同resolv.conf的timeout和attempts）
+	DefaultTimeout  = 2 * time.Second
+	DefaultAttempts = 2
+
+	// 跟随CNAME的最大次数
+	maxCNAMEChain = 8
+
+	// 缓存的最大条目数
+	maxCacheEntries = 1024
+)
+
+var (
+	// ErrNoServers 没有配置DNS服务器
+	ErrNoServers = errors.New("no DNS servers configured")
+
+	// ErrNotFound 域名不存在（NXDOMAIN）
+	ErrNotFound = errors.New("no such host")
+
+	// ErrNoData 域名存在但没有请求类型的记录
+	ErrNoData = errors.New("no records of the requested type")
+
+	// ErrServerFailure 所有服务器都返回了SERVFAIL、REFUSED等错误
+	ErrServerFailure = errors.New("DNS server failure")
+
+	// ErrTimeout 所有服务器都没有回复
+	ErrTimeout = errors.New("DNS query timed out")
+)
+
+// cacheKey 缓存按域名和类型索引
+type cacheKey struct {
+	name  string
+	qtype uint16
+}
+
+// cacheEntry 肯定应答保存记录，否定应答保存错误
+type cacheEntry struct {
+	answers []Resource
+	err     error
+	expires time.Time
+}
+
+// Resolver 存根解析器，向配置的服务器发送递归查询
+//
+// 按顺序尝试每个服务器，全部失败后重复Attempts轮；UDP回复被截断时改用TCP重新查询。
+// 应答按最小TTL缓存，NXDOMAIN和无数据应答按SOA的否定缓存时间缓存（RFC 2308）。
+// 命中缓存的否定应答只返回ErrNotFound或ErrNoData，不带SOA记录，调用方无法得知其剩余的缓存时间。
+// Resolver可以被多个goroutine同时使用。
+type Resolver struct {
+	Servers  []stack.FullAddress // DNS服务器，端口为0时使用53；为空时使用协议栈的DNS服务器（如DHCP获得的）
+	Timeout  time.Duration       // 每次查询等待回复的时间，0表示DefaultTimeout
+	Attempts int                 // 尝试的轮数，0表示DefaultAttempts
+
+	stack *stack.Stack
+
+	mu    sync.Mutex
+	cache map[cacheKey]*cacheEntry
+
+	logger *utils.Logger
+}
+
+// NewResolver 创建在协议栈s上查询的解析器
+func NewResolver(s *stack.Stack) *Resolver {
+	return &Resolver{
+		stack:  s,
+		cache:  make(map[cacheKey]*cacheEntry),
+		logger: utils.DefaultLogger,
+	}
+}
+
+// LookupHost 返回主机的IPv4地址，跟随CNAME；IP地址字面量和localhost不查询服务器
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([][4]byte, error) {
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		return [][4]byte{[4]byte(ip)}, nil
+	}
+	// localhost及其子域总是解析为环回地址（RFC 6761 6.3）
+	if name := Fqdn(host); name == "localhost." || strings.HasSuffix(name, ".localhost.") {
+		return [][4]byte{{127, 0, 0, 1}}, nil
+	}
+
+	records, _, err := r.lookupChain(ctx, host, TypeA)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([][4]byte, len(records))
+	for i, rr := range records {
+		addrs[i] = rr.A
+	}
+	return addrs, nil
+}
+
+// LookupIPv6 返回主机的IPv6地址（AAAA记录），跟随CNAME
+func (r *Resolver) LookupIPv6(ctx context.Context, host string) ([][16]byte, error) {
+	records, _, err := r.lookupChain(ctx, host, TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([][16]byte, len(records))
+	for i, rr := range records {
+		addrs[i] = rr.AAAA
+	}
+	return addrs, nil
+}
+
+// LookupCNAME 返回跟随CNAME链后的规范名
+func (r *Resolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	_, canonical, err := r.lookupChain(ctx, host, TypeA)
+	if errors.Is(err, ErrNoData) {
+		err = nil
+	}
+	return canonical, err
+}
+
+// LookupAddr 返回地址对应的域名（PTR记录）
+func (r *Resolver) LookupAddr(ctx context.Context, addr [4]byte) ([]string, error) {
+	records, _, err := r.lookupChain(ctx, ReverseName(addr), TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(records))
+	for i, rr := range records {
+		names[i] = rr.Target
+	}
+	return names, nil
+}
+
+// LookupSRV 查询_service._proto.name的SRV记录，按优先级升序、权重降序排列；service和proto都为空时直接查询name
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) ([]Resource, error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	records, _, err := r.lookupChain(ctx, target, TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+	return records, nil
+}
+
+// LookupTXT 返回TXT记录，每条记录的多个字符串拼接为一个
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, _, err := r.lookupChain(ctx, name, TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(records))
+	for i, rr := range records {
+		texts[i] = strings.Join(rr.Text, "")
+	}
+	return texts, nil
+}
+
+// lookupChain 查询name的qtype记录，跟随应答中或需要再次查询的CNAME，返回记录和规范名
+func (r *Resolver) lookupChain(ctx context.Context, name string, qtype uint16) ([]Resource, string, error) {
+	target := Fqdn(name)
+	for queries := 0; queries < maxCNAMEChain; queries++ {
+		answers, err := r.Query(ctx, target, qtype)
+		if err != nil {
+			return nil, target, fmt.Errorf("lookup %s: %w", name, err)
+		}
+
+		// 在同一应答中沿CNAME链查找
+		queried := target
+		for hops := 0; hops <= maxCNAMEChain; hops++ {
+			var records []Resource
+			next := ""
+			for _, rr := range answers {
+				if rr.Name != target || rr.Class != ClassINET {
+					continue
+				}
+				if rr.Type == qtype {
+					records = append(records, rr)
+				} else if rr.Type == TypeCNAME {
+					next = rr.Target
+				}
+			}
+			if len(records) > 0 {
+				return records, target, nil
+			}
+			if next == "" {
+				break
+			}
+			target = next
+		}
+
+		// 应答只包含CNAME时查询其目标
+		if target != queried && !hasName(answers, target) {
+			continue
+		}
+		return nil, target, fmt.Errorf("lookup %s: %w", name, ErrNoData)
+	}
+	return nil, target, fmt.Errorf("lookup %s: CNAME chain too long", name)
+}
+
+// Query 查询name的qtype记录，返回应答段；NXDOMAIN返回ErrNotFound，没有记录返回ErrNoData
+func (r *Resolver) Query(ctx context.Context, name string, qtype uint16) ([]Resource, error) {
+	key := cacheKey{Fqdn(name), qtype}
+	if answers, err, ok := r.cached(key); ok {
+		return answers, err
+	}
+
+	reply, err := r.exchange(ctx, key.name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.RCode == RCodeNameError {
+		err = ErrNotFound
+	} else if len(reply.Answers) == 0 {
+		err = ErrNoData
+	}
+	r.store(key, reply, err)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Answers, nil
+}
+
+// cached 返回未过期的缓存应答，记录的TTL改为剩余时间
+func (r *Resolver) cached(key cacheKey) ([]Resource, error, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.cache[key]
+	if !ok {
+		return nil, nil, false
+	}
+	remaining := time.Until(e.expires)
+	if remaining <= 0 {
+		delete(r.cache, key)
+		return nil, nil, false
+	}
+	if e.err != nil {
+		return nil, e.err, true
+	}
+	answers := make([]Resource, len(e.answers))
+	copy(answers, e.answers)
+	for i := range answers {
+		answers[i].TTL = uint32((remaining + time.Second - 1) / time.Second)
+	}
+	return answers, nil, true
+}
+
+// store 缓存应答：肯定应答取记录的最小TTL，否定应答取SOA的TTL和MINIMUM中的较小值，没有SOA时不缓存
+func (r *Resolver) store(key cacheKey, reply *Message, err error) {
+	var ttl uint32
+	if err == nil {
+		ttl = reply.Answers[0].TTL
+		for _, rr := range reply.Answers {
+			ttl = min(ttl, rr.TTL)
+		}
+	} else {
+		for _, rr := range reply.Authorities {
+			if rr.Type == TypeSOA {
+				ttl = min(rr.TTL, rr.Minimum)
+				break
+			}
+		}
+	}
+	if ttl == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.cache) >= maxCacheEntries {
+		now := time.Now()
+		for k, e := range r.cache {
+			if now.After(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+		// 仍然已满时任意淘汰一项
+		for k := range r.cache {
+			if len(r.cache) < maxCacheEntries {
+				break
+			}
+			delete(r.cache, k)
+		}
+	}
+	r.cache[key] = &cacheEntry{
+		answers: reply.Answers,
+		err:     err,
+		expires: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// servers 返回要查询的服务器
+func (r *Resolver) servers() []stack.FullAddress {
+	if len(r.Servers) > 0 {
+		servers := make([]stack.FullAddress, len(r.Servers))
+		for i, s := range r.Servers {
+			if s.Port == 0 {
+				s.Port = Port
+			}
+			servers[i] = s
+		}
+		return servers
+	}
+
+	var servers []stack.FullAddress
+	for _, ip := range r.stack.DNSServers() {
+		servers = append(servers, stack.FullAddress{IP: ip, Port: Port})
+	}
+	return servers
+}
+
+// exchange 依次向各服务器发送查询，返回第一个成功或NXDOMAIN的回复
+func (r *Resolver) exchange(ctx context.Context, name string, qtype uint16) (*Message, error) {
+	servers := r.servers()
+	if len(servers) == 0 {
+		return nil, ErrNoServers
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+
+	ep := udp.NewEndpoint(r.stack, 0)
+	defer ep.Close()
+	if err := ep.Bind(stack.FullAddress{}); err != nil {
+		return nil, err
+	}
+
+	lastErr := ErrTimeout
+	for attempt := 0; attempt < attempts; attempt++ {
+		for _, server := range servers {
+			query := NewQuery(uint16(rand.Uint32()), name, qtype)
+			reply, err := r.exchangeUDP(ctx, ep, server, query, timeout)
+			if err == nil && reply.Truncated {
+				r.logger.Debug("DNS: truncated reply from %s, retrying over TCP", server)
+				reply, err = r.exchangeTCP(ctx, server, query, timeout)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				r.logger.Debug("DNS: query %s %s to %s failed: %v", name, TypeName(qtype), server, err)
+				lastErr = err
+				continue
+			}
+
+			switch reply.RCode {
+			case RCodeSuccess, RCodeNameError:
+				return reply, nil
+			}
+			lastErr = fmt.Errorf("%w: %s from %s", ErrServerFailure, RCodeName(reply.RCode), server)
+		}
+	}
+	return nil, lastErr
+}
+
+// exchangeUDP 发送查询并等待ID和问题匹配的回复
+func (r *Resolver) exchangeUDP(ctx context.Context, ep *udp.Endpoint, server stack.FullAddress, query *Message, timeout time.Duration) (*Message, error) {
+	data, err := query.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ep.SendTo(data, &server); err != nil {
+		return nil, err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		data, from, err := ep.RecvFromUntil(waitCtx.Done())
+		if errors.Is(err, udp.ErrCanceled) {
+			return nil, ErrTimeout
+		}
+		if err != nil {
+			// 如服务器端口不可达
+			return nil, err
+		}
+		if from != server {
+			continue
+		}
+
+		reply := &Message{}
+		if err := reply.Unmarshal(data); err != nil {
+			r.logger.Debug("DNS: dropping reply from %s: %v", from, err)
+			continue
+		}
+		if matches(query, reply) {
+			return reply, nil
+		}
+	}
+}
+
+// exchangeTCP 通过TCP发送查询，消息前加两字节长度（RFC 1035 4.2.2）
+func (r *Resolver) exchangeTCP(ctx context.Context, server stack.FullAddress, query *Message, timeout time.Duration) (*Message, error) {
+	data, err := query.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &gonet.Dialer{Stack: r.stack, Timeout: timeout}
+	address := net.JoinHostPort(net.IP(server.IP[:]).String(), strconv.Itoa(int(server.Port)))
+	c, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := c.Write(binary.BigEndian.AppendUint16(nil, uint16(len(data)))); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(data); err != nil {
+		return nil, err
+	}
+	buf, err := readTCPMessage(c)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &Message{}
+	if err := reply.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+	if !matches(query, reply) {
+		return nil, fmt.Errorf("mismatched DNS reply from %s", server)
+	}
+	return reply, nil
+}
+
+// readTCPMessage 读取一个带两字节长度前缀的消息
+func readTCPMessage(rd io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(rd, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(rd, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// hasName 检查应答中是否有名为name的记录
+func hasName(answers []Resource, name string) bool {
+	for _, rr := range answers {
+		if rr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matches 检查回复的ID和问题是否与查询一致
+func matches(query, reply *Message) bool {
+	if !reply.Response || reply.ID != query.ID || len(reply.Questions) != 1 {
+		return false
+	}
+	q, a := query.Questions[0], reply.Questions[0]
+	return strings.EqualFold(q.Name, a.Name) && q.Type == a.Type && q.Class == a.Class
+}
+
+// ReverseName 返回IPv4地址的反向解析域名，如4.3.2.1.in-addr.arpa.
+func ReverseName(addr [4]byte) string {
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", addr[3], addr[2], addr[1], addr[0])
+}
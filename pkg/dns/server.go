@@ -0,0 +1,348 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/gonet"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+// TCP连接上两个查询之间的最长空闲时间
+const tcpIdleTimeout = 10 * time.Second
+
+// Server 权威DNS服务器，在UDP和TCP的同一端口上回答所加载区域内的查询
+//
+// 回答区域内的A、AAAA、CNAME、NS、PTR、MX、SRV、TXT、SOA和ANY查询，跟随区域内的CNAME，
+// 为SRV、MX、NS的目标附加地址记录。域名不存在时回复NXDOMAIN，存在但没有请求类型的记录时回复NOERROR，
+// 两者都在权威段给出区域的SOA；不在任何区域内的查询回复REFUSED。不支持递归、委派和通配符。
+// UDP回复超过MaxUDPMessageLength时去掉附加段，仍然过长时设置TC标志，客户端改用TCP。
+type Server struct {
+	stack *stack.Stack
+
+	mu    sync.RWMutex
+	zones map[string]*Zone // 按区域顶点索引
+
+	connMu sync.Mutex
+	ep     *udp.Endpoint
+	ln     *gonet.TCPListener
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+
+	logger *utils.Logger
+}
+
+// NewServer 创建在协议栈s上运行的DNS服务器
+func NewServer(s *stack.Stack) *Server {
+	return &Server{
+		stack:  s,
+		zones:  make(map[string]*Zone),
+		conns:  make(map[net.Conn]struct{}),
+		logger: utils.DefaultLogger,
+	}
+}
+
+// AddZone 加载区域，替换顶点相同的已有区域；服务器运行时也可以调用
+func (srv *Server) AddZone(z *Zone) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.zones[z.Origin] = z
+}
+
+// RemoveZone 删除顶点为origin的区域，返回区域是否存在
+func (srv *Server) RemoveZone(origin string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	origin = Fqdn(origin)
+	_, ok := srv.zones[origin]
+	delete(srv.zones, origin)
+	return ok
+}
+
+// Zones 返回已加载区域的顶点，按字母顺序排列
+func (srv *Server) Zones() []string {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	origins := make([]string, 0, len(srv.zones))
+	for origin := range srv.zones {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	return origins
+}
+
+// Start 在addr的UDP和TCP端口上开始服务，端口为0时使用53
+func (srv *Server) Start(addr stack.FullAddress) error {
+	if addr.Port == 0 {
+		addr.Port = Port
+	}
+
+	ep := udp.NewEndpoint(srv.stack, 0)
+	if err := ep.Bind(addr); err != nil {
+		ep.Close()
+		return err
+	}
+	ln, err := gonet.ListenTCP(srv.stack, addr)
+	if err != nil {
+		ep.Close()
+		return err
+	}
+
+	srv.connMu.Lock()
+	srv.ep, srv.ln = ep, ln
+	srv.connMu.Unlock()
+	srv.logger.Info("DNS server on %s, zones %s", addr, strings.Join(srv.Zones(), " "))
+
+	srv.wg.Add(2)
+	go srv.serveUDP(ep)
+	go srv.serveTCP(ln)
+	return nil
+}
+
+// Close 停止服务，关闭UDP端点、TCP监听和所有TCP连接
+func (srv *Server) Close() error {
+	srv.connMu.Lock()
+	ep, ln := srv.ep, srv.ln
+	srv.ep, srv.ln = nil, nil
+	for c := range srv.conns {
+		c.Close()
+	}
+	srv.connMu.Unlock()
+
+	if ep == nil {
+		return nil
+	}
+	err := ep.Close()
+	if lnErr := ln.Close(); err == nil {
+		err = lnErr
+	}
+	srv.wg.Wait()
+	return err
+}
+
+// serveUDP 逐个回答UDP查询
+func (srv *Server) serveUDP(ep *udp.Endpoint) {
+	defer srv.wg.Done()
+
+	for {
+		data, from, err := ep.RecvFrom()
+		if errors.Is(err, udp.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		reply := srv.answer(data, from, MaxUDPMessageLength)
+		if reply == nil {
+			continue
+		}
+		if _, err := ep.SendTo(reply, &from); err != nil {
+			srv.logger.Debug("DNS server: failed to reply to %s: %v", from, err)
+		}
+	}
+}
+
+// serveTCP 接受TCP连接，每个连接在单独的goroutine中处理
+func (srv *Server) serveTCP(ln *gonet.TCPListener) {
+	defer srv.wg.Done()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		srv.connMu.Lock()
+		if srv.ln == nil {
+			srv.connMu.Unlock()
+			c.Close()
+			return
+		}
+		srv.conns[c] = struct{}{}
+		srv.wg.Add(1)
+		srv.connMu.Unlock()
+
+		go srv.serveConn(c)
+	}
+}
+
+// serveConn 在一个TCP连接上依次回答带两字节长度前缀的查询，直到对端关闭或空闲超时
+func (srv *Server) serveConn(c net.Conn) {
+	defer srv.wg.Done()
+	defer func() {
+		srv.connMu.Lock()
+		delete(srv.conns, c)
+		srv.connMu.Unlock()
+		c.Close()
+	}()
+
+	addr := c.RemoteAddr().(*net.TCPAddr)
+	from := stack.FullAddress{IP: [4]byte(addr.IP.To4()), Port: uint16(addr.Port)}
+	for {
+		c.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		data, err := readTCPMessage(c)
+		if err != nil {
+			return
+		}
+
+		reply := srv.answer(data, from, 0xffff)
+		if reply == nil {
+			return
+		}
+		if _, err := c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer 解析查询并返回序列化的回复，回复超过limit时截断；无法回复时返回nil
+func (srv *Server) answer(data []byte, from stack.FullAddress, limit int) []byte {
+	query := &Message{}
+	if err := query.Unmarshal(data); err != nil {
+		srv.logger.Debug("DNS server: dropping query from %s: %v", from, err)
+		// 头部完整的查询回复FORMERR
+		if len(data) < headerLength || data[2]&0x80 != 0 {
+			return nil
+		}
+		reply := &Message{
+			ID:       binary.BigEndian.Uint16(data),
+			Response: true,
+			Opcode:   (data[2] >> 3) & 0x0f,
+			RCode:    RCodeFormatError,
+		}
+		out, _ := reply.Marshal()
+		return out
+	}
+	if query.Response {
+		return nil
+	}
+
+	reply := srv.handle(query)
+	srv.logger.Debug("DNS server: %s from %s", reply, from)
+
+	out, err := reply.Marshal()
+	if err == nil && len(out) > limit {
+		reply.Additionals = nil
+		out, err = reply.Marshal()
+	}
+	if err == nil && len(out) > limit {
+		reply.Truncated = true
+		reply.Answers, reply.Authorities = nil, nil
+		out, err = reply.Marshal()
+	}
+	if err != nil {
+		srv.logger.Warn("DNS server: %v", err)
+		return nil
+	}
+	return out
+}
+
+// handle 生成对查询的回复
+func (srv *Server) handle(query *Message) *Message {
+	reply := &Message{
+		ID:               query.ID,
+		Response:         true,
+		Opcode:           query.Opcode,
+		RecursionDesired: query.RecursionDesired,
+		Questions:        query.Questions,
+	}
+	if query.Opcode != OpcodeQuery {
+		reply.RCode = RCodeNotImplemented
+		return reply
+	}
+	if len(query.Questions) != 1 {
+		reply.RCode = RCodeFormatError
+		return reply
+	}
+	q := query.Questions[0]
+
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	zone := srv.zoneLocked(q.Name)
+	if zone == nil || q.Class != ClassINET {
+		reply.RCode = RCodeRefused
+		return reply
+	}
+	reply.Authoritative = true
+
+	name := q.Name
+	for hops := 0; ; hops++ {
+		records := zone.names[name]
+		if len(records) == 0 {
+			if !zone.nodes[name] {
+				reply.RCode = RCodeNameError
+			}
+			reply.Authorities = []Resource{zone.negative()}
+			break
+		}
+
+		// 跟随区域内的CNAME（RFC 1034 4.3.2）
+		if cname := records[0]; cname.Type == TypeCNAME && q.Type != TypeCNAME && q.Type != TypeANY {
+			reply.Answers = append(reply.Answers, cname)
+			name = cname.Target
+			if zone = srv.zoneLocked(name); zone == nil || hops == maxCNAMEChain {
+				break
+			}
+			continue
+		}
+
+		n := len(reply.Answers)
+		for _, rr := range records {
+			if rr.Type == q.Type || q.Type == TypeANY {
+				reply.Answers = append(reply.Answers, rr)
+			}
+		}
+		if len(reply.Answers) == n {
+			reply.Authorities = []Resource{zone.negative()}
+		}
+		break
+	}
+
+	reply.Additionals = srv.additionalsLocked(reply.Answers)
+	return reply
+}
+
+// additionalsLocked 返回SRV、MX、NS目标在区域内的地址记录
+func (srv *Server) additionalsLocked(answers []Resource) []Resource {
+	var additionals []Resource
+	seen := make(map[string]bool)
+	for _, rr := range answers {
+		if rr.Type != TypeSRV && rr.Type != TypeMX && rr.Type != TypeNS || seen[rr.Target] {
+			continue
+		}
+		seen[rr.Target] = true
+		zone := srv.zoneLocked(rr.Target)
+		if zone == nil {
+			continue
+		}
+		for _, target := range zone.names[rr.Target] {
+			if target.Type == TypeA || target.Type == TypeAAAA {
+				additionals = append(additionals, target)
+			}
+		}
+	}
+	return additionals
+}
+
+// zoneLocked 返回包含name的最长匹配区域
+func (srv *Server) zoneLocked(name string) *Zone {
+	for {
+		if z, ok := srv.zones[name]; ok {
+			return z
+		}
+		if name == "." {
+			return nil
+		}
+		name = parent(name)
+	}
+}
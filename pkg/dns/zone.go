@@ -0,0 +1,416 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultTTL 区域文件中没有$TTL时记录的默认生存时间
+const DefaultTTL = 3600
+
+// Zone 一个权威区域：区域顶点的SOA记录和区域内的所有记录
+type Zone struct {
+	Origin  string     // 区域顶点，即SOA记录的域名
+	Records []Resource // 按文件中的顺序排列，含SOA
+
+	soa   Resource
+	names map[string][]Resource // 按域名索引的记录
+	nodes map[string]bool       // 存在的域名，包括只有子域有记录的空非终端节点
+}
+
+// NewZone 由记录创建区域：必须在顶点有且只有一条SOA记录，所有记录位于区域内，CNAME不能与其他记录共存
+func NewZone(records []Resource) (*Zone, error) {
+	z := &Zone{
+		names: make(map[string][]Resource),
+		nodes: make(map[string]bool),
+	}
+	for _, rr := range records {
+		if rr.Type == TypeSOA {
+			if z.Origin != "" {
+				return nil, fmt.Errorf("multiple SOA records: %s and %s", z.Origin, Fqdn(rr.Name))
+			}
+			z.Origin = Fqdn(rr.Name)
+		}
+	}
+	if z.Origin == "" {
+		return nil, fmt.Errorf("zone has no SOA record")
+	}
+
+	for _, rr := range records {
+		rr.Name = Fqdn(rr.Name)
+		if rr.Class == 0 {
+			rr.Class = ClassINET
+		}
+		if !z.contains(rr.Name) {
+			return nil, fmt.Errorf("%s is outside zone %s", rr.Name, z.Origin)
+		}
+		for _, other := range z.names[rr.Name] {
+			if rr.Type == TypeCNAME || other.Type == TypeCNAME {
+				return nil, fmt.Errorf("%s has a CNAME and other records", rr.Name)
+			}
+		}
+		if rr.Type == TypeSOA {
+			z.soa = rr
+		}
+		z.Records = append(z.Records, rr)
+		z.names[rr.Name] = append(z.names[rr.Name], rr)
+
+		for name := rr.Name; !z.nodes[name]; name = parent(name) {
+			z.nodes[name] = true
+			if name == z.Origin {
+				break
+			}
+		}
+	}
+	return z, nil
+}
+
+// SOA 返回区域的SOA记录
+func (z *Zone) SOA() Resource {
+	return z.soa
+}
+
+// contains 检查域名是否位于区域内
+func (z *Zone) contains(name string) bool {
+	return z.Origin == "." || name == z.Origin || strings.HasSuffix(name, "."+z.Origin)
+}
+
+// negative 返回否定应答权威段中的SOA，TTL取SOA的TTL和MINIMUM中的较小值（RFC 2308 3）
+func (z *Zone) negative() Resource {
+	soa := z.soa
+	soa.TTL = min(soa.TTL, soa.Minimum)
+	return soa
+}
+
+// parent 返回上一级域名
+func parent(name string) string {
+	if i := strings.IndexByte(name, '.'); i >= 0 && i+1 < len(name) {
+		return name[i+1:]
+	}
+	return "."
+}
+
+// LoadZone 从文件加载区域，origin为初始的$ORIGIN，可以为空
+func LoadZone(path, origin string) (*Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	z, err := ParseZone(f, origin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return z, nil
+}
+
+// ParseZone 解析RFC 1035主文件格式的区域，例如：
+//
+//	$ORIGIN example.com.
+//	$TTL 1h
+//	@     IN SOA ns admin ( 1 3600 600 86400 60 )
+//	www      A     10.0.0.80
+//	         AAAA  fd00::80
+//	_http._tcp SRV 0 1 80 www
+//
+// 支持$ORIGIN、$TTL、@、相对域名、省略的所有者名（沿用上一条）、括号续行、分号注释，
+// 以及A、AAAA、CNAME、NS、PTR、MX、SRV、TXT、SOA记录；TTL可以带s、m、h、d、w单位。
+func ParseZone(r io.Reader, origin string) (*Zone, error) {
+	lines, err := splitZone(r)
+	if err != nil {
+		return nil, err
+	}
+	if origin != "" {
+		origin = Fqdn(origin)
+	}
+
+	var records []Resource
+	ttl := uint32(DefaultTTL)
+	owner := ""
+	for _, line := range lines {
+		tokens := line.tokens
+		errorf := func(format string, v ...interface{}) error {
+			return fmt.Errorf("line %d: %s", line.number, fmt.Sprintf(format, v...))
+		}
+
+		if !line.indented && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "$") {
+			if len(tokens) != 2 {
+				return nil, errorf("%s takes one argument", tokens[0].text)
+			}
+			switch strings.ToUpper(tokens[0].text) {
+			case "$ORIGIN":
+				if origin, err = absoluteName(tokens[1].text, origin); err != nil {
+					return nil, errorf("%v", err)
+				}
+			case "$TTL":
+				if ttl, err = parseTTL(tokens[1].text); err != nil {
+					return nil, errorf("%v", err)
+				}
+			default:
+				return nil, errorf("unsupported directive %s", tokens[0].text)
+			}
+			continue
+		}
+
+		if !line.indented {
+			if owner, err = absoluteName(tokens[0].text, origin); err != nil {
+				return nil, errorf("%v", err)
+			}
+			tokens = tokens[1:]
+		} else if owner == "" {
+			return nil, errorf("record without owner name")
+		}
+
+		rr := Resource{Name: owner, Class: ClassINET, TTL: ttl}
+		// TTL和类可以以任意顺序出现在类型之前
+		for i := 0; i < 2 && len(tokens) > 0; i++ {
+			if v, err := parseTTL(tokens[0].text); err == nil {
+				rr.TTL = v
+			} else if !strings.EqualFold(tokens[0].text, "IN") {
+				break
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			return nil, errorf("missing record type")
+		}
+		if rr.Type = typeByName(tokens[0].text); rr.Type == 0 {
+			return nil, errorf("unsupported record type %s", tokens[0].text)
+		}
+		if err := parseRData(&rr, tokens[1:], origin); err != nil {
+			return nil, errorf("%s %s: %v", owner, TypeName(rr.Type), err)
+		}
+		records = append(records, rr)
+	}
+	return NewZone(records)
+}
+
+// parseRData 解析记录数据
+func parseRData(rr *Resource, tokens []zoneToken, origin string) error {
+	want := map[uint16]int{
+		TypeA: 1, TypeAAAA: 1, TypeCNAME: 1, TypeNS: 1, TypePTR: 1,
+		TypeMX: 2, TypeSRV: 4, TypeSOA: 7,
+	}
+	if n, ok := want[rr.Type]; ok && len(tokens) != n {
+		return fmt.Errorf("expected %d fields, got %d", n, len(tokens))
+	}
+
+	var err error
+	switch rr.Type {
+	case TypeA:
+		ip := net.ParseIP(tokens[0].text).To4()
+		if ip == nil {
+			return fmt.Errorf("invalid IPv4 address %q", tokens[0].text)
+		}
+		rr.A = [4]byte(ip)
+	case TypeAAAA:
+		ip := net.ParseIP(tokens[0].text)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", tokens[0].text)
+		}
+		rr.AAAA = [16]byte(ip)
+	case TypeCNAME, TypeNS, TypePTR:
+		rr.Target, err = absoluteName(tokens[0].text, origin)
+	case TypeMX:
+		if rr.Priority, err = parseUint16(tokens[0].text); err == nil {
+			rr.Target, err = absoluteName(tokens[1].text, origin)
+		}
+	case TypeSRV:
+		fields := []*uint16{&rr.Priority, &rr.Weight, &rr.Port}
+		for i, field := range fields {
+			if *field, err = parseUint16(tokens[i].text); err != nil {
+				return err
+			}
+		}
+		rr.Target, err = absoluteName(tokens[3].text, origin)
+	case TypeTXT:
+		if len(tokens) == 0 {
+			return fmt.Errorf("missing text")
+		}
+		for _, t := range tokens {
+			if len(t.text) > 255 {
+				return fmt.Errorf("TXT string too long: %d bytes", len(t.text))
+			}
+			rr.Text = append(rr.Text, t.text)
+		}
+	case TypeSOA:
+		if rr.MName, err = absoluteName(tokens[0].text, origin); err != nil {
+			return err
+		}
+		if rr.RName, err = absoluteName(tokens[1].text, origin); err != nil {
+			return err
+		}
+		if rr.Serial, err = parseUint32(tokens[2].text); err != nil {
+			return err
+		}
+		fields := []*uint32{&rr.Refresh, &rr.Retry, &rr.Expire, &rr.Minimum}
+		for i, field := range fields {
+			if *field, err = parseTTL(tokens[3+i].text); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// absoluteName 将@和相对域名补全为origin下的完全限定域名
+func absoluteName(name, origin string) (string, error) {
+	if name == "@" {
+		if origin == "" {
+			return "", fmt.Errorf("@ used without $ORIGIN")
+		}
+		return origin, nil
+	}
+	if strings.HasSuffix(name, ".") {
+		return Fqdn(name), nil
+	}
+	if origin == "" {
+		return "", fmt.Errorf("relative name %q without $ORIGIN", name)
+	}
+	if origin == "." {
+		return Fqdn(name), nil
+	}
+	return Fqdn(name + "." + origin), nil
+}
+
+// typeByName 返回助记符对应的记录类型，不支持时返回0
+func typeByName(name string) uint16 {
+	for _, t := range []uint16{TypeA, TypeNS, TypeCNAME, TypeSOA, TypePTR, TypeMX, TypeTXT, TypeAAAA, TypeSRV} {
+		if strings.EqualFold(name, TypeName(t)) {
+			return t
+		}
+	}
+	return 0
+}
+
+// parseTTL 解析秒数，或带s、m、h、d、w单位的时长（如1h30m）
+func parseTTL(s string) (uint32, error) {
+	if v, err := parseUint32(s); err == nil {
+		return v, nil
+	}
+	units := map[byte]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+	var total, n uint64
+	digits := false
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			digits = true
+		} else if unit := units[c|0x20]; digits && unit != 0 {
+			total += n * unit
+			n, digits = 0, false
+		} else {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+		if n > math.MaxUint32 || total > math.MaxUint32 {
+			return 0, fmt.Errorf("invalid TTL %q", s)
+		}
+	}
+	// 数字后必须有单位
+	if digits || len(s) == 0 {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return uint32(total), nil
+}
+
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return uint16(v), nil
+}
+
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return uint32(v), nil
+}
+
+// zoneToken 区域文件中的一个字段，quoted表示带引号的字符串
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneLine 一个逻辑行（括号内的多个物理行合并），indented表示行首为空白，沿用上一条记录的所有者名
+type zoneLine struct {
+	tokens   []zoneToken
+	indented bool
+	number   int
+}
+
+// splitZone 将区域文件拆分为逻辑行，去掉注释
+func splitZone(r io.Reader) ([]zoneLine, error) {
+	var lines []zoneLine
+	var current zoneLine
+	depth := 0
+
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		text := scanner.Text()
+		if depth == 0 {
+			current = zoneLine{number: number, indented: len(text) > 0 && (text[0] == ' ' || text[0] == '\t')}
+		}
+
+	scan:
+		for i := 0; i < len(text); {
+			switch c := text[i]; {
+			case c == ' ' || c == '\t' || c == '\r':
+				i++
+			case c == ';':
+				break scan
+			case c == '(':
+				depth++
+				i++
+			case c == ')':
+				if depth == 0 {
+					return nil, fmt.Errorf("line %d: unbalanced parentheses", number)
+				}
+				depth--
+				i++
+			case c == '"':
+				var b strings.Builder
+				for i++; ; i++ {
+					if i >= len(text) {
+						return nil, fmt.Errorf("line %d: unterminated string", number)
+					}
+					if text[i] == '"' {
+						i++
+						break
+					}
+					if text[i] == '\\' && i+1 < len(text) {
+						i++
+					}
+					b.WriteByte(text[i])
+				}
+				current.tokens = append(current.tokens, zoneToken{text: b.String(), quoted: true})
+			default:
+				start := i
+				for i < len(text) && !strings.ContainsRune(" \t\r;()\"", rune(text[i])) {
+					i++
+				}
+				current.tokens = append(current.tokens, zoneToken{text: text[start:i]})
+			}
+		}
+
+		if depth == 0 && len(current.tokens) > 0 {
+			lines = append(lines, current)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("line %d: unbalanced parentheses", current.number)
+	}
+	return lines, nil
+}
@@ -13,8 +13,12 @@ const (
 	// 以太网类型
 	EtherTypeIPv4 = 0x0800
 	EtherTypeARP  = 0x0806
+	EtherTypeIPv6 = 0x86DD
 )
 
+// BroadcastMAC 以太网广播地址
+var BroadcastMAC = [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
 // Frame 以太网帧结构
 type Frame struct {
 	DestinationMAC [6]byte // 目标MAC地址
@@ -79,12 +83,17 @@ func (f *Frame) String() string {
 
 // IsBroadcast 检查是否为广播帧
 func (f *Frame) IsBroadcast() bool {
-	return f.DestinationMAC == [6]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	return f.DestinationMAC == BroadcastMAC
 }
 
-// IsMulticast 检查是否为多播帧
+// IsMulticast 检查是否为多播帧（广播帧不计入多播）
 func (f *Frame) IsMulticast() bool {
-	return (f.DestinationMAC[0] & 0x01) != 0
+	return (f.DestinationMAC[0]&0x01) != 0 && !f.IsBroadcast()
+}
+
+// IPv4MulticastMAC 返回IPv4多播地址对应的MAC地址 01:00:5e + 低23位
+func IPv4MulticastMAC(group [4]byte) [6]byte {
+	return [6]byte{0x01, 0x00, 0x5e, group[1] & 0x7f, group[2], group[3]}
 }
 
 // NewFrame 创建新的以太网帧
@@ -0,0 +1,96 @@
+package gonet
+
+import (
+	"sync"
+	"time"
+)
+
+// deadlineTimer 读写截止时间，到期时关闭对应的通道以唤醒阻塞的读写
+type deadlineTimer struct {
+	mu sync.Mutex
+
+	readTimer   *time.Timer
+	readCancel  chan struct{}
+	writeTimer  *time.Timer
+	writeCancel chan struct{}
+}
+
+func (d *deadlineTimer) init() {
+	d.readCancel = make(chan struct{})
+	d.writeCancel = make(chan struct{})
+}
+
+// readCancelCh 返回读截止时间到期时关闭的通道
+func (d *deadlineTimer) readCancelCh() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.readCancel
+}
+
+// writeCancelCh 返回写截止时间到期时关闭的通道
+func (d *deadlineTimer) writeCancelCh() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeCancel
+}
+
+// setDeadlineLocked 重新设置截止时间，零值表示不超时，已过去的时间立即到期
+func (d *deadlineTimer) setDeadlineLocked(cancel *chan struct{}, timer **time.Timer, t time.Time) {
+	if *timer != nil && !(*timer).Stop() {
+		// 定时器已触发，通道已经或即将关闭
+		*cancel = make(chan struct{})
+	}
+	*timer = nil
+
+	select {
+	case <-*cancel:
+		*cancel = make(chan struct{})
+	default:
+	}
+
+	if t.IsZero() {
+		return
+	}
+	timeout := time.Until(t)
+	if timeout <= 0 {
+		close(*cancel)
+		return
+	}
+	ch := *cancel
+	*timer = time.AfterFunc(timeout, func() { close(ch) })
+}
+
+// SetReadDeadline 设置读截止时间（实现net.Conn）
+func (d *deadlineTimer) SetReadDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setDeadlineLocked(&d.readCancel, &d.readTimer, t)
+	return nil
+}
+
+// SetWriteDeadline 设置写截止时间（实现net.Conn）
+func (d *deadlineTimer) SetWriteDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setDeadlineLocked(&d.writeCancel, &d.writeTimer, t)
+	return nil
+}
+
+// SetDeadline 同时设置读写截止时间（实现net.Conn）
+func (d *deadlineTimer) SetDeadline(t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setDeadlineLocked(&d.readCancel, &d.readTimer, t)
+	d.setDeadlineLocked(&d.writeCancel, &d.writeTimer, t)
+	return nil
+}
+
+// expired 检查通道是否已关闭
+func expired(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,194 @@
+package gonet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+	"ustack/pkg/udp"
+)
+
+// Dialer 在ustack协议栈上建立连接，用法同net.Dialer
+//
+// DialContext可直接用作http.Transport.DialContext。
+type Dialer struct {
+	Stack *stack.Stack
+
+	// Timeout 建立连接的最长时间，0表示tcp.ConnectionTimeout；与ctx的截止时间取较早者
+	Timeout time.Duration
+
+	// LocalAddr 本地地址，nil表示自动选择地址并分配临时端口
+	LocalAddr *stack.FullAddress
+
+	// Control TCP连接发起前调用，可设置Congestion等选项
+	Control func(ep *tcp.Connection)
+
+	// Resolver 解析地址中的主机名，nil表示只接受IPv4地址
+	Resolver HostResolver
+}
+
+// HostResolver 将主机名解析为IPv4地址，如dns.Resolver
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([][4]byte, error)
+}
+
+// Dial 在协议栈s上建立连接，network为tcp、tcp4、udp或udp4，address为"IPv4地址:端口"
+func Dial(s *stack.Stack, network, address string) (net.Conn, error) {
+	d := &Dialer{Stack: s}
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext 同Dial，ctx取消或到期时放弃连接
+func DialContext(ctx context.Context, s *stack.Stack, network, address string) (net.Conn, error) {
+	d := &Dialer{Stack: s}
+	return d.DialContext(ctx, network, address)
+}
+
+// Dial 建立连接
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext 建立连接：TCP发送SYN并等待握手完成（SYN按重传超时重发），UDP只记录对端地址
+//
+// address中的主机名由Resolver解析，依次尝试每个地址直到成功；TCP的Timeout包括解析时间。
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4":
+		timeout := d.Timeout
+		if timeout <= 0 {
+			timeout = tcp.ConnectionTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	case "udp", "udp4":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+
+	raddrs, err := d.resolve(ctx, address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var firstErr error
+	for _, raddr := range raddrs {
+		var c net.Conn
+		if network == "tcp" || network == "tcp4" {
+			c, err = d.dialTCP(ctx, raddr)
+		} else if err = ctx.Err(); err != nil {
+			err = &net.OpError{Op: "dial", Net: network, Addr: udpAddr(raddr), Err: mapContextError(err)}
+		} else {
+			c, err = d.dialUDP(raddr)
+		}
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+func (d *Dialer) dialTCP(ctx context.Context, raddr stack.FullAddress) (*TCPConn, error) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Addr: tcpAddr(raddr), Err: err}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, opError(mapContextError(err))
+	}
+
+	ep := tcp.NewConnection(d.Stack)
+	ep.ReadBuffered = true
+	if d.Control != nil {
+		d.Control(ep)
+	}
+	if d.LocalAddr != nil {
+		if err := ep.Bind(*d.LocalAddr); err != nil {
+			ep.Close()
+			return nil, opError(err)
+		}
+	}
+	c := NewTCPConn(ep)
+
+	// Connect查找路由、分配临时端口并发送SYN
+	if err := ep.Connect(raddr); err != nil {
+		ep.Close()
+		return nil, opError(err)
+	}
+
+	select {
+	case <-c.handshake:
+		if c.handshakeErr != nil {
+			return nil, opError(c.handshakeErr)
+		}
+		return c, nil
+	case <-ctx.Done():
+		ep.Close()
+		return nil, opError(mapContextError(ctx.Err()))
+	}
+}
+
+func (d *Dialer) dialUDP(raddr stack.FullAddress) (*UDPConn, error) {
+	ep := udp.NewEndpoint(d.Stack, 0)
+	if d.LocalAddr != nil {
+		if err := ep.Bind(*d.LocalAddr); err != nil {
+			ep.Close()
+			return nil, &net.OpError{Op: "dial", Net: "udp", Addr: udpAddr(raddr), Err: err}
+		}
+	}
+	if err := ep.Connect(raddr); err != nil {
+		ep.Close()
+		return nil, &net.OpError{Op: "dial", Net: "udp", Addr: udpAddr(raddr), Err: err}
+	}
+	return NewUDPConn(ep), nil
+}
+
+// resolve 解析"主机:端口"，主机为IPv4地址时直接返回，否则由Resolver查询
+func (d *Dialer) resolve(ctx context.Context, address string) ([]stack.FullAddress, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return nil, fmt.Errorf("invalid port: %q", port)
+	}
+
+	if ip4 := net.ParseIP(host).To4(); ip4 != nil {
+		return []stack.FullAddress{{IP: [4]byte(ip4), Port: uint16(p)}}, nil
+	}
+	if d.Resolver == nil {
+		return nil, fmt.Errorf("not an IPv4 address: %q", host)
+	}
+	ips, err := d.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, mapContextError(err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses for %q", host)
+	}
+	raddrs := make([]stack.FullAddress, len(ips))
+	for i, ip := range ips {
+		raddrs[i] = stack.FullAddress{IP: ip, Port: uint16(p)}
+	}
+	return raddrs, nil
+}
+
+// mapContextError 与net包一致，ctx到期报告为超时
+func mapContextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
@@ -0,0 +1,372 @@
+// Package gonet 在ustack协议栈上实现标准库的net接口，
+// 使net/http、bufio、crypto/tls等现有代码无需修改即可运行在用户态协议栈上
+package gonet
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+)
+
+// DefaultSendBufferSize 每个连接的发送缓冲区上限（已发送未确认 + 未发送），写满后Write阻塞
+const DefaultSendBufferSize = 256 * 1024
+
+var (
+	_ net.Conn     = (*TCPConn)(nil)
+	_ net.Listener = (*TCPListener)(nil)
+)
+
+// TCPConn 在ustack TCP连接上实现net.Conn
+//
+// Read阻塞直到有数据、收到FIN（返回io.EOF）或连接异常终止；应用尚未读取的数据占用接收窗口，
+// 读取慢时对端随之减速。Write在发送缓冲区满时阻塞，直到对端确认数据。
+type TCPConn struct {
+	deadlineTimer
+
+	ep *tcp.Connection
+
+	mu          sync.Mutex
+	rcvBuf      [][]byte      // 已交付未读取的数据
+	eof         bool          // 收到FIN
+	err         error         // 连接异常终止的原因
+	readClosed  bool          // 本地已关闭读
+	writeClosed bool          // 本地已关闭写
+	readReady   chan struct{} // 有数据或状态变化时关闭
+	writeReady  chan struct{} // 数据被确认或状态变化时关闭
+
+	handshake     chan struct{} // 主动连接的握手结束（建立或失败）时关闭
+	handshakeOnce sync.Once
+	handshakeErr  error // 握手失败的原因，在关闭handshake之前写入
+
+	sendBufferSize int
+}
+
+// NewTCPConn 包装ep，接管其回调
+//
+// ep应在连接建立前（Connect之前）包装并设置ReadBuffered，之后只能通过返回的TCPConn使用。
+func NewTCPConn(ep *tcp.Connection) *TCPConn {
+	c := &TCPConn{
+		ep:             ep,
+		readReady:      make(chan struct{}),
+		writeReady:     make(chan struct{}),
+		handshake:      make(chan struct{}),
+		sendBufferSize: DefaultSendBufferSize,
+	}
+	c.deadlineTimer.init()
+
+	ep.OnDataReceived = c.receive
+	ep.OnStateChanged = c.stateChanged
+	ep.OnDataAcked = func() {
+		c.mu.Lock()
+		c.wakeWriterLocked()
+		c.mu.Unlock()
+	}
+	return c
+}
+
+// Endpoint 返回底层的TCP连接
+func (c *TCPConn) Endpoint() *tcp.Connection {
+	return c.ep
+}
+
+// receive 缓存交付的数据，唤醒读取方
+func (c *TCPConn) receive(data []byte) {
+	c.mu.Lock()
+	if c.readClosed {
+		// 已关闭读，丢弃数据但保持窗口打开，直到对端关闭
+		c.mu.Unlock()
+		c.ep.Consume(len(data))
+		return
+	}
+	c.rcvBuf = append(c.rcvBuf, data)
+	c.wakeReaderLocked()
+	c.mu.Unlock()
+}
+
+// stateChanged 根据连接状态记录EOF或错误
+func (c *TCPConn) stateChanged(state string) {
+	if state != tcp.StateSynSent && state != tcp.StateSynReceived {
+		c.handshakeOnce.Do(func() {
+			if state == tcp.StateClosed {
+				if c.handshakeErr = c.ep.Err(); c.handshakeErr == nil {
+					c.handshakeErr = tcp.ErrClosed
+				}
+			}
+			close(c.handshake)
+		})
+	}
+
+	var err error
+	switch state {
+	case tcp.StateCloseWait, tcp.StateClosing, tcp.StateTimeWait:
+	case tcp.StateClosed:
+		err = c.ep.Err()
+	default:
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.err = err
+	} else {
+		c.eof = true
+	}
+	c.wakeReaderLocked()
+	c.wakeWriterLocked()
+}
+
+func (c *TCPConn) wakeReaderLocked() {
+	close(c.readReady)
+	c.readReady = make(chan struct{})
+}
+
+func (c *TCPConn) wakeWriterLocked() {
+	close(c.writeReady)
+	c.writeReady = make(chan struct{})
+}
+
+// Read 读取数据，无数据时阻塞；对端关闭后返回io.EOF（实现net.Conn）
+func (c *TCPConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	for {
+		switch {
+		case c.readClosed:
+			c.mu.Unlock()
+			return 0, c.opError("read", net.ErrClosed)
+		case expired(c.readCancelCh()):
+			c.mu.Unlock()
+			return 0, c.opError("read", os.ErrDeadlineExceeded)
+		case len(c.rcvBuf) > 0:
+			n := 0
+			for len(c.rcvBuf) > 0 && n < len(b) {
+				m := copy(b[n:], c.rcvBuf[0])
+				n += m
+				if m == len(c.rcvBuf[0]) {
+					c.rcvBuf = c.rcvBuf[1:]
+				} else {
+					c.rcvBuf[0] = c.rcvBuf[0][m:]
+				}
+			}
+			c.mu.Unlock()
+			c.ep.Consume(n)
+			return n, nil
+		case c.err != nil:
+			err := c.err
+			c.mu.Unlock()
+			return 0, c.opError("read", err)
+		case c.eof:
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+
+		ready := c.readReady
+		c.mu.Unlock()
+		select {
+		case <-ready:
+		case <-c.readCancelCh():
+		}
+		c.mu.Lock()
+	}
+}
+
+// Write 写入全部数据，发送缓冲区满时阻塞（实现net.Conn）
+func (c *TCPConn) Write(b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		c.mu.Lock()
+		closed, err, ready := c.writeClosed, c.err, c.writeReady
+		c.mu.Unlock()
+
+		switch {
+		case closed:
+			return n, c.opError("write", net.ErrClosed)
+		case err != nil:
+			return n, c.opError("write", err)
+		case expired(c.writeCancelCh()):
+			return n, c.opError("write", os.ErrDeadlineExceeded)
+		}
+
+		free := c.sendBufferSize - c.ep.SendQueued()
+		if free <= 0 {
+			select {
+			case <-ready:
+			case <-c.writeCancelCh():
+			}
+			continue
+		}
+
+		m := min(free, len(b)-n)
+		if err := c.ep.Send(b[n : n+m]); err != nil {
+			return n, c.opError("write", err)
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// Close 关闭连接：丢弃未读取的数据，发送完缓冲的数据后发送FIN（实现net.Conn）
+func (c *TCPConn) Close() error {
+	c.mu.Lock()
+	if c.readClosed && c.writeClosed {
+		c.mu.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	unread := 0
+	for _, data := range c.rcvBuf {
+		unread += len(data)
+	}
+	c.rcvBuf = nil
+	c.readClosed = true
+	c.writeClosed = true
+	c.wakeReaderLocked()
+	c.wakeWriterLocked()
+	c.mu.Unlock()
+
+	c.ep.Consume(unread)
+	return c.ep.Close()
+}
+
+// CloseWrite 关闭写方向（发送FIN），仍可继续读取
+func (c *TCPConn) CloseWrite() error {
+	c.mu.Lock()
+	if c.writeClosed {
+		c.mu.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	c.writeClosed = true
+	c.wakeWriterLocked()
+	c.mu.Unlock()
+
+	return c.ep.Close()
+}
+
+// LocalAddr 返回本地地址（实现net.Conn）
+func (c *TCPConn) LocalAddr() net.Addr {
+	return tcpAddr(c.ep.LocalAddress())
+}
+
+// RemoteAddr 返回对端地址（实现net.Conn）
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return tcpAddr(c.ep.RemoteAddress())
+}
+
+func (c *TCPConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: err}
+}
+
+// TCPListener 在ustack上实现net.Listener
+type TCPListener struct {
+	ep *tcp.Connection
+
+	mu       sync.Mutex
+	closed   bool
+	accepted chan *TCPConn
+	done     chan struct{}
+}
+
+// ListenConfig 监听选项，用法同net.ListenConfig
+type ListenConfig struct {
+	// Control 绑定端口前调用，可设置Congestion等选项，接受的连接继承这些选项
+	Control func(ep *tcp.Connection)
+}
+
+// ListenTCP 在协议栈上监听addr，端口为0时分配临时端口
+func ListenTCP(s *stack.Stack, addr stack.FullAddress) (*TCPListener, error) {
+	var lc ListenConfig
+	return lc.ListenTCP(s, addr)
+}
+
+// ListenTCP 按监听选项在协议栈上监听addr
+func (lc *ListenConfig) ListenTCP(s *stack.Stack, addr stack.FullAddress) (*TCPListener, error) {
+	ep := tcp.NewConnection(s)
+	ep.ReadBuffered = true
+	ep.ReuseAddr = true // 同net.Listen，重启后可立即监听仍有TIME_WAIT连接的端口
+	if lc.Control != nil {
+		lc.Control(ep)
+	}
+
+	l := &TCPListener{
+		ep:       ep,
+		accepted: make(chan *TCPConn, tcp.DefaultBacklog),
+		done:     make(chan struct{}),
+	}
+	ep.OnAccept = l.accept
+
+	if err := ep.Bind(addr); err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "tcp", Addr: tcpAddr(addr), Err: err}
+	}
+	if err := ep.Listen(); err != nil {
+		ep.Close()
+		return nil, &net.OpError{Op: "listen", Net: "tcp", Addr: tcpAddr(addr), Err: err}
+	}
+	return l, nil
+}
+
+// accept 在协议栈接收协程中调用，须在交付数据前接管子连接的回调
+func (l *TCPListener) accept(ep *tcp.Connection) {
+	c := NewTCPConn(ep)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		ep.Close()
+		return
+	}
+	select {
+	case l.accepted <- c:
+	default:
+		// 应用来不及Accept，关闭新连接
+		ep.Close()
+	}
+}
+
+// Accept 等待并返回下一个已建立的连接（实现net.Listener）
+func (l *TCPListener) Accept() (net.Conn, error) {
+	return l.AcceptTCP()
+}
+
+// AcceptTCP 等待并返回下一个已建立的连接
+func (l *TCPListener) AcceptTCP() (*TCPConn, error) {
+	select {
+	case c := <-l.accepted:
+		return c, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Close 停止监听并关闭尚未Accept的连接，已Accept的连接不受影响（实现net.Listener）
+func (l *TCPListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return &net.OpError{Op: "close", Net: "tcp", Addr: l.Addr(), Err: net.ErrClosed}
+	}
+	l.closed = true
+	close(l.done)
+
+	var pending []*TCPConn
+	for len(l.accepted) > 0 {
+		pending = append(pending, <-l.accepted)
+	}
+	l.mu.Unlock()
+
+	for _, c := range pending {
+		c.Close()
+	}
+	return l.ep.Close()
+}
+
+// Addr 返回监听地址（实现net.Listener）
+func (l *TCPListener) Addr() net.Addr {
+	return tcpAddr(l.ep.LocalAddress())
+}
+
+// tcpAddr 将协议栈地址转换为*net.TCPAddr
+func tcpAddr(a stack.FullAddress) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.IPv4(a.IP[0], a.IP[1], a.IP[2], a.IP[3]), Port: int(a.Port)}
+}
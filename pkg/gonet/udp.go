@@ -0,0 +1,166 @@
+package gonet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+var (
+	_ net.PacketConn = (*UDPConn)(nil)
+	_ net.Conn       = (*UDPConn)(nil)
+)
+
+// UDPConn 在ustack UDP端点上实现net.PacketConn，已连接时也可作为net.Conn使用
+//
+// 收到的数据报进入端点的有界队列，队列满时丢弃并计入Stats().Dropped；
+// 读取缓冲区小于数据报时多余部分被截断（同net.UDPConn）。
+type UDPConn struct {
+	deadlineTimer
+
+	ep *udp.Endpoint
+}
+
+// NewUDPConn 包装已创建的UDP端点
+func NewUDPConn(ep *udp.Endpoint) *UDPConn {
+	c := &UDPConn{ep: ep}
+	c.deadlineTimer.init()
+	return c
+}
+
+// ListenUDP 创建绑定到addr的UDP连接，端口为0时分配临时端口
+func ListenUDP(s *stack.Stack, addr stack.FullAddress) (*UDPConn, error) {
+	ep := udp.NewEndpoint(s, 0)
+	if err := ep.Bind(addr); err != nil {
+		ep.Close()
+		return nil, &net.OpError{Op: "listen", Net: "udp", Addr: udpAddr(addr), Err: err}
+	}
+	return NewUDPConn(ep), nil
+}
+
+// DialUDP 创建连接到raddr的UDP连接，本地地址和端口自动选择
+func DialUDP(s *stack.Stack, raddr stack.FullAddress) (*UDPConn, error) {
+	d := &Dialer{Stack: s}
+	return d.dialUDP(raddr)
+}
+
+// Endpoint 返回底层的UDP端点
+func (c *UDPConn) Endpoint() *udp.Endpoint {
+	return c.ep
+}
+
+// Stats 返回端点统计，包括队列满丢弃的数据报数
+func (c *UDPConn) Stats() udp.Stats {
+	return c.ep.Stats()
+}
+
+// ReadFrom 接收一个数据报（实现net.PacketConn）
+func (c *UDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.ReadFromUDP(b)
+	if addr == nil {
+		return n, nil, err
+	}
+	return n, addr, err
+}
+
+// ReadFromUDP 接收一个数据报，返回发送方地址
+func (c *UDPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	cancel := c.readCancelCh()
+	if expired(cancel) {
+		return 0, nil, c.opError("read", nil, os.ErrDeadlineExceeded)
+	}
+
+	payload, from, err := c.ep.RecvFromUntil(cancel)
+	if err != nil {
+		return 0, nil, c.opError("read", nil, err)
+	}
+	return copy(b, payload), udpAddr(from), nil
+}
+
+// WriteTo 向addr发送一个数据报（实现net.PacketConn）
+func (c *UDPConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	to, err := fullAddress(addr)
+	if err != nil {
+		return 0, c.opError("write", addr, err)
+	}
+	return c.write(b, &to, addr)
+}
+
+// Read 从已连接的对端接收一个数据报（实现net.Conn）
+func (c *UDPConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFromUDP(b)
+	return n, err
+}
+
+// Write 向已连接的对端发送一个数据报（实现net.Conn）
+func (c *UDPConn) Write(b []byte) (int, error) {
+	return c.write(b, nil, c.RemoteAddr())
+}
+
+func (c *UDPConn) write(b []byte, to *stack.FullAddress, addr net.Addr) (int, error) {
+	if expired(c.writeCancelCh()) {
+		return 0, c.opError("write", addr, os.ErrDeadlineExceeded)
+	}
+	n, err := c.ep.SendTo(b, to)
+	if err != nil {
+		return n, c.opError("write", addr, err)
+	}
+	return n, nil
+}
+
+// Close 关闭端点并释放端口（实现net.PacketConn）
+func (c *UDPConn) Close() error {
+	return c.ep.Close()
+}
+
+// LocalAddr 返回本地地址（实现net.PacketConn）
+func (c *UDPConn) LocalAddr() net.Addr {
+	return udpAddr(c.ep.LocalAddress())
+}
+
+// RemoteAddr 返回已连接的对端地址，未连接时返回nil（实现net.Conn）
+func (c *UDPConn) RemoteAddr() net.Addr {
+	remote, ok := c.ep.RemoteAddress()
+	if !ok {
+		return nil
+	}
+	return udpAddr(remote)
+}
+
+// opError 包装为*net.OpError，端点关闭和读取取消转换为标准库对应的错误
+func (c *UDPConn) opError(op string, addr net.Addr, err error) error {
+	switch {
+	case errors.Is(err, udp.ErrClosed):
+		err = net.ErrClosed
+	case errors.Is(err, udp.ErrCanceled):
+		err = os.ErrDeadlineExceeded
+	}
+	if addr == nil {
+		addr = c.RemoteAddr()
+	}
+	return &net.OpError{Op: op, Net: "udp", Source: c.LocalAddr(), Addr: addr, Err: err}
+}
+
+// udpAddr 将协议栈地址转换为*net.UDPAddr
+func udpAddr(a stack.FullAddress) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(a.IP[0], a.IP[1], a.IP[2], a.IP[3]), Port: int(a.Port)}
+}
+
+// fullAddress 将*net.UDPAddr转换为协议栈地址
+func fullAddress(addr net.Addr) (stack.FullAddress, error) {
+	var a stack.FullAddress
+	u, ok := addr.(*net.UDPAddr)
+	if !ok || u == nil {
+		return a, fmt.Errorf("unsupported address %v", addr)
+	}
+	ip4 := u.IP.To4()
+	if ip4 == nil || u.Port < 0 || u.Port > 65535 {
+		return a, fmt.Errorf("not an IPv4 UDP address: %s", u)
+	}
+	copy(a.IP[:], ip4)
+	a.Port = uint16(u.Port)
+	return a, nil
+}
@@ -0,0 +1,227 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 分块编码的块大小行的最大长度（含扩展）
+const maxChunkLineLength = 4096
+
+var (
+	// ErrBodyNotAllowed 状态码或请求方法不允许消息体（如204、304、HEAD）
+	ErrBodyNotAllowed = errors.New("http: request method or response status code does not allow body")
+
+	// ErrContentLength 写入的字节数超过声明的Content-Length
+	ErrContentLength = errors.New("http: wrote more than the declared Content-Length")
+
+	errHeaderTooLarge = &ProtocolError{Status: StatusRequestHeaderFieldsTooLarge, Message: "header too large"}
+)
+
+// ProtocolError 报文不符合HTTP/1.1语法，Status为服务端应回复的状态码
+type ProtocolError struct {
+	Status  int
+	Message string
+}
+
+// Error 返回错误描述
+func (e *ProtocolError) Error() string {
+	return "http: " + e.Message
+}
+
+func badRequest(format string, v ...interface{}) error {
+	return &ProtocolError{Status: StatusBadRequest, Message: fmt.Sprintf(format, v...)}
+}
+
+// noBody 没有消息体
+type noBody struct{}
+
+func (noBody) Read([]byte) (int, error) { return 0, io.EOF }
+
+// NoBody 空的消息体
+var NoBody io.Reader = noBody{}
+
+// fixedReader 按Content-Length读取消息体，连接提前结束时返回io.ErrUnexpectedEOF
+type fixedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (f *fixedReader) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.r.Read(p)
+	f.remaining -= int64(n)
+	if err == io.EOF {
+		if f.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	if err == nil && f.remaining == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkedReader 解码分块传输编码（RFC 9112 7.1），块可以跨越任意多个TCP段
+type chunkedReader struct {
+	br        *bufio.Reader
+	remaining uint64 // 当前块未读的字节数
+	started   bool   // 已读过至少一个块
+	trailer   Header // 最后一块之后的尾部字段
+	err       error
+}
+
+func newChunkedReader(br *bufio.Reader) *chunkedReader {
+	return &chunkedReader{br: br}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+// nextChunk 读取下一块的大小行，最后一块（大小为0）时读取尾部并返回io.EOF
+func (c *chunkedReader) nextChunk() error {
+	limit := maxChunkLineLength
+	if c.started {
+		// 上一块数据后的CRLF
+		line, err := readLine(c.br, &limit)
+		if err != nil {
+			return unexpected(err)
+		}
+		if len(line) != 0 {
+			return badRequest("malformed chunk terminator")
+		}
+		limit = maxChunkLineLength
+	}
+	c.started = true
+
+	line, err := readLine(c.br, &limit)
+	if err != nil {
+		return unexpected(err)
+	}
+	size := string(line)
+	if i := strings.IndexByte(size, ';'); i >= 0 {
+		size = size[:i]
+	}
+	c.remaining, err = strconv.ParseUint(strings.TrimRight(size, " \t"), 16, 63)
+	if err != nil {
+		return badRequest("invalid chunk size %q", line)
+	}
+	if c.remaining > 0 {
+		return nil
+	}
+
+	limit = maxChunkLineLength
+	if c.trailer, err = readHeader(c.br, &limit); err != nil {
+		return unexpected(err)
+	}
+	return io.EOF
+}
+
+// unexpected 消息体中途遇到连接结束时返回io.ErrUnexpectedEOF
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// chunkedWriter 以分块传输编码写出消息体
+type chunkedWriter struct {
+	w *bufio.Writer
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		// 长度为0的块表示结束，不能用于普通写入
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = c.w.WriteString("\r\n")
+	return n, err
+}
+
+// Close 写出最后一块和空的尾部
+func (c *chunkedWriter) Close() error {
+	_, err := c.w.WriteString("0\r\n\r\n")
+	return err
+}
+
+// body 请求或响应的消息体，记录是否已读完
+type body struct {
+	src         io.Reader
+	onFirstRead func() // 第一次读取前调用（如发送100 Continue）
+	sawEOF      bool
+	err         error // 读取失败的原因，之后连接上的数据边界不可信
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if f := b.onFirstRead; f != nil {
+		b.onFirstRead = nil
+		f()
+	}
+	if b.sawEOF {
+		return 0, io.EOF
+	}
+	n, err := b.src.Read(p)
+	switch {
+	case err == io.EOF:
+		b.sawEOF = true
+	case err != nil:
+		b.err = err
+	}
+	return n, err
+}
+
+// parseContentLength 解析Content-Length，多个值必须相同（RFC 9112 6.3）
+func parseContentLength(values []string) (int64, error) {
+	var n int64 = -1
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+				return -1, badRequest("invalid Content-Length %q", v)
+			}
+			l, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || (n >= 0 && l != n) {
+				return -1, badRequest("invalid Content-Length %q", v)
+			}
+			n = l
+		}
+	}
+	return n, nil
+}
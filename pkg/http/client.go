@@ -0,0 +1,551 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"ustack/pkg/gonet"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+)
+
+const (
+	// DefaultMaxRedirects 默认最多跟随的重定向次数
+	DefaultMaxRedirects = 10
+
+	// DefaultMaxIdleConnsPerHost 每个主机默认保留的空闲连接数
+	DefaultMaxIdleConnsPerHost = 2
+
+	// DefaultIdleConnTimeout 空闲连接默认的保留时间
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	userAgent = "ustack-http/1.1"
+)
+
+// Client HTTP/1.1客户端，在协议栈上建立连接并复用空闲的持久连接
+//
+// 主机为域名时由Resolver解析，未设置Resolver时主机必须是IPv4地址。Client可以被多个goroutine同时使用。
+type Client struct {
+	Stack *stack.Stack
+
+	// Timeout 整个请求（含建立连接、重定向和读取响应体）的最长时间，0表示不限制
+	Timeout time.Duration
+	// MaxRedirects 最多跟随的重定向次数，0表示DefaultMaxRedirects，负数表示不跟随而直接返回3xx响应
+	MaxRedirects int
+	// MaxIdleConnsPerHost 每个主机保留的空闲连接数，0表示DefaultMaxIdleConnsPerHost，负数表示不复用连接
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout 空闲连接的保留时间，0表示DefaultIdleConnTimeout
+	IdleConnTimeout time.Duration
+	// Control 建立TCP连接前调用，同gonet.Dialer.Control
+	Control func(ep *tcp.Connection)
+	// Resolver 解析URL中的主机名，同gonet.Dialer.Resolver
+	Resolver gonet.HostResolver
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// Response 客户端收到的HTTP响应
+type Response struct {
+	Status     string // 状态码和原因短语，如"200 OK"
+	StatusCode int
+	Proto      string // 协议版本，如HTTP/1.1
+	ProtoMajor int
+	ProtoMinor int
+	Header     Header
+
+	// ContentLength 消息体长度，分块编码或以关闭连接结束时为-1
+	ContentLength int64
+	// Chunked 消息体使用分块编码
+	Chunked bool
+	// Trailer 分块编码的尾部字段，读完消息体后才有值
+	Trailer Header
+	// Body 消息体，读完或关闭后连接才能被复用，调用方必须关闭
+	Body io.ReadCloser
+	// Close 服务端将在响应后关闭连接
+	Close bool
+
+	// Request 产生该响应的请求（跟随重定向后为最后一个请求）
+	Request *Request
+}
+
+// Get 发送GET请求
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Head 发送HEAD请求
+func (c *Client) Head(rawURL string) (*Response, error) {
+	req, err := NewRequest("HEAD", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Post 发送POST请求
+func (c *Client) Post(rawURL, contentType string, body io.Reader) (*Response, error) {
+	req, err := NewRequest("POST", rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// Do 发送请求并返回响应
+func (c *Client) Do(req *Request) (*Response, error) {
+	return c.DoContext(context.Background(), req)
+}
+
+// DoContext 发送请求并返回响应，ctx取消或到期时中止请求和响应体的读取
+//
+// 按MaxRedirects跟随重定向：301/302/303改用GET（HEAD保持不变）并去掉消息体，307/308保持方法和
+// 消息体（需要GetBody）。错误为*url.Error，超时时其Timeout()返回true。
+func (c *Client) DoContext(ctx context.Context, req *Request) (*Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+	}
+
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			cancel()
+			return nil, urlError(req, err)
+		}
+
+		next, err := c.redirect(req, resp)
+		if next == nil || maxRedirects < 0 {
+			if err != nil {
+				resp.Body.Close()
+				cancel()
+				return nil, urlError(req, err)
+			}
+			// 读完或关闭响应体时才结束ctx
+			resp.Body.(*clientBody).setCancel(cancel)
+			return resp, nil
+		}
+
+		// 丢弃少量剩余的消息体以便复用连接
+		io.CopyN(io.Discard, resp.Body, 4096)
+		resp.Body.Close()
+		if redirects+1 > maxRedirects {
+			cancel()
+			return nil, urlError(next, fmt.Errorf("stopped after %d redirects", maxRedirects))
+		}
+		req = next
+	}
+}
+
+// CloseIdleConnections 关闭所有空闲连接
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+
+	for _, list := range idle {
+		for _, pc := range list {
+			pc.nc.Close()
+		}
+	}
+}
+
+// send 在空闲连接或新连接上发送一个请求
+//
+// 复用的连接可能已被服务端关闭，没有读到任何响应时在新连接上重试（消息体可以重新获取时）。
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
+	addr, err := c.hostPort(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		pc, reused, err := c.getConn(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, retryable, err := pc.roundTrip(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		pc.nc.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !reused || !retryable {
+			return nil, err
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			retry := *req
+			if retry.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+			req = &retry
+		}
+	}
+}
+
+// getConn 取出addr的空闲连接，没有时建立新连接
+func (c *Client) getConn(ctx context.Context, addr string) (*persistConn, bool, error) {
+	c.mu.Lock()
+	for list := c.idle[addr]; len(list) > 0; list = c.idle[addr] {
+		pc := list[len(list)-1]
+		c.idle[addr] = list[:len(list)-1]
+		if time.Since(pc.idleAt) > c.idleConnTimeout() {
+			pc.nc.Close()
+			continue
+		}
+		c.mu.Unlock()
+		return pc, true, nil
+	}
+	c.mu.Unlock()
+
+	d := &gonet.Dialer{Stack: c.Stack, Control: c.Control, Resolver: c.Resolver}
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, false, err
+	}
+	return &persistConn{client: c, addr: addr, nc: nc, br: bufio.NewReader(nc), bw: bufio.NewWriter(nc)}, false, nil
+}
+
+// putIdle 保留读完响应的连接以便复用
+func (c *Client) putIdle(pc *persistConn) {
+	max := c.MaxIdleConnsPerHost
+	if max == 0 {
+		max = DefaultMaxIdleConnsPerHost
+	}
+	pc.nc.SetDeadline(time.Time{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max < 0 || len(c.idle[pc.addr]) >= max || pc.br.Buffered() > 0 {
+		pc.nc.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = make(map[string][]*persistConn)
+	}
+	pc.idleAt = time.Now()
+	c.idle[pc.addr] = append(c.idle[pc.addr], pc)
+}
+
+func (c *Client) idleConnTimeout() time.Duration {
+	if c.IdleConnTimeout > 0 {
+		return c.IdleConnTimeout
+	}
+	return DefaultIdleConnTimeout
+}
+
+// redirect 根据3xx响应构造下一个请求，不需要或无法跟随时返回nil
+func (c *Client) redirect(req *Request, resp *Response) (*Request, error) {
+	method := req.Method
+	keepBody := false
+	switch resp.StatusCode {
+	case StatusMovedPermanently, StatusFound, StatusSeeOther:
+		if method != "GET" && method != "HEAD" {
+			method = "GET"
+		}
+	case StatusTemporaryRedirect, StatusPermanentRedirect:
+		keepBody = true
+		if req.Body != nil && req.GetBody == nil {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, nil
+	}
+	u, err := req.URL.Parse(loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Location %q: %v", loc, err)
+	}
+
+	next, err := NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	next.Header = req.Header.Clone()
+	if u.Host != req.URL.Host {
+		// 不把凭据发给其他主机
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+	if keepBody && req.GetBody != nil {
+		if next.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+		next.ContentLength, next.GetBody = req.ContentLength, req.GetBody
+	} else {
+		next.Header.Del("Content-Type")
+	}
+	return next, nil
+}
+
+// persistConn 客户端的一条持久连接
+type persistConn struct {
+	client *Client
+	addr   string
+	nc     net.Conn
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	idleAt time.Time
+}
+
+// roundTrip 写出请求并读取响应头部，retryable表示服务端没有返回任何数据
+func (pc *persistConn) roundTrip(ctx context.Context, req *Request) (*Response, bool, error) {
+	// ctx结束时让阻塞的读写立即返回
+	pc.nc.SetDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() { pc.nc.SetDeadline(time.Unix(1, 0)) })
+
+	if err := req.write(pc.bw); err != nil {
+		stop()
+		return nil, true, err
+	}
+	if _, err := pc.br.Peek(1); err != nil {
+		stop()
+		return nil, true, err
+	}
+	resp, err := readResponse(pc.br, req)
+	if err != nil {
+		stop()
+		return nil, false, err
+	}
+
+	b := &clientBody{pc: pc, resp: resp, ctx: ctx, stop: stop}
+	switch {
+	case req.Method == "HEAD" || !bodyAllowedForStatus(resp.StatusCode):
+		b.r = NoBody
+	case resp.Chunked:
+		b.chunked = newChunkedReader(pc.br)
+		b.r = b.chunked
+	case resp.ContentLength >= 0:
+		b.r = &fixedReader{r: pc.br, remaining: resp.ContentLength}
+	default:
+		b.r = pc.br
+	}
+	resp.Body = b
+	if b.r == NoBody || resp.ContentLength == 0 {
+		b.finish(true)
+	}
+	return resp, false, nil
+}
+
+// clientBody 响应体，读完后将连接放回空闲连接池
+type clientBody struct {
+	pc      *persistConn
+	resp    *Response
+	r       io.Reader
+	chunked *chunkedReader
+
+	ctx    context.Context
+	stop   func() bool        // 停止ctx的监视
+	cancel context.CancelFunc // 结束Client.Timeout创建的ctx
+
+	mu   sync.Mutex
+	done bool
+	err  error
+}
+
+func (b *clientBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		if b.err != nil {
+			return 0, b.err
+		}
+		return 0, io.EOF
+	}
+
+	n, err := b.r.Read(p)
+	switch {
+	case err == io.EOF:
+		b.finish(true)
+	case err != nil:
+		if b.ctx.Err() != nil {
+			err = b.ctx.Err()
+		}
+		b.err = err
+		b.finish(false)
+	}
+	return n, err
+}
+
+// Close 关闭响应体，未读完时关闭连接
+func (b *clientBody) Close() error {
+	if !b.mu.TryLock() {
+		// 另一个goroutine正阻塞在Read中，让它立即返回
+		b.pc.nc.SetReadDeadline(time.Unix(1, 0))
+		b.mu.Lock()
+	}
+	defer b.mu.Unlock()
+	if !b.done {
+		b.err = errors.New("http: read on closed response body")
+		b.finish(false)
+	}
+	return nil
+}
+
+// setCancel 设置读完响应体时结束的ctx，已读完时立即结束
+func (b *clientBody) setCancel(cancel context.CancelFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		cancel()
+		return
+	}
+	b.cancel = cancel
+}
+
+// finish 结束响应，reuse为true时保留连接
+func (b *clientBody) finish(reuse bool) {
+	if b.done {
+		return
+	}
+	b.done = true
+	// 先停止监视再检查ctx，之后结束ctx不会再影响连接
+	b.stop()
+	reuse = reuse && !b.resp.Close && !b.resp.Request.Close && b.ctx.Err() == nil
+	if b.cancel != nil {
+		b.cancel()
+	}
+	if b.chunked != nil {
+		b.resp.Trailer = b.chunked.trailer
+	}
+
+	if reuse {
+		b.pc.client.putIdle(b.pc)
+	} else {
+		b.pc.nc.Close()
+	}
+}
+
+// readResponse 读取响应的状态行和头部，跳过1xx中间响应
+func readResponse(br *bufio.Reader, req *Request) (*Response, error) {
+	for {
+		limit := DefaultMaxHeaderBytes
+		line, err := readLine(br, &limit)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+
+		resp := &Response{Request: req}
+		var status string
+		resp.Proto, status, _ = strings.Cut(string(line), " ")
+		var ok bool
+		if resp.ProtoMajor, resp.ProtoMinor, ok = parseHTTPVersion(resp.Proto); !ok || resp.ProtoMajor != 1 {
+			return nil, fmt.Errorf("http: malformed HTTP version in status line %q", line)
+		}
+		code, _, _ := strings.Cut(status, " ")
+		if resp.StatusCode, err = strconv.Atoi(code); err != nil || len(code) != 3 {
+			return nil, fmt.Errorf("http: malformed status code in status line %q", line)
+		}
+		resp.Status = strings.TrimSpace(status)
+
+		if resp.Header, err = readHeader(br, &limit); err != nil {
+			return nil, unexpected(err)
+		}
+		if resp.StatusCode >= 100 && resp.StatusCode <= 199 {
+			continue
+		}
+
+		if err := resp.setupFraming(); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// setupFraming 确定响应体的长度（RFC 9112 6.3）和连接是否保持
+func (resp *Response) setupFraming() error {
+	if resp.ProtoAtLeast(1, 1) {
+		resp.Close = resp.Header.hasToken("Connection", "close")
+	} else {
+		resp.Close = !resp.Header.hasToken("Connection", "keep-alive")
+	}
+
+	if resp.Request.Method == "HEAD" || !bodyAllowedForStatus(resp.StatusCode) {
+		resp.ContentLength = 0
+		if resp.Request.Method == "HEAD" {
+			if n, err := parseContentLength(resp.Header.Values("Content-Length")); err == nil {
+				resp.ContentLength = n
+			}
+		}
+		return nil
+	}
+
+	te := resp.Header.Values("Transfer-Encoding")
+	cl := resp.Header.Values("Content-Length")
+	switch {
+	case len(te) > 0:
+		resp.ContentLength = -1
+		codings := strings.Split(te[len(te)-1], ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			resp.Chunked = true
+		} else {
+			// 最后的编码不是chunked时以关闭连接结束
+			resp.Close = true
+		}
+	case len(cl) > 0:
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return err
+		}
+		resp.ContentLength = n
+	default:
+		resp.ContentLength = -1
+		resp.Close = true
+	}
+	return nil
+}
+
+// ProtoAtLeast 检查协议版本是否不低于major.minor
+func (resp *Response) ProtoAtLeast(major, minor int) bool {
+	return resp.ProtoMajor > major || resp.ProtoMajor == major && resp.ProtoMinor >= minor
+}
+
+// hostPort 返回URL的"主机:端口"，默认端口80；没有Resolver时主机必须是IPv4地址
+func (c *Client) hostPort(u *url.URL) (string, error) {
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "80"
+	}
+	if host == "" {
+		return "", fmt.Errorf("http: missing host in URL")
+	}
+	if ip := net.ParseIP(host); c.Resolver == nil && (ip == nil || ip.To4() == nil) {
+		return "", fmt.Errorf("http: host %q is not an IPv4 address", host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// urlError 按标准库的习惯包装为*url.Error
+func urlError(req *Request, err error) error {
+	op := req.Method[:1] + strings.ToLower(req.Method[1:])
+	return &url.Error{Op: op, URL: req.URL.String(), Err: err}
+}
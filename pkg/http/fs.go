@@ -0,0 +1,382 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// sniffLen 检测内容类型时读取的字节数
+const sniffLen = 512
+
+// fileServer 提供目录中的静态文件
+type fileServer struct {
+	root string
+}
+
+// FileServer 返回以root为根目录提供静态文件的处理函数，应注册为GET模式（同时处理HEAD）
+//
+// 请求路径直接映射到root下的文件。目录请求返回其中的index.html，没有时返回文件列表；
+// 不以/结尾的目录路径重定向到以/结尾的形式。支持单个字节范围的Range请求（206，多个范围时返回
+// 完整内容）、If-Range，以及基于ETag和Last-Modified的条件请求（304）。
+func FileServer(root string) Handler {
+	return &fileServer{root: root}
+}
+
+// ServeHTTP 处理文件请求
+func (fsrv *fileServer) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		Error(w, "405 method not allowed", StatusMethodNotAllowed)
+		return
+	}
+
+	// 规范化后的路径不会越过根目录
+	name := path.Clean("/" + r.Path)
+	full := filepath.Join(fsrv.root, filepath.FromSlash(name))
+	f, err := os.Open(full)
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		fileError(w, err)
+		return
+	}
+
+	if fi.IsDir() {
+		if !strings.HasSuffix(r.Path, "/") {
+			localRedirect(w, r, path.Base(r.Path)+"/")
+			return
+		}
+		index, err := os.Open(filepath.Join(full, "index.html"))
+		if err != nil {
+			dirList(w, r, f)
+			return
+		}
+		defer index.Close()
+		ifi, err := index.Stat()
+		if err != nil || ifi.IsDir() {
+			dirList(w, r, f)
+			return
+		}
+		f, fi = index, ifi
+	} else if strings.HasSuffix(r.Path, "/") {
+		localRedirect(w, r, "../"+path.Base(r.Path))
+		return
+	}
+
+	ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// ServeContent 回复content的内容，处理Range和条件请求
+//
+// 内容类型先按name的扩展名确定，未知时根据开头的512字节检测；modtime为零值时不发送Last-Modified。
+// 处理函数已设置的Content-Type和ETag不会被覆盖。
+func ServeContent(w ResponseWriter, r *Request, name string, modtime time.Time, content io.ReadSeeker) {
+	h := w.Header()
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		Error(w, "500 internal server error", StatusInternalServerError)
+		return
+	}
+
+	modtime = modtime.UTC().Truncate(time.Second)
+	if !modtime.IsZero() && modtime.Unix() > 0 {
+		h.Set("Last-Modified", modtime.Format(TimeFormat))
+	} else {
+		modtime = time.Time{}
+	}
+	if h.Get("ETag") == "" && !modtime.IsZero() {
+		h.Set("ETag", fmt.Sprintf(`"%x-%x"`, modtime.Unix(), size))
+	}
+	etag := h.Get("ETag")
+
+	if notModified(r, etag, modtime) {
+		// 304不带消息体，去掉描述消息体的字段（RFC 9110 15.4.5）
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(StatusNotModified)
+		return
+	}
+
+	if h.Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(filepath.Ext(name))
+		if ctype == "" {
+			buf := make([]byte, sniffLen)
+			n, _ := io.ReadFull(content, buf)
+			ctype = detectContentType(buf[:n])
+			if _, err := content.Seek(0, io.SeekStart); err != nil {
+				Error(w, "500 internal server error", StatusInternalServerError)
+				return
+			}
+		}
+		h.Set("Content-Type", ctype)
+	}
+	h.Set("Accept-Ranges", "bytes")
+
+	code := StatusOK
+	start, length := int64(0), size
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && rangeApplies(r, etag, modtime) {
+		ranges, err := parseRange(rangeHeader, size)
+		switch {
+		case errors.Is(err, errNoOverlap):
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			Error(w, "416 requested range not satisfiable", StatusRequestedRangeNotSatisfiable)
+			return
+		case err == nil && len(ranges) == 1:
+			code = StatusPartialContent
+			start, length = ranges[0].start, ranges[0].length
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		}
+		// 格式错误或多个范围时忽略Range，返回完整内容（RFC 9110 14.2）
+	}
+
+	if start > 0 {
+		if _, err := content.Seek(start, io.SeekStart); err != nil {
+			Error(w, "500 internal server error", StatusInternalServerError)
+			return
+		}
+	}
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(code)
+	if r.Method != "HEAD" {
+		io.CopyN(w, content, length)
+	}
+}
+
+// notModified 检查GET/HEAD的条件请求，If-None-Match优先于If-Modified-Since（RFC 9110 13.2.2）
+func notModified(r *Request, etag string, modtime time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagListMatch(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modtime.IsZero() {
+		return false
+	}
+	t, err := parseHTTPTime(ims)
+	return err == nil && !modtime.After(t)
+}
+
+// rangeApplies 检查If-Range，不满足时忽略Range返回完整内容（RFC 9110 13.1.5）
+func rangeApplies(r *Request, etag string, modtime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		// If-Range要求强比较
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	t, err := parseHTTPTime(ir)
+	return err == nil && !modtime.IsZero() && modtime.Equal(t)
+}
+
+// etagListMatch 按弱比较检查If-None-Match中是否有etag
+func etagListMatch(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTTPTime 解析HTTP日期，接受IMF-fixdate和两种过时格式（RFC 9110 5.6.7）
+func parseHTTPTime(s string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{TimeFormat, time.RFC850, time.ANSIC} {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// errNoOverlap 所有范围都超出内容长度
+var errNoOverlap = errors.New("http: invalid range: failed to overlap")
+
+// byteRange 内容中的一段
+type byteRange struct {
+	start, length int64
+}
+
+// parseRange 解析"bytes=a-b, c-, -n"形式的Range（RFC 9110 14.1.2），超出内容的范围被丢弃
+func parseRange(s string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return nil, errors.New("http: invalid range unit")
+	}
+
+	var ranges []byteRange
+	for _, ra := range strings.Split(spec, ",") {
+		ra = strings.TrimSpace(ra)
+		if ra == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errors.New("http: invalid range")
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// 后缀范围：最后n个字节
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("http: invalid range")
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errors.New("http: invalid range")
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, errors.New("http: invalid range")
+				}
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+// detectContentType 根据内容开头识别常见格式，无法识别时区分文本与二进制
+func detectContentType(data []byte) string {
+	signatures := []struct {
+		prefix string
+		ctype  string
+	}{
+		{"\x89PNG\r\n\x1a\n", "image/png"},
+		{"\xff\xd8\xff", "image/jpeg"},
+		{"GIF87a", "image/gif"},
+		{"GIF89a", "image/gif"},
+		{"%PDF-", "application/pdf"},
+		{"PK\x03\x04", "application/zip"},
+		{"\x1f\x8b\x08", "application/x-gzip"},
+		{"\xd4\xc3\xb2\xa1", "application/vnd.tcpdump.pcap"},
+		{"\x0a\x0d\x0d\x0a", "application/vnd.tcpdump.pcap"},
+	}
+	for _, sig := range signatures {
+		if bytes.HasPrefix(data, []byte(sig.prefix)) {
+			return sig.ctype
+		}
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	lower := bytes.ToLower(trimmed[:min(len(trimmed), 14)])
+	if bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")) {
+		return "text/html; charset=utf-8"
+	}
+
+	// 截断处可能切开多字节字符
+	text := data
+	for i := 0; i < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if utf8.Valid(text) && bytes.IndexByte(data, 0) < 0 {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// dirList 回复目录的文件列表
+func dirList(w ResponseWriter, r *Request, dir *os.File) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		Error(w, "500 error reading directory", StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var b strings.Builder
+	title := html.EscapeString(r.Path)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<pre>\n", title, title)
+	if r.Path != "/" {
+		b.WriteString("<a href=\"../\">../</a>\n")
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).String()
+		// 含:的文件名会被当作URL的协议
+		if strings.Contains(name, ":") {
+			href = "./" + href
+		}
+		size := "-"
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			size = strconv.FormatInt(info.Size(), 10)
+		}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a> %s\n", html.EscapeString(href), html.EscapeString(name), size)
+	}
+	b.WriteString("</pre>\n</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+// localRedirect 相对当前路径重定向，保留查询串
+func localRedirect(w ResponseWriter, r *Request, target string) {
+	if r.RawQuery != "" {
+		target += "?" + r.RawQuery
+	}
+	Redirect(w, r, target, StatusMovedPermanently)
+}
+
+// fileError 将打开文件的错误转换为状态码
+func fileError(w ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		Error(w, "404 page not found", StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		Error(w, "403 forbidden", StatusForbidden)
+	default:
+		Error(w, "500 internal server error", StatusInternalServerError)
+	}
+}
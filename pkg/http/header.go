@@ -0,0 +1,156 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Header HTTP头部，键为规范化的字段名（如Content-Type）
+type Header map[string][]string
+
+// Get 返回字段的第一个值，不存在时返回空串
+func (h Header) Get(key string) string {
+	if v := h[textproto.CanonicalMIMEHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values 返回字段的所有值
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+// Set 设置字段的值，替换已有的值
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Add 为字段追加一个值
+func (h Header) Add(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Del 删除字段
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// Clone 返回头部的副本
+func (h Header) Clone() Header {
+	c := make(Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// hasToken 检查逗号分隔的字段值中是否包含token（不区分大小写），用于Connection等字段
+func (h Header) hasToken(key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// write 按字段名排序写出头部，值中的CR/LF替换为空格以防止头部注入
+func (h Header) write(w io.StringWriter) error {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range h[k] {
+			v = strings.TrimSpace(headerNewlines.Replace(v))
+			for _, s := range []string{k, ": ", v, "\r\n"} {
+				if _, err := w.WriteString(s); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var headerNewlines = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// isToken 检查字符串是否为RFC 7230 3.2.6定义的token（方法名、字段名）
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x80 || c <= ' ' || c == 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// readLine 读取一行（去掉行尾的CRLF或LF），limit为剩余可读的字节数，超出时返回errHeaderTooLarge
+func readLine(br *bufio.Reader, limit *int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		*limit -= len(chunk)
+		if *limit < 0 {
+			return nil, errHeaderTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		return line, nil
+	}
+}
+
+// readHeader 读取头部字段直到空行（RFC 9112 5）
+//
+// 字段名必须是token且与冒号之间没有空白，不接受已废弃的折行。
+func readHeader(br *bufio.Reader, limit *int) (Header, error) {
+	h := make(Header)
+	for {
+		line, err := readLine(br, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return h, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, badRequest("obsolete header line folding")
+		}
+
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 || !isToken(string(line[:i])) {
+			return nil, badRequest("malformed header line %q", line)
+		}
+		value := strings.Trim(string(line[i+1:]), " \t")
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, badRequest("invalid header value for %s", line[:i])
+		}
+		h.Add(string(line[:i]), value)
+	}
+}
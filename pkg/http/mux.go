@@ -0,0 +1,163 @@
+package http
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ServeMux 按方法和路径将请求分发给处理函数
+//
+// 模式为"[方法 ]路径"，如"GET /health"或"/static/"。路径以/结尾时匹配整个子树，否则只匹配该路径；
+// 多个模式匹配时选择路径最长的。省略方法时匹配所有方法，GET同时匹配HEAD。路径匹配但方法都不匹配时
+// 回复405并在Allow中列出可用的方法。
+type ServeMux struct {
+	mu     sync.RWMutex
+	routes []muxRoute
+}
+
+type muxRoute struct {
+	method  string
+	path    string
+	handler Handler
+}
+
+// NewServeMux 创建空的路由表
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle 注册模式的处理函数，模式无效或重复时panic
+func (m *ServeMux) Handle(pattern string, handler Handler) {
+	method, p, found := strings.Cut(pattern, " ")
+	if !found {
+		method, p = "", pattern
+	}
+	p = strings.TrimLeft(p, " ")
+	if !strings.HasPrefix(p, "/") || (method != "" && !isToken(method)) {
+		panic(fmt.Sprintf("http: invalid pattern %q", pattern))
+	}
+	if handler == nil {
+		panic("http: nil handler for " + pattern)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.routes {
+		if r.method == method && r.path == p {
+			panic(fmt.Sprintf("http: multiple registrations for %q", pattern))
+		}
+	}
+	m.routes = append(m.routes, muxRoute{method: method, path: p, handler: handler})
+}
+
+// HandleFunc 注册模式的处理函数
+func (m *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
+	m.Handle(pattern, HandlerFunc(handler))
+}
+
+// ServeHTTP 分发请求，路径不规范（含.、..或重复的/）时重定向到规范路径
+func (m *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Path == "*" {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Content-Length", "0")
+			return
+		}
+		Error(w, "400 bad request", StatusBadRequest)
+		return
+	}
+
+	if clean := cleanPath(r.Path); clean != r.Path {
+		target := clean
+		if r.RawQuery != "" {
+			target += "?" + r.RawQuery
+		}
+		code := StatusMovedPermanently
+		if r.Method != "GET" && r.Method != "HEAD" {
+			code = StatusPermanentRedirect
+		}
+		Redirect(w, r, target, code)
+		return
+	}
+
+	h, allowed := m.match(r.Method, r.Path)
+	switch {
+	case h != nil:
+		h.ServeHTTP(w, r)
+	case len(allowed) > 0:
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		Error(w, "405 method not allowed", StatusMethodNotAllowed)
+	default:
+		NotFound(w, r)
+	}
+}
+
+// match 返回匹配的处理函数；没有时返回路径匹配的路由允许的方法
+func (m *ServeMux) match(method, p string) (Handler, []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var best *muxRoute
+	longest := -1
+	var allowed []string
+	for i := range m.routes {
+		r := &m.routes[i]
+		if !pathMatches(r.path, p) {
+			continue
+		}
+		if !methodMatches(r.method, method) {
+			allowed = append(allowed, r.method)
+			if r.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+		if len(r.path) > longest || (len(r.path) == longest && r.method != "") {
+			best, longest = r, len(r.path)
+		}
+	}
+	if best != nil {
+		return best.handler, nil
+	}
+
+	sort.Strings(allowed)
+	return nil, compactStrings(allowed)
+}
+
+func pathMatches(pattern, p string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(p, pattern)
+	}
+	return p == pattern
+}
+
+func methodMatches(pattern, method string) bool {
+	return pattern == "" || pattern == method || (pattern == "GET" && method == "HEAD")
+}
+
+// cleanPath 返回规范的路径，保留结尾的/
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+func compactStrings(s []string) []string {
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,308 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Request 服务端收到或客户端发送的HTTP请求
+type Request struct {
+	Method     string   // 请求方法，如GET
+	URL        *url.URL // 客户端请求的地址；服务端为请求目标解析的结果
+	Target     string   // 原始请求目标，如/search?q=go
+	Path       string   // 解码后的路径
+	RawQuery   string   // 未解码的查询串（不含?）
+	Proto      string   // 协议版本，如HTTP/1.1
+	ProtoMajor int
+	ProtoMinor int
+	Header     Header
+	Host       string // Host字段或绝对形式请求目标中的主机
+
+	// ContentLength 消息体长度，分块编码时为-1；客户端请求有消息体时0也表示未知
+	ContentLength int64
+	// Body 消息体，没有消息体时读取立即返回io.EOF；处理函数返回后未读的部分由服务端丢弃
+	Body io.Reader
+	// GetBody 返回消息体的新副本，客户端在307/308重定向和重试时用于重新发送
+	GetBody func() (io.Reader, error)
+	// Close 响应后关闭连接（HTTP/1.1的Connection: close，或HTTP/1.0未请求keep-alive）
+	Close bool
+
+	RemoteAddr string // 客户端地址
+}
+
+// NewRequest 创建客户端请求，rawURL必须是http地址
+//
+// body为*bytes.Buffer、*bytes.Reader或*strings.Reader时自动设置ContentLength和GetBody，
+// 其他长度未知的消息体使用分块编码发送。
+func NewRequest(method, rawURL string, body io.Reader) (*Request, error) {
+	if method == "" {
+		method = "GET"
+	}
+	if !isToken(method) {
+		return nil, fmt.Errorf("http: invalid method %q", method)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("http: unsupported protocol scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("http: no host in request URL %q", rawURL)
+	}
+
+	r := &Request{
+		Method:     method,
+		URL:        u,
+		Target:     u.RequestURI(),
+		Path:       u.Path,
+		RawQuery:   u.RawQuery,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(Header),
+		Host:       u.Host,
+		Body:       body,
+	}
+
+	switch v := body.(type) {
+	case *bytes.Buffer:
+		buf := v.Bytes()
+		r.ContentLength = int64(len(buf))
+		r.GetBody = func() (io.Reader, error) { return bytes.NewReader(buf), nil }
+	case *bytes.Reader:
+		snapshot := *v
+		r.ContentLength = int64(v.Len())
+		r.GetBody = func() (io.Reader, error) { c := snapshot; return &c, nil }
+	case *strings.Reader:
+		snapshot := *v
+		r.ContentLength = int64(v.Len())
+		r.GetBody = func() (io.Reader, error) { c := snapshot; return &c, nil }
+	case nil:
+		r.GetBody = func() (io.Reader, error) { return nil, nil }
+	}
+	if r.GetBody != nil && r.ContentLength == 0 {
+		r.Body = nil
+	}
+	return r, nil
+}
+
+// ProtoAtLeast 检查协议版本是否不低于major.minor
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	return r.ProtoMajor > major || r.ProtoMajor == major && r.ProtoMinor >= minor
+}
+
+// Query 返回解析后的查询参数
+func (r *Request) Query() url.Values {
+	v, _ := url.ParseQuery(r.RawQuery)
+	return v
+}
+
+// expectsContinue 客户端等待100 Continue后才发送消息体
+func (r *Request) expectsContinue() bool {
+	return r.ProtoAtLeast(1, 1) && r.Header.hasToken("Expect", "100-continue")
+}
+
+// readRequest 从br读取一个请求的请求行和头部并设置消息体
+//
+// br中可能已缓存后续（流水线）请求的数据，消息体只读到本请求结束为止。
+func readRequest(br *bufio.Reader, maxHeaderBytes int) (*Request, error) {
+	limit := maxHeaderBytes
+
+	// 忽略请求行之前的空行（RFC 9112 2.2）
+	var line []byte
+	for {
+		var err error
+		if line, err = readLine(br, &limit); err != nil {
+			return nil, err
+		}
+		if len(line) > 0 {
+			break
+		}
+	}
+
+	r := &Request{}
+	parts := strings.Split(string(line), " ")
+	if len(parts) != 3 {
+		return nil, badRequest("malformed request line %q", line)
+	}
+	r.Method, r.Target, r.Proto = parts[0], parts[1], parts[2]
+	if !isToken(r.Method) {
+		return nil, badRequest("invalid method %q", r.Method)
+	}
+
+	var ok bool
+	if r.ProtoMajor, r.ProtoMinor, ok = parseHTTPVersion(r.Proto); !ok {
+		return nil, badRequest("malformed HTTP version %q", r.Proto)
+	}
+	if r.ProtoMajor != 1 {
+		return nil, &ProtocolError{Status: StatusHTTPVersionNotSupported, Message: "unsupported protocol version " + r.Proto}
+	}
+	if err := r.parseTarget(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if r.Header, err = readHeader(br, &limit); err != nil {
+		return nil, err
+	}
+
+	// HTTP/1.1请求必须有且只有一个Host（RFC 9112 3.2）
+	hosts := r.Header.Values("Host")
+	switch {
+	case len(hosts) > 1:
+		return nil, badRequest("too many Host headers")
+	case len(hosts) == 0 && r.ProtoAtLeast(1, 1):
+		return nil, badRequest("missing required Host header")
+	case r.Host == "" && len(hosts) == 1:
+		r.Host = hosts[0]
+	}
+
+	if r.ProtoAtLeast(1, 1) {
+		r.Close = r.Header.hasToken("Connection", "close")
+	} else {
+		r.Close = !r.Header.hasToken("Connection", "keep-alive")
+	}
+
+	if err := r.setupBody(br); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// parseTarget 解析请求目标（RFC 9112 3.2），支持源形式、绝对形式和OPTIONS的星号形式
+func (r *Request) parseTarget() error {
+	target := r.Target
+	if target == "*" && r.Method == "OPTIONS" {
+		r.Path = "*"
+		r.URL = &url.URL{Path: "*"}
+		return nil
+	}
+
+	if !strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return badRequest("invalid request target %q", target)
+		}
+		r.Host = u.Host
+		target = u.RequestURI()
+	}
+
+	path, query, _ := strings.Cut(target, "?")
+	p, err := url.PathUnescape(path)
+	if err != nil {
+		return badRequest("invalid request target %q", r.Target)
+	}
+	r.Path, r.RawQuery = p, query
+	r.URL = &url.URL{Path: p, RawPath: path, RawQuery: query, Host: r.Host}
+	return nil
+}
+
+// write 写出客户端请求，消息体长度未知时使用分块编码（RFC 9112 6.1）
+func (r *Request) write(bw *bufio.Writer) error {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", r.Method, r.URL.RequestURI())
+
+	h := r.Header.Clone()
+	h.Set("Host", host)
+	h.Del("Transfer-Encoding")
+	h.Del("Content-Length")
+	if h.Get("User-Agent") == "" {
+		h.Set("User-Agent", userAgent)
+	}
+
+	// 有GetBody时长度已知，0表示没有消息体
+	hasBody := r.Body != nil && (r.ContentLength != 0 || r.GetBody == nil)
+	var chunked bool
+	switch {
+	case !hasBody:
+		// 通常带消息体的方法显式给出长度0（RFC 9110 8.6）
+		if r.Method == "POST" || r.Method == "PUT" || r.Method == "PATCH" {
+			h.Set("Content-Length", "0")
+		}
+	case r.ContentLength > 0:
+		h.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	default:
+		h.Set("Transfer-Encoding", "chunked")
+		chunked = true
+	}
+	if err := h.write(bw); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	switch {
+	case !hasBody:
+	case chunked:
+		cw := &chunkedWriter{w: bw}
+		if _, err := io.Copy(cw, r.Body); err != nil {
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+	default:
+		n, err := io.Copy(bw, io.LimitReader(r.Body, r.ContentLength))
+		if err != nil {
+			return err
+		}
+		if n != r.ContentLength {
+			return fmt.Errorf("http: ContentLength=%d with body length %d", r.ContentLength, n)
+		}
+	}
+	return bw.Flush()
+}
+
+// setupBody 按Transfer-Encoding和Content-Length确定消息体的长度（RFC 9112 6.3）
+func (r *Request) setupBody(br *bufio.Reader) error {
+	te := r.Header.Values("Transfer-Encoding")
+	cl := r.Header.Values("Content-Length")
+
+	switch {
+	case len(te) > 0:
+		// 同时出现两者可能被用于请求走私，直接拒绝
+		if len(cl) > 0 {
+			return badRequest("both Transfer-Encoding and Content-Length")
+		}
+		if len(te) != 1 || !strings.EqualFold(strings.TrimSpace(te[0]), "chunked") {
+			return &ProtocolError{Status: StatusNotImplemented, Message: "unsupported transfer encoding " + strings.Join(te, ",")}
+		}
+		r.ContentLength = -1
+		r.Body = &body{src: newChunkedReader(br)}
+	case len(cl) > 0:
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return err
+		}
+		r.ContentLength = n
+		r.Body = &body{src: &fixedReader{r: br, remaining: n}}
+	default:
+		r.Body = &body{src: NoBody}
+	}
+	return nil
+}
+
+// parseHTTPVersion 解析"HTTP/x.y"
+func parseHTTPVersion(proto string) (int, int, bool) {
+	rest, ok := strings.CutPrefix(proto, "HTTP/")
+	if !ok || len(rest) != 3 || rest[1] != '.' {
+		return 0, 0, false
+	}
+	major, err1 := strconv.Atoi(rest[:1])
+	minor, err2 := strconv.Atoi(rest[2:])
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
@@ -0,0 +1,247 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TimeFormat HTTP日期格式（RFC 9110 5.6.7的IMF-fixdate），时间必须为UTC
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// 处理函数写入的数据先缓存，处理函数在缓存满之前返回时可以给出准确的Content-Length
+const responseBufferSize = 4096
+
+// ResponseWriter 处理函数通过它构造响应
+type ResponseWriter interface {
+	// Header 返回响应头部，WriteHeader或第一次Write之后的修改无效
+	Header() Header
+	// WriteHeader 设置状态码，只有第一次调用有效
+	WriteHeader(code int)
+	// Write 写入消息体，未调用WriteHeader时使用200
+	Write(p []byte) (int, error)
+}
+
+// Flusher 由ResponseWriter实现，立即发送已写入的数据（此后长度未知的响应使用分块编码）
+type Flusher interface {
+	Flush()
+}
+
+// response 服务端的ResponseWriter
+//
+// 消息体的长度按以下规则确定：处理函数设置了Content-Length时使用该值；处理函数返回时
+// 数据仍在缓存中则使用实际长度；否则HTTP/1.1请求使用分块编码，HTTP/1.0请求在响应后关闭连接。
+type response struct {
+	c   *conn
+	req *Request
+
+	header      Header
+	status      int
+	wroteHeader bool // 已确定状态码
+	headerSent  bool // 状态行和头部已写出
+
+	buf           []byte // 发送头部之前缓存的消息体
+	chunked       *chunkedWriter
+	contentLength int64 // 声明的长度，-1表示未声明
+	written       int64 // 处理函数写入的字节数
+
+	closeAfter bool // 响应后关闭连接
+	err        error
+}
+
+func newResponse(c *conn, req *Request) *response {
+	return &response{c: c, req: req, header: make(Header), contentLength: -1}
+}
+
+// Header 返回响应头部
+func (w *response) Header() Header {
+	return w.header
+}
+
+// WriteHeader 设置状态码
+func (w *response) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.c.srv.logger().Warn("HTTP: superfluous WriteHeader(%d) for %s %s", code, w.req.Method, w.req.Target)
+		return
+	}
+	if code < 100 || code > 999 {
+		panic(fmt.Sprintf("http: invalid status code %d", code))
+	}
+	w.wroteHeader = true
+	w.status = code
+
+	if cl := w.header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			w.contentLength = n
+		} else {
+			w.c.srv.logger().Warn("HTTP: invalid Content-Length %q set by handler", cl)
+			w.header.Del("Content-Length")
+		}
+	}
+}
+
+// Write 写入消息体
+func (w *response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(StatusOK)
+	}
+	if !bodyAllowedForStatus(w.status) {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.written += int64(len(p))
+	if w.req.Method == "HEAD" {
+		return len(p), nil
+	}
+	if !w.headerSent {
+		if len(w.buf)+len(p) <= responseBufferSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		w.sendHeader(false)
+	}
+	return w.writeBody(p)
+}
+
+// Flush 立即发送头部和已写入的数据
+func (w *response) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(StatusOK)
+	}
+	if !w.headerSent {
+		w.sendHeader(false)
+	}
+	if err := w.c.bw.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// sendHeader 确定消息体长度和连接是否保持，写出状态行和头部
+//
+// final表示处理函数已返回，此时缓存中即为完整的消息体。
+func (w *response) sendHeader(final bool) {
+	w.headerSent = true
+	h := w.header
+	h.Del("Transfer-Encoding")
+
+	switch {
+	case !bodyAllowedForStatus(w.status):
+		h.Del("Content-Length")
+	case w.contentLength >= 0:
+	case final && (w.req.Method != "HEAD" || w.written > 0):
+		w.contentLength = w.written
+		h.Set("Content-Length", strconv.FormatInt(w.written, 10))
+	case w.req.Method == "HEAD":
+		// 处理函数没有写入也没有声明长度，不给出长度
+	case w.req.ProtoAtLeast(1, 1):
+		h.Set("Transfer-Encoding", "chunked")
+		w.chunked = &chunkedWriter{w: w.c.bw}
+	default:
+		// HTTP/1.0客户端以连接关闭判断消息体结束
+		w.closeAfter = true
+	}
+
+	if b, ok := w.req.Body.(*body); ok && b.err != nil {
+		w.closeAfter = true
+	}
+	if w.req.Close || h.hasToken("Connection", "close") || w.c.srv.shuttingDown() {
+		w.closeAfter = true
+	}
+	if w.closeAfter {
+		h.Set("Connection", "close")
+	} else if !w.req.ProtoAtLeast(1, 1) {
+		h.Set("Connection", "keep-alive")
+	}
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(TimeFormat))
+	}
+
+	bw := w.c.bw
+	text := StatusText(w.status)
+	if text == "" {
+		text = "status code " + strconv.Itoa(w.status)
+	}
+	fmt.Fprintf(bw, "HTTP/1.1 %d %s\r\n", w.status, text)
+	h.write(bw)
+	bw.WriteString("\r\n")
+
+	if len(w.buf) > 0 {
+		buf := w.buf
+		w.buf = nil
+		w.writeBody(buf)
+	}
+}
+
+// writeBody 写出消息体数据
+func (w *response) writeBody(p []byte) (int, error) {
+	var n int
+	var err error
+	if w.chunked != nil {
+		n, err = w.chunked.Write(p)
+	} else {
+		n, err = w.c.bw.Write(p)
+	}
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// finish 处理函数返回后结束响应
+func (w *response) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(StatusOK)
+	}
+	if !w.headerSent {
+		w.sendHeader(true)
+	}
+	if w.chunked != nil {
+		if err := w.chunked.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+	}
+	if err := w.c.bw.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+
+	// 写入的数据少于声明的长度时，客户端无法确定响应的边界
+	if w.req.Method != "HEAD" && bodyAllowedForStatus(w.status) && w.contentLength >= 0 && w.written < w.contentLength {
+		w.closeAfter = true
+	}
+	if w.err != nil {
+		w.closeAfter = true
+	}
+}
+
+// Error 回复纯文本的错误信息
+func Error(w ResponseWriter, message string, code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, message)
+}
+
+// NotFound 回复404
+func NotFound(w ResponseWriter, r *Request) {
+	Error(w, "404 page not found", StatusNotFound)
+}
+
+// Redirect 重定向到url，code应为3xx
+func Redirect(w ResponseWriter, r *Request, url string, code int) {
+	w.Header().Set("Location", url)
+	if r.Method == "GET" || r.Method == "HEAD" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "%s: %s\n", StatusText(code), url)
+		return
+	}
+	w.WriteHeader(code)
+}
@@ -0,0 +1,298 @@
+// Package http 在ustack TCP上实现HTTP/1.1服务端和客户端
+//
+// 请求按字节流增量解析，请求行、头部和消息体可以任意拆分在多个TCP段中；连接默认保持，
+// 同一连接上流水线发送的请求按顺序处理和响应。客户端按主机复用空闲连接，并处理重定向和超时。
+package http
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/gonet"
+	"ustack/pkg/stack"
+)
+
+const (
+	// DefaultMaxHeaderBytes 请求行和头部的默认长度上限
+	DefaultMaxHeaderBytes = 1 << 20
+
+	// 处理函数返回后最多丢弃的未读请求体，超过时关闭连接而不是继续读取
+	maxDrainBytes = 256 << 10
+)
+
+// ErrServerClosed 服务端已关闭
+var ErrServerClosed = errors.New("http: server closed")
+
+// Handler 处理HTTP请求
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc 将函数适配为Handler
+type HandlerFunc func(ResponseWriter, *Request)
+
+// ServeHTTP 调用f(w, r)
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// Server HTTP/1.1服务端
+type Server struct {
+	Handler Handler // 请求处理函数，通常为*ServeMux
+
+	ReadTimeout    time.Duration // 读取整个请求的最长时间，0表示不限制
+	WriteTimeout   time.Duration // 写出响应的最长时间，0表示不限制
+	IdleTimeout    time.Duration // 持久连接等待下一个请求的最长时间，0表示使用ReadTimeout
+	MaxHeaderBytes int           // 请求行和头部的长度上限，0表示DefaultMaxHeaderBytes
+
+	Logger *utils.Logger // 日志，nil表示utils.DefaultLogger
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+}
+
+// ListenAndServe 在协议栈s的addr上监听并处理请求
+func ListenAndServe(s *stack.Stack, addr stack.FullAddress, handler Handler) error {
+	srv := &Server{Handler: handler}
+	return srv.ListenAndServe(s, addr)
+}
+
+// ListenAndServe 在协议栈s的addr上监听并处理请求，直到Close
+func (srv *Server) ListenAndServe(s *stack.Stack, addr stack.FullAddress) error {
+	l, err := gonet.ListenTCP(s, addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve 接受l上的连接，每个连接在单独的goroutine中处理，返回时l已关闭
+func (srv *Server) Serve(l net.Listener) error {
+	if !srv.track(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer srv.untrack(l)
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c := &conn{srv: srv, nc: nc, br: bufio.NewReader(nc), bw: bufio.NewWriter(nc)}
+		if !srv.trackConn(c, true) {
+			nc.Close()
+			return ErrServerClosed
+		}
+		go c.serve()
+	}
+}
+
+// Close 关闭所有监听和连接，正在处理的请求的连接被直接关闭
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	srv.closed = true
+	listeners, conns := srv.listeners, srv.conns
+	srv.listeners, srv.conns = nil, nil
+	srv.mu.Unlock()
+
+	var err error
+	for l := range listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range conns {
+		c.nc.Close()
+	}
+	return err
+}
+
+func (srv *Server) track(l net.Listener) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.closed {
+		return false
+	}
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	srv.listeners[l] = struct{}{}
+	return true
+}
+
+func (srv *Server) untrack(l net.Listener) {
+	srv.mu.Lock()
+	delete(srv.listeners, l)
+	srv.mu.Unlock()
+	l.Close()
+}
+
+func (srv *Server) trackConn(c *conn, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !add {
+		delete(srv.conns, c)
+		return true
+	}
+	if srv.closed {
+		return false
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[*conn]struct{})
+	}
+	srv.conns[c] = struct{}{}
+	return true
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.closed
+}
+
+func (srv *Server) logger() *utils.Logger {
+	if srv.Logger != nil {
+		return srv.Logger
+	}
+	return utils.DefaultLogger
+}
+
+func (srv *Server) maxHeaderBytes() int {
+	if srv.MaxHeaderBytes > 0 {
+		return srv.MaxHeaderBytes
+	}
+	return DefaultMaxHeaderBytes
+}
+
+func (srv *Server) idleTimeout() time.Duration {
+	if srv.IdleTimeout > 0 {
+		return srv.IdleTimeout
+	}
+	return srv.ReadTimeout
+}
+
+// conn 服务端的一条连接
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	br  *bufio.Reader
+	bw  *bufio.Writer
+}
+
+// serve 依次读取请求并响应，直到对端关闭、出错或某个响应要求关闭连接
+func (c *conn) serve() {
+	defer func() {
+		c.nc.Close()
+		c.srv.trackConn(c, false)
+	}()
+	log := c.srv.logger()
+	log.Debug("HTTP: accepted connection from %s", c.nc.RemoteAddr())
+
+	for {
+		// 等待下一个请求的第一个字节
+		if d := c.srv.idleTimeout(); d > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(d))
+		}
+		if _, err := c.br.Peek(1); err != nil {
+			return
+		}
+		if d := c.srv.ReadTimeout; d > 0 {
+			c.nc.SetReadDeadline(time.Now().Add(d))
+		} else {
+			c.nc.SetReadDeadline(time.Time{})
+		}
+
+		req, err := readRequest(c.br, c.srv.maxHeaderBytes())
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		req.RemoteAddr = c.nc.RemoteAddr().String()
+
+		if d := c.srv.WriteTimeout; d > 0 {
+			c.nc.SetWriteDeadline(time.Now().Add(d))
+		}
+		w := newResponse(c, req)
+		if !c.handle(w, req) {
+			return
+		}
+		if w.closeAfter || !c.drain(req) {
+			return
+		}
+	}
+}
+
+// handle 调用处理函数并结束响应，处理函数panic时返回false
+func (c *conn) handle(w *response, req *Request) (ok bool) {
+	if req.expectsContinue() {
+		// 处理函数读取消息体时才让客户端发送，未读取时无法确定客户端是否已发送，响应后关闭连接
+		w.closeAfter = true
+		req.Body.(*body).onFirstRead = func() {
+			if !w.headerSent {
+				w.closeAfter = false
+				c.bw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+				c.bw.Flush()
+			}
+		}
+	} else if req.Header.Get("Expect") != "" {
+		w.closeAfter = true
+		Error(w, "417 expectation failed", StatusExpectationFailed)
+		w.finish()
+		return true
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			c.srv.logger().Error("HTTP: panic serving %s %s: %v\n%s", req.Method, req.Target, v, debug.Stack())
+			ok = false
+		}
+	}()
+
+	handler := c.srv.Handler
+	if handler == nil {
+		handler = HandlerFunc(NotFound)
+	}
+	handler.ServeHTTP(w, req)
+	w.finish()
+	return true
+}
+
+// drain 丢弃处理函数未读的请求体，使下一个请求从正确的位置开始
+func (c *conn) drain(req *Request) bool {
+	n, err := io.CopyN(io.Discard, req.Body, maxDrainBytes+1)
+	return err == io.EOF && n <= maxDrainBytes
+}
+
+// replyError 对无法解析的请求回复错误并关闭连接，连接已关闭或超时时不回复
+func (c *conn) replyError(err error) {
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			perr = &ProtocolError{Status: StatusRequestTimeout, Message: "request timeout"}
+		} else {
+			return
+		}
+	}
+	c.srv.logger().Debug("HTTP: bad request from %s: %v", c.nc.RemoteAddr(), err)
+
+	text := fmt.Sprintf("%d %s\n", perr.Status, StatusText(perr.Status))
+	c.nc.SetWriteDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(c.bw, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %s\r\nConnection: close\r\n\r\n%s",
+		perr.Status, StatusText(perr.Status), strconv.Itoa(len(text)), text)
+	c.bw.Flush()
+}
@@ -0,0 +1,90 @@
+package http
+
+// HTTP状态码（RFC 9110）
+const (
+	StatusContinue = 100
+
+	StatusOK             = 200
+	StatusCreated        = 201
+	StatusAccepted       = 202
+	StatusNoContent      = 204
+	StatusPartialContent = 206
+
+	StatusMovedPermanently  = 301
+	StatusFound             = 302
+	StatusSeeOther          = 303
+	StatusNotModified       = 304
+	StatusTemporaryRedirect = 307
+	StatusPermanentRedirect = 308
+
+	StatusBadRequest                   = 400
+	StatusUnauthorized                 = 401
+	StatusForbidden                    = 403
+	StatusNotFound                     = 404
+	StatusMethodNotAllowed             = 405
+	StatusRequestTimeout               = 408
+	StatusLengthRequired               = 411
+	StatusPreconditionFailed           = 412
+	StatusRequestEntityTooLarge        = 413
+	StatusRequestURITooLong            = 414
+	StatusRequestedRangeNotSatisfiable = 416
+	StatusExpectationFailed            = 417
+	StatusRequestHeaderFieldsTooLarge  = 431
+
+	StatusInternalServerError     = 500
+	StatusNotImplemented          = 501
+	StatusServiceUnavailable      = 503
+	StatusHTTPVersionNotSupported = 505
+)
+
+var statusText = map[int]string{
+	StatusContinue: "Continue",
+
+	StatusOK:             "OK",
+	StatusCreated:        "Created",
+	StatusAccepted:       "Accepted",
+	StatusNoContent:      "No Content",
+	StatusPartialContent: "Partial Content",
+
+	StatusMovedPermanently:  "Moved Permanently",
+	StatusFound:             "Found",
+	StatusSeeOther:          "See Other",
+	StatusNotModified:       "Not Modified",
+	StatusTemporaryRedirect: "Temporary Redirect",
+	StatusPermanentRedirect: "Permanent Redirect",
+
+	StatusBadRequest:                   "Bad Request",
+	StatusUnauthorized:                 "Unauthorized",
+	StatusForbidden:                    "Forbidden",
+	StatusNotFound:                     "Not Found",
+	StatusMethodNotAllowed:             "Method Not Allowed",
+	StatusRequestTimeout:               "Request Timeout",
+	StatusLengthRequired:               "Length Required",
+	StatusPreconditionFailed:           "Precondition Failed",
+	StatusRequestEntityTooLarge:        "Request Entity Too Large",
+	StatusRequestURITooLong:            "Request URI Too Long",
+	StatusRequestedRangeNotSatisfiable: "Requested Range Not Satisfiable",
+	StatusExpectationFailed:            "Expectation Failed",
+	StatusRequestHeaderFieldsTooLarge:  "Request Header Fields Too Large",
+
+	StatusInternalServerError:     "Internal Server Error",
+	StatusNotImplemented:          "Not Implemented",
+	StatusServiceUnavailable:      "Service Unavailable",
+	StatusHTTPVersionNotSupported: "HTTP Version Not Supported",
+}
+
+// StatusText 返回状态码的原因短语，未知状态码返回空串
+func StatusText(code int) string {
+	return statusText[code]
+}
+
+// bodyAllowedForStatus 检查状态码的响应是否可以携带消息体（RFC 9110 6.4.1）
+func bodyAllowedForStatus(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == StatusNoContent, code == StatusNotModified:
+		return false
+	}
+	return true
+}
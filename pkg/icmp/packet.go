@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"ustack/internal/utils"
+	"ustack/pkg/ip"
 )
 
 const (
@@ -16,9 +17,32 @@ const (
 	// ICMP代码
 	CodeEchoRequest = 0
 	CodeEchoReply   = 0
+
+	// Destination Unreachable代码
+	CodeNetUnreach        = 0
+	CodeHostUnreach       = 1
+	CodeProtoUnreach      = 2
+	CodePortUnreach       = 3
+	CodeFragNeeded        = 4
+	CodeSourceRouteFailed = 5
+	CodeNetUnknown        = 6
+	CodeHostUnknown       = 7
+	CodeNetProhibited     = 9
+	CodeHostProhibited    = 10
+	CodeAdminProhibited   = 13
+
+	// Time Exceeded代码
+	CodeTTLExceeded        = 0
+	CodeReassemblyExceeded = 1
+
+	// 差错报文携带的原始数据报数据长度（IP头部之后）
+	ErrorQuoteLength = 8
 )
 
 // Packet ICMP数据包结构
+//
+// 差错报文中ID未使用，Sequence在分片需要（Fragmentation Needed）时为下一跳MTU（RFC 1191），
+// Data为原始IP头部和前8字节数据。
 type Packet struct {
 	Type     uint8  // 类型
 	Code     uint8  // 代码
@@ -59,12 +83,16 @@ func (p *Packet) Marshal() ([]byte, error) {
 	return data, nil
 }
 
-// Unmarshal 从字节数组解析ICMP数据包
+// Unmarshal 从字节数组解析ICMP数据包并校验校验和
 func (p *Packet) Unmarshal(data []byte) error {
 	if len(data) < 8 {
 		return fmt.Errorf("ICMP packet too short: %d bytes", len(data))
 	}
 
+	if utils.CalculateChecksum(data) != 0 {
+		return fmt.Errorf("ICMP checksum mismatch")
+	}
+
 	// 类型
 	p.Type = data[0]
 
@@ -103,6 +131,46 @@ func (p *Packet) IsEchoReply() bool {
 	return p.Type == TypeEchoReply
 }
 
+// IsError 检查是否为差错报文
+func (p *Packet) IsError() bool {
+	return IsErrorType(p.Type)
+}
+
+// IsErrorType 检查ICMP类型是否为差错报文
+func IsErrorType(typ uint8) bool {
+	switch typ {
+	case TypeDestUnreach, TypeTimeExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// NextHopMTU 返回分片需要报文中的下一跳MTU，不适用或未携带时返回0
+func (p *Packet) NextHopMTU() uint16 {
+	if p.Type != TypeDestUnreach || p.Code != CodeFragNeeded {
+		return 0
+	}
+	return p.Sequence
+}
+
+// OriginalDatagram 解析差错报文中携带的原始IP头部，返回头部和其后的传输层数据
+func (p *Packet) OriginalDatagram() (*ip.Header, []byte, error) {
+	if !p.IsError() {
+		return nil, nil, fmt.Errorf("ICMP type %d is not an error message", p.Type)
+	}
+
+	h := &ip.Header{}
+	if err := h.Unmarshal(p.Data); err != nil {
+		return nil, nil, fmt.Errorf("invalid quoted IP header: %v", err)
+	}
+	headerLength := int(h.IHL) * 4
+	if h.Version != 4 || headerLength < ip.IPHeaderLength || headerLength > len(p.Data) {
+		return nil, nil, fmt.Errorf("invalid quoted IP header")
+	}
+	return h, p.Data[headerLength:], nil
+}
+
 // CreateReply 创建回复数据包
 func (p *Packet) CreateReply() *Packet {
 	return &Packet{
@@ -126,3 +194,42 @@ func NewEchoRequest(id, sequence uint16, data []byte) *Packet {
 		Data:     data,
 	}
 }
+
+// NewDestUnreach 创建Destination Unreachable报文，original为触发差错的原始IP数据报
+func NewDestUnreach(code uint8, original []byte) *Packet {
+	return &Packet{
+		Type: TypeDestUnreach,
+		Code: code,
+		Data: quoteDatagram(original),
+	}
+}
+
+// NewFragNeeded 创建携带下一跳MTU的分片需要报文（RFC 1191）
+func NewFragNeeded(mtu uint16, original []byte) *Packet {
+	p := NewDestUnreach(CodeFragNeeded, original)
+	p.Sequence = mtu
+	return p
+}
+
+// NewTimeExceeded 创建Time Exceeded报文
+func NewTimeExceeded(code uint8, original []byte) *Packet {
+	return &Packet{
+		Type: TypeTimeExceeded,
+		Code: code,
+		Data: quoteDatagram(original),
+	}
+}
+
+// quoteDatagram 截取原始数据报的IP头部和前8字节数据（RFC 792）
+func quoteDatagram(original []byte) []byte {
+	length := len(original)
+	if length > 0 {
+		if quoted := int(original[0]&0x0F)*4 + ErrorQuoteLength; quoted < length {
+			length = quoted
+		}
+	}
+
+	data := make([]byte, length)
+	copy(data, original)
+	return data
+}
@@ -0,0 +1,59 @@
+package icmp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// 默认每秒允许发送的差错报文数（同Linux的icmp_msgs_per_sec）
+	DefaultRateLimit = 1000
+
+	// 默认突发量（同Linux的icmp_msgs_burst）
+	DefaultRateBurst = 50
+)
+
+// RateLimiter 令牌桶限速器，限制ICMP差错报文的发送速率（RFC 1812 4.3.2.8）
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // 每秒补充的令牌数
+	burst  float64   // 桶容量
+	tokens float64   // 当前令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// NewRateLimiter 创建新的限速器，rate为0表示不限速
+func NewRateLimiter(rate, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow 消耗一个令牌，令牌不足时返回false
+func (l *RateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
@@ -0,0 +1,241 @@
+package igmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+	"ustack/internal/utils"
+)
+
+const (
+	// IGMP消息类型
+	TypeMembershipQuery    = 0x11
+	TypeV1MembershipReport = 0x12
+	TypeV2MembershipReport = 0x16
+	TypeV2LeaveGroup       = 0x17
+	TypeV3MembershipReport = 0x22
+
+	// IGMPv3组记录类型
+	RecordModeIsInclude   = 1
+	RecordModeIsExclude   = 2
+	RecordChangeToInclude = 3
+	RecordChangeToExclude = 4
+	RecordAllowNewSources = 5
+	RecordBlockOldSources = 6
+
+	// 报文长度
+	v1v2MessageLength         = 8
+	v3QueryMinLength          = 12
+	v3ReportHeaderLength      = 8
+	v3GroupRecordHeaderLength = 8
+)
+
+var (
+	// AllHostsGroup 所有主机组 224.0.0.1
+	AllHostsGroup = [4]byte{224, 0, 0, 1}
+
+	// AllRoutersGroup 所有路由器组 224.0.0.2（IGMPv2离开报文的目标）
+	AllRoutersGroup = [4]byte{224, 0, 0, 2}
+
+	// V3ReportsGroup IGMPv3报告的目标地址 224.0.0.22
+	V3ReportsGroup = [4]byte{224, 0, 0, 22}
+)
+
+// GroupRecord IGMPv3组记录
+type GroupRecord struct {
+	Type      uint8     // 记录类型
+	Multicast [4]byte   // 多播组地址
+	Sources   [][4]byte // 源地址列表
+}
+
+// Message IGMP消息结构
+type Message struct {
+	Type         uint8   // 类型
+	MaxRespCode  uint8   // 最大响应时间编码（查询）
+	Checksum     uint16  // 校验和
+	GroupAddress [4]byte // 组地址（v1/v2消息与查询）
+
+	// IGMPv3查询字段
+	Version uint8     // 查询版本（1、2或3），由报文长度和最大响应时间推断
+	QRV     uint8     // 查询者健壮性变量
+	QQIC    uint8     // 查询者查询间隔编码
+	Sources [][4]byte // 源地址列表
+
+	// IGMPv3报告字段
+	Records []GroupRecord
+}
+
+// Marshal 将IGMP消息序列化为字节数组
+func (m *Message) Marshal() ([]byte, error) {
+	var data []byte
+
+	switch {
+	case m.Type == TypeV3MembershipReport:
+		length := v3ReportHeaderLength
+		for _, r := range m.Records {
+			length += v3GroupRecordHeaderLength + 4*len(r.Sources)
+		}
+		data = make([]byte, length)
+		binary.BigEndian.PutUint16(data[6:8], uint16(len(m.Records)))
+
+		offset := v3ReportHeaderLength
+		for _, r := range m.Records {
+			data[offset] = r.Type
+			binary.BigEndian.PutUint16(data[offset+2:offset+4], uint16(len(r.Sources)))
+			copy(data[offset+4:offset+8], r.Multicast[:])
+			offset += v3GroupRecordHeaderLength
+			for _, src := range r.Sources {
+				copy(data[offset:offset+4], src[:])
+				offset += 4
+			}
+		}
+
+	case m.Type == TypeMembershipQuery && m.Version == 3:
+		data = make([]byte, v3QueryMinLength+4*len(m.Sources))
+		data[1] = m.MaxRespCode
+		copy(data[4:8], m.GroupAddress[:])
+		data[8] = m.QRV & 0x07
+		data[9] = m.QQIC
+		binary.BigEndian.PutUint16(data[10:12], uint16(len(m.Sources)))
+		for i, src := range m.Sources {
+			copy(data[12+4*i:16+4*i], src[:])
+		}
+
+	default:
+		data = make([]byte, v1v2MessageLength)
+		data[1] = m.MaxRespCode
+		copy(data[4:8], m.GroupAddress[:])
+	}
+
+	data[0] = m.Type
+
+	// 计算校验和
+	m.Checksum = utils.CalculateChecksum(data)
+	binary.BigEndian.PutUint16(data[2:4], m.Checksum)
+
+	return data, nil
+}
+
+// Unmarshal 从字节数组解析IGMP消息并校验校验和
+func (m *Message) Unmarshal(data []byte) error {
+	if len(data) < v1v2MessageLength {
+		return fmt.Errorf("IGMP message too short: %d bytes", len(data))
+	}
+	if utils.CalculateChecksum(data) != 0 {
+		return fmt.Errorf("IGMP checksum mismatch")
+	}
+
+	m.Type = data[0]
+	m.MaxRespCode = data[1]
+	m.Checksum = binary.BigEndian.Uint16(data[2:4])
+	m.Sources = nil
+	m.Records = nil
+
+	switch m.Type {
+	case TypeMembershipQuery:
+		copy(m.GroupAddress[:], data[4:8])
+		switch {
+		case len(data) >= v3QueryMinLength:
+			m.Version = 3
+			m.QRV = data[8] & 0x07
+			m.QQIC = data[9]
+			count := int(binary.BigEndian.Uint16(data[10:12]))
+			if len(data) < v3QueryMinLength+4*count {
+				return fmt.Errorf("IGMPv3 query truncated: %d sources, %d bytes", count, len(data))
+			}
+			for i := 0; i < count; i++ {
+				var src [4]byte
+				copy(src[:], data[12+4*i:16+4*i])
+				m.Sources = append(m.Sources, src)
+			}
+		case m.MaxRespCode == 0:
+			m.Version = 1
+		default:
+			m.Version = 2
+		}
+
+	case TypeV3MembershipReport:
+		count := int(binary.BigEndian.Uint16(data[6:8]))
+		offset := v3ReportHeaderLength
+		for i := 0; i < count; i++ {
+			if len(data) < offset+v3GroupRecordHeaderLength {
+				return fmt.Errorf("IGMPv3 report truncated at record %d", i)
+			}
+			r := GroupRecord{Type: data[offset]}
+			auxLength := int(data[offset+1]) * 4
+			sources := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+			copy(r.Multicast[:], data[offset+4:offset+8])
+			offset += v3GroupRecordHeaderLength
+			if len(data) < offset+4*sources+auxLength {
+				return fmt.Errorf("IGMPv3 report truncated at record %d", i)
+			}
+			for j := 0; j < sources; j++ {
+				var src [4]byte
+				copy(src[:], data[offset:offset+4])
+				r.Sources = append(r.Sources, src)
+				offset += 4
+			}
+			offset += auxLength
+			m.Records = append(m.Records, r)
+		}
+
+	default:
+		copy(m.GroupAddress[:], data[4:8])
+	}
+
+	return nil
+}
+
+// MaxResponseTime 返回查询的最大响应时间
+func (m *Message) MaxResponseTime() time.Duration {
+	switch m.Version {
+	case 1:
+		// IGMPv1查询没有最大响应时间字段，固定为10秒
+		return 10 * time.Second
+	case 3:
+		return time.Duration(decodeCode(m.MaxRespCode)) * 100 * time.Millisecond
+	default:
+		return time.Duration(m.MaxRespCode) * 100 * time.Millisecond
+	}
+}
+
+// decodeCode 解码IGMPv3的浮点格式编码（RFC 3376 4.1.1）
+func decodeCode(code uint8) int {
+	if code < 128 {
+		return int(code)
+	}
+	mant := int(code & 0x0f)
+	exp := int((code >> 4) & 0x07)
+	return (mant | 0x10) << (exp + 3)
+}
+
+// String 返回IGMP消息的字符串表示
+func (m *Message) String() string {
+	switch m.Type {
+	case TypeMembershipQuery:
+		return fmt.Sprintf("IGMPv%d Query: Group=%s, MaxResp=%s",
+			m.Version, net.IP(m.GroupAddress[:]).String(), m.MaxResponseTime())
+	case TypeV3MembershipReport:
+		return fmt.Sprintf("IGMPv3 Report: Records=%d", len(m.Records))
+	case TypeV2LeaveGroup:
+		return fmt.Sprintf("IGMPv2 Leave: Group=%s", net.IP(m.GroupAddress[:]).String())
+	default:
+		return fmt.Sprintf("IGMP Report: Type=0x%02x, Group=%s", m.Type, net.IP(m.GroupAddress[:]).String())
+	}
+}
+
+// NewV2Report 创建IGMPv2成员报告
+func NewV2Report(group [4]byte) *Message {
+	return &Message{Type: TypeV2MembershipReport, GroupAddress: group}
+}
+
+// NewV2Leave 创建IGMPv2离开报文
+func NewV2Leave(group [4]byte) *Message {
+	return &Message{Type: TypeV2LeaveGroup, GroupAddress: group}
+}
+
+// NewV3Report 创建IGMPv3成员报告
+func NewV3Report(records ...GroupRecord) *Message {
+	return &Message{Type: TypeV3MembershipReport, Records: records}
+}
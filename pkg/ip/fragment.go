@@ -0,0 +1,78 @@
+package ip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPacketTooBig 报文超过MTU且设置了DF标志
+var ErrPacketTooBig = errors.New("packet too big and DF is set")
+
+// Fragment 按MTU将IP报文分片，返回序列化后的完整报文
+//
+// 所有分片共享头部的Identification，调用方需在分片前为头部分配标识。
+// 报文不超过MTU时返回单个报文；设置了DF标志且超过MTU时返回错误。
+func Fragment(h *Header, payload []byte, mtu int) ([][]byte, error) {
+	headerLength := h.HeaderLength()
+
+	if headerLength+len(payload) <= mtu {
+		packet, err := buildPacket(h, h.FragmentOffset, h.Flags&FlagMF != 0, payload, headerLength)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{packet}, nil
+	}
+
+	if h.Flags&FlagDF != 0 {
+		return nil, fmt.Errorf("%w: %d bytes, MTU %d", ErrPacketTooBig, headerLength+len(payload), mtu)
+	}
+
+	// 除最后一片外，每片载荷长度必须是8的倍数
+	maxData := (mtu - headerLength) &^ 7
+	if maxData <= 0 {
+		return nil, fmt.Errorf("MTU %d too small to fragment", mtu)
+	}
+
+	// 原报文本身可能是一个分片（转发时再分片），需保留其偏移和MF标志
+	baseOffset := int(h.FragmentOffset) * 8
+	lastHasMF := h.Flags&FlagMF != 0
+
+	fragments := make([][]byte, 0, (len(payload)+maxData-1)/maxData)
+	for offset := 0; offset < len(payload); offset += maxData {
+		end := offset + maxData
+		more := true
+		if end >= len(payload) {
+			end = len(payload)
+			more = lastHasMF
+		}
+
+		packet, err := buildPacket(h, uint16((baseOffset+offset)/8), more, payload[offset:end], headerLength)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, packet)
+	}
+
+	return fragments, nil
+}
+
+// buildPacket 序列化一个分片
+func buildPacket(h *Header, fragmentOffset uint16, more bool, payload []byte, headerLength int) ([]byte, error) {
+	fh := *h
+	fh.TotalLength = uint16(headerLength + len(payload))
+	fh.FragmentOffset = fragmentOffset
+	fh.Flags &^= FlagMF
+	if more {
+		fh.Flags |= FlagMF
+	}
+
+	header, err := fh.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	packet := make([]byte, 0, len(header)+len(payload))
+	packet = append(packet, header...)
+	packet = append(packet, payload...)
+	return packet, nil
+}
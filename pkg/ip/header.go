@@ -11,8 +11,12 @@ const (
 	// IP头部长度
 	IPHeaderLength = 20
 
+	// IP头部最大长度（含选项）
+	IPMaxHeaderLength = 60
+
 	// IP协议号
 	ProtocolICMP = 1
+	ProtocolIGMP = 2
 	ProtocolTCP  = 6
 	ProtocolUDP  = 17
 
@@ -35,13 +39,28 @@ type Header struct {
 	Checksum       uint16  // 校验和
 	SourceIP       [4]byte // 源IP地址
 	DestinationIP  [4]byte // 目标IP地址
+	Options        []byte  // 选项（可选）
+}
+
+// RouterAlertOption 路由器告警选项（RFC 2113），IGMP报文必须携带
+var RouterAlertOption = []byte{0x94, 0x04, 0x00, 0x00}
+
+// HeaderLength 返回头部长度（含按4字节对齐的选项）
+func (h *Header) HeaderLength() int {
+	return IPHeaderLength + (len(h.Options)+3)/4*4
 }
 
 // Marshal 将IP头部序列化为字节数组
 func (h *Header) Marshal() ([]byte, error) {
-	data := make([]byte, IPHeaderLength)
+	headerLength := h.HeaderLength()
+	if headerLength > IPMaxHeaderLength {
+		return nil, fmt.Errorf("IP header too large: %d bytes", headerLength)
+	}
+
+	data := make([]byte, headerLength)
 
 	// 版本和头部长度
+	h.IHL = uint8(headerLength / 4)
 	data[0] = (h.Version << 4) | h.IHL
 
 	// 服务类型
@@ -72,6 +91,9 @@ func (h *Header) Marshal() ([]byte, error) {
 	// 目标IP地址
 	copy(data[16:20], h.DestinationIP[:])
 
+	// 选项（不足4字节的部分以0填充，即End of Option List）
+	copy(data[20:], h.Options)
+
 	// 计算校验和
 	h.Checksum = utils.CalculateChecksum(data)
 	binary.BigEndian.PutUint16(data[10:12], h.Checksum)
@@ -118,6 +140,14 @@ func (h *Header) Unmarshal(data []byte) error {
 	// 目标IP地址
 	copy(h.DestinationIP[:], data[16:20])
 
+	// 选项
+	h.Options = nil
+	headerLength := int(h.IHL) * 4
+	if headerLength > IPHeaderLength && len(data) >= headerLength {
+		h.Options = make([]byte, headerLength-IPHeaderLength)
+		copy(h.Options, data[IPHeaderLength:headerLength])
+	}
+
 	return nil
 }
 
@@ -141,6 +171,11 @@ func (h *Header) IsFirstFragment() bool {
 	return h.FragmentOffset == 0
 }
 
+// IsMulticast 检查地址是否为多播地址 224.0.0.0/4
+func IsMulticast(addr [4]byte) bool {
+	return addr[0]&0xf0 == 0xe0
+}
+
 // NewHeader 创建新的IP头部
 func NewHeader(srcIP, dstIP [4]byte, protocol uint8, totalLength uint16) *Header {
 	return &Header{
@@ -148,7 +183,7 @@ func NewHeader(srcIP, dstIP [4]byte, protocol uint8, totalLength uint16) *Header
 		IHL:            5, // 20字节 = 5个32位字
 		TOS:            0,
 		TotalLength:    totalLength,
-		Identification: 0, // 将由发送方设置
+		Identification: 0, // 将由发送方通过IDGenerator设置
 		Flags:          0,
 		FragmentOffset: 0,
 		TTL:            64,
@@ -0,0 +1,62 @@
+package ip
+
+import (
+	"hash/maphash"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 标识计数器桶数量（与Linux的ip_idents一致）
+	idBuckets = 2048
+)
+
+// IDGenerator IP标识生成器
+//
+// 与Linux相同，按(源地址, 目标地址, 协议)哈希到一组计数器桶，
+// 同一目的地的标识递增且不重复，不同目的地之间互不关联，
+// 空闲期间计数器按经过的时间随机前移，外部难以预测下一个标识。
+type IDGenerator struct {
+	seed   maphash.Seed
+	idents [idBuckets]atomic.Uint32
+	stamps [idBuckets]atomic.Int64
+}
+
+// NewIDGenerator 创建新的IP标识生成器
+func NewIDGenerator() *IDGenerator {
+	g := &IDGenerator{seed: maphash.MakeSeed()}
+	for i := range g.idents {
+		g.idents[i].Store(rand.Uint32())
+	}
+	return g
+}
+
+// Next 为一个报文分配标识
+func (g *IDGenerator) Next(srcIP, dstIP [4]byte, protocol uint8) uint16 {
+	return g.Reserve(srcIP, dstIP, protocol, 1)
+}
+
+// Reserve 连续分配count个标识并返回第一个（用于GSO等批量发送）
+func (g *IDGenerator) Reserve(srcIP, dstIP [4]byte, protocol uint8, count int) uint16 {
+	bucket := g.bucket(srcIP, dstIP, protocol)
+
+	// 距离上次分配经过的毫秒数越多，随机跳过的标识越多
+	now := time.Now().UnixMilli()
+	var delta uint32
+	if old := g.stamps[bucket].Swap(now); old != 0 && now > old {
+		delta = uint32(rand.Int63n(now - old))
+	}
+
+	next := g.idents[bucket].Add(delta + uint32(count))
+	return uint16(next - uint32(count))
+}
+
+// bucket 计算目的地对应的计数器桶
+func (g *IDGenerator) bucket(srcIP, dstIP [4]byte, protocol uint8) int {
+	var key [9]byte
+	copy(key[0:4], srcIP[:])
+	copy(key[4:8], dstIP[:])
+	key[8] = protocol
+	return int(maphash.Bytes(g.seed, key[:]) % idBuckets)
+}
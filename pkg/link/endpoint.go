@@ -0,0 +1,34 @@
+package link
+
+import (
+	"errors"
+)
+
+const (
+	// 以太网默认MTU
+	DefaultMTU = 1500
+)
+
+// ErrClosed 链路端点已关闭
+var ErrClosed = errors.New("link endpoint closed")
+
+// Dispatcher 接收帧的回调
+type Dispatcher func(frame []byte)
+
+// Endpoint 链路层端点，收发完整的以太网帧
+type Endpoint interface {
+	// MTU 返回链路MTU（不含以太网头部）
+	MTU() int
+
+	// MACAddress 返回端点的MAC地址
+	MACAddress() [6]byte
+
+	// WriteFrame 发送一个以太网帧
+	WriteFrame(frame []byte) error
+
+	// Attach 注册接收回调，收到的每个帧都会交给dispatcher
+	Attach(dispatcher Dispatcher)
+
+	// Close 关闭端点
+	Close() error
+}
@@ -0,0 +1,124 @@
+package link
+
+import (
+	"sync"
+)
+
+const (
+	// 管道每个方向的帧队列长度
+	pipeQueueLength = 1024
+)
+
+// PipeEndpoint 进程内管道链路的一端
+type PipeEndpoint struct {
+	mu         sync.RWMutex
+	mac        [6]byte
+	mtu        int
+	peer       *PipeEndpoint
+	queue      chan []byte
+	dispatcher Dispatcher
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewPipe 创建一对互相连接的管道端点，写入一端的帧会异步出现在另一端
+func NewPipe(macA, macB [6]byte, mtu int) (*PipeEndpoint, *PipeEndpoint) {
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+
+	a := newPipeEndpoint(macA, mtu)
+	b := newPipeEndpoint(macB, mtu)
+	a.peer = b
+	b.peer = a
+
+	go a.deliver()
+	go b.deliver()
+
+	return a, b
+}
+
+// NewLoopback 创建环回端点，写入的帧异步交还给自身
+func NewLoopback(mac [6]byte, mtu int) *PipeEndpoint {
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+
+	p := newPipeEndpoint(mac, mtu)
+	p.peer = p
+	go p.deliver()
+	return p
+}
+
+func newPipeEndpoint(mac [6]byte, mtu int) *PipeEndpoint {
+	return &PipeEndpoint{
+		mac:   mac,
+		mtu:   mtu,
+		queue: make(chan []byte, pipeQueueLength),
+		done:  make(chan struct{}),
+	}
+}
+
+// MTU 返回链路MTU
+func (p *PipeEndpoint) MTU() int {
+	return p.mtu
+}
+
+// MACAddress 返回端点的MAC地址
+func (p *PipeEndpoint) MACAddress() [6]byte {
+	return p.mac
+}
+
+// WriteFrame 发送一个帧到对端，队列满时丢弃
+func (p *PipeEndpoint) WriteFrame(frame []byte) error {
+	select {
+	case <-p.done:
+		return ErrClosed
+	default:
+	}
+
+	data := make([]byte, len(frame))
+	copy(data, frame)
+
+	select {
+	case p.peer.queue <- data:
+	case <-p.peer.done:
+		return ErrClosed
+	default:
+		// 模拟链路拥塞丢包
+	}
+	return nil
+}
+
+// Attach 注册接收回调
+func (p *PipeEndpoint) Attach(dispatcher Dispatcher) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dispatcher = dispatcher
+}
+
+// Close 关闭端点
+func (p *PipeEndpoint) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
+
+// deliver 将队列中的帧依次交给接收回调
+func (p *PipeEndpoint) deliver() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case frame := <-p.queue:
+			p.mu.RLock()
+			dispatcher := p.dispatcher
+			p.mu.RUnlock()
+
+			if dispatcher != nil {
+				dispatcher(frame)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package link
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// TUN/TAP ioctl与标志（linux/if_tun.h）
+	tunSetIFF = 0x400454ca
+	iffTAP    = 0x0002
+	iffNoPI   = 0x1000
+
+	// 接收缓冲区长度（以太网头部 + 最大MTU）
+	tapBufferLength = 65536 + 14
+)
+
+// ifReq struct ifreq中用于TUNSETIFF的部分
+type ifReq struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// TAPEndpoint Linux TAP设备端点，收发完整的以太网帧
+//
+// 设备需预先创建并启用（如 ip tuntap add dev tap0 mode tap && ip link set tap0 up），
+// 或由具有CAP_NET_ADMIN权限的进程打开时自动创建。
+type TAPEndpoint struct {
+	mu         sync.RWMutex
+	name       string
+	mac        [6]byte
+	mtu        int
+	file       *os.File
+	dispatcher Dispatcher
+	closeOnce  sync.Once
+}
+
+// NewTAP 打开名为name的TAP设备，mac为协议栈在该链路上使用的MAC地址
+func NewTAP(name string, mac [6]byte, mtu int) (*TAPEndpoint, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return nil, fmt.Errorf("TAP device name too long: %s", name)
+	}
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open /dev/net/tun: %w", err)
+	}
+
+	var req ifReq
+	copy(req.name[:], name)
+	req.flags = iffTAP | iffNoPI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), tunSetIFF, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("TUNSETIFF %s: %w", name, errno)
+	}
+
+	// 非阻塞模式下由运行时轮询，Close可以中断阻塞的读取
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	t := &TAPEndpoint{
+		name: name,
+		mac:  mac,
+		mtu:  mtu,
+		file: os.NewFile(uintptr(fd), "/dev/net/tun"),
+	}
+	go t.deliver()
+	return t, nil
+}
+
+// Name 返回设备名
+func (t *TAPEndpoint) Name() string {
+	return t.name
+}
+
+// MTU 返回链路MTU
+func (t *TAPEndpoint) MTU() int {
+	return t.mtu
+}
+
+// MACAddress 返回端点的MAC地址
+func (t *TAPEndpoint) MACAddress() [6]byte {
+	return t.mac
+}
+
+// WriteFrame 向设备写入一个帧
+func (t *TAPEndpoint) WriteFrame(frame []byte) error {
+	if _, err := t.file.Write(frame); err != nil {
+		if errors.Is(err, os.ErrClosed) {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// Attach 注册接收回调
+func (t *TAPEndpoint) Attach(dispatcher Dispatcher) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dispatcher = dispatcher
+}
+
+// Close 关闭设备
+func (t *TAPEndpoint) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		err = t.file.Close()
+	})
+	return err
+}
+
+// deliver 读取设备上的帧并交给接收回调
+func (t *TAPEndpoint) deliver() {
+	buf := make([]byte, tapBufferLength)
+	for {
+		n, err := t.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		t.mu.RLock()
+		dispatcher := t.dispatcher
+		t.mu.RUnlock()
+
+		if dispatcher != nil {
+			frame := make([]byte, n)
+			copy(frame, buf[:n])
+			dispatcher(frame)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package link
+
+import (
+	"errors"
+)
+
+// TAPEndpoint TAP设备端点，仅支持Linux
+type TAPEndpoint struct {
+	PipeEndpoint
+}
+
+// NewTAP 在非Linux平台上返回错误
+func NewTAP(name string, mac [6]byte, mtu int) (*TAPEndpoint, error) {
+	return nil, errors.New("TAP devices are only supported on Linux")
+}
+
+// Name 返回设备名
+func (t *TAPEndpoint) Name() string {
+	return ""
+}
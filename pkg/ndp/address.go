@@ -0,0 +1,70 @@
+package ndp
+
+var (
+	// UnspecifiedAddress 未指定地址 ::
+	UnspecifiedAddress = [16]byte{}
+
+	// AllNodesAddress 链路本地所有节点多播地址 ff02::1
+	AllNodesAddress = [16]byte{0xff, 0x02, 15: 0x01}
+
+	// AllRoutersAddress 链路本地所有路由器多播地址 ff02::2
+	AllRoutersAddress = [16]byte{0xff, 0x02, 15: 0x02}
+
+	// LinkLocalPrefix 链路本地前缀 fe80::/64
+	LinkLocalPrefix = [16]byte{0xfe, 0x80}
+)
+
+// InterfaceID 根据MAC地址生成修改后的EUI-64接口标识符
+func InterfaceID(mac [6]byte) [8]byte {
+	return [8]byte{
+		mac[0] ^ 0x02, // 翻转U/L位
+		mac[1],
+		mac[2],
+		0xff,
+		0xfe,
+		mac[3],
+		mac[4],
+		mac[5],
+	}
+}
+
+// AutoconfAddress 使用/64前缀和MAC地址生成无状态自动配置地址
+func AutoconfAddress(prefix [16]byte, mac [6]byte) [16]byte {
+	var addr [16]byte
+	copy(addr[:8], prefix[:8])
+	iid := InterfaceID(mac)
+	copy(addr[8:], iid[:])
+	return addr
+}
+
+// LinkLocalAddress 根据MAC地址生成链路本地地址 fe80::/64
+func LinkLocalAddress(mac [6]byte) [16]byte {
+	return AutoconfAddress(LinkLocalPrefix, mac)
+}
+
+// SolicitedNodeAddress 返回地址对应的被请求节点多播地址 ff02::1:ffXX:XXXX
+func SolicitedNodeAddress(addr [16]byte) [16]byte {
+	return [16]byte{
+		0xff, 0x02,
+		11: 0x01,
+		12: 0xff,
+		13: addr[13],
+		14: addr[14],
+		15: addr[15],
+	}
+}
+
+// MulticastMAC 返回IPv6多播地址对应的以太网多播MAC 33:33:XX:XX:XX:XX
+func MulticastMAC(addr [16]byte) [6]byte {
+	return [6]byte{0x33, 0x33, addr[12], addr[13], addr[14], addr[15]}
+}
+
+// IsLinkLocal 检查是否为链路本地单播地址
+func IsLinkLocal(addr [16]byte) bool {
+	return addr[0] == 0xfe && addr[1]&0xc0 == 0x80
+}
+
+// IsMulticast 检查是否为多播地址
+func IsMulticast(addr [16]byte) bool {
+	return addr[0] == 0xff
+}
@@ -0,0 +1,139 @@
+package ndp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 邻居缓存状态
+	StateIncomplete = "INCOMPLETE"
+	StateReachable  = "REACHABLE"
+	StateStale      = "STALE"
+)
+
+// Neighbor 邻居缓存表项
+type Neighbor struct {
+	Address   [16]byte  // IPv6地址
+	MAC       [6]byte   // 链路层地址
+	State     string    // 状态
+	IsRouter  bool      // 是否为路由器
+	UpdatedAt time.Time // 最近一次确认可达的时间
+	probes    int       // 已发送的请求次数
+}
+
+// String 返回邻居表项的字符串表示
+func (n *Neighbor) String() string {
+	return fmt.Sprintf("Neighbor %s -> %s [%s]",
+		net.IP(n.Address[:]).String(),
+		net.HardwareAddr(n.MAC[:]).String(),
+		n.State)
+}
+
+// NeighborCache 邻居缓存
+type NeighborCache struct {
+	mu            sync.Mutex
+	entries       map[[16]byte]*Neighbor
+	reachableTime time.Duration
+}
+
+// NewNeighborCache 创建新的邻居缓存
+func NewNeighborCache(reachableTime time.Duration) *NeighborCache {
+	return &NeighborCache{
+		entries:       make(map[[16]byte]*Neighbor),
+		reachableTime: reachableTime,
+	}
+}
+
+// SetReachableTime 设置可达时间（来自Router Advertisement）
+func (c *NeighborCache) SetReachableTime(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reachableTime = d
+}
+
+// Lookup 查找邻居，可达时间超时的表项降级为STALE
+func (c *NeighborCache) Lookup(addr [16]byte) (Neighbor, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.entries[addr]
+	if !ok {
+		return Neighbor{}, false
+	}
+	if n.State == StateReachable && time.Since(n.UpdatedAt) > c.reachableTime {
+		n.State = StateStale
+	}
+	return *n, true
+}
+
+// Update 更新邻居的链路层地址，confirmed为true表示可达性已被确认
+func (c *NeighborCache) Update(addr [16]byte, mac [6]byte, confirmed, override bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.entries[addr]
+	if !ok {
+		n = &Neighbor{Address: addr}
+		c.entries[addr] = n
+	} else if n.State != StateIncomplete && n.MAC != mac && !override {
+		// 未设置覆盖标志时不替换已知地址，仅标记为过期
+		n.State = StateStale
+		return
+	}
+
+	changed := n.MAC != mac
+	n.MAC = mac
+	n.probes = 0
+	if confirmed {
+		n.State = StateReachable
+		n.UpdatedAt = time.Now()
+	} else if n.State == "" || n.State == StateIncomplete || changed {
+		n.State = StateStale
+	}
+}
+
+// markIncomplete 创建INCOMPLETE表项并返回已发送的请求次数
+func (c *NeighborCache) markIncomplete(addr [16]byte) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, ok := c.entries[addr]
+	if !ok {
+		n = &Neighbor{Address: addr, State: StateIncomplete}
+		c.entries[addr] = n
+	}
+	n.probes++
+	return n.probes
+}
+
+// setRouter 设置邻居的路由器标志
+func (c *NeighborCache) setRouter(addr [16]byte, isRouter bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n, ok := c.entries[addr]; ok {
+		n.IsRouter = isRouter
+	}
+}
+
+// Remove 删除邻居表项
+func (c *NeighborCache) Remove(addr [16]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, addr)
+}
+
+// Entries 返回所有邻居表项的快照
+func (c *NeighborCache) Entries() []Neighbor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]Neighbor, 0, len(c.entries))
+	for _, n := range c.entries {
+		entries = append(entries, *n)
+	}
+	return entries
+}
@@ -0,0 +1,589 @@
+package ndp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+)
+
+const (
+	// 地址状态
+	AddressTentative = "TENTATIVE"
+	AddressPreferred = "PREFERRED"
+	AddressDuplicate = "DUPLICATE"
+
+	// RFC 4861 协议常量
+	DefaultRetransTimer            = 1 * time.Second
+	DefaultReachableTime           = 30 * time.Second
+	DefaultDupAddrDetectTransmits  = 1
+	DefaultMaxRtrSolicitations     = 3
+	DefaultRtrSolicitationInterval = 4 * time.Second
+	MaxMulticastSolicit            = 3
+)
+
+// SendFunc 发送NDP消息的回调，由IPv6输出路径实现。
+// 回调在端点持有锁时调用，不得同步回调HandleMessage。
+type SendFunc func(srcIP, dstIP [16]byte, dstMAC [6]byte, data []byte) error
+
+// Config NDP端点配置
+type Config struct {
+	MAC                     [6]byte       // 网卡MAC地址
+	DupAddrDetectTransmits  int           // DAD发送的NS次数，0表示禁用DAD
+	RetransTimer            time.Duration // NS重传间隔
+	MaxRtrSolicitations     int           // 启动时发送的RS次数
+	RtrSolicitationInterval time.Duration // RS发送间隔
+}
+
+// Address 接口上配置的IPv6地址
+type Address struct {
+	Address           [16]byte  // 地址
+	PrefixLength      uint8     // 前缀长度
+	State             string    // 状态
+	ValidUntil        time.Time // 有效期限，零值表示永久
+	PreferredUntil    time.Time // 首选期限，零值表示永久
+	dadTransmits      int       // 已发送的DAD请求次数
+	dadTimer          *time.Timer
+	autoconfiguration bool
+}
+
+// String 返回地址的字符串表示
+func (a *Address) String() string {
+	return fmt.Sprintf("%s/%d [%s]", net.IP(a.Address[:]).String(), a.PrefixLength, a.State)
+}
+
+// expired 地址在now时是否已超过有效期限
+func (a *Address) expired(now time.Time) bool {
+	return !a.ValidUntil.IsZero() && now.After(a.ValidUntil)
+}
+
+// deprecated 地址在now时是否已超过首选期限，已废弃的地址仍可接收但不应作为新通信的源地址
+func (a *Address) deprecated(now time.Time) bool {
+	return !a.PreferredUntil.IsZero() && now.After(a.PreferredUntil)
+}
+
+// Router 默认路由器表项
+type Router struct {
+	Address    [16]byte  // 路由器链路本地地址
+	ValidUntil time.Time // 路由器生存期限
+}
+
+// Endpoint NDP端点，负责地址解析、DAD和无状态地址自动配置
+type Endpoint struct {
+	mu sync.Mutex
+
+	config    Config
+	send      SendFunc
+	cache     *NeighborCache
+	addresses map[[16]byte]*Address
+	routers   map[[16]byte]*Router
+
+	// 从Router Advertisement学习到的链路参数
+	CurHopLimit uint8
+	LinkMTU     uint32
+
+	rsTimer  *time.Timer
+	rsCount  int
+	nsTimers map[[16]byte]*time.Timer
+	stopped  bool
+
+	// 回调函数
+	OnAddressReady     func([16]byte)
+	OnDuplicateAddress func([16]byte)
+
+	// 日志
+	logger *utils.Logger
+}
+
+// NewEndpoint 创建新的NDP端点
+func NewEndpoint(config Config, send SendFunc) *Endpoint {
+	if config.RetransTimer == 0 {
+		config.RetransTimer = DefaultRetransTimer
+	}
+	if config.RtrSolicitationInterval == 0 {
+		config.RtrSolicitationInterval = DefaultRtrSolicitationInterval
+	}
+
+	return &Endpoint{
+		config:      config,
+		send:        send,
+		cache:       NewNeighborCache(DefaultReachableTime),
+		addresses:   make(map[[16]byte]*Address),
+		routers:     make(map[[16]byte]*Router),
+		nsTimers:    make(map[[16]byte]*time.Timer),
+		CurHopLimit: 64,
+		logger:      utils.DefaultLogger,
+	}
+}
+
+// Start 配置链路本地地址并开始路由器请求
+func (e *Endpoint) Start() {
+	e.AddAddress(LinkLocalAddress(e.config.MAC), 64)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.config.MaxRtrSolicitations > 0 {
+		e.sendRouterSolicitationLocked()
+	}
+}
+
+// Stop 停止所有定时器
+func (e *Endpoint) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stopped = true
+	if e.rsTimer != nil {
+		e.rsTimer.Stop()
+	}
+	for _, addr := range e.addresses {
+		if addr.dadTimer != nil {
+			addr.dadTimer.Stop()
+		}
+	}
+	for _, t := range e.nsTimers {
+		t.Stop()
+	}
+}
+
+// Cache 返回邻居缓存
+func (e *Endpoint) Cache() *NeighborCache {
+	return e.cache
+}
+
+// AddAddress 添加地址并执行重复地址检测
+func (e *Endpoint) AddAddress(addr [16]byte, prefixLength uint8) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.addAddressLocked(addr, prefixLength, false)
+}
+
+func (e *Endpoint) addAddressLocked(addr [16]byte, prefixLength uint8, autoconf bool) *Address {
+	if a, ok := e.addresses[addr]; ok {
+		return a
+	}
+
+	a := &Address{
+		Address:           addr,
+		PrefixLength:      prefixLength,
+		State:             AddressTentative,
+		autoconfiguration: autoconf,
+	}
+	e.addresses[addr] = a
+
+	if e.config.DupAddrDetectTransmits <= 0 {
+		e.markPreferredLocked(a)
+		return a
+	}
+
+	e.logger.Debug("NDP: starting DAD for %s", net.IP(addr[:]))
+	e.sendDADLocked(a)
+	return a
+}
+
+// sendDADLocked 发送一次DAD邻居请求并安排下一步
+func (e *Endpoint) sendDADLocked(a *Address) {
+	if e.stopped || a.State != AddressTentative {
+		return
+	}
+
+	// DAD请求：源地址为未指定地址，不携带源链路层地址选项
+	ns := NewNeighborSolicitation(a.Address, e.config.MAC, false)
+	dst := SolicitedNodeAddress(a.Address)
+	e.transmit(ns, UnspecifiedAddress, dst, MulticastMAC(dst))
+	a.dadTransmits++
+
+	a.dadTimer = time.AfterFunc(e.config.RetransTimer, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if a.State != AddressTentative {
+			return
+		}
+		if a.dadTransmits < e.config.DupAddrDetectTransmits {
+			e.sendDADLocked(a)
+			return
+		}
+		e.markPreferredLocked(a)
+	})
+}
+
+func (e *Endpoint) markPreferredLocked(a *Address) {
+	a.State = AddressPreferred
+	e.logger.Info("NDP: address %s is ready", net.IP(a.Address[:]))
+	if e.OnAddressReady != nil {
+		go e.OnAddressReady(a.Address)
+	}
+}
+
+func (e *Endpoint) markDuplicateLocked(a *Address) {
+	if a.dadTimer != nil {
+		a.dadTimer.Stop()
+	}
+	a.State = AddressDuplicate
+	e.logger.Warn("NDP: duplicate address detected: %s", net.IP(a.Address[:]))
+	if e.OnDuplicateAddress != nil {
+		go e.OnDuplicateAddress(a.Address)
+	}
+}
+
+// Addresses 返回所有地址的快照
+func (e *Endpoint) Addresses() []Address {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	addrs := make([]Address, 0, len(e.addresses))
+	for _, a := range e.addresses {
+		if a.expired(now) {
+			continue
+		}
+		addrs = append(addrs, *a)
+	}
+	return addrs
+}
+
+// HasAddress 检查地址是否已配置、通过DAD且未过期
+func (e *Endpoint) HasAddress(addr [16]byte) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	a, ok := e.addresses[addr]
+	return ok && a.State == AddressPreferred && !a.expired(time.Now())
+}
+
+// DefaultRouter 返回一个仍然有效的默认路由器
+func (e *Endpoint) DefaultRouter() ([16]byte, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for addr, r := range e.routers {
+		if now.Before(r.ValidUntil) {
+			return addr, true
+		}
+		delete(e.routers, addr)
+	}
+	return [16]byte{}, false
+}
+
+// Resolve 解析IPv6地址对应的MAC地址，未知时发送邻居请求并返回false
+func (e *Endpoint) Resolve(addr [16]byte) ([6]byte, bool) {
+	if IsMulticast(addr) {
+		return MulticastMAC(addr), true
+	}
+
+	if n, ok := e.cache.Lookup(addr); ok && n.State != StateIncomplete {
+		return n.MAC, true
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, pending := e.nsTimers[addr]; !pending {
+		e.solicitLocked(addr)
+	}
+	return [6]byte{}, false
+}
+
+// solicitLocked 发送地址解析请求，最多重试MaxMulticastSolicit次
+func (e *Endpoint) solicitLocked(addr [16]byte) {
+	if e.stopped {
+		return
+	}
+
+	src, ok := e.sourceForLocked(addr)
+	if !ok {
+		return
+	}
+
+	if e.cache.markIncomplete(addr) > MaxMulticastSolicit {
+		e.cache.Remove(addr)
+		delete(e.nsTimers, addr)
+		e.logger.Debug("NDP: address resolution failed for %s", net.IP(addr[:]))
+		return
+	}
+
+	ns := NewNeighborSolicitation(addr, e.config.MAC, true)
+	dst := SolicitedNodeAddress(addr)
+	e.transmit(ns, src, dst, MulticastMAC(dst))
+
+	e.nsTimers[addr] = time.AfterFunc(e.config.RetransTimer, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if n, ok := e.cache.Lookup(addr); ok && n.State != StateIncomplete {
+			delete(e.nsTimers, addr)
+			return
+		}
+		e.solicitLocked(addr)
+	})
+}
+
+// sourceForLocked 为目标选择源地址，跳过已过期的地址
+//
+// 优先使用与目标作用域相同（同为链路本地或全局）的地址，其次避免已废弃的地址（RFC 4862 5.5.4）。
+func (e *Endpoint) sourceForLocked(dst [16]byte) ([16]byte, bool) {
+	now := time.Now()
+	var best [16]byte
+	bestScore := -1
+	for addr, a := range e.addresses {
+		if a.State != AddressPreferred || a.expired(now) {
+			continue
+		}
+		score := 0
+		if IsLinkLocal(addr) == IsLinkLocal(dst) {
+			score += 2
+		}
+		if !a.deprecated(now) {
+			score++
+		}
+		if score == 3 {
+			return addr, true
+		}
+		if score > bestScore {
+			best, bestScore = addr, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// sendRouterSolicitationLocked 发送路由器请求并安排重传
+func (e *Endpoint) sendRouterSolicitationLocked() {
+	if e.stopped || e.rsCount >= e.config.MaxRtrSolicitations {
+		return
+	}
+
+	// 尚无可用地址时使用未指定源地址，且不得携带源链路层地址选项
+	src, ok := e.sourceForLocked(LinkLocalAddress(e.config.MAC))
+	if !ok {
+		src = UnspecifiedAddress
+	}
+	rs := NewRouterSolicitation(e.config.MAC, ok)
+	e.transmit(rs, src, AllRoutersAddress, MulticastMAC(AllRoutersAddress))
+	e.rsCount++
+
+	e.rsTimer = time.AfterFunc(e.config.RtrSolicitationInterval, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if len(e.routers) == 0 {
+			e.sendRouterSolicitationLocked()
+		}
+	})
+}
+
+// transmit 序列化并发送NDP消息
+func (e *Endpoint) transmit(m *Message, src, dst [16]byte, dstMAC [6]byte) {
+	data, err := m.Marshal(src, dst)
+	if err != nil {
+		e.logger.Error("NDP: failed to marshal %s: %v", m, err)
+		return
+	}
+	if err := e.send(src, dst, dstMAC, data); err != nil {
+		e.logger.Debug("NDP: failed to send %s: %v", m, err)
+	}
+}
+
+// HandleMessage 处理收到的NDP消息
+func (e *Endpoint) HandleMessage(src, dst [16]byte, hopLimit uint8, data []byte) error {
+	if hopLimit != HopLimit {
+		return fmt.Errorf("NDP message with invalid hop limit: %d", hopLimit)
+	}
+	if !VerifyChecksum(data, src, dst) {
+		return fmt.Errorf("NDP message checksum mismatch")
+	}
+
+	m := &Message{}
+	if err := m.Unmarshal(data); err != nil {
+		return err
+	}
+	if m.Code != 0 {
+		return fmt.Errorf("NDP message with invalid code: %d", m.Code)
+	}
+
+	switch m.Type {
+	case TypeNeighborSolicitation:
+		return e.handleNeighborSolicitation(src, dst, m)
+	case TypeNeighborAdvertisement:
+		return e.handleNeighborAdvertisement(m)
+	case TypeRouterAdvertisement:
+		return e.handleRouterAdvertisement(src, m)
+	case TypeRouterSolicitation:
+		// 主机忽略路由器请求
+		return nil
+	}
+	return nil
+}
+
+func (e *Endpoint) handleNeighborSolicitation(src, dst [16]byte, m *Message) error {
+	if IsMulticast(m.TargetAddress) {
+		return fmt.Errorf("NS target is multicast")
+	}
+	srcMAC, hasSource := m.LinkAddress(OptionSourceLinkAddress)
+	if src == UnspecifiedAddress && (hasSource || dst != SolicitedNodeAddress(m.TargetAddress)) {
+		return fmt.Errorf("invalid DAD neighbor solicitation")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	a, ok := e.addresses[m.TargetAddress]
+	if !ok {
+		return nil
+	}
+
+	if a.State == AddressTentative {
+		// 另一个节点正在对同一地址做DAD
+		if src == UnspecifiedAddress {
+			e.markDuplicateLocked(a)
+		}
+		return nil
+	}
+	if a.State != AddressPreferred {
+		return nil
+	}
+
+	if src == UnspecifiedAddress {
+		// 回复DAD请求，通告到所有节点
+		na := NewNeighborAdvertisement(m.TargetAddress, e.config.MAC, FlagOverride)
+		e.transmit(na, m.TargetAddress, AllNodesAddress, MulticastMAC(AllNodesAddress))
+		return nil
+	}
+
+	if hasSource {
+		e.cache.Update(src, srcMAC, false, true)
+	}
+
+	replyMAC := srcMAC
+	if !hasSource {
+		n, found := e.cache.Lookup(src)
+		if !found || n.State == StateIncomplete {
+			return nil
+		}
+		replyMAC = n.MAC
+	}
+
+	na := NewNeighborAdvertisement(m.TargetAddress, e.config.MAC, FlagSolicited|FlagOverride)
+	e.transmit(na, m.TargetAddress, src, replyMAC)
+	return nil
+}
+
+func (e *Endpoint) handleNeighborAdvertisement(m *Message) error {
+	if IsMulticast(m.TargetAddress) {
+		return fmt.Errorf("NA target is multicast")
+	}
+
+	e.mu.Lock()
+	if a, ok := e.addresses[m.TargetAddress]; ok {
+		if a.State == AddressTentative {
+			e.markDuplicateLocked(a)
+		} else {
+			e.logger.Warn("NDP: received NA for our address %s", net.IP(m.TargetAddress[:]))
+		}
+		e.mu.Unlock()
+		return nil
+	}
+	if t, ok := e.nsTimers[m.TargetAddress]; ok {
+		t.Stop()
+		delete(e.nsTimers, m.TargetAddress)
+	}
+	e.mu.Unlock()
+
+	mac, ok := m.LinkAddress(OptionTargetLinkAddress)
+	if !ok {
+		n, found := e.cache.Lookup(m.TargetAddress)
+		if !found || n.State == StateIncomplete {
+			return nil
+		}
+		mac = n.MAC
+	}
+
+	e.cache.Update(m.TargetAddress, mac, m.Flags&FlagSolicited != 0, m.Flags&FlagOverride != 0)
+	e.cache.setRouter(m.TargetAddress, m.Flags&FlagRouter != 0)
+	return nil
+}
+
+func (e *Endpoint) handleRouterAdvertisement(src [16]byte, m *Message) error {
+	if !IsLinkLocal(src) {
+		return fmt.Errorf("RA source is not link-local: %s", net.IP(src[:]))
+	}
+
+	if mac, ok := m.LinkAddress(OptionSourceLinkAddress); ok {
+		e.cache.Update(src, mac, false, true)
+		e.cache.setRouter(src, true)
+	}
+	if m.ReachableTime != 0 {
+		e.cache.SetReachableTime(time.Duration(m.ReachableTime) * time.Millisecond)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if m.CurHopLimit != 0 {
+		e.CurHopLimit = m.CurHopLimit
+	}
+	if m.RetransTimer != 0 {
+		e.config.RetransTimer = time.Duration(m.RetransTimer) * time.Millisecond
+	}
+	if mtu, ok := m.MTU(); ok && mtu >= 1280 {
+		e.LinkMTU = mtu
+	}
+
+	if m.RouterLifetime > 0 {
+		e.routers[src] = &Router{
+			Address:    src,
+			ValidUntil: time.Now().Add(time.Duration(m.RouterLifetime) * time.Second),
+		}
+		if e.rsTimer != nil {
+			e.rsTimer.Stop()
+		}
+	} else {
+		delete(e.routers, src)
+	}
+
+	for _, pi := range m.Prefixes() {
+		e.handlePrefixLocked(pi)
+	}
+	return nil
+}
+
+// handlePrefixLocked 根据前缀信息执行无状态地址自动配置（RFC 4862 5.5.3）
+func (e *Endpoint) handlePrefixLocked(pi PrefixInfo) {
+	if pi.Flags&PrefixFlagAutonomous == 0 || IsLinkLocal(pi.Prefix) {
+		return
+	}
+	if pi.PreferredLifetime > pi.ValidLifetime || pi.PrefixLength != 64 {
+		return
+	}
+
+	addr := AutoconfAddress(pi.Prefix, e.config.MAC)
+	a, exists := e.addresses[addr]
+	if !exists {
+		if pi.ValidLifetime == 0 {
+			return
+		}
+		a = e.addAddressLocked(addr, pi.PrefixLength, true)
+	}
+	if !a.autoconfiguration {
+		return
+	}
+
+	now := time.Now()
+	if pi.ValidLifetime != 0xffffffff {
+		validUntil := now.Add(time.Duration(pi.ValidLifetime) * time.Second)
+		// 防止伪造RA缩短地址寿命：剩余超过2小时时不缩短到2小时以下
+		twoHours := now.Add(2 * time.Hour)
+		if !exists || validUntil.After(twoHours) || (!a.ValidUntil.IsZero() && validUntil.After(a.ValidUntil)) {
+			a.ValidUntil = validUntil
+		} else if a.ValidUntil.IsZero() || a.ValidUntil.After(twoHours) {
+			a.ValidUntil = twoHours
+		}
+	} else {
+		a.ValidUntil = time.Time{}
+	}
+	if pi.PreferredLifetime != 0xffffffff {
+		a.PreferredUntil = now.Add(time.Duration(pi.PreferredLifetime) * time.Second)
+	} else {
+		a.PreferredUntil = time.Time{}
+	}
+}
@@ -0,0 +1,316 @@
+package ndp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"ustack/internal/utils"
+)
+
+const (
+	// ICMPv6 NDP消息类型
+	TypeRouterSolicitation    = 133
+	TypeRouterAdvertisement   = 134
+	TypeNeighborSolicitation  = 135
+	TypeNeighborAdvertisement = 136
+
+	// NDP选项类型
+	OptionSourceLinkAddress = 1
+	OptionTargetLinkAddress = 2
+	OptionPrefixInfo        = 3
+	OptionMTU               = 5
+
+	// Router Advertisement 标志
+	FlagManaged = 0x80 // M: 地址由DHCPv6管理
+	FlagOther   = 0x40 // O: 其他配置由DHCPv6提供
+
+	// Neighbor Advertisement 标志
+	FlagRouter    = 0x80 // R: 发送方是路由器
+	FlagSolicited = 0x40 // S: 响应邀请
+	FlagOverride  = 0x20 // O: 覆盖已有缓存
+
+	// Prefix Information 标志
+	PrefixFlagOnLink     = 0x80 // L: 前缀在链路上
+	PrefixFlagAutonomous = 0x40 // A: 可用于无状态地址自动配置
+
+	// NDP报文要求的跳数限制
+	HopLimit = 255
+
+	// ICMPv6协议号
+	ProtocolICMPv6 = 58
+)
+
+// Option NDP选项
+type Option struct {
+	Type uint8  // 选项类型
+	Data []byte // 选项数据（不含类型和长度字段）
+}
+
+// PrefixInfo 前缀信息选项
+type PrefixInfo struct {
+	PrefixLength      uint8    // 前缀长度
+	Flags             uint8    // L/A 标志
+	ValidLifetime     uint32   // 有效生存期（秒）
+	PreferredLifetime uint32   // 首选生存期（秒）
+	Prefix            [16]byte // 前缀
+}
+
+// Message NDP消息结构
+type Message struct {
+	Type     uint8  // 类型
+	Code     uint8  // 代码
+	Checksum uint16 // 校验和
+
+	// Router Advertisement 字段
+	CurHopLimit    uint8  // 建议跳数限制
+	RouterLifetime uint16 // 路由器生存期（秒）
+	ReachableTime  uint32 // 可达时间（毫秒）
+	RetransTimer   uint32 // 重传间隔（毫秒）
+
+	// RA 与 NA 的标志字节
+	Flags uint8
+
+	// Neighbor Solicitation/Advertisement 目标地址
+	TargetAddress [16]byte
+
+	// 选项
+	Options []Option
+}
+
+// bodyLength 返回固定部分（不含4字节ICMPv6头部）的长度
+func (m *Message) bodyLength() (int, error) {
+	switch m.Type {
+	case TypeRouterSolicitation:
+		return 4, nil
+	case TypeRouterAdvertisement:
+		return 12, nil
+	case TypeNeighborSolicitation, TypeNeighborAdvertisement:
+		return 20, nil
+	default:
+		return 0, fmt.Errorf("unsupported NDP message type: %d", m.Type)
+	}
+}
+
+// Marshal 将NDP消息序列化为字节数组，校验和使用IPv6伪头部计算
+func (m *Message) Marshal(srcIP, dstIP [16]byte) ([]byte, error) {
+	bodyLength, err := m.bodyLength()
+	if err != nil {
+		return nil, err
+	}
+
+	length := 4 + bodyLength
+	for _, opt := range m.Options {
+		if (len(opt.Data)+2)%8 != 0 {
+			return nil, fmt.Errorf("NDP option %d has invalid length: %d bytes", opt.Type, len(opt.Data)+2)
+		}
+		length += len(opt.Data) + 2
+	}
+
+	data := make([]byte, length)
+
+	// 类型和代码
+	data[0] = m.Type
+	data[1] = m.Code
+
+	// 固定部分
+	switch m.Type {
+	case TypeRouterAdvertisement:
+		data[4] = m.CurHopLimit
+		data[5] = m.Flags
+		binary.BigEndian.PutUint16(data[6:8], m.RouterLifetime)
+		binary.BigEndian.PutUint32(data[8:12], m.ReachableTime)
+		binary.BigEndian.PutUint32(data[12:16], m.RetransTimer)
+	case TypeNeighborSolicitation:
+		copy(data[8:24], m.TargetAddress[:])
+	case TypeNeighborAdvertisement:
+		data[4] = m.Flags
+		copy(data[8:24], m.TargetAddress[:])
+	}
+
+	// 选项
+	offset := 4 + bodyLength
+	for _, opt := range m.Options {
+		data[offset] = opt.Type
+		data[offset+1] = uint8((len(opt.Data) + 2) / 8)
+		copy(data[offset+2:], opt.Data)
+		offset += len(opt.Data) + 2
+	}
+
+	// 计算校验和
+	m.Checksum = utils.CalculateICMPv6Checksum(data, srcIP[:], dstIP[:])
+	binary.BigEndian.PutUint16(data[2:4], m.Checksum)
+
+	return data, nil
+}
+
+// Unmarshal 从字节数组解析NDP消息
+func (m *Message) Unmarshal(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("NDP message too short: %d bytes", len(data))
+	}
+
+	m.Type = data[0]
+	m.Code = data[1]
+	m.Checksum = binary.BigEndian.Uint16(data[2:4])
+
+	bodyLength, err := m.bodyLength()
+	if err != nil {
+		return err
+	}
+	if len(data) < 4+bodyLength {
+		return fmt.Errorf("NDP message type %d too short: %d bytes", m.Type, len(data))
+	}
+
+	switch m.Type {
+	case TypeRouterAdvertisement:
+		m.CurHopLimit = data[4]
+		m.Flags = data[5]
+		m.RouterLifetime = binary.BigEndian.Uint16(data[6:8])
+		m.ReachableTime = binary.BigEndian.Uint32(data[8:12])
+		m.RetransTimer = binary.BigEndian.Uint32(data[12:16])
+	case TypeNeighborSolicitation:
+		copy(m.TargetAddress[:], data[8:24])
+	case TypeNeighborAdvertisement:
+		m.Flags = data[4]
+		copy(m.TargetAddress[:], data[8:24])
+	}
+
+	// 解析选项
+	m.Options = nil
+	rest := data[4+bodyLength:]
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return fmt.Errorf("NDP option truncated: %d bytes", len(rest))
+		}
+		optLength := int(rest[1]) * 8
+		if optLength == 0 {
+			return fmt.Errorf("NDP option %d has zero length", rest[0])
+		}
+		if optLength > len(rest) {
+			return fmt.Errorf("NDP option %d truncated: need %d bytes, have %d", rest[0], optLength, len(rest))
+		}
+		optData := make([]byte, optLength-2)
+		copy(optData, rest[2:optLength])
+		m.Options = append(m.Options, Option{Type: rest[0], Data: optData})
+		rest = rest[optLength:]
+	}
+
+	return nil
+}
+
+// String 返回NDP消息的字符串表示
+func (m *Message) String() string {
+	switch m.Type {
+	case TypeRouterSolicitation:
+		return fmt.Sprintf("NDP Router Solicitation: Options=%d", len(m.Options))
+	case TypeRouterAdvertisement:
+		return fmt.Sprintf("NDP Router Advertisement: HopLimit=%d, Lifetime=%ds, Flags=0x%02x, Options=%d",
+			m.CurHopLimit, m.RouterLifetime, m.Flags, len(m.Options))
+	case TypeNeighborSolicitation:
+		return fmt.Sprintf("NDP Neighbor Solicitation: Target=%s, Options=%d",
+			net.IP(m.TargetAddress[:]).String(), len(m.Options))
+	case TypeNeighborAdvertisement:
+		return fmt.Sprintf("NDP Neighbor Advertisement: Target=%s, Flags=0x%02x, Options=%d",
+			net.IP(m.TargetAddress[:]).String(), m.Flags, len(m.Options))
+	default:
+		return fmt.Sprintf("NDP Message: Type=%d, Code=%d", m.Type, m.Code)
+	}
+}
+
+// LinkAddress 返回指定类型的链路层地址选项
+func (m *Message) LinkAddress(optType uint8) ([6]byte, bool) {
+	var mac [6]byte
+	for _, opt := range m.Options {
+		if opt.Type == optType && len(opt.Data) >= 6 {
+			copy(mac[:], opt.Data[:6])
+			return mac, true
+		}
+	}
+	return mac, false
+}
+
+// Prefixes 返回消息中的所有前缀信息选项
+func (m *Message) Prefixes() []PrefixInfo {
+	var prefixes []PrefixInfo
+	for _, opt := range m.Options {
+		if opt.Type != OptionPrefixInfo || len(opt.Data) < 30 {
+			continue
+		}
+		var pi PrefixInfo
+		pi.PrefixLength = opt.Data[0]
+		pi.Flags = opt.Data[1]
+		pi.ValidLifetime = binary.BigEndian.Uint32(opt.Data[2:6])
+		pi.PreferredLifetime = binary.BigEndian.Uint32(opt.Data[6:10])
+		copy(pi.Prefix[:], opt.Data[14:30])
+		prefixes = append(prefixes, pi)
+	}
+	return prefixes
+}
+
+// MTU 返回消息中的MTU选项
+func (m *Message) MTU() (uint32, bool) {
+	for _, opt := range m.Options {
+		if opt.Type == OptionMTU && len(opt.Data) >= 6 {
+			return binary.BigEndian.Uint32(opt.Data[2:6]), true
+		}
+	}
+	return 0, false
+}
+
+// NewLinkAddressOption 创建源/目标链路层地址选项
+func NewLinkAddressOption(optType uint8, mac [6]byte) Option {
+	data := make([]byte, 6)
+	copy(data, mac[:])
+	return Option{Type: optType, Data: data}
+}
+
+// NewPrefixInfoOption 创建前缀信息选项
+func NewPrefixInfoOption(pi PrefixInfo) Option {
+	data := make([]byte, 30)
+	data[0] = pi.PrefixLength
+	data[1] = pi.Flags
+	binary.BigEndian.PutUint32(data[2:6], pi.ValidLifetime)
+	binary.BigEndian.PutUint32(data[6:10], pi.PreferredLifetime)
+	copy(data[14:30], pi.Prefix[:])
+	return Option{Type: OptionPrefixInfo, Data: data}
+}
+
+// NewMTUOption 创建MTU选项
+func NewMTUOption(mtu uint32) Option {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint32(data[2:6], mtu)
+	return Option{Type: OptionMTU, Data: data}
+}
+
+// VerifyChecksum 校验NDP消息的ICMPv6校验和
+func VerifyChecksum(data []byte, srcIP, dstIP [16]byte) bool {
+	return utils.CalculateICMPv6Checksum(data, srcIP[:], dstIP[:]) == 0
+}
+
+// NewRouterSolicitation 创建Router Solicitation消息
+func NewRouterSolicitation(srcMAC [6]byte, includeSource bool) *Message {
+	m := &Message{Type: TypeRouterSolicitation}
+	if includeSource {
+		m.Options = append(m.Options, NewLinkAddressOption(OptionSourceLinkAddress, srcMAC))
+	}
+	return m
+}
+
+// NewNeighborSolicitation 创建Neighbor Solicitation消息
+func NewNeighborSolicitation(target [16]byte, srcMAC [6]byte, includeSource bool) *Message {
+	m := &Message{Type: TypeNeighborSolicitation, TargetAddress: target}
+	if includeSource {
+		m.Options = append(m.Options, NewLinkAddressOption(OptionSourceLinkAddress, srcMAC))
+	}
+	return m
+}
+
+// NewNeighborAdvertisement 创建Neighbor Advertisement消息
+func NewNeighborAdvertisement(target [16]byte, targetMAC [6]byte, flags uint8) *Message {
+	return &Message{
+		Type:          TypeNeighborAdvertisement,
+		Flags:         flags,
+		TargetAddress: target,
+		Options:       []Option{NewLinkAddressOption(OptionTargetLinkAddress, targetMAC)},
+	}
+}
@@ -0,0 +1,110 @@
+package pcap
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"ustack/pkg/link"
+)
+
+// CaptureEndpoint 记录收发帧的链路端点包装
+//
+// 协议栈发送的帧在交给内层端点前写出，内层端点收到的帧在交给协议栈前写出，时间戳为当前时间。
+// 写出失败不影响收发，第一个错误由Err返回。
+type CaptureEndpoint struct {
+	inner link.Endpoint
+	w     PacketWriter
+	file  io.Closer
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewCapture 包装inner，将每个帧写到w
+func NewCapture(inner link.Endpoint, w PacketWriter) *CaptureEndpoint {
+	return &CaptureEndpoint{inner: inner, w: w}
+}
+
+// CreateCapture 创建抓包文件并包装inner，扩展名为.pcapng时使用pcapng格式（记录方向），否则使用pcap格式；
+// Close时关闭文件
+func CreateCapture(inner link.Endpoint, path string) (*CaptureEndpoint, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var w PacketWriter
+	if strings.EqualFold(filepath.Ext(path), ".pcapng") {
+		w, err = NewNgWriter(f, "ustack0", 0)
+	} else {
+		w, err = NewWriter(f, 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e := NewCapture(inner, w)
+	e.file = f
+	return e, nil
+}
+
+// MTU 返回内层端点的MTU
+func (e *CaptureEndpoint) MTU() int {
+	return e.inner.MTU()
+}
+
+// MACAddress 返回内层端点的MAC地址
+func (e *CaptureEndpoint) MACAddress() [6]byte {
+	return e.inner.MACAddress()
+}
+
+// WriteFrame 记录并发送帧
+func (e *CaptureEndpoint) WriteFrame(frame []byte) error {
+	e.record(frame, DirectionOutbound)
+	return e.inner.WriteFrame(frame)
+}
+
+// Attach 注册接收回调，收到的帧先记录再交给dispatcher
+func (e *CaptureEndpoint) Attach(dispatcher link.Dispatcher) {
+	e.inner.Attach(func(frame []byte) {
+		e.record(frame, DirectionInbound)
+		dispatcher(frame)
+	})
+}
+
+// Close 关闭内层端点和抓包文件
+func (e *CaptureEndpoint) Close() error {
+	err := e.inner.Close()
+	if e.file != nil {
+		if ferr := e.file.Close(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Err 返回第一个写出错误
+func (e *CaptureEndpoint) Err() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.err
+}
+
+// Inner 返回被包装的端点
+func (e *CaptureEndpoint) Inner() link.Endpoint {
+	return e.inner
+}
+
+func (e *CaptureEndpoint) record(frame []byte, dir Direction) {
+	p := &Packet{Timestamp: time.Now(), Data: frame, Length: len(frame), Direction: dir}
+	if err := e.w.WritePacket(p); err != nil {
+		e.mu.Lock()
+		if e.err == nil {
+			e.err = err
+		}
+		e.mu.Unlock()
+	}
+}
@@ -0,0 +1,367 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// 块类型
+	blockSectionHeader  = 0x0a0d0d0a
+	blockInterface      = 0x00000001
+	blockSimplePacket   = 0x00000003
+	blockEnhancedPacket = 0x00000006
+
+	// 节头部中的字节序魔数
+	byteOrderMagic = 0x1a2b3c4d
+
+	// 选项代码
+	optEndOfOpt   = 0
+	optShbUserApp = 4 // shb_userappl
+	optIfName     = 2 // if_name
+	optIfTsresol  = 9 // if_tsresol
+	optEpbFlags   = 2 // epb_flags
+
+	// 块允许的最大长度
+	maxBlockLength = maxRecordLength + 1024
+)
+
+// ngInterface 接口描述块中与解析帧有关的字段
+type ngInterface struct {
+	linkType uint32
+	snapLen  uint32
+	resol    uint64 // 时间戳每秒的单位数
+}
+
+// NgReader 按顺序读取pcapng文件中的帧
+//
+// 支持多个节和两种字节序，读取增强分组块和简单分组块，跳过其他类型的块。
+type NgReader struct {
+	r          io.Reader
+	order      binary.ByteOrder
+	interfaces []ngInterface
+}
+
+// NewNgReader 读取节头部块和第一个接口描述块
+func NewNgReader(r io.Reader) (*NgReader, error) {
+	nr := &NgReader{r: r}
+	for len(nr.interfaces) == 0 {
+		blockType, body, err := nr.readBlock()
+		if err == io.EOF {
+			return nil, fmt.Errorf("pcap: no interface description block")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case blockInterface:
+			if err := nr.addInterface(body); err != nil {
+				return nil, err
+			}
+		case blockEnhancedPacket, blockSimplePacket:
+			return nil, fmt.Errorf("pcap: packet block before interface description")
+		}
+	}
+	return nr, nil
+}
+
+// LinkType 返回第一个接口的链路类型
+func (r *NgReader) LinkType() uint32 {
+	return r.interfaces[0].linkType
+}
+
+// ReadPacket 读取下一个帧，文件结束时返回io.EOF
+func (r *NgReader) ReadPacket() (*Packet, error) {
+	for {
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case blockSectionHeader:
+			// 新的节重新编号接口
+			r.interfaces = nil
+		case blockInterface:
+			if err := r.addInterface(body); err != nil {
+				return nil, err
+			}
+		case blockEnhancedPacket:
+			return r.enhancedPacket(body)
+		case blockSimplePacket:
+			return r.simplePacket(body)
+		}
+	}
+}
+
+// readBlock 读取一个块，返回块类型和去掉首尾长度字段的块体；节头部块决定后续块的字节序
+func (r *NgReader) readBlock() (uint32, []byte, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("pcap: truncated block header")
+		}
+		return 0, nil, err
+	}
+
+	// 块类型0x0a0d0d0a与字节序无关，字节序由其后的魔数确定
+	if binary.LittleEndian.Uint32(hdr[0:4]) == blockSectionHeader {
+		var magic [4]byte
+		if _, err := io.ReadFull(r.r, magic[:]); err != nil {
+			return 0, nil, fmt.Errorf("pcap: truncated section header")
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic[:]) == byteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic[:]) == byteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, ErrBadMagic
+		}
+		body, err := r.readBody(r.order.Uint32(hdr[4:8]), 12)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(body) < 12 {
+			return 0, nil, fmt.Errorf("pcap: truncated section header")
+		}
+		if major := r.order.Uint16(body[0:2]); major != 1 {
+			return 0, nil, fmt.Errorf("pcap: unsupported pcapng version %d.%d", major, r.order.Uint16(body[2:4]))
+		}
+		return blockSectionHeader, body, nil
+	}
+	if r.order == nil {
+		return 0, nil, ErrBadMagic
+	}
+
+	blockType := r.order.Uint32(hdr[0:4])
+	body, err := r.readBody(r.order.Uint32(hdr[4:8]), 8)
+	return blockType, body, err
+}
+
+// readBody 读取块的剩余部分（已读read字节），检查尾部的长度字段
+func (r *NgReader) readBody(length uint32, read int) ([]byte, error) {
+	if length < uint32(read)+4 || length%4 != 0 || length > maxBlockLength {
+		return nil, fmt.Errorf("pcap: invalid block length %d", length)
+	}
+	buf := make([]byte, int(length)-read)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, fmt.Errorf("pcap: truncated block: %w", err)
+	}
+	body, trailer := buf[:len(buf)-4], buf[len(buf)-4:]
+	if r.order.Uint32(trailer) != length {
+		return nil, fmt.Errorf("pcap: block length mismatch")
+	}
+	return body, nil
+}
+
+// addInterface 解析接口描述块
+func (r *NgReader) addInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("pcap: truncated interface description")
+	}
+	iface := ngInterface{
+		linkType: uint32(r.order.Uint16(body[0:2])),
+		snapLen:  r.order.Uint32(body[4:8]),
+		resol:    1e6,
+	}
+	err := r.options(body[8:], func(code uint16, value []byte) error {
+		if code != optIfTsresol || len(value) != 1 {
+			return nil
+		}
+		// 最高位为1时是2的负幂，否则是10的负幂
+		exp := value[0] & 0x7f
+		if value[0]&0x80 != 0 && exp < 64 {
+			iface.resol = 1 << exp
+			return nil
+		}
+		if value[0]&0x80 == 0 && exp <= 19 {
+			iface.resol = 1
+			for i := byte(0); i < exp; i++ {
+				iface.resol *= 10
+			}
+			return nil
+		}
+		return fmt.Errorf("pcap: unsupported timestamp resolution %#x", value[0])
+	})
+	if err != nil {
+		return err
+	}
+	r.interfaces = append(r.interfaces, iface)
+	return nil
+}
+
+// enhancedPacket 解析增强分组块
+func (r *NgReader) enhancedPacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("pcap: truncated enhanced packet block")
+	}
+	ifID := r.order.Uint32(body[0:4])
+	if int(ifID) >= len(r.interfaces) {
+		return nil, fmt.Errorf("pcap: packet for unknown interface %d", ifID)
+	}
+	iface := r.interfaces[ifID]
+	ticks := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+	capLen := int(r.order.Uint32(body[12:16]))
+	origLen := int(r.order.Uint32(body[16:20]))
+	if capLen > len(body)-20 {
+		return nil, fmt.Errorf("pcap: truncated enhanced packet block")
+	}
+
+	p := &Packet{
+		Timestamp: timestamp(ticks, iface.resol),
+		Data:      append([]byte(nil), body[20:20+capLen]...),
+		Length:    origLen,
+	}
+	options := body[min(20+pad4(capLen), len(body)):]
+	err := r.options(options, func(code uint16, value []byte) error {
+		if code == optEpbFlags && len(value) == 4 {
+			switch r.order.Uint32(value) & 0x3 {
+			case 1:
+				p.Direction = DirectionInbound
+			case 2:
+				p.Direction = DirectionOutbound
+			}
+		}
+		return nil
+	})
+	return p, err
+}
+
+// simplePacket 解析简单分组块，没有时间戳，属于第一个接口
+func (r *NgReader) simplePacket(body []byte) (*Packet, error) {
+	if len(r.interfaces) == 0 || len(body) < 4 {
+		return nil, fmt.Errorf("pcap: invalid simple packet block")
+	}
+	origLen := int(r.order.Uint32(body[0:4]))
+	capLen := origLen
+	if snap := int(r.interfaces[0].snapLen); snap > 0 && capLen > snap {
+		capLen = snap
+	}
+	if capLen > len(body)-4 {
+		return nil, fmt.Errorf("pcap: truncated simple packet block")
+	}
+	return &Packet{Data: append([]byte(nil), body[4:4+capLen]...), Length: origLen}, nil
+}
+
+// options 依次处理选项，直到opt_endofopt或数据结束
+func (r *NgReader) options(data []byte, fn func(code uint16, value []byte) error) error {
+	for len(data) >= 4 {
+		code := r.order.Uint16(data[0:2])
+		length := int(r.order.Uint16(data[2:4]))
+		if code == optEndOfOpt {
+			return nil
+		}
+		if 4+length > len(data) {
+			return fmt.Errorf("pcap: truncated option %d", code)
+		}
+		if err := fn(code, data[4:4+length]); err != nil {
+			return err
+		}
+		data = data[min(4+pad4(length), len(data)):]
+	}
+	return nil
+}
+
+// timestamp 将以1/resol秒为单位的时间戳转换为时间
+func timestamp(ticks, resol uint64) time.Time {
+	sec, rem := ticks/resol, ticks%resol
+	// rem < resol，商不会溢出
+	hi, lo := bits.Mul64(rem, 1e9)
+	nsec, _ := bits.Div64(hi, lo, resol)
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// pad4 返回按4字节对齐后的长度
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// NgWriter 按pcapng格式（小端、纳秒精度、以太网链路的单个接口）写出帧，记录帧的方向；
+// 可以被多个goroutine同时使用
+type NgWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	snapLen int
+}
+
+// NewNgWriter 写出节头部块和名为ifName的接口描述块，snapLen为0时使用DefaultSnapLen
+func NewNgWriter(w io.Writer, ifName string, snapLen int) (*NgWriter, error) {
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+
+	shb := binary.LittleEndian.AppendUint32(nil, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1)
+	shb = binary.LittleEndian.AppendUint16(shb, 0)
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0)) // 节长度未知
+	shb = appendOption(shb, optShbUserApp, []byte("ustack"))
+	shb = appendOption(shb, optEndOfOpt, nil)
+
+	idb := binary.LittleEndian.AppendUint16(nil, LinkTypeEthernet)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(snapLen))
+	if ifName != "" {
+		idb = appendOption(idb, optIfName, []byte(ifName))
+	}
+	idb = appendOption(idb, optIfTsresol, []byte{9})
+	idb = appendOption(idb, optEndOfOpt, nil)
+
+	data := append(block(blockSectionHeader, shb), block(blockInterface, idb)...)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("pcap: writing section header: %w", err)
+	}
+	return &NgWriter{w: w, snapLen: snapLen}, nil
+}
+
+// WritePacket 写出一个增强分组块，超过快照长度的部分被截断；Length为0时使用数据长度
+func (w *NgWriter) WritePacket(p *Packet) error {
+	data := p.Data
+	if len(data) > w.snapLen {
+		data = data[:w.snapLen]
+	}
+	length := p.Length
+	if length == 0 {
+		length = len(p.Data)
+	}
+	ticks := uint64(p.Timestamp.UnixNano())
+
+	body := make([]byte, 20, 20+pad4(len(data))+12)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ticks>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ticks))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(length))
+	body = append(body, data...)
+	body = append(body, make([]byte, pad4(len(data))-len(data))...)
+	if p.Direction != DirectionUnknown {
+		body = appendOption(body, optEpbFlags, binary.LittleEndian.AppendUint32(nil, uint32(p.Direction)))
+		body = appendOption(body, optEndOfOpt, nil)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.w.Write(block(blockEnhancedPacket, body))
+	return err
+}
+
+// block 为块体加上类型和首尾的长度字段
+func block(blockType uint32, body []byte) []byte {
+	length := uint32(12 + pad4(len(body)))
+	b := binary.LittleEndian.AppendUint32(make([]byte, 0, length), blockType)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+	b = append(b, make([]byte, pad4(len(body))-len(body))...)
+	return binary.LittleEndian.AppendUint32(b, length)
+}
+
+// appendOption 追加一个按4字节对齐的选项
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value))-len(value))...)
+}
@@ -0,0 +1,171 @@
+package pcap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"ustack/pkg/link"
+)
+
+// ReplayEndpoint 回放抓包文件的链路端点
+//
+// Start后按原始的时间间隔将文件中的帧交给协议栈，协议栈发送的帧被丢弃。用于重现抓到的流量，
+// 此时MAC地址应设置为抓包中目标主机的地址。文件可以是pcap或pcapng格式，pcapng中标记为发出的帧
+// （如CaptureEndpoint记录的本机发送的帧）不回放。
+type ReplayEndpoint struct {
+	mu         sync.RWMutex
+	r          PacketReader
+	file       io.Closer
+	mac        [6]byte
+	mtu        int
+	dispatcher link.Dispatcher
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	done      chan struct{} // 回放结束
+	closed    chan struct{}
+	err       error
+}
+
+// NewReplay 创建回放r中帧的端点，文件的链路类型必须是以太网
+func NewReplay(r io.Reader, mac [6]byte, mtu int) (*ReplayEndpoint, error) {
+	pr, err := OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if pr.LinkType() != LinkTypeEthernet {
+		return nil, fmt.Errorf("pcap: unsupported link type %d", pr.LinkType())
+	}
+	if mtu <= 0 {
+		mtu = link.DefaultMTU
+	}
+	return &ReplayEndpoint{
+		r:      pr,
+		mac:    mac,
+		mtu:    mtu,
+		done:   make(chan struct{}),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+// OpenReplay 打开抓包文件并创建回放端点，Close时关闭文件
+func OpenReplay(path string, mac [6]byte, mtu int) (*ReplayEndpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewReplay(f, mac, mtu)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e.file = f
+	return e, nil
+}
+
+// MTU 返回链路MTU
+func (e *ReplayEndpoint) MTU() int {
+	return e.mtu
+}
+
+// MACAddress 返回端点的MAC地址
+func (e *ReplayEndpoint) MACAddress() [6]byte {
+	return e.mac
+}
+
+// WriteFrame 丢弃协议栈发送的帧
+func (e *ReplayEndpoint) WriteFrame(frame []byte) error {
+	select {
+	case <-e.closed:
+		return link.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Attach 注册接收回调
+func (e *ReplayEndpoint) Attach(dispatcher link.Dispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dispatcher = dispatcher
+}
+
+// Start 开始回放，应在协议栈配置完成后调用，多次调用无效
+func (e *ReplayEndpoint) Start() {
+	e.startOnce.Do(func() { go e.replay() })
+}
+
+// Done 返回回放结束（文件读完、出错或端点关闭）时关闭的通道
+func (e *ReplayEndpoint) Done() <-chan struct{} {
+	return e.done
+}
+
+// Err 返回导致回放提前结束的错误，文件正常读完时为nil
+func (e *ReplayEndpoint) Err() error {
+	<-e.done
+	return e.err
+}
+
+// Close 停止回放并关闭文件
+func (e *ReplayEndpoint) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		if e.file != nil {
+			err = e.file.Close()
+		}
+	})
+	return err
+}
+
+// replay 读取帧并按相对于第一个帧的时间交付
+func (e *ReplayEndpoint) replay() {
+	defer close(e.done)
+
+	var first time.Time
+	start := time.Now()
+	for {
+		p, err := e.r.ReadPacket()
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-e.closed:
+				default:
+					e.err = err
+				}
+			}
+			return
+		}
+
+		if p.Direction == DirectionOutbound {
+			continue
+		}
+		if first.IsZero() {
+			first = p.Timestamp
+		}
+		if wait := p.Timestamp.Sub(first) - time.Since(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-e.closed:
+				timer.Stop()
+				return
+			}
+		}
+
+		select {
+		case <-e.closed:
+			return
+		default:
+		}
+
+		e.mu.RLock()
+		dispatcher := e.dispatcher
+		e.mu.RUnlock()
+		if dispatcher != nil {
+			dispatcher(p.Data)
+		}
+	}
+}
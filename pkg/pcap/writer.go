@@ -0,0 +1,63 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PacketWriter 写出抓到的帧，Writer和NgWriter都实现了该接口
+type PacketWriter interface {
+	WritePacket(p *Packet) error
+}
+
+// Writer 按pcap格式（小端、微秒精度、以太网链路）写出帧，可以被多个goroutine同时使用
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	snapLen int
+}
+
+// NewWriter 写出文件头，snapLen为0时使用DefaultSnapLen
+func NewWriter(w io.Writer, snapLen int) (*Writer, error) {
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+
+	var hdr [fileHeaderLength]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], magicMicroseconds)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(snapLen))
+	binary.LittleEndian.PutUint32(hdr[20:24], LinkTypeEthernet)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, fmt.Errorf("pcap: writing file header: %w", err)
+	}
+	return &Writer{w: w, snapLen: snapLen}, nil
+}
+
+// WritePacket 写出一个帧，超过快照长度的部分被截断；Length为0时使用数据长度
+func (w *Writer) WritePacket(p *Packet) error {
+	data := p.Data
+	if len(data) > w.snapLen {
+		data = data[:w.snapLen]
+	}
+	length := p.Length
+	if length == 0 {
+		length = len(p.Data)
+	}
+
+	// 头部和数据一次写出，并发写入时记录不会交错
+	rec := make([]byte, recordHeaderLength+len(data))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(p.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(p.Timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(length))
+	copy(rec[recordHeaderLength:], data)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.w.Write(rec)
+	return err
+}
@@ -0,0 +1,213 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/stack"
+)
+
+const (
+	// 默认接收队列长度
+	DefaultReceiveQueueLength = 256
+
+	// 单个Echo Request的最大数据长度
+	MaxPayloadLength = 65535 - ip.IPHeaderLength - 8
+)
+
+// ErrClosed 端点已关闭
+var ErrClosed = errors.New("ping endpoint closed")
+
+// Reply 收到的Echo Reply，或与本端点发出的Echo Request相关的ICMP差错
+type Reply struct {
+	From     [4]byte   // 回复方地址（差错报文为发出差错的路由器）
+	Sequence uint16    // 序列号
+	TTL      uint8     // 回复报文的TTL
+	Size     int       // ICMP报文长度（头部 + 数据）
+	Payload  []byte    // 回显的数据
+	Received time.Time // 接收时间
+
+	// 差错报文对应的错误，Echo Reply时为nil
+	Err *stack.TransportError
+
+	// 以下字段由Pinger填写
+	RTT       time.Duration // 往返时间
+	Duplicate bool          // 重复的回复
+}
+
+// ErrorString 返回差错的描述（同iputils ping）
+func (r *Reply) ErrorString() string {
+	if r.Err == nil {
+		return ""
+	}
+	return Describe(r.Err.Type, r.Err.Code, r.Err.MTU)
+}
+
+// Describe 返回ICMP差错类型和代码的描述
+func Describe(typ, code uint8, mtu uint16) string {
+	switch typ {
+	case icmp.TypeDestUnreach:
+		switch code {
+		case icmp.CodeNetUnreach:
+			return "Destination Net Unreachable"
+		case icmp.CodeHostUnreach:
+			return "Destination Host Unreachable"
+		case icmp.CodeProtoUnreach:
+			return "Destination Protocol Unreachable"
+		case icmp.CodePortUnreach:
+			return "Destination Port Unreachable"
+		case icmp.CodeFragNeeded:
+			return fmt.Sprintf("Frag needed and DF set (mtu = %d)", mtu)
+		case icmp.CodeSourceRouteFailed:
+			return "Source Route Failed"
+		case icmp.CodeNetUnknown:
+			return "Destination Net Unknown"
+		case icmp.CodeHostUnknown:
+			return "Destination Host Unknown"
+		case icmp.CodeNetProhibited:
+			return "Destination Net Prohibited"
+		case icmp.CodeHostProhibited:
+			return "Destination Host Prohibited"
+		case icmp.CodeAdminProhibited:
+			return "Packet filtered"
+		}
+		return fmt.Sprintf("Dest Unreachable, Bad Code: %d", code)
+	case icmp.TypeTimeExceeded:
+		switch code {
+		case icmp.CodeTTLExceeded:
+			return "Time to live exceeded"
+		case icmp.CodeReassemblyExceeded:
+			return "Frag reassembly time exceeded"
+		}
+		return fmt.Sprintf("Time exceeded, Bad Code: %d", code)
+	}
+	return fmt.Sprintf("Bad ICMP type: %d", typ)
+}
+
+// Endpoint ICMP Echo端点（同Linux ping套接字），按标识符接收Echo Reply和相关差错
+type Endpoint struct {
+	stack *stack.Stack
+	id    stack.TransportEndpointID
+
+	queue     chan Reply
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// 日志
+	logger *utils.Logger
+}
+
+// NewEndpoint 创建Echo端点并分配标识符
+func NewEndpoint(s *stack.Stack) (*Endpoint, error) {
+	e := &Endpoint{
+		stack:  s,
+		queue:  make(chan Reply, DefaultReceiveQueueLength),
+		closed: make(chan struct{}),
+		logger: utils.DefaultLogger,
+	}
+
+	id, err := s.RegisterTransportEndpoint(ip.ProtocolICMP, stack.TransportEndpointID{}, e)
+	if err != nil {
+		return nil, err
+	}
+	e.id = id
+	return e, nil
+}
+
+// ID 返回Echo Request使用的标识符
+func (e *Endpoint) ID() uint16 {
+	return e.id.LocalPort
+}
+
+// Send 向dst发送Echo Request，ttl为0时使用默认TTL
+func (e *Endpoint) Send(dst [4]byte, seq uint16, payload []byte, ttl uint8) error {
+	if len(payload) > MaxPayloadLength {
+		return fmt.Errorf("ping payload too large: %d bytes", len(payload))
+	}
+	if e.isClosed() {
+		return ErrClosed
+	}
+
+	r, err := e.stack.FindRoute(dst)
+	if err != nil {
+		return err
+	}
+
+	data, err := icmp.NewEchoRequest(e.ID(), seq, payload).Marshal()
+	if err != nil {
+		return err
+	}
+	return e.stack.WritePacket(r, ip.ProtocolICMP, data, stack.WriteOptions{TTL: ttl})
+}
+
+// Replies 返回接收队列
+func (e *Endpoint) Replies() <-chan Reply {
+	return e.queue
+}
+
+// HandlePacket 处理协议栈分发的Echo Reply
+func (e *Endpoint) HandlePacket(pkt *stack.Packet) {
+	msg := &icmp.Packet{}
+	if err := msg.Unmarshal(pkt.Payload); err != nil || msg.ID != e.ID() {
+		return
+	}
+
+	e.enqueue(Reply{
+		From:     pkt.IPHeader.SourceIP,
+		Sequence: msg.Sequence,
+		TTL:      pkt.IPHeader.TTL,
+		Size:     len(pkt.Payload),
+		Payload:  msg.Data,
+		Received: time.Now(),
+	})
+}
+
+// HandleError 处理协议栈分发的ICMP差错
+func (e *Endpoint) HandleError(err *stack.TransportError) {
+	if len(err.Header) < 8 {
+		return
+	}
+
+	e.enqueue(Reply{
+		From:     err.From,
+		Sequence: binary.BigEndian.Uint16(err.Header[6:8]),
+		Received: time.Now(),
+		Err:      err,
+	})
+}
+
+// enqueue 放入接收队列，队列满时丢弃
+func (e *Endpoint) enqueue(r Reply) {
+	if e.isClosed() {
+		return
+	}
+	select {
+	case e.queue <- r:
+	default:
+		e.logger.Debug("ping: queue full, dropping reply from %s", net.IP(r.From[:]))
+	}
+}
+
+// Close 关闭端点并释放标识符
+func (e *Endpoint) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		e.stack.UnregisterTransportEndpoint(ip.ProtocolICMP, e.id, e)
+	})
+	return nil
+}
+
+func (e *Endpoint) isClosed() bool {
+	select {
+	case <-e.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,209 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+	"ustack/pkg/stack"
+)
+
+const (
+	// 默认参数（同iputils ping）
+	DefaultInterval = time.Second
+	DefaultSize     = 56
+	DefaultTimeout  = 10 * time.Second
+)
+
+// Statistics ping统计
+type Statistics struct {
+	Destination [4]byte       // 目标地址
+	Transmitted int           // 发送的请求数
+	Received    int           // 收到的回复数（不含重复）
+	Duplicates  int           // 重复的回复数
+	Errors      int           // 收到的ICMP差错数
+	Elapsed     time.Duration // 总耗时
+
+	MinRTT  time.Duration // 最小往返时间
+	AvgRTT  time.Duration // 平均往返时间
+	MaxRTT  time.Duration // 最大往返时间
+	StdDev  time.Duration // 往返时间标准差（iputils中的mdev）
+	rttSum  float64
+	rttSum2 float64
+}
+
+// addRTT 记录一个往返时间样本
+func (st *Statistics) addRTT(rtt time.Duration) {
+	if st.Received == 0 || rtt < st.MinRTT {
+		st.MinRTT = rtt
+	}
+	if rtt > st.MaxRTT {
+		st.MaxRTT = rtt
+	}
+	st.Received++
+
+	// 同iputils：mdev = sqrt(E[rtt^2] - E[rtt]^2)
+	v := float64(rtt)
+	st.rttSum += v
+	st.rttSum2 += v * v
+	avg := st.rttSum / float64(st.Received)
+	st.AvgRTT = time.Duration(avg)
+	st.StdDev = time.Duration(math.Sqrt(math.Max(st.rttSum2/float64(st.Received)-avg*avg, 0)))
+}
+
+// PacketLoss 返回丢包率（百分比）
+func (st *Statistics) PacketLoss() float64 {
+	if st.Transmitted == 0 {
+		return 0
+	}
+	return float64(st.Transmitted-st.Received) * 100 / float64(st.Transmitted)
+}
+
+// String 返回与iputils ping相同格式的统计摘要
+func (st *Statistics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s ping statistics ---\n", net.IP(st.Destination[:]))
+	fmt.Fprintf(&b, "%d packets transmitted, %d received", st.Transmitted, st.Received)
+	if st.Duplicates > 0 {
+		fmt.Fprintf(&b, ", +%d duplicates", st.Duplicates)
+	}
+	if st.Errors > 0 {
+		fmt.Fprintf(&b, ", +%d errors", st.Errors)
+	}
+	fmt.Fprintf(&b, ", %g%% packet loss, time %dms", math.Round(st.PacketLoss()*1000)/1000, st.Elapsed.Milliseconds())
+	if st.Received > 0 {
+		fmt.Fprintf(&b, "\nrtt min/avg/max/mdev = %s/%s/%s/%s ms",
+			millis(st.MinRTT), millis(st.AvgRTT), millis(st.MaxRTT), millis(st.StdDev))
+	}
+	return b.String()
+}
+
+// millis 以毫秒为单位格式化时间，保留3位小数
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
+// Pinger 按固定间隔发送Echo Request并统计回复
+type Pinger struct {
+	Count    int           // 发送次数，0表示直到ctx取消
+	Interval time.Duration // 发送间隔，0表示DefaultInterval
+	Size     int           // 数据长度（不含ICMP头部）
+	TTL      uint8         // 生存时间，0表示使用默认值
+	Timeout  time.Duration // 最后一个请求发出后等待回复的时间，0表示DefaultTimeout
+
+	// OnReply 收到回复或差错时调用（在Run的goroutine中）
+	OnReply func(r *Reply)
+
+	stack *stack.Stack
+}
+
+// NewPinger 创建使用默认参数的Pinger
+func NewPinger(s *stack.Stack) *Pinger {
+	return &Pinger{
+		Interval: DefaultInterval,
+		Size:     DefaultSize,
+		Timeout:  DefaultTimeout,
+		stack:    s,
+	}
+}
+
+// Run 向dst发送Echo Request，直到发送Count个请求并收到全部回复、超时或ctx取消
+//
+// 按标识符和序列号匹配回复；ctx取消时返回已有的统计而非错误。
+func (p *Pinger) Run(ctx context.Context, dst [4]byte) (*Statistics, error) {
+	ep, err := NewEndpoint(p.stack)
+	if err != nil {
+		return nil, err
+	}
+	defer ep.Close()
+
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	st := &Statistics{Destination: dst}
+	start := time.Now()
+	sent := make(map[uint16]time.Time)
+	replied := make(map[uint16]bool)
+	// 数据按字节序号填充（同iputils）
+	payload := make([]byte, p.Size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	send := func() error {
+		seq := uint16(st.Transmitted + 1)
+		sent[seq] = time.Now()
+		delete(replied, seq)
+		st.Transmitted++
+		return ep.Send(dst, seq, payload, p.TTL)
+	}
+
+	if err := send(); err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// 全部发送后开始计算等待超时
+	var deadline <-chan time.Time
+	done := func() bool { return p.Count > 0 && st.Transmitted >= p.Count }
+	if done() {
+		ticker.Stop()
+		deadline = time.After(timeout)
+	}
+
+	for {
+		if done() && st.Received+st.Errors >= st.Transmitted {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			st.Elapsed = time.Since(start)
+			return st, nil
+		case <-deadline:
+			st.Elapsed = time.Since(start)
+			return st, nil
+		case <-ticker.C:
+			if err := send(); err != nil {
+				return st, err
+			}
+			if done() {
+				ticker.Stop()
+				deadline = time.After(timeout)
+			}
+		case r := <-ep.Replies():
+			sentAt, ok := sent[r.Sequence]
+			if !ok {
+				continue
+			}
+			r.RTT = r.Received.Sub(sentAt)
+
+			switch {
+			case r.Err != nil:
+				st.Errors++
+			case replied[r.Sequence]:
+				r.Duplicate = true
+				st.Duplicates++
+			default:
+				replied[r.Sequence] = true
+				st.addRTT(r.RTT)
+			}
+			if p.OnReply != nil {
+				p.OnReply(&r)
+			}
+		}
+	}
+
+	st.Elapsed = time.Since(start)
+	return st, nil
+}
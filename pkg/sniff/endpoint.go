@@ -0,0 +1,72 @@
+package sniff
+
+import (
+	"ustack/internal/utils"
+	"ustack/pkg/link"
+)
+
+// Endpoint 记录收发帧摘要的链路端点包装
+//
+// 协议栈发送的帧在交给内层端点前记录，内层端点收到的帧在交给协议栈前记录，
+// 与过滤器匹配的帧以INFO级别写入日志，方向为in或out。
+type Endpoint struct {
+	inner  link.Endpoint
+	filter *Filter
+
+	// LinkLayer 为true时摘要总是包含以太网头部
+	LinkLayer bool
+
+	logger *utils.Logger
+}
+
+// NewEndpoint 包装inner，filter为nil时记录所有帧
+func NewEndpoint(inner link.Endpoint, filter *Filter) *Endpoint {
+	return &Endpoint{inner: inner, filter: filter, logger: utils.DefaultLogger}
+}
+
+// SetLogger 设置日志记录器
+func (e *Endpoint) SetLogger(logger *utils.Logger) {
+	e.logger = logger
+}
+
+// MTU 返回内层端点的MTU
+func (e *Endpoint) MTU() int {
+	return e.inner.MTU()
+}
+
+// MACAddress 返回内层端点的MAC地址
+func (e *Endpoint) MACAddress() [6]byte {
+	return e.inner.MACAddress()
+}
+
+// WriteFrame 记录并发送帧
+func (e *Endpoint) WriteFrame(frame []byte) error {
+	e.record(frame, "out")
+	return e.inner.WriteFrame(frame)
+}
+
+// Attach 注册接收回调，收到的帧先记录再交给dispatcher
+func (e *Endpoint) Attach(dispatcher link.Dispatcher) {
+	e.inner.Attach(func(frame []byte) {
+		e.record(frame, "in")
+		dispatcher(frame)
+	})
+}
+
+// Close 关闭内层端点
+func (e *Endpoint) Close() error {
+	return e.inner.Close()
+}
+
+// Inner 返回被包装的端点
+func (e *Endpoint) Inner() link.Endpoint {
+	return e.inner
+}
+
+func (e *Endpoint) record(frame []byte, direction string) {
+	p := Decode(frame)
+	if !e.filter.Match(p) {
+		return
+	}
+	e.logger.Info("%s %s", direction, p.summary(e.LinkLayer))
+}
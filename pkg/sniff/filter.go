@@ -0,0 +1,280 @@
+package sniff
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Filter 编译后的过滤表达式
+type Filter struct {
+	expr  string
+	match func(p *Packet) bool
+}
+
+// ParseFilter 编译tcpdump风格的过滤表达式，空表达式匹配所有帧
+//
+// 支持的原语：
+//
+//	arp ip tcp udp icmp igmp        协议
+//	[src|dst] host 地址             IPv4报文的源/目标地址，ARP报文的发送方/目标地址
+//	[src|dst] port 端口             TCP或UDP端口
+//	tcp [src|dst] port 端口         限定协议的端口，udp同理
+//
+// 原语可以用and（&&）、or（||）、not（!）和括号组合，not优先级最高，and高于or。
+// 省略src/dst时两个方向都匹配；同一限定词的连续原语可以省略关键字，如"port 80 or 443"。
+func ParseFilter(expr string) (*Filter, error) {
+	f := &Filter{expr: strings.TrimSpace(expr)}
+	if f.expr == "" {
+		f.match = func(*Packet) bool { return true }
+		return f, nil
+	}
+
+	p := &filterParser{tokens: tokenize(f.expr)}
+	match, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sniff: bad filter %q: %w", f.expr, err)
+	}
+	f.match = match
+	return f, nil
+}
+
+// Match 报告帧是否与表达式匹配，nil过滤器匹配所有帧
+func (f *Filter) Match(p *Packet) bool {
+	return f == nil || f.match(p)
+}
+
+// String 返回表达式原文
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.expr
+}
+
+// tokenize 按空白切分，括号和!单独成为记号
+func tokenize(expr string) []string {
+	var tokens []string
+	start := -1
+	for i, r := range expr {
+		switch {
+		case r == '(' || r == ')' || r == '!':
+			if start >= 0 {
+				tokens = append(tokens, expr[start:i])
+				start = -1
+			}
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n':
+			if start >= 0 {
+				tokens = append(tokens, expr[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, expr[start:])
+	}
+	return tokens
+}
+
+// qualifier 原语的限定词，用于省略关键字的连续原语
+type qualifier struct {
+	proto string // tcp、udp或空
+	dir   string // src、dst或空
+	kind  string // host或port
+}
+
+// filterParser 递归下降解析器
+type filterParser struct {
+	tokens []string
+	pos    int
+	last   *qualifier // 上一个host/port原语的限定词
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	tok := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return tok
+}
+
+// parseOr 解析 and-表达式 { or and-表达式 }
+func (p *filterParser) parseOr() (func(*Packet) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "or" || tok == "||"; tok = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *Packet) bool { return l(pkt) || right(pkt) }
+	}
+	return left, nil
+}
+
+// parseAnd 解析 not-表达式 { and not-表达式 }
+func (p *filterParser) parseAnd() (func(*Packet) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "and" || tok == "&&"; tok = p.peek() {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *Packet) bool { return l(pkt) && right(pkt) }
+	}
+	return left, nil
+}
+
+// parseNot 解析 { not } 基本表达式
+func (p *filterParser) parseNot() (func(*Packet) bool, error) {
+	if tok := p.peek(); tok == "not" || tok == "!" {
+		p.next()
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(pkt *Packet) bool { return !inner(pkt) }, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary 解析括号表达式或原语
+func (p *filterParser) parsePrimary() (func(*Packet) bool, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	case "arp":
+		return func(pkt *Packet) bool { return pkt.ARP != nil }, nil
+	case "ip":
+		return func(pkt *Packet) bool { return pkt.IP != nil }, nil
+	case "icmp":
+		return func(pkt *Packet) bool { return pkt.ICMP != nil }, nil
+	case "igmp":
+		return func(pkt *Packet) bool { return pkt.IGMP != nil }, nil
+	case "tcp", "udp":
+		if next := p.peek(); next == "port" || next == "src" || next == "dst" {
+			return p.parseQualified(qualifier{proto: tok})
+		}
+		return protoMatcher(tok), nil
+	case "src", "dst", "host", "port":
+		p.pos--
+		return p.parseQualified(qualifier{})
+	}
+
+	// 省略关键字时沿用上一个原语的限定词
+	if p.last != nil {
+		p.pos--
+		return p.value(*p.last)
+	}
+	return nil, fmt.Errorf("unknown primitive %q", tok)
+}
+
+// parseQualified 解析 [src|dst] host|port 值
+func (p *filterParser) parseQualified(q qualifier) (func(*Packet) bool, error) {
+	if tok := p.peek(); tok == "src" || tok == "dst" {
+		q.dir = p.next()
+	}
+	switch q.kind = p.next(); q.kind {
+	case "host":
+		if q.proto != "" {
+			return nil, fmt.Errorf("%s host is not supported", q.proto)
+		}
+	case "port":
+	case "":
+		return nil, fmt.Errorf("missing host or port after %q", p.tokens[p.pos-1])
+	default:
+		return nil, fmt.Errorf("expected host or port, got %q", p.tokens[p.pos-1])
+	}
+	return p.value(q)
+}
+
+// value 解析原语的值并生成匹配函数
+func (p *filterParser) value(q qualifier) (func(*Packet) bool, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing value after %s", q.kind)
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	var match func(*Packet) bool
+	if q.kind == "host" {
+		parsed := net.ParseIP(tok).To4()
+		if parsed == nil {
+			return nil, fmt.Errorf("bad host %q", tok)
+		}
+		addr := [4]byte(parsed)
+		match = func(pkt *Packet) bool {
+			src, dst, ok := pkt.addresses()
+			return ok && directional(q.dir, src == addr, dst == addr)
+		}
+	} else {
+		port, err := strconv.ParseUint(tok, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad port %q", tok)
+		}
+		proto := protoMatcher(q.proto)
+		match = func(pkt *Packet) bool {
+			src, dst, ok := pkt.ports()
+			return ok && proto(pkt) && directional(q.dir, src == uint16(port), dst == uint16(port))
+		}
+	}
+	p.last = &q
+	return match, nil
+}
+
+// protoMatcher 返回匹配传输层协议的函数，proto为空时匹配所有帧
+func protoMatcher(proto string) func(*Packet) bool {
+	switch proto {
+	case "tcp":
+		return func(pkt *Packet) bool { return pkt.TCP != nil }
+	case "udp":
+		return func(pkt *Packet) bool { return pkt.UDP != nil }
+	}
+	return func(*Packet) bool { return true }
+}
+
+// directional 按方向限定词组合源和目标的匹配结果
+func directional(dir string, src, dst bool) bool {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	}
+	return src || dst
+}
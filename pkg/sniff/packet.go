@@ -0,0 +1,164 @@
+// Package sniff 逐帧解码并记录收发报文的链路端点包装
+//
+// 每个帧依次按以太网、ARP/IPv4、TCP/UDP/ICMP/IGMP解码，通过各层的String方法生成一行摘要，
+// 例如：
+//
+//	out IP Header: 10.0.0.1 -> 10.0.0.2, Protocol: 6, TTL: 64, Length: 44 | TCP Header: 40000 -> 80, Seq: 1, Ack: 0, Flags: [SYN ], Window: 65535
+//
+// 只记录与过滤表达式匹配的帧，表达式语法是tcpdump的子集，见ParseFilter。
+package sniff
+
+import (
+	"fmt"
+	"strings"
+	"ustack/pkg/arp"
+	"ustack/pkg/eth"
+	"ustack/pkg/icmp"
+	"ustack/pkg/igmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/tcp"
+	"ustack/pkg/udp"
+)
+
+// Packet 解码后的帧，未出现或无法解码的层为nil
+type Packet struct {
+	Frame eth.Frame
+	ARP   *arp.Packet
+	IP    *ip.Header
+	TCP   *tcp.Header
+	UDP   *udp.Packet
+	ICMP  *icmp.Packet
+	IGMP  *igmp.Message
+
+	// TCP段的数据长度
+	Payload int
+
+	// 第一个解码错误，此前各层仍然可用
+	Err error
+}
+
+// Decode 解码以太网帧，出错时停在出错的那一层
+func Decode(frame []byte) *Packet {
+	p := &Packet{}
+	if p.Err = p.Frame.Unmarshal(frame); p.Err != nil {
+		return p
+	}
+
+	switch p.Frame.EtherType {
+	case eth.EtherTypeARP:
+		a := &arp.Packet{}
+		if p.Err = a.Unmarshal(p.Frame.Payload); p.Err == nil {
+			p.ARP = a
+		}
+	case eth.EtherTypeIPv4:
+		p.decodeIP(p.Frame.Payload)
+	}
+	return p
+}
+
+// decodeIP 解码IPv4头部和传输层，以太网填充按总长度去掉；非首个分片不含传输层头部
+func (p *Packet) decodeIP(data []byte) {
+	h := &ip.Header{}
+	if p.Err = h.Unmarshal(data); p.Err != nil {
+		return
+	}
+	p.IP = h
+
+	headerLength := int(h.IHL) * 4
+	end := int(h.TotalLength)
+	if h.Version != 4 || headerLength < ip.IPHeaderLength || end < headerLength || end > len(data) {
+		p.Err = fmt.Errorf("malformed IPv4 header: version %d, header %d bytes, total %d of %d bytes",
+			h.Version, headerLength, end, len(data))
+		return
+	}
+	if !h.IsFirstFragment() {
+		return
+	}
+	data = data[headerLength:end]
+
+	switch h.Protocol {
+	case ip.ProtocolTCP:
+		t := &tcp.Header{}
+		if p.Err = t.Unmarshal(data); p.Err == nil {
+			p.TCP = t
+			p.Payload = len(data) - t.HeaderLength()
+		}
+	case ip.ProtocolUDP:
+		u := &udp.Packet{}
+		if p.Err = u.Unmarshal(data); p.Err == nil {
+			p.UDP = u
+		}
+	case ip.ProtocolICMP:
+		c := &icmp.Packet{}
+		if p.Err = c.Unmarshal(data); p.Err == nil {
+			p.ICMP = c
+		}
+	case ip.ProtocolIGMP:
+		m := &igmp.Message{}
+		if p.Err = m.Unmarshal(data); p.Err == nil {
+			p.IGMP = m
+		}
+	}
+}
+
+// String 返回一行摘要：已解码的各层从网络层开始以" | "连接，无法识别的帧给出以太网头部，解码错误附在末尾
+func (p *Packet) String() string {
+	return p.summary(false)
+}
+
+// summary 生成摘要，linkLayer为true时总是包含以太网头部
+func (p *Packet) summary(linkLayer bool) string {
+	var parts []string
+	if linkLayer || p.ARP == nil && p.IP == nil {
+		parts = append(parts, p.Frame.String())
+	}
+
+	switch {
+	case p.ARP != nil:
+		parts = append(parts, p.ARP.String())
+	case p.IP != nil:
+		parts = append(parts, p.IP.String())
+		if p.IP.IsFragment() {
+			parts = append(parts, fmt.Sprintf("Fragment: Offset %d, MF %t",
+				int(p.IP.FragmentOffset)*8, p.IP.Flags&ip.FlagMF != 0))
+		}
+	}
+
+	switch {
+	case p.TCP != nil:
+		parts = append(parts, p.TCP.String(), fmt.Sprintf("Payload: %d bytes", p.Payload))
+	case p.UDP != nil:
+		parts = append(parts, p.UDP.String())
+	case p.ICMP != nil:
+		parts = append(parts, p.ICMP.String())
+	case p.IGMP != nil:
+		parts = append(parts, p.IGMP.String())
+	}
+
+	if p.Err != nil {
+		parts = append(parts, "Error: "+p.Err.Error())
+	}
+	return strings.Join(parts, " | ")
+}
+
+// ports 返回传输层端口，没有端口时ok为false
+func (p *Packet) ports() (src, dst uint16, ok bool) {
+	switch {
+	case p.TCP != nil:
+		return p.TCP.SourcePort, p.TCP.DestinationPort, true
+	case p.UDP != nil:
+		return p.UDP.SourcePort, p.UDP.DestinationPort, true
+	}
+	return 0, 0, false
+}
+
+// addresses 返回IPv4或ARP报文的源和目标地址，都没有时ok为false
+func (p *Packet) addresses() (src, dst [4]byte, ok bool) {
+	switch {
+	case p.IP != nil:
+		return p.IP.SourceIP, p.IP.DestinationIP, true
+	case p.ARP != nil:
+		return p.ARP.SenderIP, p.ARP.TargetIP, true
+	}
+	return src, dst, false
+}
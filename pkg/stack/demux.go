@@ -0,0 +1,298 @@
+package stack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"net"
+	"sync"
+)
+
+// FullAddress 传输层地址（IP + 端口）
+type FullAddress struct {
+	IP   [4]byte // 地址，零值表示任意地址
+	Port uint16  // 端口，0表示任意端口
+}
+
+// String 返回地址的字符串表示
+func (a FullAddress) String() string {
+	return fmt.Sprintf("%s:%d", net.IP(a.IP[:]).String(), a.Port)
+}
+
+// TransportEndpointID 传输层端点标识（四元组）
+type TransportEndpointID struct {
+	LocalAddress  [4]byte
+	LocalPort     uint16
+	RemoteAddress [4]byte
+	RemotePort    uint16
+}
+
+// String 返回四元组的字符串表示
+func (id TransportEndpointID) String() string {
+	return fmt.Sprintf("%s:%d <-> %s:%d",
+		net.IP(id.LocalAddress[:]).String(), id.LocalPort,
+		net.IP(id.RemoteAddress[:]).String(), id.RemotePort)
+}
+
+// TransportEndpoint 传输层端点，接收分发给它的报文
+type TransportEndpoint interface {
+	HandlePacket(pkt *Packet)
+}
+
+// TransportErrorHandler 可选接口，端点实现后可收到与其报文相关的ICMP差错
+type TransportErrorHandler interface {
+	HandleError(err *TransportError)
+}
+
+// TimeWaitEndpoint 可选接口，已连接的端点实现后，设置ReuseAddr的绑定可以忽略处于TIME_WAIT的端点
+//
+// 在端口管理器的锁下调用，实现不能获取可能在占用或释放端口时持有的锁。
+type TimeWaitEndpoint interface {
+	InTimeWait() bool
+}
+
+// 每个协议端点表的分片数，必须是2的幂
+const transportTableShards = 64
+
+// transportShard 端点表的一个分片
+type transportShard struct {
+	mu        sync.RWMutex
+	endpoints map[TransportEndpointID][]TransportEndpoint
+}
+
+// portEntry 按本地端口索引的端点
+type portEntry struct {
+	id TransportEndpointID
+	ep TransportEndpoint
+}
+
+// portIndex 按本地端口索引的一个分片，用于广播/多播分发
+type portIndex struct {
+	mu        sync.RWMutex
+	endpoints map[uint16][]portEntry
+}
+
+// transportTable 单个传输协议的端点表
+//
+// 按四元组哈希分片，每个分片有独立的锁，收到报文时只锁定被查找的四元组所在分片，
+// 大量连接并发收发时不会争用同一把锁。同一个四元组可对应多个端点（ReusePort），
+// 端口冲突由PortManager在注册前检查。
+// 另按本地端口维护一份索引，广播/多播报文只需查看目标端口所在的分片。
+type transportTable struct {
+	shards [transportTableShards]transportShard
+	ports  [transportTableShards]portIndex
+}
+
+func newTransportTable() *transportTable {
+	t := &transportTable{}
+	for i := range t.shards {
+		t.shards[i].endpoints = make(map[TransportEndpointID][]TransportEndpoint)
+		t.ports[i].endpoints = make(map[uint16][]portEntry)
+	}
+	return t
+}
+
+// shard 返回四元组所在的分片
+func (t *transportTable) shard(h uint64) *transportShard {
+	return &t.shards[h&(transportTableShards-1)]
+}
+
+// portIndex 返回本地端口所在的索引分片
+func (t *transportTable) portIndex(port uint16) *portIndex {
+	return &t.ports[port&(transportTableShards-1)]
+}
+
+// transportDemuxer 按四元组将报文分发到传输层端点
+type transportDemuxer struct {
+	tables map[uint8]*transportTable
+	seed   maphash.Seed
+}
+
+func newTransportDemuxer(protocols ...uint8) *transportDemuxer {
+	d := &transportDemuxer{tables: make(map[uint8]*transportTable), seed: maphash.MakeSeed()}
+	for _, p := range protocols {
+		d.tables[p] = newTransportTable()
+	}
+	return d
+}
+
+// table 返回协议的端点表
+func (d *transportDemuxer) table(protocol uint8) (*transportTable, error) {
+	t, ok := d.tables[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unsupported transport protocol: %d", protocol)
+	}
+	return t, nil
+}
+
+// register 注册端点
+func (d *transportDemuxer) register(protocol uint8, id TransportEndpointID, ep TransportEndpoint) error {
+	t, err := d.table(protocol)
+	if err != nil {
+		return err
+	}
+
+	sh := t.shard(d.hash(id))
+	sh.mu.Lock()
+	sh.endpoints[id] = append(sh.endpoints[id], ep)
+	sh.mu.Unlock()
+
+	ps := t.portIndex(id.LocalPort)
+	ps.mu.Lock()
+	ps.endpoints[id.LocalPort] = append(ps.endpoints[id.LocalPort], portEntry{id, ep})
+	ps.mu.Unlock()
+	return nil
+}
+
+// unregister 注销端点
+func (d *transportDemuxer) unregister(protocol uint8, id TransportEndpointID, ep TransportEndpoint) {
+	t, ok := d.tables[protocol]
+	if !ok {
+		return
+	}
+
+	sh := t.shard(d.hash(id))
+	sh.mu.Lock()
+	eps := sh.endpoints[id]
+	for i, existing := range eps {
+		if existing == ep {
+			eps = append(eps[:i:i], eps[i+1:]...)
+			break
+		}
+	}
+	if len(eps) == 0 {
+		delete(sh.endpoints, id)
+	} else {
+		sh.endpoints[id] = eps
+	}
+	sh.mu.Unlock()
+
+	ps := t.portIndex(id.LocalPort)
+	ps.mu.Lock()
+	entries := ps.endpoints[id.LocalPort]
+	for i, e := range entries {
+		if e.id == id && e.ep == ep {
+			entries = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(ps.endpoints, id.LocalPort)
+	} else {
+		ps.endpoints[id.LocalPort] = entries
+	}
+	ps.mu.Unlock()
+}
+
+// lookup 先按完整四元组查找，再依次放宽远端和本地地址
+//
+// 已建立的连接在第一次查找即命中，只有新连接和未连接的端点才回退到监听的通配表项。
+// 多个端点共享同一四元组（ReusePort）时按报文四元组的哈希选择，同一条流总是交给同一个端点。
+func (d *transportDemuxer) lookup(protocol uint8, id TransportEndpointID) TransportEndpoint {
+	t, ok := d.tables[protocol]
+	if !ok {
+		return nil
+	}
+
+	h := d.hash(id)
+	candidates := [...]TransportEndpointID{
+		id,
+		{LocalAddress: id.LocalAddress, LocalPort: id.LocalPort},
+		{LocalPort: id.LocalPort, RemoteAddress: id.RemoteAddress, RemotePort: id.RemotePort},
+		{LocalPort: id.LocalPort},
+	}
+	for i, c := range candidates {
+		ch := h
+		if i > 0 {
+			ch = d.hash(c)
+		}
+		if ep := t.shard(ch).get(c, h); ep != nil {
+			return ep
+		}
+	}
+	return nil
+}
+
+// get 返回四元组对应的端点，多个端点时按流哈希h选择
+func (sh *transportShard) get(id TransportEndpointID, h uint64) TransportEndpoint {
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	switch eps := sh.endpoints[id]; len(eps) {
+	case 0:
+		return nil
+	case 1:
+		return eps[0]
+	default:
+		return eps[h%uint64(len(eps))]
+	}
+}
+
+// hash 返回四元组的哈希
+func (d *transportDemuxer) hash(id TransportEndpointID) uint64 {
+	var b [12]byte
+	copy(b[0:4], id.LocalAddress[:])
+	copy(b[4:8], id.RemoteAddress[:])
+	binary.BigEndian.PutUint16(b[8:10], id.LocalPort)
+	binary.BigEndian.PutUint16(b[10:12], id.RemotePort)
+	return maphash.Bytes(d.seed, b[:])
+}
+
+// forEach 遍历协议的所有端点，fn返回false时停止
+//
+// 逐个分片加读锁复制后再回调，fn中可以注册或注销端点；遍历期间的变化不保证可见。
+func (d *transportDemuxer) forEach(protocol uint8, fn func(TransportEndpointID, TransportEndpoint) bool) {
+	t, ok := d.tables[protocol]
+	if !ok {
+		return
+	}
+
+	type entry struct {
+		id TransportEndpointID
+		ep TransportEndpoint
+	}
+	var entries []entry
+	for i := range t.shards {
+		sh := &t.shards[i]
+		entries = entries[:0]
+		sh.mu.RLock()
+		for id, eps := range sh.endpoints {
+			for _, ep := range eps {
+				entries = append(entries, entry{id, ep})
+			}
+		}
+		sh.mu.RUnlock()
+
+		for _, e := range entries {
+			if !fn(e.id, e.ep) {
+				return
+			}
+		}
+	}
+}
+
+// lookupAll 返回可以接收广播/多播报文的所有端点：
+// 本地端口匹配，本地地址为通配或等于目标地址，且未连接或已连接到报文的源地址。
+// 只查看目标端口所在的索引分片；多播组成员关系已在网卡上检查，绑定到组地址的端点按本地地址匹配。
+func (d *transportDemuxer) lookupAll(protocol uint8, id TransportEndpointID) []TransportEndpoint {
+	t, ok := d.tables[protocol]
+	if !ok {
+		return nil
+	}
+
+	ps := t.portIndex(id.LocalPort)
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var result []TransportEndpoint
+	for _, e := range ps.endpoints[id.LocalPort] {
+		if e.id.LocalAddress != [4]byte{} && e.id.LocalAddress != id.LocalAddress {
+			continue
+		}
+		if e.id.RemotePort != 0 && (e.id.RemotePort != id.RemotePort || e.id.RemoteAddress != id.RemoteAddress) {
+			continue
+		}
+		result = append(result, e.ep)
+	}
+	return result
+}
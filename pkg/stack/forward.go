@@ -0,0 +1,84 @@
+package stack
+
+import (
+	"errors"
+	"net"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+)
+
+// SetForwarding 启用或关闭IPv4转发（同net.ipv4.ip_forward），启用后协议栈作为路由器转发非本机报文
+func (s *Stack) SetForwarding(enabled bool) {
+	s.forwarding.Store(enabled)
+}
+
+// Forwarding 返回是否启用了IPv4转发
+func (s *Stack) Forwarding() bool {
+	return s.forwarding.Load()
+}
+
+// forward 转发目标不是本机的报文（RFC 1812 5.2）
+//
+// TTL耗尽时回复超时，无路由时回复网络不可达，超过出接口MTU且设置了DF时回复需要分片。
+// 不为已分片报文重组，必要时按出接口MTU再分片。
+func (s *Stack) forward(nic *NIC, h *ip.Header, data []byte) {
+	pkt := &Packet{
+		NICID:    nic.ID,
+		IPHeader: h,
+		Network:  data,
+		Payload:  data[h.HeaderLength():],
+	}
+
+	// 不转发源地址无效或本机发出的报文
+	if h.SourceIP == [4]byte{} || ip.IsMulticast(h.SourceIP) || h.SourceIP == limitedBroadcast || s.IsLocalAddress(h.SourceIP) {
+		return
+	}
+
+	if h.TTL <= 1 {
+		s.logger.Debug("NIC %d: TTL exceeded forwarding to %s", nic.ID, net.IP(h.DestinationIP[:]))
+		s.sendICMPError(pkt, icmp.NewTimeExceeded(icmp.CodeTTLExceeded, data))
+		return
+	}
+
+	r, err := s.FindRoute(h.DestinationIP)
+	if err != nil {
+		s.sendICMPError(pkt, icmp.NewDestUnreach(icmp.CodeNetUnreach, data))
+		return
+	}
+	// 路由器按出接口MTU转发，路径MTU由端主机发现
+	r.PathMTU = 0
+
+	fh := *h
+	fh.TTL--
+	if err := s.writeHeader(r, &fh, pkt.Payload); err != nil {
+		if errors.Is(err, ErrMessageTooLong) {
+			s.sendICMPError(pkt, icmp.NewFragNeeded(uint16(r.MTU()), data))
+			return
+		}
+		s.logger.Debug("NIC %d: failed to forward to %s: %v", nic.ID, net.IP(h.DestinationIP[:]), err)
+	}
+}
+
+// reportUnreachable 地址解析失败时为丢弃的报文回复主机不可达（同Linux arp_error_report）
+//
+// 本机发出的报文直接将差错交给发送端点。
+func (s *Stack) reportUnreachable(packets [][]byte) {
+	for _, data := range packets {
+		h := &ip.Header{}
+		if err := h.Unmarshal(data); err != nil || h.HeaderLength() > len(data) {
+			continue
+		}
+		msg := icmp.NewDestUnreach(icmp.CodeHostUnreach, data)
+
+		if s.IsLocalAddress(h.SourceIP) {
+			if !h.IsFirstFragment() {
+				continue
+			}
+			self := &ip.Header{SourceIP: h.SourceIP, DestinationIP: h.SourceIP}
+			s.handleICMPError(&Packet{IPHeader: self}, msg)
+			continue
+		}
+
+		s.sendICMPError(&Packet{IPHeader: h, Network: data, Payload: data[h.HeaderLength():]}, msg)
+	}
+}
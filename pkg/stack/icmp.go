@@ -0,0 +1,203 @@
+package stack
+
+import (
+	"encoding/binary"
+	"net"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+)
+
+// SetICMPRateLimit 设置ICMP差错报文的发送速率（每秒报文数）和突发量，rate为0表示不限速
+func (s *Stack) SetICMPRateLimit(rate, burst int) {
+	s.icmpLimiter.Store(icmp.NewRateLimiter(rate, burst))
+}
+
+// handleICMP 处理收到的ICMP报文
+func (s *Stack) handleICMP(pkt *Packet) {
+	msg := &icmp.Packet{}
+	if err := msg.Unmarshal(pkt.Payload); err != nil {
+		s.logger.Debug("dropping ICMP packet from %s: %v", net.IP(pkt.IPHeader.SourceIP[:]), err)
+		return
+	}
+
+	s.logger.Debug("ICMP input: %s", msg)
+
+	switch {
+	case msg.IsEchoRequest():
+		// 不响应发往广播/多播地址的Echo Request（防止Smurf放大攻击）
+		if pkt.Broadcast || pkt.Multicast {
+			return
+		}
+		s.sendEchoReply(pkt, msg)
+	case msg.Type == icmp.TypeEchoReply:
+		s.deliverEchoReply(pkt, msg)
+	case msg.IsError():
+		s.handleICMPError(pkt, msg)
+	}
+}
+
+// handleICMPError 将收到的ICMP差错交给发出原始报文的传输层端点
+func (s *Stack) handleICMPError(pkt *Packet, msg *icmp.Packet) {
+	if pkt.Broadcast || pkt.Multicast {
+		return
+	}
+
+	original, transport, err := msg.OriginalDatagram()
+	if err != nil {
+		s.logger.Debug("dropping ICMP error from %s: %v", net.IP(pkt.IPHeader.SourceIP[:]), err)
+		return
+	}
+	// 原始报文必须由本机发出，且至少携带端口
+	if !s.IsLocalAddress(original.SourceIP) || len(transport) < 4 {
+		return
+	}
+
+	id := TransportEndpointID{
+		LocalAddress:  original.SourceIP,
+		LocalPort:     binary.BigEndian.Uint16(transport[0:2]),
+		RemoteAddress: original.DestinationIP,
+		RemotePort:    binary.BigEndian.Uint16(transport[2:4]),
+	}
+	if original.Protocol == ip.ProtocolICMP {
+		// Echo Request按标识符分发，其他ICMP报文不产生差错通知
+		if len(transport) < 8 || transport[0] != icmp.TypeEchoRequest {
+			return
+		}
+		id.LocalPort = binary.BigEndian.Uint16(transport[4:6])
+		id.RemotePort = 0
+	}
+	// 即使没有匹配的端点也更新路径MTU（RFC 1191）
+	var pmtu int
+	if msg.Type == icmp.TypeDestUnreach && msg.Code == icmp.CodeFragNeeded {
+		pmtu = s.updatePathMTU(original.DestinationIP, int(msg.NextHopMTU()), int(original.TotalLength))
+	}
+
+	ep := s.demux.lookup(original.Protocol, id)
+	if ep == nil {
+		return
+	}
+	handler, ok := ep.(TransportErrorHandler)
+	if !ok {
+		return
+	}
+
+	terr := icmpTransportError(msg.Type, msg.Code)
+	if terr == nil {
+		return
+	}
+	terr.MTU = uint16(pmtu)
+	terr.From = pkt.IPHeader.SourceIP
+	terr.ID = id
+	terr.Header = transport
+
+	s.logger.Debug("ICMP error for %s: %v", id, terr)
+	handler.HandleError(terr)
+}
+
+// deliverEchoReply 按标识符将Echo Reply交给发出请求的端点（同Linux ping套接字）
+func (s *Stack) deliverEchoReply(pkt *Packet, msg *icmp.Packet) {
+	h := pkt.IPHeader
+	id := TransportEndpointID{
+		LocalAddress:  h.DestinationIP,
+		LocalPort:     msg.ID,
+		RemoteAddress: h.SourceIP,
+	}
+	if ep := s.demux.lookup(ip.ProtocolICMP, id); ep != nil {
+		ep.HandlePacket(pkt)
+	}
+}
+
+// icmpTransportError 将ICMP类型和代码转换为传输层错误（同Linux icmp_err_convert）
+func icmpTransportError(typ, code uint8) *TransportError {
+	e := &TransportError{Type: typ, Code: code}
+
+	switch typ {
+	case icmp.TypeDestUnreach:
+		switch code {
+		case icmp.CodeNetUnreach:
+			e.Err = ErrNetworkUnreachable
+		case icmp.CodeHostUnreach, icmp.CodeSourceRouteFailed:
+			e.Err = ErrHostUnreachable
+		case icmp.CodeProtoUnreach:
+			e.Err, e.Hard = ErrProtocolUnreachable, true
+		case icmp.CodePortUnreach:
+			e.Err, e.Hard = ErrConnectionRefused, true
+		case icmp.CodeFragNeeded:
+			e.Err = ErrMessageTooLong
+		case icmp.CodeNetUnknown:
+			e.Err, e.Hard = ErrNetworkUnreachable, true
+		case icmp.CodeHostUnknown:
+			e.Err, e.Hard = ErrHostDown, true
+		case icmp.CodeNetProhibited, icmp.CodeHostProhibited, icmp.CodeAdminProhibited:
+			e.Err, e.Hard = ErrAccessDenied, true
+		default:
+			e.Err = ErrHostUnreachable
+		}
+	case icmp.TypeTimeExceeded:
+		e.Err = ErrHostUnreachable
+	default:
+		return nil
+	}
+	return e
+}
+
+// sendEchoReply 回复Echo Request
+func (s *Stack) sendEchoReply(pkt *Packet, request *icmp.Packet) {
+	h := pkt.IPHeader
+
+	data, err := request.CreateReply().Marshal()
+	if err != nil {
+		return
+	}
+
+	r, err := s.FindRoute(h.SourceIP)
+	if err != nil {
+		s.logger.Debug("cannot reply to echo request: %v", err)
+		return
+	}
+	r.LocalIP = h.DestinationIP
+
+	if err := s.WritePacket(r, ip.ProtocolICMP, data, WriteOptions{TOS: h.TOS}); err != nil {
+		s.logger.Debug("failed to send echo reply: %v", err)
+	}
+}
+
+// sendICMPError 为收到的报文回复ICMP差错报文，msg由icmp.NewDestUnreach等构造
+//
+// 按RFC 1122 3.2.2，不为ICMP差错报文、广播/多播报文、非首个分片
+// 以及源地址无效的报文产生差错报文；发送速率受令牌桶限制。
+func (s *Stack) sendICMPError(pkt *Packet, msg *icmp.Packet) {
+	h := pkt.IPHeader
+
+	if pkt.Broadcast || pkt.Multicast || !h.IsFirstFragment() {
+		return
+	}
+	if h.SourceIP == [4]byte{} || h.SourceIP == limitedBroadcast || ip.IsMulticast(h.SourceIP) {
+		return
+	}
+	if h.Protocol == ip.ProtocolICMP && len(pkt.Payload) > 0 && icmp.IsErrorType(pkt.Payload[0]) {
+		return
+	}
+
+	if !s.icmpLimiter.Load().Allow() {
+		s.logger.Debug("ICMP error to %s rate limited", net.IP(h.SourceIP[:]))
+		return
+	}
+
+	data, err := msg.Marshal()
+	if err != nil {
+		return
+	}
+
+	r, err := s.FindRoute(h.SourceIP)
+	if err != nil {
+		return
+	}
+	if s.IsLocalAddress(h.DestinationIP) {
+		r.LocalIP = h.DestinationIP
+	}
+
+	if err := s.WritePacket(r, ip.ProtocolICMP, data, WriteOptions{}); err != nil {
+		s.logger.Debug("failed to send ICMP error: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package stack
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/igmp"
+	"ustack/pkg/ip"
+)
+
+const (
+	// 主动报告的重传间隔（RFC 3376 8.11）
+	UnsolicitedReportInterval = 1 * time.Second
+
+	// 旧版本查询者存在超时：健壮性变量 * 查询间隔 + 查询响应间隔（RFC 3376 8.12）
+	OlderVersionQuerierTimeout = 2*125*time.Second + 10*time.Second
+)
+
+// membership 网卡上的多播组成员关系
+type membership struct {
+	group       [4]byte
+	refs        int
+	reportTimer *time.Timer
+	reportAt    time.Time
+}
+
+// igmpMessage 待发送的IGMP消息
+type igmpMessage struct {
+	msg *igmp.Message
+	dst [4]byte
+}
+
+// JoinGroup 在网卡上加入多播组，引用计数为1时发送成员报告
+func (s *Stack) JoinGroup(nicID int, group [4]byte) error {
+	if !ip.IsMulticast(group) {
+		return fmt.Errorf("%s is not a multicast address", net.IP(group[:]))
+	}
+
+	s.mu.Lock()
+	nic, ok := s.nics[nicID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("NIC %d not found", nicID)
+	}
+
+	if m, ok := nic.groups[group]; ok {
+		m.refs++
+		s.mu.Unlock()
+		return nil
+	}
+
+	m := &membership{group: group, refs: 1}
+	nic.groups[group] = m
+	nic.multicastMACs[eth.IPv4MulticastMAC(group)]++
+	s.publishLocked()
+
+	var report *igmpMessage
+	if group != igmp.AllHostsGroup {
+		report = s.joinReportLocked(nic, group)
+		// 主动报告再重传一次，防止首个报告丢失
+		s.scheduleReportLocked(nic, m, UnsolicitedReportInterval, false)
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("NIC %d joined multicast group %s", nicID, net.IP(group[:]))
+	if report != nil {
+		s.sendIGMP(nic, report)
+	}
+	return nil
+}
+
+// LeaveGroup 在网卡上离开多播组，引用计数归零时发送离开报文
+func (s *Stack) LeaveGroup(nicID int, group [4]byte) error {
+	s.mu.Lock()
+	nic, ok := s.nics[nicID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("NIC %d not found", nicID)
+	}
+
+	m, ok := nic.groups[group]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("NIC %d is not a member of %s", nicID, net.IP(group[:]))
+	}
+
+	m.refs--
+	if m.refs > 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	delete(nic.groups, group)
+	mac := eth.IPv4MulticastMAC(group)
+	if nic.multicastMACs[mac]--; nic.multicastMACs[mac] <= 0 {
+		delete(nic.multicastMACs, mac)
+	}
+	s.publishLocked()
+	if m.reportTimer != nil {
+		m.reportTimer.Stop()
+	}
+
+	var leave *igmpMessage
+	if group != igmp.AllHostsGroup {
+		leave = s.leaveMessageLocked(nic, group)
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("NIC %d left multicast group %s", nicID, net.IP(group[:]))
+	if leave != nil {
+		s.sendIGMP(nic, leave)
+	}
+	return nil
+}
+
+// IsMember 检查网卡是否为多播组成员
+func (s *Stack) IsMember(nicID int, group [4]byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[nicID]
+	if !ok {
+		return false
+	}
+	_, member := nic.groups[group]
+	return member || group == igmp.AllHostsGroup
+}
+
+// igmpVersionLocked 返回当前的主机兼容模式（RFC 3376 7.2.1）
+func (nic *NIC) igmpVersionLocked() int {
+	now := time.Now()
+	switch {
+	case now.Before(nic.igmpV1Until):
+		return 1
+	case now.Before(nic.igmpV2Until):
+		return 2
+	default:
+		return 3
+	}
+}
+
+// joinReportLocked 构造加入组时发送的主动报告
+func (s *Stack) joinReportLocked(nic *NIC, group [4]byte) *igmpMessage {
+	switch nic.igmpVersionLocked() {
+	case 1:
+		return &igmpMessage{msg: &igmp.Message{Type: igmp.TypeV1MembershipReport, GroupAddress: group}, dst: group}
+	case 2:
+		return &igmpMessage{msg: igmp.NewV2Report(group), dst: group}
+	default:
+		record := igmp.GroupRecord{Type: igmp.RecordChangeToExclude, Multicast: group}
+		return &igmpMessage{msg: igmp.NewV3Report(record), dst: igmp.V3ReportsGroup}
+	}
+}
+
+// queryReportLocked 构造响应查询的当前状态报告
+func (s *Stack) queryReportLocked(nic *NIC, group [4]byte) *igmpMessage {
+	if nic.igmpVersionLocked() == 3 {
+		record := igmp.GroupRecord{Type: igmp.RecordModeIsExclude, Multicast: group}
+		return &igmpMessage{msg: igmp.NewV3Report(record), dst: igmp.V3ReportsGroup}
+	}
+	return s.joinReportLocked(nic, group)
+}
+
+// leaveMessageLocked 构造离开组时发送的消息，IGMPv1没有离开报文
+func (s *Stack) leaveMessageLocked(nic *NIC, group [4]byte) *igmpMessage {
+	switch nic.igmpVersionLocked() {
+	case 1:
+		return nil
+	case 2:
+		return &igmpMessage{msg: igmp.NewV2Leave(group), dst: igmp.AllRoutersGroup}
+	default:
+		record := igmp.GroupRecord{Type: igmp.RecordChangeToInclude, Multicast: group}
+		return &igmpMessage{msg: igmp.NewV3Report(record), dst: igmp.V3ReportsGroup}
+	}
+}
+
+// scheduleReportLocked 在delay后发送报告，已有更早的报告计划时保留原计划
+func (s *Stack) scheduleReportLocked(nic *NIC, m *membership, delay time.Duration, query bool) {
+	at := time.Now().Add(delay)
+	if m.reportTimer != nil && !m.reportAt.IsZero() && m.reportAt.Before(at) {
+		return
+	}
+	if m.reportTimer != nil {
+		m.reportTimer.Stop()
+	}
+
+	m.reportAt = at
+	m.reportTimer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		if current, ok := nic.groups[m.group]; !ok || current != m {
+			s.mu.Unlock()
+			return
+		}
+		m.reportAt = time.Time{}
+		var report *igmpMessage
+		if query {
+			report = s.queryReportLocked(nic, m.group)
+		} else {
+			report = s.joinReportLocked(nic, m.group)
+		}
+		s.mu.Unlock()
+
+		s.sendIGMP(nic, report)
+	})
+}
+
+// handleIGMP 处理收到的IGMP消息
+func (s *Stack) handleIGMP(nic *NIC, pkt *Packet) {
+	msg := &igmp.Message{}
+	if err := msg.Unmarshal(pkt.Payload); err != nil {
+		s.logger.Debug("NIC %d: dropping IGMP message: %v", nic.ID, err)
+		return
+	}
+
+	s.logger.Debug("NIC %d: received %s", nic.ID, msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Type {
+	case igmp.TypeMembershipQuery:
+		switch msg.Version {
+		case 1:
+			nic.igmpV1Until = time.Now().Add(OlderVersionQuerierTimeout)
+		case 2:
+			nic.igmpV2Until = time.Now().Add(OlderVersionQuerierTimeout)
+		}
+
+		maxResp := msg.MaxResponseTime()
+		if maxResp <= 0 {
+			maxResp = 100 * time.Millisecond
+		}
+		for group, m := range nic.groups {
+			if group == igmp.AllHostsGroup {
+				continue
+			}
+			if msg.GroupAddress != [4]byte{} && msg.GroupAddress != group {
+				continue
+			}
+			s.scheduleReportLocked(nic, m, time.Duration(rand.Int63n(int64(maxResp))), true)
+		}
+
+	case igmp.TypeV1MembershipReport, igmp.TypeV2MembershipReport:
+		// v1/v2兼容模式下，其他成员已报告时抑制自己的报告
+		if nic.igmpVersionLocked() == 3 {
+			return
+		}
+		if m, ok := nic.groups[msg.GroupAddress]; ok && m.reportTimer != nil {
+			m.reportTimer.Stop()
+			m.reportAt = time.Time{}
+		}
+	}
+}
+
+// sendIGMP 发送IGMP消息：TTL为1并携带路由器告警选项
+func (s *Stack) sendIGMP(nic *NIC, m *igmpMessage) {
+	data, err := m.msg.Marshal()
+	if err != nil {
+		s.logger.Error("failed to marshal %s: %v", m.msg, err)
+		return
+	}
+
+	s.mu.RLock()
+	var src [4]byte
+	if len(nic.addresses) > 0 {
+		src = nic.addresses[0].IP
+	}
+	s.mu.RUnlock()
+
+	h := ip.NewHeader(src, m.dst, ip.ProtocolIGMP, 0)
+	h.TTL = 1
+	h.TOS = 0xc0 // Internetwork Control
+	h.Options = ip.RouterAlertOption
+	h.Identification = s.ids.Next(src, m.dst, ip.ProtocolIGMP)
+
+	r := &RouteInfo{NIC: nic, LocalIP: src, RemoteIP: m.dst, NextHop: m.dst}
+	if err := s.writeHeader(r, h, data); err != nil {
+		s.logger.Debug("failed to send %s: %v", m.msg, err)
+	}
+}
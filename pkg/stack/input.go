@@ -0,0 +1,192 @@
+package stack
+
+import (
+	"encoding/binary"
+	"net"
+	"ustack/internal/utils"
+	"ustack/pkg/eth"
+	"ustack/pkg/icmp"
+	"ustack/pkg/igmp"
+	"ustack/pkg/ip"
+)
+
+// Packet 收到的IP报文
+type Packet struct {
+	NICID     int        // 接收网卡
+	IPHeader  *ip.Header // IP头部
+	Network   []byte     // 完整IP报文（头部 + 数据），用于构造ICMP差错报文
+	Payload   []byte     // 传输层数据（含传输层头部）
+	Broadcast bool       // 目标地址为广播地址
+	Multicast bool       // 目标地址为多播地址
+}
+
+// handleFrame 处理链路层收到的帧
+func (s *Stack) handleFrame(nic *NIC, data []byte) {
+	frame := &eth.Frame{}
+	if err := frame.Unmarshal(data); err != nil {
+		s.logger.Debug("NIC %d: dropping frame: %v", nic.ID, err)
+		return
+	}
+
+	if !s.acceptFrame(nic, frame) {
+		return
+	}
+
+	switch frame.EtherType {
+	case eth.EtherTypeIPv4:
+		s.handleIPv4(nic, frame.Payload)
+	case eth.EtherTypeARP:
+		s.handleARP(nic, frame.Payload)
+	case eth.EtherTypeIPv6:
+		s.handleIPv6(nic, frame.Payload)
+	default:
+		s.logger.Debug("NIC %d: unsupported ether type 0x%04x", nic.ID, frame.EtherType)
+	}
+}
+
+// acceptFrame 按目标MAC过滤帧：本机单播、广播、已加入组的多播，以及启用IPv6时的IPv6多播（由handleIPv6按地址过滤）
+func (s *Stack) acceptFrame(nic *NIC, frame *eth.Frame) bool {
+	if frame.DestinationMAC == nic.MACAddress() || frame.IsBroadcast() {
+		return true
+	}
+	if !frame.IsMulticast() {
+		return false
+	}
+
+	n := s.nicSnapshot(nic)
+	if n == nil {
+		return false
+	}
+	if n.ndp != nil && frame.DestinationMAC[0] == 0x33 && frame.DestinationMAC[1] == 0x33 {
+		return true
+	}
+	_, joined := n.multicastMACs[frame.DestinationMAC]
+	return joined || frame.DestinationMAC == eth.IPv4MulticastMAC(igmp.AllHostsGroup)
+}
+
+// handleIPv4 校验IPv4报文并交给上层协议
+func (s *Stack) handleIPv4(nic *NIC, data []byte) {
+	h := &ip.Header{}
+	if err := h.Unmarshal(data); err != nil {
+		s.logger.Debug("NIC %d: dropping IP packet: %v", nic.ID, err)
+		return
+	}
+
+	headerLength := int(h.IHL) * 4
+	if h.Version != 4 || headerLength < ip.IPHeaderLength || headerLength > len(data) {
+		s.logger.Debug("NIC %d: dropping malformed IP packet", nic.ID)
+		return
+	}
+	if utils.CalculateChecksum(data[:headerLength]) != 0 {
+		s.logger.Debug("NIC %d: dropping IP packet with bad checksum", nic.ID)
+		return
+	}
+	if int(h.TotalLength) < headerLength || int(h.TotalLength) > len(data) {
+		s.logger.Debug("NIC %d: dropping IP packet with bad length %d", nic.ID, h.TotalLength)
+		return
+	}
+	data = data[:h.TotalLength]
+
+	local, broadcast := s.classifyDestination(nic, h.DestinationIP)
+	if !local {
+		if !broadcast && s.Forwarding() {
+			s.forward(nic, h, data)
+		}
+		return
+	}
+	multicast := ip.IsMulticast(h.DestinationIP)
+
+	if h.IsFragment() {
+		s.logger.Debug("NIC %d: dropping IP fragment from %s (reassembly not supported)",
+			nic.ID, net.IP(h.SourceIP[:]))
+		return
+	}
+
+	s.logger.Debug("IP input: %s", h)
+
+	pkt := &Packet{
+		NICID:     nic.ID,
+		IPHeader:  h,
+		Network:   data,
+		Payload:   data[headerLength:],
+		Broadcast: broadcast && !multicast,
+		Multicast: multicast,
+	}
+
+	switch h.Protocol {
+	case ip.ProtocolICMP:
+		s.handleICMP(pkt)
+	case ip.ProtocolIGMP:
+		s.handleIGMP(nic, pkt)
+	case ip.ProtocolUDP, ip.ProtocolTCP:
+		s.deliverTransport(pkt)
+	default:
+		s.sendICMPError(pkt, icmp.NewDestUnreach(icmp.CodeProtoUnreach, pkt.Network))
+	}
+}
+
+// classifyDestination 判断目标地址是否为本机地址，broadcast表示需要交给所有匹配端点
+func (s *Stack) classifyDestination(nic *NIC, dst [4]byte) (local, broadcast bool) {
+	if dst == limitedBroadcast {
+		return true, true
+	}
+
+	snap := s.snapshot.Load()
+	n := snap.nics[nic.ID]
+	if n == nil {
+		return false, false
+	}
+
+	if ip.IsMulticast(dst) {
+		_, member := n.groups[dst]
+		return member || dst == igmp.AllHostsGroup, true
+	}
+
+	for _, a := range n.addresses {
+		if a.PrefixLength < 31 && a.Broadcast() == dst {
+			return true, true
+		}
+	}
+	_, local = snap.local[dst]
+	return local, false
+}
+
+// deliverTransport 按四元组将报文分发给传输层端点
+func (s *Stack) deliverTransport(pkt *Packet) {
+	h := pkt.IPHeader
+	if len(pkt.Payload) < 4 {
+		s.logger.Debug("dropping protocol %d packet: too short", h.Protocol)
+		return
+	}
+
+	id := TransportEndpointID{
+		LocalAddress:  h.DestinationIP,
+		LocalPort:     binary.BigEndian.Uint16(pkt.Payload[2:4]),
+		RemoteAddress: h.SourceIP,
+		RemotePort:    binary.BigEndian.Uint16(pkt.Payload[0:2]),
+	}
+
+	// 广播和多播报文交给所有匹配的端点，且不回复差错报文
+	if pkt.Broadcast || pkt.Multicast {
+		if h.Protocol != ip.ProtocolUDP {
+			return
+		}
+		for _, ep := range s.demux.lookupAll(h.Protocol, id) {
+			ep.HandlePacket(pkt)
+		}
+		return
+	}
+
+	if ep := s.demux.lookup(h.Protocol, id); ep != nil {
+		ep.HandlePacket(pkt)
+		return
+	}
+
+	handler := unknownDestinationHandler(h.Protocol)
+	switch {
+	case handler != nil:
+		handler(s, pkt)
+	case h.Protocol == ip.ProtocolUDP:
+		s.sendICMPError(pkt, icmp.NewDestUnreach(icmp.CodePortUnreach, pkt.Network))
+	}
+}
@@ -0,0 +1,160 @@
+package stack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"ustack/pkg/eth"
+	"ustack/pkg/ndp"
+)
+
+// IPv6固定头部长度
+const ipv6HeaderLength = 40
+
+// ipv6Header IPv6固定头部，目前只承载NDP报文，不处理扩展头部
+type ipv6Header struct {
+	PayloadLength uint16
+	NextHeader    uint8
+	HopLimit      uint8
+	SourceIP      [16]byte
+	DestinationIP [16]byte
+}
+
+func (h *ipv6Header) marshal() []byte {
+	data := make([]byte, ipv6HeaderLength)
+	data[0] = 6 << 4
+	binary.BigEndian.PutUint16(data[4:6], h.PayloadLength)
+	data[6] = h.NextHeader
+	data[7] = h.HopLimit
+	copy(data[8:24], h.SourceIP[:])
+	copy(data[24:40], h.DestinationIP[:])
+	return data
+}
+
+func (h *ipv6Header) unmarshal(data []byte) error {
+	if len(data) < ipv6HeaderLength {
+		return errors.New("IPv6 packet too short")
+	}
+	if data[0]>>4 != 6 {
+		return fmt.Errorf("bad IPv6 version %d", data[0]>>4)
+	}
+	h.PayloadLength = binary.BigEndian.Uint16(data[4:6])
+	h.NextHeader = data[6]
+	h.HopLimit = data[7]
+	copy(h.SourceIP[:], data[8:24])
+	copy(h.DestinationIP[:], data[24:40])
+	if int(h.PayloadLength) > len(data)-ipv6HeaderLength {
+		return fmt.Errorf("bad IPv6 payload length %d", h.PayloadLength)
+	}
+	return nil
+}
+
+// EnableIPv6 在网卡上启动NDP：配置链路本地地址并进行DAD，发送路由器请求，
+// 并根据收到的路由器通告自动配置地址。config.MAC由网卡MAC地址填充。
+func (s *Stack) EnableIPv6(nicID int, config ndp.Config) error {
+	s.mu.Lock()
+	nic, ok := s.nics[nicID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("NIC %d not found", nicID)
+	}
+	if nic.ndp != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("IPv6 already enabled on NIC %d", nicID)
+	}
+	config.MAC = nic.MACAddress()
+	nic.ndp = ndp.NewEndpoint(config, func(srcIP, dstIP [16]byte, dstMAC [6]byte, data []byte) error {
+		return s.writeIPv6(nic, srcIP, dstIP, dstMAC, data)
+	})
+	ep := nic.ndp
+	s.publishLocked()
+	s.mu.Unlock()
+
+	s.logger.Info("NIC %d: IPv6 enabled", nicID)
+	ep.Start()
+	return nil
+}
+
+// NDP 返回网卡的NDP端点，未启用IPv6时返回nil
+func (s *Stack) NDP(nicID int) *ndp.Endpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if nic, ok := s.nics[nicID]; ok {
+		return nic.ndp
+	}
+	return nil
+}
+
+// IPv6Addresses 返回网卡上已通过DAD的IPv6地址，包括链路本地地址和SLAAC地址
+func (s *Stack) IPv6Addresses(nicID int) []ndp.Address {
+	ep := s.NDP(nicID)
+	if ep == nil {
+		return nil
+	}
+
+	var addrs []ndp.Address
+	for _, a := range ep.Addresses() {
+		if a.State == ndp.AddressPreferred {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// handleIPv6 校验IPv6报文，发往本机的ICMPv6交给网卡的NDP端点
+func (s *Stack) handleIPv6(nic *NIC, data []byte) {
+	n := s.nicSnapshot(nic)
+	if n == nil || n.ndp == nil {
+		return
+	}
+	ep := n.ndp
+
+	h := &ipv6Header{}
+	if err := h.unmarshal(data); err != nil {
+		s.logger.Debug("NIC %d: dropping IPv6 packet: %v", nic.ID, err)
+		return
+	}
+	if !ipv6ForUs(ep, h.DestinationIP) {
+		return
+	}
+	if h.NextHeader != ndp.ProtocolICMPv6 {
+		s.logger.Debug("NIC %d: unsupported IPv6 next header %d", nic.ID, h.NextHeader)
+		return
+	}
+
+	payload := data[ipv6HeaderLength : ipv6HeaderLength+int(h.PayloadLength)]
+	if err := ep.HandleMessage(h.SourceIP, h.DestinationIP, h.HopLimit, payload); err != nil {
+		s.logger.Debug("NIC %d: dropping ICMPv6 from %s: %v", nic.ID, net.IP(h.SourceIP[:]), err)
+	}
+}
+
+// ipv6ForUs 判断目标地址是本机地址（含DAD中的地址），或是本机监听的全节点、请求节点多播地址
+func ipv6ForUs(ep *ndp.Endpoint, dst [16]byte) bool {
+	if dst == ndp.AllNodesAddress {
+		return true
+	}
+	for _, a := range ep.Addresses() {
+		if a.Address == dst || ndp.SolicitedNodeAddress(a.Address) == dst {
+			return true
+		}
+	}
+	return false
+}
+
+// writeIPv6 封装IPv6头部和以太网帧后从网卡发出，作为NDP端点的输出路径
+func (s *Stack) writeIPv6(nic *NIC, srcIP, dstIP [16]byte, dstMAC [6]byte, data []byte) error {
+	h := &ipv6Header{
+		PayloadLength: uint16(len(data)),
+		NextHeader:    ndp.ProtocolICMPv6,
+		HopLimit:      ndp.HopLimit,
+		SourceIP:      srcIP,
+		DestinationIP: dstIP,
+	}
+	frame, err := eth.NewFrame(nic.MACAddress(), dstMAC, eth.EtherTypeIPv6, append(h.marshal(), data...)).Marshal()
+	if err != nil {
+		return err
+	}
+	return nic.link.WriteFrame(frame)
+}
@@ -0,0 +1,256 @@
+package stack
+
+import (
+	"net"
+	"time"
+	"ustack/pkg/arp"
+	"ustack/pkg/eth"
+	"ustack/pkg/ip"
+)
+
+const (
+	// ARPRetransmitTimeout ARP请求重传间隔（同Linux retrans_time）
+	ARPRetransmitTimeout = time.Second
+
+	// MaxARPProbes 地址解析失败前发送的ARP请求次数（同Linux mcast_solicit）
+	MaxARPProbes = 3
+
+	// NeighborTimeout 动态邻居表项的有效期，过期后重新解析
+	NeighborTimeout = 60 * time.Second
+
+	// 等待地址解析的报文队列长度（同Linux unres_qlen）
+	neighborQueueLength = 16
+)
+
+// Neighbor 邻居表项
+type Neighbor struct {
+	Address  [4]byte // IPv4地址
+	MAC      [6]byte // 链路层地址
+	Static   bool    // 是否为静态表项
+	Resolved bool    // 是否已完成解析
+}
+
+// neighbor 邻居表内部状态
+type neighbor struct {
+	Neighbor
+	updated time.Time
+
+	// 等待解析完成的IP报文
+	nic     *NIC
+	pending [][]byte
+	probes  int
+	timer   *time.Timer
+}
+
+// AddNeighbor 添加静态邻居表项
+func (s *Stack) AddNeighbor(addr [4]byte, mac [6]byte) {
+	s.neighborMu.Lock()
+	n := s.neighbors[addr]
+	if n == nil {
+		n = &neighbor{}
+		s.neighbors[addr] = n
+	}
+	n.Address = addr
+	n.Static = true
+	nic, pending := s.completeNeighborLocked(n, mac)
+	s.neighborMu.Unlock()
+
+	s.flushPending(nic, mac, pending)
+}
+
+// Neighbors 返回邻居表快照
+func (s *Stack) Neighbors() []Neighbor {
+	s.neighborMu.RLock()
+	defer s.neighborMu.RUnlock()
+
+	neighbors := make([]Neighbor, 0, len(s.neighbors))
+	for _, n := range s.neighbors {
+		neighbors = append(neighbors, n.Neighbor)
+	}
+	return neighbors
+}
+
+// resolve 查找下一跳的MAC地址，未知时返回false
+func (s *Stack) resolve(r *RouteInfo) ([6]byte, bool) {
+	if r.NextHop == limitedBroadcast {
+		return eth.BroadcastMAC, true
+	}
+	if ip.IsMulticast(r.NextHop) {
+		return eth.IPv4MulticastMAC(r.NextHop), true
+	}
+
+	for _, a := range s.nicAddresses(r.NIC) {
+		if a.PrefixLength < 31 && a.Broadcast() == r.NextHop {
+			return eth.BroadcastMAC, true
+		}
+		// 发往本机地址（如环回链路）
+		if a.IP == r.NextHop {
+			return r.NIC.MACAddress(), true
+		}
+	}
+
+	s.neighborMu.RLock()
+	defer s.neighborMu.RUnlock()
+
+	n, ok := s.neighbors[r.NextHop]
+	if !ok || !n.Resolved {
+		return [6]byte{}, false
+	}
+	if !n.Static && time.Since(n.updated) > NeighborTimeout {
+		return [6]byte{}, false
+	}
+	return n.MAC, true
+}
+
+// queuePackets 缓存等待地址解析的报文，并在需要时发送ARP请求
+//
+// 查找之后地址刚好完成解析时不缓存，返回MAC地址由调用方直接发送。
+func (s *Stack) queuePackets(r *RouteInfo, packets [][]byte) ([6]byte, bool) {
+	s.neighborMu.Lock()
+	defer s.neighborMu.Unlock()
+
+	n := s.neighbors[r.NextHop]
+	if n != nil && n.Resolved && (n.Static || time.Since(n.updated) <= NeighborTimeout) {
+		return n.MAC, true
+	}
+	if n == nil || n.Resolved {
+		// 新表项或过期的动态表项，重新解析
+		if n != nil && n.timer != nil {
+			n.timer.Stop()
+		}
+		n = &neighbor{Neighbor: Neighbor{Address: r.NextHop}}
+		s.neighbors[r.NextHop] = n
+	}
+	n.nic = r.NIC
+
+	for _, p := range packets {
+		if len(n.pending) >= neighborQueueLength {
+			// 丢弃最早的报文
+			n.pending = n.pending[1:]
+		}
+		n.pending = append(n.pending, p)
+	}
+
+	if n.timer == nil {
+		s.solicitLocked(n)
+	}
+	return [6]byte{}, false
+}
+
+// solicitLocked 发送ARP请求，超过MaxARPProbes次仍未解析时删除表项，返回丢弃的缓存报文
+func (s *Stack) solicitLocked(n *neighbor) [][]byte {
+	if n.probes >= MaxARPProbes {
+		s.logger.Debug("ARP: address resolution failed for %s, dropping %d packet(s)",
+			net.IP(n.Address[:]), len(n.pending))
+		delete(s.neighbors, n.Address)
+		dropped := n.pending
+		n.pending = nil
+		n.timer = nil
+		return dropped
+	}
+	n.probes++
+
+	// 源地址优先选择与目标同子网的地址
+	var src [4]byte
+	for i, a := range s.nicAddresses(n.nic) {
+		if i == 0 || a.Contains(n.Address) {
+			src = a.IP
+		}
+		if a.Contains(n.Address) {
+			break
+		}
+	}
+
+	s.sendARP(n.nic, eth.BroadcastMAC, arp.NewRequest(n.nic.MACAddress(), src, n.Address))
+
+	n.timer = time.AfterFunc(ARPRetransmitTimeout, func() {
+		s.neighborMu.Lock()
+		if s.neighbors[n.Address] != n || n.Resolved {
+			s.neighborMu.Unlock()
+			return
+		}
+		dropped := s.solicitLocked(n)
+		s.neighborMu.Unlock()
+
+		s.reportUnreachable(dropped)
+	})
+	return nil
+}
+
+// handleARP 处理收到的ARP报文（RFC 826）
+func (s *Stack) handleARP(nic *NIC, data []byte) {
+	p := &arp.Packet{}
+	if err := p.Unmarshal(data); err != nil {
+		s.logger.Debug("NIC %d: dropping ARP packet: %v", nic.ID, err)
+		return
+	}
+	if p.SenderMAC == nic.MACAddress() || p.SenderIP == [4]byte{} {
+		return
+	}
+
+	s.logger.Debug("NIC %d: %s", nic.ID, p)
+
+	targetLocal := false
+	for _, a := range s.nicAddresses(nic) {
+		if a.IP == p.TargetIP {
+			targetLocal = true
+			break
+		}
+	}
+
+	// 已有表项时更新（合并），发给本机时新建表项
+	var flushNIC *NIC
+	var pending [][]byte
+	s.neighborMu.Lock()
+	n := s.neighbors[p.SenderIP]
+	if n == nil && targetLocal {
+		n = &neighbor{Neighbor: Neighbor{Address: p.SenderIP}}
+		s.neighbors[p.SenderIP] = n
+	}
+	if n != nil && !n.Static {
+		flushNIC, pending = s.completeNeighborLocked(n, p.SenderMAC)
+	}
+	s.neighborMu.Unlock()
+
+	s.flushPending(flushNIC, p.SenderMAC, pending)
+
+	if targetLocal && p.IsRequest() {
+		s.sendARP(nic, p.SenderMAC, p.CreateReply(nic.MACAddress()))
+	}
+}
+
+// completeNeighborLocked 记录解析结果，返回需要发送的缓存报文
+func (s *Stack) completeNeighborLocked(n *neighbor, mac [6]byte) (*NIC, [][]byte) {
+	n.MAC = mac
+	n.Resolved = true
+	n.updated = time.Now()
+	n.probes = 0
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+
+	pending := n.pending
+	n.pending = nil
+	return n.nic, pending
+}
+
+// flushPending 发送地址解析完成前缓存的报文
+func (s *Stack) flushPending(nic *NIC, mac [6]byte, pending [][]byte) {
+	for _, packet := range pending {
+		if err := s.writeFrame(nic, mac, eth.EtherTypeIPv4, packet); err != nil {
+			s.logger.Debug("NIC %d: failed to send queued packet: %v", nic.ID, err)
+		}
+	}
+}
+
+// sendARP 发送ARP报文
+func (s *Stack) sendARP(nic *NIC, dstMAC [6]byte, p *arp.Packet) {
+	data, err := p.Marshal()
+	if err != nil {
+		return
+	}
+	if err := s.writeFrame(nic, dstMAC, eth.EtherTypeARP, data); err != nil {
+		s.logger.Debug("NIC %d: failed to send ARP packet: %v", nic.ID, err)
+	}
+}
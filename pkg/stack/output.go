@@ -0,0 +1,84 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"ustack/pkg/eth"
+	"ustack/pkg/ip"
+)
+
+const (
+	// 默认TTL
+	DefaultTTL = 64
+)
+
+var (
+	// limitedBroadcast 受限广播地址
+	limitedBroadcast = [4]byte{255, 255, 255, 255}
+)
+
+// WriteOptions IP层发送选项
+type WriteOptions struct {
+	TTL          uint8 // 生存时间，0表示使用默认值
+	TOS          uint8 // 服务类型
+	DontFragment bool  // 设置DF标志
+}
+
+// WritePacket 通过路由发送一个IP报文，必要时分片
+func (s *Stack) WritePacket(r *RouteInfo, protocol uint8, payload []byte, opts WriteOptions) error {
+	totalLength := ip.IPHeaderLength + len(payload)
+	if totalLength > 0xFFFF {
+		return fmt.Errorf("IP packet too large: %d bytes", totalLength)
+	}
+
+	h := ip.NewHeader(r.LocalIP, r.RemoteIP, protocol, uint16(totalLength))
+	h.TOS = opts.TOS
+	if opts.TTL != 0 {
+		h.TTL = opts.TTL
+	}
+	if opts.DontFragment {
+		h.Flags |= ip.FlagDF
+	}
+
+	// 所有分片共享同一个按目的地生成的标识
+	h.Identification = s.ids.Next(r.LocalIP, r.RemoteIP, protocol)
+
+	return s.writeHeader(r, h, payload)
+}
+
+// writeHeader 按出接口MTU和路径MTU分片并发送
+func (s *Stack) writeHeader(r *RouteInfo, h *ip.Header, payload []byte) error {
+	packets, err := ip.Fragment(h, payload, r.MTU())
+	if errors.Is(err, ip.ErrPacketTooBig) {
+		return fmt.Errorf("%w: %v", ErrMessageTooLong, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	dstMAC, ok := s.resolve(r)
+	if !ok {
+		if dstMAC, ok = s.queuePackets(r, packets); !ok {
+			s.logger.Debug("IP output: %s, %d fragment(s) awaiting address resolution", h, len(packets))
+			return nil
+		}
+	}
+	for _, packet := range packets {
+		if err := s.writeFrame(r.NIC, dstMAC, eth.EtherTypeIPv4, packet); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Debug("IP output: %s, %d fragment(s)", h, len(packets))
+	return nil
+}
+
+// writeFrame 封装以太网帧并写入链路
+func (s *Stack) writeFrame(nic *NIC, dstMAC [6]byte, etherType uint16, payload []byte) error {
+	frame := eth.NewFrame(nic.MACAddress(), dstMAC, etherType, payload)
+	data, err := frame.Marshal()
+	if err != nil {
+		return err
+	}
+	return nic.link.WriteFrame(data)
+}
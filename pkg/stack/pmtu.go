@@ -0,0 +1,95 @@
+package stack
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MinPathMTU 接受的最小路径MTU（同Linux min_pmtu），防止伪造的ICMP将MTU压得过低
+	MinPathMTU = 552
+
+	// PathMTUExpiry 路径MTU的有效期，过期后恢复为出接口MTU以探测路径MTU是否增大（RFC 1191 6.3）
+	PathMTUExpiry = 10 * time.Minute
+)
+
+// mtuPlateaus 常见MTU取值（RFC 1191 7），路由器未携带下一跳MTU时按原报文长度选择下一档
+var mtuPlateaus = []int{32000, 17914, 8166, 4352, 2002, 1492, 1006, 508, 296, 68}
+
+// pmtuEntry 路径MTU缓存项
+type pmtuEntry struct {
+	mtu     int
+	expires time.Time
+}
+
+// pmtuCache 按目标地址缓存路径MTU
+//
+// 每次路由查找都会读取，使用sync.Map使读取不争用锁；表项很少写入。
+type pmtuCache struct {
+	entries sync.Map // [4]byte -> pmtuEntry
+}
+
+func newPMTUCache() *pmtuCache {
+	return &pmtuCache{}
+}
+
+// get 返回未过期的路径MTU及其过期时间，不存在时返回0
+func (c *pmtuCache) get(dst [4]byte) (int, time.Time) {
+	v, ok := c.entries.Load(dst)
+	if !ok {
+		return 0, time.Time{}
+	}
+	e := v.(pmtuEntry)
+	if time.Now().After(e.expires) {
+		c.entries.CompareAndDelete(dst, e)
+		return 0, time.Time{}
+	}
+	return e.mtu, e.expires
+}
+
+// set 记录路径MTU
+func (c *pmtuCache) set(dst [4]byte, mtu int) {
+	c.entries.Store(dst, pmtuEntry{mtu: mtu, expires: time.Now().Add(PathMTUExpiry)})
+}
+
+// PathMTU 返回到达目标地址的路径MTU，未发现更小的路径MTU时为出接口MTU
+func (s *Stack) PathMTU(dst [4]byte) (int, error) {
+	r, err := s.FindRoute(dst)
+	if err != nil {
+		return 0, err
+	}
+	return r.MTU(), nil
+}
+
+// updatePathMTU 根据分片需要报文更新路径MTU，返回更新后的值
+//
+// mtu为0表示路由器不支持RFC 1191，按发出的报文长度sent估计下一档MTU。
+// 路径MTU只会减小，增大依赖缓存过期后的重新探测。
+func (s *Stack) updatePathMTU(dst [4]byte, mtu, sent int) int {
+	current, err := s.PathMTU(dst)
+	if err != nil {
+		return 0
+	}
+
+	if mtu == 0 || mtu >= sent {
+		mtu = 0
+		for _, plateau := range mtuPlateaus {
+			if plateau < sent {
+				mtu = plateau
+				break
+			}
+		}
+	}
+	if mtu < MinPathMTU {
+		mtu = MinPathMTU
+	}
+	if mtu >= current {
+		return current
+	}
+
+	s.pmtu.set(dst, mtu)
+	s.routeGeneration.Add(1)
+	s.logger.Debug("path MTU to %s reduced to %d", net.IP(dst[:]), mtu)
+	return mtu
+}
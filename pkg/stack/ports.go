@@ -0,0 +1,379 @@
+package stack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"sort"
+	"sync"
+	"ustack/pkg/ip"
+)
+
+const (
+	// 临时端口选择的扰动表大小（RFC 6056 3.3.4，同Linux INET_TABLE_PERTURB_SIZE的用法）
+	portPerturbTableSize = 256
+
+	// 占用表按端口分片，每个分片有独立的锁
+	portTableShards = 64
+)
+
+// PortFlags 绑定端口时的共享选项
+type PortFlags struct {
+	// ReuseAddr 允许绑定仍被TIME_WAIT连接使用的端口；UDP双方都设置时可共同绑定（同SO_REUSEADDR）
+	ReuseAddr bool
+	// ReusePort 双方都设置时允许多个未连接端点绑定同一地址和端口，按四元组哈希分担（同SO_REUSEPORT）
+	ReusePort bool
+}
+
+// portReservation 一次端口占用
+type portReservation struct {
+	id    TransportEndpointID
+	flags PortFlags
+	owner TransportEndpoint
+}
+
+// portKey 按协议和端口索引占用
+type portKey struct {
+	protocol uint8
+	port     uint16
+}
+
+// connKey 按协议和四元组索引已连接的占用
+type connKey struct {
+	protocol uint8
+	id       TransportEndpointID
+}
+
+// portShard 未连接占用的一个分片，按端口选择
+//
+// 未连接的占用（绑定、监听）每个端口只有少数几个，按端口保存为列表。
+// 占用已连接的四元组时持有读锁，绑定未连接的端点时持有写锁，两者检查冲突时互斥，
+// 同一端口上的大量连接（如监听接受的连接）之间不互斥。
+type portShard struct {
+	mu    sync.RWMutex
+	bound map[portKey][]portReservation
+}
+
+// connShard 已连接占用的一个分片，按四元组哈希选择
+//
+// 按本地端口和地址计数，以便绑定时检查与已连接端点的冲突。
+type connShard struct {
+	mu        sync.Mutex
+	connected map[connKey]TransportEndpoint
+	connCount map[portKey]map[[4]byte]int
+}
+
+// PortManager 跟踪各协议已占用的端口并分配临时端口
+//
+// 临时端口按RFC 6056算法4（双哈希）选择：起点由本地地址、对端地址和端口的密钥哈希决定，
+// 不同目的地的端口序列互不相关，难以被预测。已连接的端点只与完全相同的四元组冲突，
+// 因此同一临时端口可用于不同的对端；端口在所有占用（含TIME_WAIT连接）释放后才重新可用。
+// 未连接的占用按端口分片，已连接的占用按完整四元组分片，同一端口上的连接建立和释放互不阻塞。
+type PortManager struct {
+	mu          sync.RWMutex // 保护临时端口范围和扰动表
+	first, last uint16
+	seed        maphash.Seed
+	perturb     [portPerturbTableSize]uint16
+
+	shards [portTableShards]portShard
+	conns  [portTableShards]connShard
+}
+
+// NewPortManager 创建使用默认临时端口范围的端口管理器
+func NewPortManager() *PortManager {
+	m := &PortManager{
+		first: EphemeralPortFirst,
+		last:  EphemeralPortLast,
+		seed:  maphash.MakeSeed(),
+	}
+	for i := range m.shards {
+		m.shards[i].bound = make(map[portKey][]portReservation)
+		m.conns[i].connected = make(map[connKey]TransportEndpoint)
+		m.conns[i].connCount = make(map[portKey]map[[4]byte]int)
+	}
+	return m
+}
+
+// shard 返回端口的未连接占用所在的分片
+func (m *PortManager) shard(port uint16) *portShard {
+	return &m.shards[port%portTableShards]
+}
+
+// connShard 返回已连接的四元组所在的分片
+func (m *PortManager) connShard(protocol uint8, id TransportEndpointID) *connShard {
+	var b [13]byte
+	copy(b[0:4], id.LocalAddress[:])
+	copy(b[4:8], id.RemoteAddress[:])
+	binary.BigEndian.PutUint16(b[8:10], id.LocalPort)
+	binary.BigEndian.PutUint16(b[10:12], id.RemotePort)
+	b[12] = protocol
+	return &m.conns[maphash.Bytes(m.seed, b[:])%portTableShards]
+}
+
+// SetEphemeralRange 设置临时端口范围（同net.ipv4.ip_local_port_range）
+func (m *PortManager) SetEphemeralRange(first, last uint16) error {
+	if first == 0 || first > last {
+		return fmt.Errorf("invalid ephemeral port range %d-%d", first, last)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.first, m.last = first, last
+	return nil
+}
+
+// EphemeralRange 返回临时端口范围
+func (m *PortManager) EphemeralRange() (uint16, uint16) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.first, m.last
+}
+
+// Reserve 占用id中的本地端口，端口为0时分配临时端口，返回实际占用的四元组
+func (m *PortManager) Reserve(protocol uint8, id TransportEndpointID, flags PortFlags, owner TransportEndpoint) (TransportEndpointID, error) {
+	if id.LocalPort != 0 {
+		if !m.reserve(protocol, id, flags, owner, false) {
+			return id, fmt.Errorf("%w: %s", ErrPortInUse, id)
+		}
+		return id, nil
+	}
+
+	offset := m.hash(id, 0)
+	index := m.hash(id, 1) % portPerturbTableSize
+	m.mu.RLock()
+	first, last, perturb := m.first, m.last, m.perturb[index]
+	m.mu.RUnlock()
+
+	count := uint32(last) - uint32(first) + 1
+	for i := uint32(0); i < count; i++ {
+		id.LocalPort = first + uint16((offset+uint32(perturb)+i)%count)
+		if !m.tryReserveEphemeral(protocol, id, flags, owner) {
+			continue
+		}
+		// 下次从之后的端口开始，加入小的随机增量使同一目的地的端口序列不可推测（同Linux）
+		m.mu.Lock()
+		m.perturb[index] += uint16(i+1) + uint16(rand.Intn(8))
+		m.mu.Unlock()
+		return id, nil
+	}
+	return id, ErrNoFreePort
+}
+
+// tryReserveEphemeral 临时端口可用时占用
+func (m *PortManager) tryReserveEphemeral(protocol uint8, id TransportEndpointID, flags PortFlags, owner TransportEndpoint) bool {
+	return m.reserve(protocol, id, flags, owner, true)
+}
+
+// reserve 检查冲突并占用id，ephemeral表示id中的端口是候选的临时端口
+func (m *PortManager) reserve(protocol uint8, id TransportEndpointID, flags PortFlags, owner TransportEndpoint, ephemeral bool) bool {
+	key := portKey{protocol: protocol, port: id.LocalPort}
+	sh := m.shard(id.LocalPort)
+
+	if isConnected(id) {
+		// 读锁阻止并发的绑定，已连接的占用之间只在四元组分片上互斥
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+		if ephemeral && sh.boundOverlapLocked(key, id.LocalAddress) {
+			return false
+		}
+
+		cs := m.connShard(protocol, id)
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		ck := connKey{protocol: protocol, id: id}
+		if _, ok := cs.connected[ck]; ok {
+			return false
+		}
+		cs.connected[ck] = owner
+		counts := cs.connCount[key]
+		if counts == nil {
+			counts = make(map[[4]byte]int)
+			cs.connCount[key] = counts
+		}
+		counts[id.LocalAddress]++
+		return true
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if ephemeral {
+		// 未连接的端点需要完全空闲的端口
+		if sh.boundOverlapLocked(key, id.LocalAddress) || m.connectedOverlap(key, id.LocalAddress, false) {
+			return false
+		}
+	} else if sh.conflictsLocked(protocol, id, flags) || m.connectedOverlap(key, id.LocalAddress, flags.ReuseAddr) {
+		return false
+	}
+	sh.bound[key] = append(sh.bound[key], portReservation{id: id, flags: flags, owner: owner})
+	return true
+}
+
+// Release 释放owner对id的占用
+func (m *PortManager) Release(protocol uint8, id TransportEndpointID, owner TransportEndpoint) {
+	key := portKey{protocol: protocol, port: id.LocalPort}
+	if isConnected(id) {
+		cs := m.connShard(protocol, id)
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+
+		ck := connKey{protocol: protocol, id: id}
+		if o, ok := cs.connected[ck]; !ok || o != owner {
+			return
+		}
+		delete(cs.connected, ck)
+		counts := cs.connCount[key]
+		if counts[id.LocalAddress]--; counts[id.LocalAddress] == 0 {
+			delete(counts, id.LocalAddress)
+		}
+		if len(counts) == 0 {
+			delete(cs.connCount, key)
+		}
+		return
+	}
+
+	sh := m.shard(id.LocalPort)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	list := sh.bound[key]
+	for i, r := range list {
+		if r.id == id && r.owner == owner {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(sh.bound, key)
+	} else {
+		sh.bound[key] = list
+	}
+}
+
+// Reserved 返回协议已占用的四元组，按端口排序
+func (m *PortManager) Reserved(protocol uint8) []TransportEndpointID {
+	var ids []TransportEndpointID
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for key, list := range sh.bound {
+			if key.protocol != protocol {
+				continue
+			}
+			for _, r := range list {
+				ids = append(ids, r.id)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	for i := range m.conns {
+		cs := &m.conns[i]
+		cs.mu.Lock()
+		for key := range cs.connected {
+			if key.protocol == protocol {
+				ids = append(ids, key.id)
+			}
+		}
+		cs.mu.Unlock()
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].LocalPort != ids[j].LocalPort {
+			return ids[i].LocalPort < ids[j].LocalPort
+		}
+		return ids[i].String() < ids[j].String()
+	})
+	return ids
+}
+
+// conflictsLocked 检查未连接的端点绑定到id是否与已有的未连接占用冲突
+//
+// 本地地址不重叠时不冲突；两个未连接的端点冲突，除非都设置ReusePort，或UDP下都设置ReuseAddr。
+// 与已连接端点的冲突由connectedOverlap检查；已连接的端点只与完全相同的四元组冲突。
+func (sh *portShard) conflictsLocked(protocol uint8, id TransportEndpointID, flags PortFlags) bool {
+	key := portKey{protocol: protocol, port: id.LocalPort}
+	for _, r := range sh.bound[key] {
+		if !addressesOverlap(r.id.LocalAddress, id.LocalAddress) {
+			continue
+		}
+		if flags.ReusePort && r.flags.ReusePort {
+			continue
+		}
+		if protocol == ip.ProtocolUDP && flags.ReuseAddr && r.flags.ReuseAddr {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// boundOverlapLocked 检查端口上是否有本地地址与addr重叠的未连接占用
+func (sh *portShard) boundOverlapLocked(key portKey, addr [4]byte) bool {
+	for _, r := range sh.bound[key] {
+		if addressesOverlap(r.id.LocalAddress, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// connectedOverlap 检查端口上是否有本地地址与addr重叠的已连接占用，skipTimeWait时忽略处于TIME_WAIT的连接
+//
+// 需要查看所有四元组分片，只在绑定未连接的端点时调用，调用方持有端口分片的写锁。
+func (m *PortManager) connectedOverlap(key portKey, addr [4]byte, skipTimeWait bool) bool {
+	for i := range m.conns {
+		cs := &m.conns[i]
+		cs.mu.Lock()
+		overlap := cs.overlapLocked(key, addr, skipTimeWait)
+		cs.mu.Unlock()
+		if overlap {
+			return true
+		}
+	}
+	return false
+}
+
+// overlapLocked 检查分片中是否有本地地址与addr重叠的已连接占用
+//
+// 先按计数排除没有重叠的分片；需要忽略TIME_WAIT时才逐个查看占用者的状态。
+func (cs *connShard) overlapLocked(key portKey, addr [4]byte, skipTimeWait bool) bool {
+	counts := cs.connCount[key]
+	if len(counts) == 0 {
+		return false
+	}
+	if addr != ([4]byte{}) && counts[addr] == 0 && counts[[4]byte{}] == 0 {
+		return false
+	}
+	if !skipTimeWait {
+		return true
+	}
+	for ck, owner := range cs.connected {
+		if ck.protocol != key.protocol || ck.id.LocalPort != key.port || !addressesOverlap(ck.id.LocalAddress, addr) {
+			continue
+		}
+		if tw, ok := owner.(TimeWaitEndpoint); ok && tw.InTimeWait() {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// hash 返回本地地址、对端地址和端口的密钥哈希（RFC 6056中的F和G）
+func (m *PortManager) hash(id TransportEndpointID, salt byte) uint32 {
+	var b [11]byte
+	copy(b[0:4], id.LocalAddress[:])
+	copy(b[4:8], id.RemoteAddress[:])
+	binary.BigEndian.PutUint16(b[8:10], id.RemotePort)
+	b[10] = salt
+	return uint32(maphash.Bytes(m.seed, b[:]))
+}
+
+// addressesOverlap 检查两个本地地址是否重叠（相同或任一为通配地址）
+func addressesOverlap(a, b [4]byte) bool {
+	return a == b || a == [4]byte{} || b == [4]byte{}
+}
+
+// isConnected 检查四元组是否指定了对端
+func isConnected(id TransportEndpointID) bool {
+	return id.RemotePort != 0 || id.RemoteAddress != [4]byte{}
+}
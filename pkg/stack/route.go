@@ -0,0 +1,210 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// Route 路由表项
+type Route struct {
+	Destination  [4]byte // 目标网络
+	PrefixLength int     // 前缀长度
+	Gateway      [4]byte // 网关，零值表示直连
+	NIC          int     // 出接口
+}
+
+// String 返回路由的字符串表示
+func (r Route) String() string {
+	via := "direct"
+	if r.Gateway != [4]byte{} {
+		via = "via " + net.IP(r.Gateway[:]).String()
+	}
+	return fmt.Sprintf("%s/%d %s dev %d", net.IP(r.Destination[:]).String(), r.PrefixLength, via, r.NIC)
+}
+
+// RouteInfo 路由查找结果
+type RouteInfo struct {
+	NIC      *NIC    // 出接口
+	LocalIP  [4]byte // 源地址
+	RemoteIP [4]byte // 目标地址
+	NextHop  [4]byte // 下一跳地址
+	PathMTU  int     // 已发现的路径MTU，0表示未知
+
+	generation uint64    // 查找时的路由代数
+	expires    time.Time // 路径MTU过期时间，零值表示未使用缓存的路径MTU
+}
+
+// MTU 返回发送报文可用的MTU，即出接口MTU与路径MTU中的较小值
+func (r *RouteInfo) MTU() int {
+	mtu := r.NIC.MTU()
+	if r.PathMTU > 0 && r.PathMTU < mtu {
+		mtu = r.PathMTU
+	}
+	return mtu
+}
+
+// AddRoute 添加路由，按最长前缀排序
+func (s *Stack) AddRoute(r Route) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addRouteLocked(r)
+	s.publishLocked()
+}
+
+func (s *Stack) addRouteLocked(r Route) {
+	r.Destination = maskAddress(r.Destination, r.PrefixLength)
+	for _, existing := range s.routes {
+		if existing == r {
+			return
+		}
+	}
+	s.routes = append(s.routes, r)
+	sort.SliceStable(s.routes, func(i, j int) bool {
+		return s.routes[i].PrefixLength > s.routes[j].PrefixLength
+	})
+}
+
+// RemoveRoute 删除路由，路由不存在时返回false
+func (s *Stack) RemoveRoute(r Route) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.removeRouteLocked(r) {
+		return false
+	}
+	s.publishLocked()
+	return true
+}
+
+func (s *Stack) removeRouteLocked(r Route) bool {
+	r.Destination = maskAddress(r.Destination, r.PrefixLength)
+	for i, existing := range s.routes {
+		if existing == r {
+			s.routes = append(s.routes[:i], s.routes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Routes 返回路由表快照
+func (s *Stack) Routes() []Route {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	routes := make([]Route, len(s.routes))
+	copy(routes, s.routes)
+	return routes
+}
+
+// FindRoute 查找到达目标地址的路由
+//
+// 在当前快照上查找，不获取全局锁。结果可以缓存，用RouteValid检查是否仍然有效。
+func (s *Stack) FindRoute(dst [4]byte) (*RouteInfo, error) {
+	// 先读代数再读快照和路径MTU，查找期间发生的变化会使结果在下次检查时失效
+	generation := s.routeGeneration.Load()
+	return s.findRoute(s.snapshot.Load(), dst, generation)
+}
+
+// RouteValid 报告FindRoute返回的路由是否仍然有效：此后路由表、地址和路径MTU都没有变化，且缓存的路径MTU未过期
+func (s *Stack) RouteValid(r *RouteInfo) bool {
+	if r.generation != s.routeGeneration.Load() {
+		return false
+	}
+	return r.expires.IsZero() || time.Now().Before(r.expires)
+}
+
+func (s *Stack) findRoute(snap *netSnapshot, dst [4]byte, generation uint64) (*RouteInfo, error) {
+	for _, r := range snap.routes {
+		if !prefixMatch(r.Destination, dst, r.PrefixLength) {
+			continue
+		}
+
+		n, ok := snap.nics[r.NIC]
+		if !ok || len(n.addresses) == 0 {
+			continue
+		}
+
+		info := &RouteInfo{
+			NIC:        n.nic,
+			LocalIP:    n.addresses[0].IP,
+			RemoteIP:   dst,
+			NextHop:    dst,
+			generation: generation,
+		}
+		info.PathMTU, info.expires = s.pmtu.get(dst)
+		if r.Gateway != [4]byte{} {
+			info.NextHop = r.Gateway
+		}
+
+		// 优先选择与下一跳同子网的源地址
+		for _, a := range n.addresses {
+			if a.Contains(info.NextHop) {
+				info.LocalIP = a.IP
+				break
+			}
+		}
+
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("no route to host %s", net.IP(dst[:]).String())
+}
+
+// FindMulticastRoute 为多播或受限广播目标选择出接口
+//
+// nicID为0时优先使用路由表（如默认路由）选择的网卡，否则使用编号最小的网卡。
+// 网卡尚未配置地址时源地址为0.0.0.0（如DHCP发现阶段）。
+func (s *Stack) FindMulticastRoute(nicID int, dst [4]byte) (*RouteInfo, error) {
+	generation := s.routeGeneration.Load()
+	snap := s.snapshot.Load()
+
+	var nic *nicSnapshot
+	if nicID != 0 {
+		nic = snap.nics[nicID]
+		if nic == nil {
+			return nil, fmt.Errorf("NIC %d not found", nicID)
+		}
+	} else if r, err := s.findRoute(snap, dst, generation); err == nil {
+		nic = snap.nics[r.NIC.ID]
+	} else {
+		for id, n := range snap.nics {
+			if nic == nil || id < nic.nic.ID {
+				nic = n
+			}
+		}
+	}
+	if nic == nil {
+		return nil, fmt.Errorf("no interface for %s", net.IP(dst[:]).String())
+	}
+
+	info := &RouteInfo{NIC: nic.nic, RemoteIP: dst, NextHop: dst, generation: generation}
+	if len(nic.addresses) > 0 {
+		info.LocalIP = nic.addresses[0].IP
+	}
+	return info, nil
+}
+
+// prefixMask 返回前缀长度对应的掩码
+func prefixMask(prefixLength int) [4]byte {
+	var mask [4]byte
+	for i := 0; i < prefixLength && i < 32; i++ {
+		mask[i/8] |= 0x80 >> (i % 8)
+	}
+	return mask
+}
+
+// maskAddress 对地址应用前缀掩码
+func maskAddress(addr [4]byte, prefixLength int) [4]byte {
+	mask := prefixMask(prefixLength)
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+	return addr
+}
+
+// prefixMatch 检查两个地址的前缀是否相同
+func prefixMatch(a, b [4]byte, prefixLength int) bool {
+	return maskAddress(a, prefixLength) == maskAddress(b, prefixLength)
+}
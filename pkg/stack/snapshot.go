@@ -0,0 +1,68 @@
+package stack
+
+import (
+	"ustack/pkg/ndp"
+)
+
+// netSnapshot 网卡地址、多播组和路由表的只读快照
+//
+// 配置在s.mu下修改后由publishLocked整体替换（RCU方式），收包和路由查找只原子地读取当前快照，
+// 不获取全局锁。快照一经发布不再修改。
+type netSnapshot struct {
+	nics   map[int]*nicSnapshot
+	routes []Route
+	local  map[[4]byte]struct{} // 所有网卡上的地址
+}
+
+// nicSnapshot 单个网卡的只读快照
+type nicSnapshot struct {
+	nic           *NIC
+	addresses     []Address
+	groups        map[[4]byte]struct{}
+	multicastMACs map[[6]byte]struct{}
+	ndp           *ndp.Endpoint
+}
+
+// publishLocked 根据当前配置发布新的快照，并使缓存的路由失效；每次修改网卡、地址、多播组或路由后调用
+func (s *Stack) publishLocked() {
+	snap := &netSnapshot{
+		nics:   make(map[int]*nicSnapshot, len(s.nics)),
+		routes: append([]Route(nil), s.routes...),
+		local:  make(map[[4]byte]struct{}),
+	}
+	for id, nic := range s.nics {
+		n := &nicSnapshot{
+			nic:           nic,
+			addresses:     append([]Address(nil), nic.addresses...),
+			groups:        make(map[[4]byte]struct{}, len(nic.groups)),
+			multicastMACs: make(map[[6]byte]struct{}, len(nic.multicastMACs)),
+			ndp:           nic.ndp,
+		}
+		for group := range nic.groups {
+			n.groups[group] = struct{}{}
+		}
+		for mac := range nic.multicastMACs {
+			n.multicastMACs[mac] = struct{}{}
+		}
+		for _, a := range nic.addresses {
+			snap.local[a.IP] = struct{}{}
+		}
+		snap.nics[id] = n
+	}
+
+	s.snapshot.Store(snap)
+	s.routeGeneration.Add(1)
+}
+
+// nicSnapshot 返回网卡在当前快照中的状态，网卡尚未发布时返回nil
+func (s *Stack) nicSnapshot(nic *NIC) *nicSnapshot {
+	return s.snapshot.Load().nics[nic.ID]
+}
+
+// nicAddresses 返回网卡在当前快照中的地址
+func (s *Stack) nicAddresses(nic *NIC) []Address {
+	if n := s.nicSnapshot(nic); n != nil {
+		return n.addresses
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/ndp"
+)
+
+// Address 网卡上配置的IPv4地址
+type Address struct {
+	IP           [4]byte // 地址
+	PrefixLength int     // 前缀长度
+}
+
+// String 返回地址的字符串表示
+func (a Address) String() string {
+	return fmt.Sprintf("%s/%d", net.IP(a.IP[:]).String(), a.PrefixLength)
+}
+
+// Contains 检查目标地址是否在该地址所在子网内
+func (a Address) Contains(dst [4]byte) bool {
+	return prefixMatch(a.IP, dst, a.PrefixLength)
+}
+
+// Broadcast 返回子网广播地址
+func (a Address) Broadcast() [4]byte {
+	mask := prefixMask(a.PrefixLength)
+	var bcast [4]byte
+	for i := range bcast {
+		bcast[i] = a.IP[i] | ^mask[i]
+	}
+	return bcast
+}
+
+// NIC 网卡，连接一个链路端点
+type NIC struct {
+	ID        int
+	link      link.Endpoint
+	addresses []Address
+
+	// 多播组成员关系与MAC过滤表
+	groups        map[[4]byte]*membership
+	multicastMACs map[[6]byte]int
+
+	// 旧版本IGMP查询者存在的截止时间
+	igmpV1Until time.Time
+	igmpV2Until time.Time
+
+	// IPv6邻居发现，EnableIPv6之前为nil
+	ndp *ndp.Endpoint
+}
+
+// MTU 返回网卡MTU
+func (n *NIC) MTU() int {
+	return n.link.MTU()
+}
+
+// MACAddress 返回网卡MAC地址
+func (n *NIC) MACAddress() [6]byte {
+	return n.link.MACAddress()
+}
+
+// Stack 用户态协议栈，管理网卡、路由和各协议的收发
+type Stack struct {
+	mu sync.RWMutex
+
+	nics   map[int]*NIC
+	routes []Route
+
+	// 邻居表有独立的锁，发包路径上的地址解析不获取全局锁；持有时不获取s.mu
+	neighborMu sync.RWMutex
+	neighbors  map[[4]byte]*neighbor
+
+	// 网卡地址、多播组和路由表的快照，收发路径无锁读取
+	snapshot atomic.Pointer[netSnapshot]
+	// 快照或路径MTU每次变化时递增，用于使传输层缓存的路由失效
+	routeGeneration atomic.Uint64
+
+	// 传输层端点分发表和端口占用
+	demux *transportDemuxer
+	ports *PortManager
+
+	// IP标识生成器
+	ids *ip.IDGenerator
+
+	// ICMP差错报文限速器，收包路径上原子地读取
+	icmpLimiter atomic.Pointer[icmp.RateLimiter]
+
+	// 路径MTU缓存
+	pmtu *pmtuCache
+
+	// 是否转发非本机报文
+	forwarding atomic.Bool
+
+	// DNS服务器（如DHCP获得的），供解析器使用
+	dnsServers [][4]byte
+
+	// 日志
+	logger *utils.Logger
+}
+
+// New 创建新的协议栈
+func New() *Stack {
+	s := &Stack{
+		nics:      make(map[int]*NIC),
+		neighbors: make(map[[4]byte]*neighbor),
+		demux:     newTransportDemuxer(ip.ProtocolUDP, ip.ProtocolTCP, ip.ProtocolICMP),
+		ports:     NewPortManager(),
+		ids:       ip.NewIDGenerator(),
+		pmtu:      newPMTUCache(),
+		logger:    utils.DefaultLogger,
+	}
+	s.icmpLimiter.Store(icmp.NewRateLimiter(icmp.DefaultRateLimit, icmp.DefaultRateBurst))
+	s.publishLocked()
+	return s
+}
+
+// SetLogger 设置日志记录器
+func (s *Stack) SetLogger(logger *utils.Logger) {
+	s.logger = logger
+}
+
+// AddNIC 添加网卡
+func (s *Stack) AddNIC(id int, ep link.Endpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.nics[id]; ok {
+		return fmt.Errorf("NIC %d already exists", id)
+	}
+
+	nic := &NIC{
+		ID:            id,
+		link:          ep,
+		groups:        make(map[[4]byte]*membership),
+		multicastMACs: make(map[[6]byte]int),
+	}
+	s.nics[id] = nic
+	s.publishLocked()
+	ep.Attach(func(frame []byte) {
+		s.handleFrame(nic, frame)
+	})
+	s.logger.Info("NIC %d added: %s, MTU %d", id, net.HardwareAddr(mac(ep)).String(), ep.MTU())
+
+	return nil
+}
+
+// AddAddress 为网卡添加地址，并添加对应的直连路由
+func (s *Stack) AddAddress(nicID int, addr [4]byte, prefixLength int) error {
+	if prefixLength < 0 || prefixLength > 32 {
+		return fmt.Errorf("invalid prefix length: %d", prefixLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nic, ok := s.nics[nicID]
+	if !ok {
+		return fmt.Errorf("NIC %d not found", nicID)
+	}
+
+	a := Address{IP: addr, PrefixLength: prefixLength}
+	for _, existing := range nic.addresses {
+		if existing.IP == addr {
+			return fmt.Errorf("address %s already exists on NIC %d", a, nicID)
+		}
+	}
+	nic.addresses = append(nic.addresses, a)
+
+	s.addRouteLocked(Route{
+		Destination:  maskAddress(addr, prefixLength),
+		PrefixLength: prefixLength,
+		NIC:          nicID,
+	})
+	s.publishLocked()
+
+	s.logger.Info("NIC %d address added: %s", nicID, a)
+	return nil
+}
+
+// RemoveAddress 删除网卡上的地址；没有其他地址使用同一子网时一并删除对应的直连路由
+func (s *Stack) RemoveAddress(nicID int, addr [4]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nic, ok := s.nics[nicID]
+	if !ok {
+		return fmt.Errorf("NIC %d not found", nicID)
+	}
+
+	idx := -1
+	for i, a := range nic.addresses {
+		if a.IP == addr {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("address %s not found on NIC %d", net.IP(addr[:]), nicID)
+	}
+	a := nic.addresses[idx]
+	nic.addresses = append(nic.addresses[:idx], nic.addresses[idx+1:]...)
+
+	shared := false
+	for _, other := range nic.addresses {
+		if other.PrefixLength == a.PrefixLength && prefixMatch(other.IP, a.IP, a.PrefixLength) {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		s.removeRouteLocked(Route{Destination: a.IP, PrefixLength: a.PrefixLength, NIC: nicID})
+	}
+	s.publishLocked()
+
+	s.logger.Info("NIC %d address removed: %s", nicID, a)
+	return nil
+}
+
+// Addresses 返回网卡上配置的地址
+func (s *Stack) Addresses(nicID int) []Address {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[nicID]
+	if !ok {
+		return nil
+	}
+	addrs := make([]Address, len(nic.addresses))
+	copy(addrs, nic.addresses)
+	return addrs
+}
+
+// IsLocalAddress 检查地址是否配置在某个网卡上
+func (s *Stack) IsLocalAddress(addr [4]byte) bool {
+	_, ok := s.snapshot.Load().local[addr]
+	return ok
+}
+
+// IsBroadcastAddress 检查地址是否为受限广播或某个网卡的子网广播地址
+func (s *Stack) IsBroadcastAddress(addr [4]byte) bool {
+	if addr == limitedBroadcast {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, nic := range s.nics {
+		for _, a := range nic.addresses {
+			if a.PrefixLength < 31 && a.Broadcast() == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetDNSServers 设置DNS服务器列表，nil表示清空
+func (s *Stack) SetDNSServers(servers [][4]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dnsServers = append([][4]byte(nil), servers...)
+}
+
+// DNSServers 返回配置的DNS服务器
+func (s *Stack) DNSServers() [][4]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([][4]byte(nil), s.dnsServers...)
+}
+
+// Close 关闭所有网卡
+func (s *Stack) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, nic := range s.nics {
+		if nic.ndp != nil {
+			nic.ndp.Stop()
+		}
+		if err := nic.link.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mac(ep link.Endpoint) []byte {
+	m := ep.MACAddress()
+	return m[:]
+}
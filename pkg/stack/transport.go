@@ -0,0 +1,121 @@
+package stack
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// 临时端口范围（IANA建议）
+	EphemeralPortFirst = 49152
+	EphemeralPortLast  = 65535
+)
+
+var (
+	// ErrPortInUse 端口已被占用
+	ErrPortInUse = errors.New("address already in use")
+
+	// ErrNoFreePort 临时端口已耗尽
+	ErrNoFreePort = errors.New("no free ephemeral port")
+
+	// 由ICMP差错报文转换得到的错误（同Linux icmp_err_convert）
+	ErrConnectionRefused   = errors.New("connection refused")
+	ErrHostUnreachable     = errors.New("no route to host")
+	ErrNetworkUnreachable  = errors.New("network is unreachable")
+	ErrProtocolUnreachable = errors.New("protocol not available")
+	ErrMessageTooLong      = errors.New("message too long")
+	ErrHostDown            = errors.New("host is down")
+	ErrAccessDenied        = errors.New("permission denied")
+)
+
+// TransportError 发往对端的报文触发的ICMP差错
+//
+// Hard为true时表示对端明确拒绝（如端口不可达），连接应当中止；
+// 否则为软错误（如主机不可达、TTL超时），连接可继续重试（RFC 1122 4.2.3.9）。
+type TransportError struct {
+	Err  error               // 对应的错误
+	Hard bool                // 是否为硬错误
+	Type uint8               // ICMP类型
+	Code uint8               // ICMP代码
+	MTU  uint16              // 分片需要时更新后的路径MTU
+	From [4]byte             // 发出差错报文的主机
+	ID   TransportEndpointID // 原始报文对应的四元组
+
+	Header []byte // 原始报文的传输层头部前8字节（TCP可据此校验序列号）
+}
+
+// Error 返回错误描述
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap 返回对应的错误，便于errors.Is判断
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// RegisterTransportEndpoint 注册传输层端点，本地端口为0时分配临时端口
+//
+// 返回实际注册的四元组。
+func (s *Stack) RegisterTransportEndpoint(protocol uint8, id TransportEndpointID, ep TransportEndpoint) (TransportEndpointID, error) {
+	return s.RegisterTransportEndpointWithFlags(protocol, id, ep, PortFlags{})
+}
+
+// RegisterTransportEndpointWithFlags 同RegisterTransportEndpoint，按flags允许共享端口
+func (s *Stack) RegisterTransportEndpointWithFlags(protocol uint8, id TransportEndpointID, ep TransportEndpoint, flags PortFlags) (TransportEndpointID, error) {
+	if _, err := s.demux.table(protocol); err != nil {
+		return id, err
+	}
+
+	id, err := s.ports.Reserve(protocol, id, flags, ep)
+	if err != nil {
+		return id, err
+	}
+	if err := s.demux.register(protocol, id, ep); err != nil {
+		s.ports.Release(protocol, id, ep)
+		return id, err
+	}
+	return id, nil
+}
+
+// UnknownDestinationHandler 处理没有匹配端点的报文，如TCP回复RST
+type UnknownDestinationHandler func(s *Stack, pkt *Packet)
+
+var (
+	unknownDestinationMu       sync.RWMutex
+	unknownDestinationHandlers = make(map[uint8]UnknownDestinationHandler)
+)
+
+// RegisterUnknownDestinationHandler 注册传输协议在没有匹配端点时的处理函数
+//
+// 由传输协议包在init中调用，对所有协议栈实例生效。
+func RegisterUnknownDestinationHandler(protocol uint8, handler UnknownDestinationHandler) {
+	unknownDestinationMu.Lock()
+	defer unknownDestinationMu.Unlock()
+	unknownDestinationHandlers[protocol] = handler
+}
+
+// unknownDestinationHandler 返回协议注册的处理函数
+func unknownDestinationHandler(protocol uint8) UnknownDestinationHandler {
+	unknownDestinationMu.RLock()
+	defer unknownDestinationMu.RUnlock()
+	return unknownDestinationHandlers[protocol]
+}
+
+// UnregisterTransportEndpoint 注销传输层端点
+func (s *Stack) UnregisterTransportEndpoint(protocol uint8, id TransportEndpointID, ep TransportEndpoint) {
+	s.demux.unregister(protocol, id, ep)
+	s.ports.Release(protocol, id, ep)
+}
+
+// Ports 返回协议栈的端口管理器，可用于设置临时端口范围和查看已占用的端口
+func (s *Stack) Ports() *PortManager {
+	return s.ports
+}
+
+// RangeTransportEndpoints 遍历协议已注册的所有端点及其四元组，fn返回false时停止，用于诊断
+//
+// 遍历不持有全局锁，期间注册或注销的端点不保证可见。
+func (s *Stack) RangeTransportEndpoints(protocol uint8, fn func(id TransportEndpointID, ep TransportEndpoint) bool) {
+	s.demux.forEach(protocol, fn)
+}
@@ -0,0 +1,126 @@
+package tcp
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// CongestionReno NewReno拥塞控制（RFC 5681、RFC 6582），默认算法
+	CongestionReno = "reno"
+	// CongestionCubic CUBIC拥塞控制（RFC 9438）
+	CongestionCubic = "cubic"
+
+	// CUBIC参数（RFC 9438 4.1）
+	cubicC    = 0.4
+	cubicBeta = 0.7
+)
+
+// congestionControl 拥塞控制算法
+//
+// 算法只决定确认到达时窗口如何增长以及丢包后的慢启动阈值；快速重传、快速恢复与超时后的
+// 慢启动由连接统一处理。
+type congestionControl interface {
+	// onAck 不在快速恢复中时收到确认了acked字节新数据的ACK
+	onAck(c *Connection, acked, mss uint32)
+	// onLoss 检测到丢包（三次重复ACK或超时），返回新的慢启动阈值
+	onLoss(c *Connection, mss uint32) uint32
+}
+
+// ValidCongestionControl 检查拥塞控制算法名是否受支持，空串表示默认算法
+func ValidCongestionControl(name string) bool {
+	switch name {
+	case "", CongestionReno, CongestionCubic:
+		return true
+	}
+	return false
+}
+
+// newCongestionControl 按名称创建拥塞控制算法
+func newCongestionControl(name string) (congestionControl, error) {
+	switch name {
+	case "", CongestionReno:
+		return &reno{}, nil
+	case CongestionCubic:
+		return &cubic{}, nil
+	}
+	return nil, fmt.Errorf("unknown congestion control algorithm %q", name)
+}
+
+// reno 慢启动每个ACK增加最多一个MSS，拥塞避免每个RTT增加一个MSS，丢包后减半
+type reno struct{}
+
+func (reno) onAck(c *Connection, acked, mss uint32) {
+	if c.cwnd < c.ssthresh {
+		c.cwnd += min(acked, mss)
+		return
+	}
+	c.cwnd += max(mss*mss/c.cwnd, 1)
+}
+
+func (reno) onLoss(c *Connection, mss uint32) uint32 {
+	return max((c.sndNxt-c.sndUna)/2, 2*mss)
+}
+
+// cubic 拥塞避免阶段窗口按距上次丢包时间的三次函数增长，在上次丢包的窗口附近趋于平稳
+//
+// 窗口以MSS为单位计算；估计的Reno窗口更大时按Reno增长（RFC 9438 4.3的Reno友好区域）。
+type cubic struct {
+	wMax       float64   // 上次丢包时的窗口
+	k          float64   // 窗口增长回wMax所需的秒数
+	epochStart time.Time // 本轮拥塞避免开始的时间，零值表示尚未开始
+	wEst       float64   // 估计的Reno窗口
+}
+
+func (cc *cubic) onAck(c *Connection, acked, mss uint32) {
+	if c.cwnd < c.ssthresh {
+		c.cwnd += min(acked, mss)
+		return
+	}
+
+	now := time.Now()
+	cwnd := float64(c.cwnd) / float64(mss)
+	if cc.epochStart.IsZero() {
+		cc.epochStart = now
+		cc.wEst = cwnd
+		if cwnd < cc.wMax {
+			cc.k = math.Cbrt((cc.wMax - cwnd) / cubicC)
+		} else {
+			cc.k = 0
+			cc.wMax = cwnd
+		}
+	}
+
+	// 目标窗口取一个RTT之后的值（RFC 9438 4.2），限制在[cwnd, 1.5cwnd]
+	rtt := c.srtt
+	if rtt == 0 {
+		rtt = c.rto
+	}
+	t := (now.Sub(cc.epochStart) + rtt).Seconds() - cc.k
+	target := cubicC*t*t*t + cc.wMax
+	target = min(max(target, cwnd), 1.5*cwnd)
+
+	segments := float64(acked) / float64(mss)
+	cc.wEst += 3 * (1 - cubicBeta) / (1 + cubicBeta) * segments / cwnd
+
+	var next float64
+	if cc.wEst > target {
+		next = cc.wEst
+	} else {
+		next = cwnd + (target-cwnd)/cwnd*segments
+	}
+	c.cwnd = max(uint32(next*float64(mss)), c.cwnd)
+}
+
+func (cc *cubic) onLoss(c *Connection, mss uint32) uint32 {
+	cwnd := float64(c.cwnd) / float64(mss)
+	// 快速收敛：窗口未恢复到上次的wMax时进一步降低wMax，让出带宽（RFC 9438 4.7）
+	if cwnd < cc.wMax {
+		cc.wMax = cwnd * (1 + cubicBeta) / 2
+	} else {
+		cc.wMax = cwnd
+	}
+	cc.epochStart = time.Time{}
+	return max(uint32(float64(c.cwnd)*cubicBeta), 2*mss)
+}
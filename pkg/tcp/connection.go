@@ -1,12 +1,17 @@
 package tcp
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 	"ustack/internal/utils"
+	"ustack/pkg/ip"
+	"ustack/pkg/stack"
 )
 
 const (
@@ -28,201 +33,1373 @@ const (
 
 	// 超时时间
 	ConnectionTimeout = 30 * time.Second
-	RetransmitTimeout = 3 * time.Second
+	RetransmitTimeout = 1 * time.Second // 初始重传超时（RFC 6298 2.1）
+
+	// 重传超时上下限
+	MinRetransmitTimeout = 200 * time.Millisecond
+	MaxRetransmitTimeout = 60 * time.Second
+
+	// 放弃连接前的最大重传次数（同Linux tcp_syn_retries和tcp_retries2）
+	MaxSynRetransmits = 6
+	MaxRetransmits    = 15
+
+	// TIME_WAIT持续时间（2MSL）
+	TimeWaitTimeout = 60 * time.Second
+
+	// 默认半连接队列长度
+	DefaultBacklog = 128
+
+	// 分组层路径MTU探测（RFC 4821）：连续超时达到该次数时怀疑存在ICMP黑洞
+	BlackHoleRetransmits = 2
+
+	// 黑洞检测后MSS的下限（同Linux tcp_base_mss）
+	BaseMSS = 1024
+
+	// 探测范围小于该值（字节）时停止二分搜索（同Linux tcp_probe_threshold）
+	MTUProbeThreshold = 8
+
+	// 搜索结束后再次向路径MSS探测的间隔（同Linux tcp_probe_interval）
+	MTUProbeInterval = 10 * time.Minute
+)
+
+var (
+	// ErrNotConnected 连接未建立
+	ErrNotConnected = errors.New("TCP connection not established")
+
+	// ErrClosed 连接已关闭或正在关闭
+	ErrClosed = errors.New("TCP connection closed")
+
+	// ErrConnectionReset 连接被对端重置
+	ErrConnectionReset = errors.New("connection reset by peer")
+
+	// ErrTimeout 重传超时
+	ErrTimeout = errors.New("connection timed out")
 )
 
 // Connection TCP连接结构
+//
+// 同一个结构既用于监听（Listen）也用于主动连接（Connect）。收到的数据通过
+// OnDataReceived回调交付，回调在协议栈的接收协程中调用，不持有连接锁。
 type Connection struct {
 	mu sync.Mutex
 
+	stack *stack.Stack
+
 	// 连接标识
-	LocalIP    [4]byte
-	LocalPort  uint16
-	RemoteIP   [4]byte
-	RemotePort uint16
+	id         stack.TransportEndpointID
+	registered bool
 
 	// 状态
-	State string
+	state string
+	err   error // 连接终止的原因
+	soft  error // 最近的ICMP软错误，超时时代替ErrTimeout报告
+
+	// 发送序列空间（RFC 793 3.2）
+	iss    uint32
+	sndUna uint32
+	sndNxt uint32
+	sndMax uint32 // 已发送的最大序列号，超时重传退回sndNxt后对端仍可确认到这里
+	sndWnd uint32
+	sndWl1 uint32
+	sndWl2 uint32
+
+	// 发送缓冲区，从sndUna开始（已发送未确认 + 未发送）
+	sndBuf    []byte
+	finQueued bool
+	finSent   bool
+	finAcked  bool
+
+	// 接收序列空间
+	irs    uint32
+	rcvNxt uint32
+	rcvWnd uint32
+
+	// 已交付但应用尚未读取的字节数（ReadBuffered时占用接收窗口）
+	rcvUnread int
+	// 最近通告的窗口右边界
+	rcvAdvEdge uint32
+
+	// 乱序到达的段
+	ooo      []*segment
+	oooBytes int
+
+	// MSS
+	peerMSS uint16
+
+	// 分组层路径MTU探测（RFC 4821）：黑洞检测降低MSS后，在[probeMSS, probeHigh)间二分探测更大的段
+	probeMSS  int       // 已确认可用的MSS上限，0表示不限制
+	probeHigh int       // 已知丢失的最小段大小
+	probeNext time.Time // 下一次探测的最早时间
+	probing   bool      // 是否有未确认的探测段
+	probeSeq  uint32    // 探测段的起始序列号
+	probeSize int       // 探测段的大小
 
-	// 序列号
-	SendSequence    uint32
-	ReceiveSequence uint32
+	// 缓存的到对端的路由及其允许的MSS，协议栈的路由、地址或路径MTU变化后重新查找
+	route    *stack.RouteInfo
+	routeMSS int
 
-	// 窗口
-	SendWindow    uint16
-	ReceiveWindow uint16
+	// 重传定时器（RFC 6298）
+	srtt        time.Duration
+	rttvar      time.Duration
+	rto         time.Duration
+	rttTiming   bool
+	rttSeq      uint32
+	rttStart    time.Time
+	retransmits int
+	timer       *time.Timer
+	timerGen    uint64
 
-	// 缓冲区
-	SendBuffer    []byte
-	ReceiveBuffer []byte
+	// 坚持定时器（RFC 1122 4.2.2.17）：对端通告零窗口时周期性探测，探测不计入重传次数
+	persisting     bool
+	persistProbes  int           // 未收到ACK的连续探测次数，收到任何可接受的ACK时清零
+	persistTimeout time.Duration // 当前探测间隔，按指数退避
 
 	// 拥塞控制
-	CongestionWindow   uint16
-	SlowStartThreshold uint16
+	cc         congestionControl
+	cwnd       uint32
+	ssthresh   uint32
+	dupAcks    int
+	inRecovery bool
+	recover    uint32
 
-	// 定时器
-	RetransmitTimer *time.Timer
-	KeepAliveTimer  *time.Timer
+	// 监听
+	listener *Connection
+	backlog  int
+	pending  int
+
+	// 选项
+	MTUProbing   bool // 启用分组层路径MTU探测，应对丢弃ICMP的网络（RFC 4821）
+	ReadBuffered bool // 交付的数据在调用Consume前占用接收窗口，接收窗口随应用的读取速度变化
+	ReuseAddr    bool // 允许绑定仍有TIME_WAIT连接使用的端口（同SO_REUSEADDR），需在Bind前设置
+	ReusePort    bool // 都设置时多个连接可监听同一端口，新连接按四元组哈希分担（同SO_REUSEPORT），需在Bind前设置
+
+	// Congestion 拥塞控制算法（CongestionReno或CongestionCubic），空串表示CongestionReno，需在Connect或Listen前设置
+	Congestion string
+	// ReceiveWindow 接收窗口（字节），0表示DefaultWindowSize；不支持窗口缩放，最大65535，需在Connect或Listen前设置
+	ReceiveWindow int
+	// RetransmitLimit 放弃连接前数据段的最大重传次数，0表示MaxRetransmits；也限制对端无响应时的零窗口探测次数
+	RetransmitLimit int
 
 	// 回调函数
 	OnDataReceived func([]byte)
 	OnStateChanged func(string)
+	OnAccept       func(*Connection)
+	OnDataAcked    func() // 发送缓冲区中有数据被确认
+
+	// 锁释放后执行的回调
+	events []func()
+
+	// 是否处于TIME_WAIT，端口管理器在其锁下读取，不能获取连接锁
+	timeWait atomic.Bool
 
 	// 日志
 	logger *utils.Logger
 }
 
-// NewConnection 创建新的TCP连接
-func NewConnection(localIP [4]byte, localPort uint16, remoteIP [4]byte, remotePort uint16) *Connection {
-	conn := &Connection{
-		LocalIP:            localIP,
-		LocalPort:          localPort,
-		RemoteIP:           remoteIP,
-		RemotePort:         remotePort,
-		State:              StateClosed,
-		SendWindow:         DefaultWindowSize,
-		ReceiveWindow:      DefaultWindowSize,
-		CongestionWindow:   1, // 慢启动初始窗口
-		SlowStartThreshold: 65535,
-		SendBuffer:         make([]byte, 0, 8192),
-		ReceiveBuffer:      make([]byte, 0, 8192),
-		logger:             utils.DefaultLogger,
+// NewConnection 在协议栈上创建新的TCP连接
+func NewConnection(s *stack.Stack) *Connection {
+	return &Connection{
+		stack:      s,
+		state:      StateClosed,
+		rcvWnd:     DefaultWindowSize,
+		peerMSS:    DefaultMSS,
+		rto:        RetransmitTimeout,
+		ssthresh:   0xFFFFFFFF,
+		cc:         &reno{},
+		MTUProbing: true,
+		logger:     utils.DefaultLogger,
 	}
-
-	return conn
 }
 
-// Connect 建立连接（客户端）
-func (c *Connection) Connect() error {
+// Bind 绑定本地地址，端口为0时分配临时端口
+func (c *Connection) Bind(addr stack.FullAddress) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.State != StateClosed {
-		return fmt.Errorf("connection not in CLOSED state")
+	if c.state != StateClosed || c.registered {
+		return fmt.Errorf("connection already bound")
+	}
+	if addr.IP != [4]byte{} && !c.stack.IsLocalAddress(addr.IP) {
+		return fmt.Errorf("cannot bind to non-local address %s", addr)
 	}
 
-	// 生成随机序列号
-	c.SendSequence = rand.Uint32()
-	c.ReceiveSequence = 0
+	id, err := c.stack.RegisterTransportEndpointWithFlags(ip.ProtocolTCP, stack.TransportEndpointID{
+		LocalAddress: addr.IP,
+		LocalPort:    addr.Port,
+	}, c, stack.PortFlags{ReuseAddr: c.ReuseAddr, ReusePort: c.ReusePort})
+	if err != nil {
+		return err
+	}
 
-	// 发送SYN包
-	synHeader := NewHeader(c.LocalPort, c.RemotePort, c.SendSequence, 0, FlagSYN, c.SendWindow)
+	c.id = id
+	c.registered = true
+	return nil
+}
 
-	c.State = StateSynSent
-	c.logger.LogConnection("SYN_SENT", fmt.Sprintf("%s:%d", net.IP(c.LocalIP[:]), c.LocalPort),
-		fmt.Sprintf("%s:%d", net.IP(c.RemoteIP[:]), c.RemotePort))
+// Listen 监听连接（服务端），需先绑定端口
+func (c *Connection) Listen() error {
+	c.mu.Lock()
+	defer c.unlock()
 
-	// 这里应该发送SYN包到网络层
-	// 简化处理，直接模拟收到SYN+ACK
-	c.handleSynAck(synHeader)
+	if !c.registered {
+		return fmt.Errorf("connection not bound")
+	}
+	if c.state != StateClosed {
+		return fmt.Errorf("connection not in CLOSED state")
+	}
 
+	if err := c.applyOptionsLocked(); err != nil {
+		return err
+	}
+	if c.backlog == 0 {
+		c.backlog = DefaultBacklog
+	}
+	c.setStateLocked(StateListen)
 	return nil
 }
 
-// Listen 监听连接（服务端）
-func (c *Connection) Listen() error {
+// Connect 向对端发起连接（客户端），发送SYN后立即返回，建立结果通过OnStateChanged通知
+func (c *Connection) Connect(addr stack.FullAddress) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	c.State = StateListen
-	c.logger.LogConnection("LISTEN", fmt.Sprintf("%s:%d", net.IP(c.LocalIP[:]), c.LocalPort), "")
+	if c.state != StateClosed {
+		return fmt.Errorf("connection not in CLOSED state")
+	}
+	if addr.Port == 0 {
+		return fmt.Errorf("invalid remote port 0")
+	}
+	if err := c.applyOptionsLocked(); err != nil {
+		return err
+	}
+
+	r, err := c.stack.FindRoute(addr.IP)
+	if err != nil {
+		return err
+	}
+
+	id := c.id
+	if id.LocalAddress == [4]byte{} {
+		id.LocalAddress = r.LocalIP
+	}
+	id.RemoteAddress = addr.IP
+	id.RemotePort = addr.Port
 
+	registered, err := c.stack.RegisterTransportEndpoint(ip.ProtocolTCP, id, c)
+	if err != nil {
+		return err
+	}
+	if c.registered {
+		c.stack.UnregisterTransportEndpoint(ip.ProtocolTCP, c.id, c)
+	}
+	c.id = registered
+	c.route = nil
+	c.registered = true
+
+	// 生成随机初始序列号
+	c.iss = rand.Uint32()
+	c.sndUna = c.iss
+	c.sndNxt = c.iss + 1
+	c.sndMax = c.sndNxt
+	c.err = nil
+
+	c.setStateLocked(StateSynSent)
+	c.sendSynLocked()
 	return nil
 }
 
-// Send 发送数据
+// Send 将数据加入发送缓冲区并在窗口允许时发送，不阻塞
 func (c *Connection) Send(data []byte) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	if c.State != StateEstablished {
-		return fmt.Errorf("connection not established")
+	switch c.state {
+	case StateSynSent, StateSynReceived, StateEstablished, StateCloseWait:
+	default:
+		if c.err != nil {
+			return c.err
+		}
+		if c.state == StateClosed || c.state == StateListen {
+			return ErrNotConnected
+		}
+		return ErrClosed
+	}
+	if c.finQueued {
+		return ErrClosed
 	}
 
-	// 添加到发送缓冲区
-	c.SendBuffer = append(c.SendBuffer, data...)
-
-	// 创建数据包（这里应该通过IP层发送）
-	_ = NewHeader(c.LocalPort, c.RemotePort, c.SendSequence, c.ReceiveSequence, FlagPSH|FlagACK, c.SendWindow)
+	c.sndBuf = append(c.sndBuf, data...)
+	c.outputLocked()
 
-	// 发送数据
-	// 这里应该通过IP层发送
-	c.SendSequence += uint32(len(data))
+	c.logger.LogPacket("SEND", "TCP", c.localString(), c.remoteString(), len(data))
+	return nil
+}
 
-	c.logger.LogPacket("SEND", "TCP", fmt.Sprintf("%s:%d", net.IP(c.LocalIP[:]), c.LocalPort),
-		fmt.Sprintf("%s:%d", net.IP(c.RemoteIP[:]), c.RemotePort), len(data))
+// Close 关闭连接，已缓冲的数据发送完毕后发送FIN
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.unlock()
 
+	switch c.state {
+	case StateListen, StateSynSent:
+		c.releaseLocked(nil)
+	case StateSynReceived, StateEstablished:
+		c.finQueued = true
+		c.setStateLocked(StateFinWait1)
+		c.outputLocked()
+	case StateCloseWait:
+		c.finQueued = true
+		c.setStateLocked(StateLastAck)
+		c.outputLocked()
+	}
 	return nil
 }
 
-// Receive 接收数据
-func (c *Connection) Receive(data []byte) error {
+// Consume 通知应用已读取n字节交付的数据（ReadBuffered时），窗口明显增大时立即通告
+func (c *Connection) Consume(n int) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.ReadBuffered {
+		return
+	}
+	c.rcvUnread = max(c.rcvUnread-n, 0)
+
+	switch c.state {
+	case StateEstablished, StateFinWait1, StateFinWait2:
+	default:
+		return
+	}
+
+	// 接收方避免糊涂窗口：增量达到min(缓冲区一半, MSS)时才发送窗口更新（RFC 1122 4.2.3.3）
+	edge := c.rcvNxt + c.receiveWindowLocked()
+	if seqGT(edge, c.rcvAdvEdge) && edge-c.rcvAdvEdge >= min(c.rcvWnd/2, uint32(c.effectiveMSSLocked())) {
+		c.sendAckLocked()
+	}
+}
+
+// SendQueued 返回发送缓冲区中尚未被确认的字节数
+func (c *Connection) SendQueued() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return len(c.sndBuf)
+}
 
-	// 添加到接收缓冲区
-	c.ReceiveBuffer = append(c.ReceiveBuffer, data...)
+// State 返回连接状态
+func (c *Connection) State() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
 
-	// 更新接收序列号
-	c.ReceiveSequence += uint32(len(data))
+// InTimeWait 连接是否处于TIME_WAIT，实现stack.TimeWaitEndpoint
+func (c *Connection) InTimeWait() bool {
+	return c.timeWait.Load()
+}
 
-	// 发送ACK（这里应该通过IP层发送）
-	_ = NewHeader(c.LocalPort, c.RemotePort, c.SendSequence, c.ReceiveSequence, FlagACK, c.ReceiveWindow)
+// Err 返回连接异常终止的原因，如ErrConnectionReset、stack.ErrConnectionRefused
+func (c *Connection) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
 
-	c.logger.LogPacket("RECV", "TCP", fmt.Sprintf("%s:%d", net.IP(c.RemoteIP[:]), c.RemotePort),
-		fmt.Sprintf("%s:%d", net.IP(c.LocalIP[:]), c.LocalPort), len(data))
+// LocalAddress 返回本地地址
+func (c *Connection) LocalAddress() stack.FullAddress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return stack.FullAddress{IP: c.id.LocalAddress, Port: c.id.LocalPort}
+}
 
-	// 调用数据接收回调
-	if c.OnDataReceived != nil {
-		c.OnDataReceived(data)
+// RemoteAddress 返回对端地址
+func (c *Connection) RemoteAddress() stack.FullAddress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return stack.FullAddress{IP: c.id.RemoteAddress, Port: c.id.RemotePort}
+}
+
+// MSS 返回当前发送使用的MSS
+func (c *Connection) MSS() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.effectiveMSSLocked()
+}
+
+// HandlePacket 处理协议栈分发的TCP段
+func (c *Connection) HandlePacket(pkt *stack.Packet) {
+	seg, err := parseSegment(pkt)
+	if err != nil {
+		c.logger.Debug("TCP: dropping segment from %s: %v", net.IP(pkt.IPHeader.SourceIP[:]), err)
+		return
 	}
 
-	return nil
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.logger.Debug("TCP input [%s]: %s, %d bytes", c.state, seg.header, len(seg.payload))
+
+	switch c.state {
+	case StateClosed:
+		sendReset(c.stack, seg)
+	case StateListen:
+		c.handleListenLocked(seg)
+	case StateSynSent:
+		c.handleSynSentLocked(seg)
+	default:
+		c.handleSegmentLocked(seg)
+	}
 }
 
-// Close 关闭连接
-func (c *Connection) Close() error {
+// HandleError 处理协议栈分发的ICMP差错
+func (c *Connection) HandleError(err *stack.TransportError) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+
+	// 原始段的序列号必须在未确认范围内，防止伪造的ICMP（RFC 5927）
+	if len(err.Header) >= 8 {
+		seq := binary.BigEndian.Uint32(err.Header[4:8])
+		if seqLT(seq, c.sndUna) || seqGEQ(seq, c.sndMax) {
+			return
+		}
+	}
+
+	if errors.Is(err, stack.ErrMessageTooLong) {
+		// 路径MTU已由协议栈更新，按新的MSS立即重新分段重传，不视为拥塞（RFC 1191 6.4）
+		if c.sndNxt != c.sndUna {
+			c.logger.Debug("TCP %s: path MTU %d, MSS now %d", c, err.MTU, c.effectiveMSSLocked())
+			c.rewindLocked()
+			c.outputLocked()
+		}
+		return
+	}
+
+	switch c.state {
+	case StateSynSent, StateSynReceived:
+		if err.Hard {
+			c.releaseLocked(err)
+			return
+		}
+	}
+	c.soft = err
+}
+
+// String 返回连接的字符串表示
+func (c *Connection) String() string {
+	return fmt.Sprintf("TCP Connection: %s -> %s [%s]", c.localString(), c.remoteString(), c.state)
+}
+
+func (c *Connection) localString() string {
+	return fmt.Sprintf("%s:%d", net.IP(c.id.LocalAddress[:]), c.id.LocalPort)
+}
+
+func (c *Connection) remoteString() string {
+	return fmt.Sprintf("%s:%d", net.IP(c.id.RemoteAddress[:]), c.id.RemotePort)
+}
+
+// handleListenLocked 处理监听状态下收到的段
+func (c *Connection) handleListenLocked(seg *segment) {
+	switch {
+	case seg.header.HasFlag(FlagRST):
+		return
+	case seg.header.HasFlag(FlagACK):
+		sendReset(c.stack, seg)
+		return
+	case !seg.header.HasFlag(FlagSYN):
+		return
+	}
+
+	if c.pending >= c.backlog {
+		c.logger.Debug("TCP %s: backlog full, dropping SYN", c)
+		return
+	}
+
+	child := NewConnection(c.stack)
+	child.MTUProbing = c.MTUProbing
+	child.ReadBuffered = c.ReadBuffered
+	child.Congestion = c.Congestion
+	child.ReceiveWindow = c.ReceiveWindow
+	child.RetransmitLimit = c.RetransmitLimit
+	child.applyOptionsLocked()
+	child.logger = c.logger
+	child.listener = c
+	child.id = stack.TransportEndpointID{
+		LocalAddress:  seg.dst,
+		LocalPort:     c.id.LocalPort,
+		RemoteAddress: seg.src,
+		RemotePort:    seg.header.SourcePort,
+	}
+	if _, err := c.stack.RegisterTransportEndpoint(ip.ProtocolTCP, child.id, child); err != nil {
+		c.logger.Debug("TCP %s: cannot register child: %v", c, err)
+		return
+	}
+	c.pending++
+
+	child.mu.Lock()
+	defer child.unlock()
+
+	child.registered = true
+	child.iss = rand.Uint32()
+	child.sndUna = child.iss
+	child.sndNxt = child.iss + 1
+	child.sndMax = child.sndNxt
+	child.receiveSynLocked(seg)
+	child.setStateLocked(StateSynReceived)
+	child.sendSynLocked()
+}
+
+// handleSynSentLocked 处理SYN_SENT状态下收到的段（RFC 793 3.9）
+func (c *Connection) handleSynSentLocked(seg *segment) {
+	h := seg.header
+
+	ackAcceptable := false
+	if h.HasFlag(FlagACK) {
+		if seqLEQ(h.Acknowledgment, c.iss) || seqGT(h.Acknowledgment, c.sndNxt) {
+			sendReset(c.stack, seg)
+			return
+		}
+		ackAcceptable = true
+	}
+
+	if h.HasFlag(FlagRST) {
+		if ackAcceptable {
+			c.releaseLocked(stack.ErrConnectionRefused)
+		}
+		return
+	}
+
+	if !h.HasFlag(FlagSYN) {
+		return
+	}
 
-	if c.State == StateClosed {
-		return nil
+	c.receiveSynLocked(seg)
+
+	if !ackAcceptable {
+		// 同时打开
+		c.setStateLocked(StateSynReceived)
+		c.sendSynLocked()
+		return
 	}
 
-	// 发送FIN包（这里应该通过IP层发送）
-	_ = NewHeader(c.LocalPort, c.RemotePort, c.SendSequence, c.ReceiveSequence, FlagFIN|FlagACK, c.SendWindow)
+	c.sndWl1 = h.SequenceNumber
+	c.sndWl2 = h.Acknowledgment
+	c.sndWnd = uint32(h.WindowSize)
+	c.ackLocked(h.Acknowledgment)
+	if c.rttTiming {
+		c.updateRTTLocked(time.Since(c.rttStart))
+		c.rttTiming = false
+	}
+	c.stopTimerLocked()
+	c.establishLocked()
+	c.sendAckLocked()
+	c.outputLocked()
+}
 
-	c.State = StateFinWait1
-	c.logger.LogConnection("FIN_WAIT_1", fmt.Sprintf("%s:%d", net.IP(c.LocalIP[:]), c.LocalPort),
-		fmt.Sprintf("%s:%d", net.IP(c.RemoteIP[:]), c.RemotePort))
+// handleSegmentLocked 处理已同步状态下收到的段（RFC 793 3.9）
+func (c *Connection) handleSegmentLocked(seg *segment) {
+	h := seg.header
+	seq := h.SequenceNumber
 
-	// 简化处理，直接关闭
-	c.State = StateClosed
+	// 1. 检查序列号
+	if !c.acceptableLocked(seq, seg.length()) {
+		if !h.HasFlag(FlagRST) {
+			c.sendAckLocked()
+		}
+		return
+	}
+
+	// 2. 检查RST，序列号不精确匹配时回复挑战ACK（RFC 5961 3）
+	if h.HasFlag(FlagRST) {
+		if seq != c.rcvNxt {
+			c.sendAckLocked()
+			return
+		}
+		switch c.state {
+		case StateSynReceived, StateClosing, StateLastAck, StateTimeWait:
+			c.releaseLocked(nil)
+		default:
+			c.releaseLocked(ErrConnectionReset)
+		}
+		return
+	}
+
+	// 3. 窗口内的SYN回复挑战ACK（RFC 5961 4）
+	if h.HasFlag(FlagSYN) {
+		c.sendAckLocked()
+		return
+	}
+
+	// 4. 检查ACK
+	if !h.HasFlag(FlagACK) {
+		return
+	}
+
+	if c.state == StateSynReceived {
+		if seqLEQ(h.Acknowledgment, c.sndUna) || seqGT(h.Acknowledgment, c.sndNxt) {
+			sendReset(c.stack, seg)
+			return
+		}
+		c.sndWl1 = seq
+		c.sndWl2 = h.Acknowledgment
+		c.sndWnd = uint32(h.WindowSize)
+		c.establishLocked()
+	}
+
+	if !c.processAckLocked(seg) {
+		return
+	}
+
+	if c.finAcked {
+		switch c.state {
+		case StateFinWait1:
+			c.setStateLocked(StateFinWait2)
+		case StateClosing:
+			c.enterTimeWaitLocked()
+			return
+		case StateLastAck:
+			c.releaseLocked(nil)
+			return
+		}
+	}
+
+	if c.state == StateTimeWait {
+		// 对端重传的FIN，重新确认并重启2MSL定时器
+		if h.HasFlag(FlagFIN) {
+			c.sendAckLocked()
+			c.enterTimeWaitLocked()
+		}
+		return
+	}
 
+	// 5. 处理数据和FIN
+	switch c.state {
+	case StateEstablished, StateFinWait1, StateFinWait2:
+		if len(seg.payload) > 0 || h.HasFlag(FlagFIN) {
+			c.receiveLocked(seg)
+		}
+	}
+
+	c.outputLocked()
+}
+
+// acceptableLocked 检查段是否落在接收窗口内（RFC 793 3.3）
+func (c *Connection) acceptableLocked(seq, length uint32) bool {
+	wnd := c.receiveWindowLocked()
+	if length == 0 {
+		if wnd == 0 {
+			return seq == c.rcvNxt
+		}
+		return seqInWindow(seq, c.rcvNxt, wnd)
+	}
+	if wnd == 0 {
+		return false
+	}
+	return seqInWindow(seq, c.rcvNxt, wnd) || seqInWindow(seq+length-1, c.rcvNxt, wnd)
+}
+
+// receiveSynLocked 记录对端的初始序列号和MSS
+func (c *Connection) receiveSynLocked(seg *segment) {
+	c.irs = seg.header.SequenceNumber
+	c.rcvNxt = c.irs + 1
+	if mss, ok := ParseMSSOption(seg.header.Options); ok && mss > 0 {
+		c.peerMSS = mss
+	}
+}
+
+// applyOptionsLocked 按Congestion和ReceiveWindow设置拥塞控制算法和接收窗口
+func (c *Connection) applyOptionsLocked() error {
+	cc, err := newCongestionControl(c.Congestion)
+	if err != nil {
+		return err
+	}
+	if c.ReceiveWindow < 0 || c.ReceiveWindow > DefaultWindowSize {
+		return fmt.Errorf("invalid receive window %d", c.ReceiveWindow)
+	}
+	c.cc = cc
+	c.rcvWnd = DefaultWindowSize
+	if c.ReceiveWindow > 0 {
+		c.rcvWnd = uint32(c.ReceiveWindow)
+	}
 	return nil
 }
 
-// handleSynAck 处理SYN+ACK包
-func (c *Connection) handleSynAck(synHeader *Header) {
-	// 模拟收到SYN+ACK
-	c.ReceiveSequence = synHeader.SequenceNumber + 1
+// establishLocked 进入ESTABLISHED状态
+func (c *Connection) establishLocked() {
+	c.retransmits = 0
+	c.cwnd = uint32(10 * c.effectiveMSSLocked()) // 初始窗口（RFC 6928）
+	c.setStateLocked(StateEstablished)
 
-	// 发送ACK（这里应该通过IP层发送）
-	_ = NewHeader(c.LocalPort, c.RemotePort, c.SendSequence, c.ReceiveSequence, FlagACK, c.SendWindow)
+	if l := c.listener; l != nil {
+		c.events = append(c.events, func() { l.accepted(c) })
+	}
+}
 
-	c.State = StateEstablished
-	c.logger.LogConnection("ESTABLISHED", fmt.Sprintf("%s:%d", net.IP(c.LocalIP[:]), c.LocalPort),
-		fmt.Sprintf("%s:%d", net.IP(c.RemoteIP[:]), c.RemotePort))
+// accepted 半连接完成握手，交给应用
+func (l *Connection) accepted(child *Connection) {
+	l.mu.Lock()
+	l.pending--
+	onAccept := l.OnAccept
+	l.mu.Unlock()
 
-	if c.OnStateChanged != nil {
-		c.OnStateChanged(c.State)
+	if onAccept != nil {
+		onAccept(child)
 	}
 }
 
-// String 返回连接的字符串表示
-func (c *Connection) String() string {
-	return fmt.Sprintf("TCP Connection: %s:%d -> %s:%d [%s]",
-		net.IP(c.LocalIP[:]), c.LocalPort,
-		net.IP(c.RemoteIP[:]), c.RemotePort,
-		c.State)
+// ackLocked 推进sndUna并释放已确认的数据，返回确认的字节数（不含SYN/FIN）
+func (c *Connection) ackLocked(ack uint32) int {
+	acked := int(ack - c.sndUna)
+
+	// SYN占用一个序列号
+	if c.sndUna == c.iss {
+		acked--
+	}
+	if acked > len(c.sndBuf) {
+		// 确认了FIN
+		c.finAcked = true
+		acked = len(c.sndBuf)
+	}
+	c.sndBuf = c.sndBuf[acked:]
+	c.sndUna = ack
+	if seqLT(c.sndNxt, c.sndUna) {
+		c.sndNxt = c.sndUna
+	}
+	return acked
+}
+
+// processAckLocked 处理确认号：释放数据、更新RTT、拥塞窗口和发送窗口
+func (c *Connection) processAckLocked(seg *segment) bool {
+	h := seg.header
+	ack := h.Acknowledgment
+	mss := uint32(c.effectiveMSSLocked())
+
+	if seqGT(ack, c.sndMax) {
+		c.sendAckLocked()
+		return false
+	}
+	// 对端仍在响应，即使窗口仍为零
+	c.persistProbes = 0
+
+	windowUpdate := seqLT(c.sndWl1, h.SequenceNumber) ||
+		(c.sndWl1 == h.SequenceNumber && seqLEQ(c.sndWl2, ack))
+
+	switch {
+	case seqGT(ack, c.sndUna):
+		acked := uint32(c.ackLocked(ack))
+		if acked > 0 {
+			c.events = append(c.events, func() {
+				if c.OnDataAcked != nil {
+					c.OnDataAcked()
+				}
+			})
+		}
+
+		if c.rttTiming && seqGEQ(ack, c.rttSeq) {
+			c.updateRTTLocked(time.Since(c.rttStart))
+			c.rttTiming = false
+		}
+		if c.probing && seqGEQ(ack, c.probeSeq+uint32(c.probeSize)) {
+			c.probeSucceededLocked()
+		}
+		c.retransmits = 0
+		c.soft = nil
+		c.dupAcks = 0
+
+		switch {
+		case c.inRecovery && seqGEQ(ack, c.recover):
+			c.inRecovery = false
+			c.cwnd = c.ssthresh
+		case c.inRecovery:
+			// 部分确认，重传下一个丢失的段（RFC 6582）
+			c.retransmitFirstLocked()
+		default:
+			c.cc.onAck(c, acked, mss)
+		}
+
+		if c.sndUna == c.sndNxt {
+			c.stopTimerLocked()
+		} else {
+			c.resetTimerLocked(c.rto)
+		}
+
+	case ack == c.sndUna && len(seg.payload) == 0 && !h.HasFlag(FlagFIN) &&
+		uint32(h.WindowSize) == c.sndWnd && c.sndNxt != c.sndUna:
+		// 重复ACK，三次后快速重传（RFC 5681 3.2）
+		c.dupAcks++
+		switch {
+		case c.dupAcks == 3 && !c.inRecovery && c.probeLostLocked():
+			// 丢失的是探测段，不视为拥塞：按原MSS重传，恢复结束后cwnd不变（RFC 4821 7.6.2）
+			c.ssthresh = c.cwnd
+			c.inRecovery = true
+			c.recover = c.sndMax
+			c.retransmitFirstLocked()
+		case c.dupAcks == 3 && !c.inRecovery:
+			c.ssthresh = c.cc.onLoss(c, mss)
+			c.cwnd = c.ssthresh + 3*mss
+			c.inRecovery = true
+			c.recover = c.sndMax
+			c.retransmitFirstLocked()
+		case c.dupAcks > 3:
+			c.cwnd += mss
+		}
+	}
+
+	if windowUpdate {
+		c.sndWnd = uint32(h.WindowSize)
+		c.sndWl1 = h.SequenceNumber
+		c.sndWl2 = ack
+		if c.persisting && c.sndWnd > 0 {
+			// 窗口打开，由随后的输出恢复发送并启动重传定时器
+			c.persisting = false
+			c.stopTimerLocked()
+		}
+	}
+	return true
+}
+
+// receiveLocked 接收数据和FIN，乱序段暂存等待缺口填补
+func (c *Connection) receiveLocked(seg *segment) {
+	if seqGT(seg.header.SequenceNumber, c.rcvNxt) {
+		if c.oooBytes+len(seg.payload) <= int(c.rcvWnd) {
+			c.ooo = append(c.ooo, seg)
+			c.oooBytes += len(seg.payload)
+		}
+		// 立即发送重复ACK，触发对端快速重传
+		c.sendAckLocked()
+		return
+	}
+
+	c.deliverLocked(seg)
+
+	// 填补缺口后交付暂存的乱序段
+	for progress := true; progress && len(c.ooo) > 0; {
+		progress = false
+		for i, s := range c.ooo {
+			if seqGT(s.header.SequenceNumber, c.rcvNxt) {
+				continue
+			}
+			c.ooo = append(c.ooo[:i], c.ooo[i+1:]...)
+			c.oooBytes -= len(s.payload)
+			c.deliverLocked(s)
+			progress = true
+			break
+		}
+	}
+
+	c.sendAckLocked()
+}
+
+// deliverLocked 交付从rcvNxt开始的数据，并处理紧随其后的FIN
+func (c *Connection) deliverLocked(seg *segment) {
+	payload := seg.payload
+	seq := seg.header.SequenceNumber
+
+	// 去掉已接收的部分
+	if skip := c.rcvNxt - seq; seqLT(seq, c.rcvNxt) {
+		if int(skip) >= len(payload) {
+			payload = nil
+		} else {
+			payload = payload[skip:]
+		}
+		seq = c.rcvNxt
+	}
+
+	// 丢弃超出接收窗口的部分，FIN随之丢弃
+	fin := seg.header.HasFlag(FlagFIN)
+	if wnd := int(c.receiveWindowLocked()); len(payload) > wnd {
+		payload = payload[:wnd]
+		fin = false
+	}
+
+	if len(payload) > 0 {
+		c.rcvNxt += uint32(len(payload))
+		if c.ReadBuffered {
+			c.rcvUnread += len(payload)
+		}
+		data := payload
+		c.events = append(c.events, func() {
+			if c.OnDataReceived != nil {
+				c.OnDataReceived(data)
+			}
+		})
+		c.logger.LogPacket("RECV", "TCP", c.remoteString(), c.localString(), len(data))
+	}
+
+	if !fin || seq+uint32(len(payload)) != c.rcvNxt {
+		return
+	}
+
+	c.rcvNxt++
+	switch c.state {
+	case StateEstablished:
+		c.setStateLocked(StateCloseWait)
+	case StateFinWait1:
+		if c.finAcked {
+			c.enterTimeWaitLocked()
+		} else {
+			c.setStateLocked(StateClosing)
+		}
+	case StateFinWait2:
+		c.enterTimeWaitLocked()
+	}
+}
+
+// receiveWindowLocked 返回通告的接收窗口，扣除应用尚未读取的数据
+func (c *Connection) receiveWindowLocked() uint32 {
+	if c.rcvUnread >= int(c.rcvWnd) {
+		return 0
+	}
+	return c.rcvWnd - uint32(c.rcvUnread)
+}
+
+// effectiveMSSLocked 返回发送使用的MSS：路径MSS与分组层探测确认的MSS中的较小值
+func (c *Connection) effectiveMSSLocked() int {
+	mss := c.pathMSSLocked()
+	if c.probeMSS > 0 && c.probeMSS < mss {
+		mss = c.probeMSS
+	}
+	return mss
+}
+
+// pathMSSLocked 返回对端通告的MSS与路径MTU允许的MSS中的较小值
+func (c *Connection) pathMSSLocked() int {
+	mss := int(c.peerMSS)
+	if _, err := c.routeLocked(); err == nil && c.routeMSS < mss {
+		mss = c.routeMSS
+	}
+	return mss
+}
+
+// outputLocked 在发送窗口和拥塞窗口允许的范围内发送缓冲区中的数据和FIN
+func (c *Connection) outputLocked() {
+	switch c.state {
+	case StateEstablished, StateCloseWait, StateFinWait1, StateClosing, StateLastAck:
+	default:
+		return
+	}
+
+	mss := c.effectiveMSSLocked()
+	for {
+		inFlight := int(c.sndNxt - c.sndUna)
+		unsent := len(c.sndBuf) - inFlight
+		if unsent <= 0 {
+			break
+		}
+
+		window := int(min(c.sndWnd, c.cwnd))
+		avail := window - inFlight
+		if avail <= 0 {
+			// 零窗口且无在途数据时启动坚持定时器
+			if c.sndWnd == 0 && inFlight == 0 && !c.persisting {
+				c.persisting = true
+				c.persistTimeout = c.rto
+				c.resetTimerLocked(c.persistTimeout)
+			}
+			return
+		}
+
+		if size := c.probeSizeLocked(mss, unsent, avail); size > 0 {
+			c.probing = true
+			c.probeSeq = c.sndNxt
+			c.probeSize = size
+			c.logger.Debug("TCP %s: probing MSS %d", c, size)
+			c.sendDataLocked(size)
+			continue
+		}
+
+		n := min(mss, unsent, avail)
+		// 避免糊涂窗口综合征：有在途数据时不发送小于MSS的段（RFC 1122 4.2.3.4）
+		if n < mss && n < unsent && inFlight > 0 {
+			return
+		}
+		c.sendDataLocked(n)
+	}
+
+	if c.finQueued && !c.finSent && int(c.sndNxt-c.sndUna) == len(c.sndBuf) {
+		c.sendSegmentLocked(c.sndNxt, FlagFIN|FlagACK, nil)
+		c.finSent = true
+		c.sndNxt++
+		c.sndMax = seqMax(c.sndMax, c.sndNxt)
+		c.startTimerLocked()
+	}
+}
+
+// sendDataLocked 从sndNxt开始发送n字节数据
+func (c *Connection) sendDataLocked(n int) {
+	offset := int(c.sndNxt - c.sndUna)
+	flags := uint8(FlagACK)
+	if offset+n == len(c.sndBuf) {
+		flags |= FlagPSH
+	}
+
+	if !c.rttTiming && c.retransmits == 0 {
+		c.rttTiming = true
+		c.rttSeq = c.sndNxt + uint32(n)
+		c.rttStart = time.Now()
+	}
+
+	c.sendSegmentLocked(c.sndNxt, flags, c.sndBuf[offset:offset+n])
+	c.sndNxt += uint32(n)
+	c.sndMax = seqMax(c.sndMax, c.sndNxt)
+	c.startTimerLocked()
+}
+
+// retransmitFirstLocked 重传sndUna处的一个段，该段是未确认的探测段时视为探测失败
+func (c *Connection) retransmitFirstLocked() {
+	if c.probeLostLocked() {
+		c.probeFailedLocked()
+	}
+	n := min(c.effectiveMSSLocked(), len(c.sndBuf))
+	if n > 0 {
+		c.sendSegmentLocked(c.sndUna, FlagACK, c.sndBuf[:n])
+	} else if c.finSent {
+		c.sendSegmentLocked(c.sndUna, FlagFIN|FlagACK, nil)
+	}
+	c.rttTiming = false
+}
+
+// rewindLocked 将sndNxt退回sndUna，之后的输出按当前MSS重新分段发送
+//
+// 未确认的探测段随之重新分段，探测没有结论。
+func (c *Connection) rewindLocked() {
+	c.sndNxt = c.sndUna
+	c.finSent = false
+	c.rttTiming = false
+	c.probing = false
+}
+
+// sendSynLocked 发送SYN或SYN+ACK，携带MSS选项
+func (c *Connection) sendSynLocked() {
+	flags := uint8(FlagSYN)
+	if c.state == StateSynReceived {
+		flags |= FlagACK
+	}
+	// 未重传的SYN可用于RTT采样（RFC 6298 3）
+	c.rttTiming = c.retransmits == 0
+	c.rttSeq = c.iss + 1
+	c.rttStart = time.Now()
+
+	c.sendSegmentLocked(c.iss, flags, nil)
+	c.startTimerLocked()
+}
+
+// sendAckLocked 发送纯ACK
+func (c *Connection) sendAckLocked() {
+	c.sendSegmentLocked(c.sndNxt, FlagACK, nil)
+}
+
+// routeLocked 返回到对端的路由，只在缓存失效时重新查找，每个段的发送不访问全局路由状态
+func (c *Connection) routeLocked() (*stack.RouteInfo, error) {
+	if c.route != nil && c.stack.RouteValid(c.route) {
+		return c.route, nil
+	}
+
+	r, err := c.stack.FindRoute(c.id.RemoteAddress)
+	if err != nil {
+		c.route = nil
+		return nil, err
+	}
+	r.LocalIP = c.id.LocalAddress
+	c.route = r
+	c.routeMSS = r.MTU() - ip.IPHeaderLength - TCPHeaderLength
+	return r, nil
+}
+
+// sendSegmentLocked 构造并发送一个段，所有段都设置DF以发现路径MTU
+func (c *Connection) sendSegmentLocked(seq uint32, flags uint8, payload []byte) {
+	ack := c.rcvNxt
+	if flags&FlagACK == 0 {
+		ack = 0
+	}
+	wnd := c.receiveWindowLocked()
+	h := NewHeader(c.id.LocalPort, c.id.RemotePort, seq, ack, flags, uint16(wnd))
+	if flags&FlagACK != 0 {
+		c.rcvAdvEdge = c.rcvNxt + wnd
+	}
+
+	r, err := c.routeLocked()
+	if err != nil {
+		c.logger.Debug("TCP %s: %v", c, err)
+		return
+	}
+
+	if flags&FlagSYN != 0 {
+		h.Options = MSSOption(uint16(r.NIC.MTU() - ip.IPHeaderLength - TCPHeaderLength))
+	}
+
+	data, err := h.MarshalSegment(payload, r.LocalIP, r.RemoteIP)
+	if err != nil {
+		return
+	}
+	if err := c.stack.WritePacket(r, ip.ProtocolTCP, data, stack.WriteOptions{DontFragment: true}); err != nil {
+		c.logger.Debug("TCP %s: failed to send segment: %v", c, err)
+	}
+}
+
+// updateRTTLocked 根据RTT样本更新重传超时（RFC 6298 2）
+func (c *Connection) updateRTTLocked(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		delta := c.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+
+	c.rto = c.srtt + max(4*c.rttvar, time.Millisecond)
+	c.rto = min(max(c.rto, MinRetransmitTimeout), MaxRetransmitTimeout)
+}
+
+// startTimerLocked 重传定时器未运行时启动
+func (c *Connection) startTimerLocked() {
+	if c.timer == nil {
+		c.resetTimerLocked(c.rto)
+	}
+}
+
+// resetTimerLocked 重启定时器
+func (c *Connection) resetTimerLocked(d time.Duration) {
+	c.stopTimerLocked()
+	c.timerGen++
+	gen := c.timerGen
+	c.timer = time.AfterFunc(d, func() { c.handleTimer(gen) })
+}
+
+// stopTimerLocked 停止定时器
+func (c *Connection) stopTimerLocked() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// handleTimer 处理重传、坚持和TIME_WAIT定时器到期
+func (c *Connection) handleTimer(gen uint64) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if gen != c.timerGen {
+		return
+	}
+	c.timer = nil
+
+	switch c.state {
+	case StateTimeWait:
+		c.releaseLocked(nil)
+		return
+	case StateClosed, StateListen:
+		return
+	}
+
+	if c.persisting {
+		c.probeWindowLocked()
+		return
+	}
+	if c.sndNxt == c.sndUna {
+		return
+	}
+	if c.probeLostLocked() {
+		// 超时的是探测段：不退避、不计入重传，按原MSS重新发送在途数据，cwnd不变（RFC 4821 7.6.2）
+		c.probeFailedLocked()
+		c.rewindLocked()
+		c.outputLocked()
+		c.startTimerLocked()
+		return
+	}
+
+	c.retransmits++
+	limit := c.retransmitLimit()
+	if c.state == StateSynSent || c.state == StateSynReceived {
+		limit = MaxSynRetransmits
+	}
+	if c.retransmits > limit {
+		err := error(ErrTimeout)
+		if c.soft != nil {
+			err = c.soft
+		}
+		if c.state == StateSynReceived {
+			err = nil
+		}
+		c.releaseLocked(err)
+		return
+	}
+
+	c.rto = min(c.rto*2, MaxRetransmitTimeout)
+	c.rttTiming = false
+
+	if c.state == StateSynSent || c.state == StateSynReceived {
+		c.sendSynLocked()
+		return
+	}
+
+	c.detectBlackHoleLocked()
+
+	// 超时后重新慢启动并从sndUna开始重传（RFC 5681 3.1）
+	mss := uint32(c.effectiveMSSLocked())
+	c.ssthresh = c.cc.onLoss(c, mss)
+	c.cwnd = mss
+	c.inRecovery = false
+	c.dupAcks = 0
+	c.rewindLocked()
+	c.outputLocked()
+	c.startTimerLocked()
+}
+
+// probeWindowLocked 坚持定时器到期，探测对端的零窗口
+//
+// 探测段的序列号为sndUna-1且不带数据，不占用新的序列号，对端总会回复携带当前窗口的ACK（同Linux）。
+// 对端持续回复零窗口时连接一直保持；连续retransmitLimit次探测都没有回复时放弃连接。
+func (c *Connection) probeWindowLocked() {
+	c.persistProbes++
+	if c.persistProbes > c.retransmitLimit() {
+		c.releaseLocked(ErrTimeout)
+		return
+	}
+
+	c.sendSegmentLocked(c.sndUna-1, FlagACK, nil)
+	c.persistTimeout = min(c.persistTimeout*2, MaxRetransmitTimeout)
+	c.resetTimerLocked(c.persistTimeout)
+}
+
+// retransmitLimit 返回放弃连接前的最大重传次数
+func (c *Connection) retransmitLimit() int {
+	if c.RetransmitLimit > 0 {
+		return c.RetransmitLimit
+	}
+	return MaxRetransmits
+}
+
+// detectBlackHoleLocked 连续超时且段大于BaseMSS时怀疑路径丢弃大包且不回复ICMP，减半MSS（RFC 4821）
+//
+// 之后由probeSizeLocked在减半后的MSS与丢失的段大小之间探测，找回路径实际允许的MSS。
+func (c *Connection) detectBlackHoleLocked() {
+	if !c.MTUProbing || c.retransmits < BlackHoleRetransmits {
+		return
+	}
+
+	mss := c.effectiveMSSLocked()
+	if mss <= BaseMSS || len(c.sndBuf) <= BaseMSS {
+		return
+	}
+
+	c.probeMSS = max(mss/2, BaseMSS)
+	c.probeHigh = mss
+	c.probeNext = time.Time{}
+	c.logger.Debug("TCP %s: suspected PMTU black hole, MSS reduced to %d", c, c.probeMSS)
+}
+
+// probeSizeLocked 返回现在应发送的探测段大小，不需要探测时返回0
+//
+// 只在黑洞检测降低了MSS、没有未确认的探测段、不在快速恢复中，且待发送的数据和窗口都容得下探测段时探测。
+// 探测大小取搜索范围的中点；范围小于MTUProbeThreshold时搜索结束，MTUProbeInterval后再向路径MSS探测。
+func (c *Connection) probeSizeLocked(mss, unsent, avail int) int {
+	if !c.MTUProbing || c.probeMSS == 0 || c.probing || c.inRecovery || c.dupAcks > 0 || c.state != StateEstablished {
+		return 0
+	}
+	// 超时后重新发送已发出的数据时不探测，对端可能已经收到这些数据
+	if c.sndNxt != c.sndMax {
+		return 0
+	}
+	if time.Now().Before(c.probeNext) {
+		return 0
+	}
+
+	high := min(c.probeHigh, c.pathMSSLocked()+1)
+	if high-mss < MTUProbeThreshold {
+		c.probeHigh = c.pathMSSLocked() + 1
+		c.probeNext = time.Now().Add(MTUProbeInterval)
+		return 0
+	}
+
+	size := (mss + high) / 2
+	if size > unsent || size > avail {
+		return 0
+	}
+	return size
+}
+
+// probeLostLocked 检查未确认的数据是否从探测段开始，此时重传或超时说明探测段丢失
+func (c *Connection) probeLostLocked() bool {
+	return c.probing && seqGEQ(c.sndUna, c.probeSeq)
+}
+
+// probeSucceededLocked 探测段被确认，提高MSS；达到路径MSS时不再限制
+func (c *Connection) probeSucceededLocked() {
+	c.probing = false
+	c.probeMSS = c.probeSize
+	if c.probeMSS >= c.pathMSSLocked() {
+		c.probeMSS = 0
+	}
+	c.logger.Debug("TCP %s: MSS probe of %d succeeded", c, c.probeSize)
+}
+
+// probeFailedLocked 探测段丢失，缩小搜索范围
+func (c *Connection) probeFailedLocked() {
+	c.probing = false
+	c.probeHigh = c.probeSize
+	c.logger.Debug("TCP %s: MSS probe of %d lost", c, c.probeSize)
+}
+
+// enterTimeWaitLocked 进入TIME_WAIT，2MSL后释放四元组
+func (c *Connection) enterTimeWaitLocked() {
+	c.setStateLocked(StateTimeWait)
+	c.resetTimerLocked(TimeWaitTimeout)
+}
+
+// releaseLocked 关闭连接并注销四元组
+func (c *Connection) releaseLocked(err error) {
+	if c.state == StateClosed {
+		return
+	}
+
+	c.stopTimerLocked()
+	c.timerGen++
+	if c.registered {
+		c.stack.UnregisterTransportEndpoint(ip.ProtocolTCP, c.id, c)
+		c.registered = false
+	}
+
+	if l := c.listener; l != nil && (c.state == StateSynReceived) {
+		c.events = append(c.events, func() {
+			l.mu.Lock()
+			l.pending--
+			l.mu.Unlock()
+		})
+	}
+
+	c.err = err
+	c.sndBuf = nil
+	c.ooo = nil
+	c.oooBytes = 0
+	c.setStateLocked(StateClosed)
+}
+
+// setStateLocked 切换状态并通知回调
+func (c *Connection) setStateLocked(state string) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	c.timeWait.Store(state == StateTimeWait)
+	c.logger.LogConnection(state, c.localString(), c.remoteString())
+
+	c.events = append(c.events, func() {
+		if c.OnStateChanged != nil {
+			c.OnStateChanged(state)
+		}
+	})
+}
+
+// unlock 释放连接锁并执行期间产生的回调
+func (c *Connection) unlock() {
+	events := c.events
+	c.events = nil
+	c.mu.Unlock()
+
+	for _, event := range events {
+		event()
+	}
 }
@@ -2,6 +2,7 @@ package tcp
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"ustack/internal/utils"
 )
@@ -19,6 +20,9 @@ const (
 	FlagURG = 0x20
 )
 
+// ErrBadChecksum 校验和错误
+var ErrBadChecksum = errors.New("TCP checksum mismatch")
+
 // Header TCP头部结构
 type Header struct {
 	SourcePort      uint16 // 源端口
@@ -57,7 +61,8 @@ func (h *Header) Marshal() ([]byte, error) {
 
 	// 数据偏移和标志
 	dataOffset := uint8(headerLength / 4) // 以4字节为单位
-	data[12] = (dataOffset << 4) | (h.Flags & 0x3F)
+	data[12] = dataOffset << 4
+	data[13] = h.Flags & 0x3F
 
 	// 窗口大小
 	binary.BigEndian.PutUint16(data[14:16], h.WindowSize)
@@ -100,7 +105,7 @@ func (h *Header) Unmarshal(data []byte) error {
 
 	// 数据偏移和标志
 	h.DataOffset = data[12] >> 4
-	h.Flags = data[12] & 0x3F
+	h.Flags = data[13] & 0x3F
 
 	// 窗口大小
 	h.WindowSize = binary.BigEndian.Uint16(data[14:16])
@@ -112,15 +117,54 @@ func (h *Header) Unmarshal(data []byte) error {
 	h.UrgentPointer = binary.BigEndian.Uint16(data[18:20])
 
 	// 选项
-	optionsLength := int(h.DataOffset)*4 - TCPHeaderLength
-	if optionsLength > 0 && len(data) >= TCPHeaderLength+optionsLength {
-		h.Options = make([]byte, optionsLength)
-		copy(h.Options, data[20:20+optionsLength])
+	headerLength := h.HeaderLength()
+	if headerLength < TCPHeaderLength || headerLength > len(data) {
+		return fmt.Errorf("invalid TCP data offset: %d", h.DataOffset)
+	}
+	h.Options = nil
+	if headerLength > TCPHeaderLength {
+		h.Options = make([]byte, headerLength-TCPHeaderLength)
+		copy(h.Options, data[TCPHeaderLength:headerLength])
 	}
 
 	return nil
 }
 
+// HeaderLength 返回头部长度（含选项）
+func (h *Header) HeaderLength() int {
+	return int(h.DataOffset) * 4
+}
+
+// MarshalSegment 将头部和数据序列化为完整的TCP段，校验和包含IPv4伪头部
+func (h *Header) MarshalSegment(payload []byte, srcIP, dstIP [4]byte) ([]byte, error) {
+	header, err := h.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	h.DataOffset = uint8(len(header) / 4)
+
+	segment := make([]byte, len(header)+len(payload))
+	copy(segment, header)
+	copy(segment[len(header):], payload)
+
+	binary.BigEndian.PutUint16(segment[16:18], 0)
+	h.Checksum = utils.CalculateTCPChecksum(segment, nil, srcIP[:], dstIP[:])
+	binary.BigEndian.PutUint16(segment[16:18], h.Checksum)
+
+	return segment, nil
+}
+
+// VerifyChecksum 校验包含IPv4伪头部的TCP校验和
+func VerifyChecksum(segment []byte, srcIP, dstIP [4]byte) error {
+	if len(segment) < TCPHeaderLength {
+		return fmt.Errorf("TCP segment too short: %d bytes", len(segment))
+	}
+	if utils.CalculateTCPChecksum(segment, nil, srcIP[:], dstIP[:]) != 0 {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
 // String 返回TCP头部的字符串表示
 func (h *Header) String() string {
 	flags := ""
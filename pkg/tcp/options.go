@@ -0,0 +1,53 @@
+package tcp
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// TCP选项类型
+	OptionEnd = 0
+	OptionNOP = 1
+	OptionMSS = 2
+
+	// MSS选项长度
+	mssOptionLength = 4
+
+	// DefaultMSS 对端未通告MSS时使用的默认值（RFC 1122 4.2.2.6）
+	DefaultMSS = 536
+)
+
+// MSSOption 构造MSS选项
+func MSSOption(mss uint16) []byte {
+	option := make([]byte, mssOptionLength)
+	option[0] = OptionMSS
+	option[1] = mssOptionLength
+	binary.BigEndian.PutUint16(option[2:4], mss)
+	return option
+}
+
+// ParseMSSOption 从选项中解析MSS，未携带时返回false
+func ParseMSSOption(options []byte) (uint16, bool) {
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case OptionEnd:
+			return 0, false
+		case OptionNOP:
+			i++
+			continue
+		}
+
+		if i+1 >= len(options) {
+			return 0, false
+		}
+		length := int(options[i+1])
+		if length < 2 || i+length > len(options) {
+			return 0, false
+		}
+		if options[i] == OptionMSS && length == mssOptionLength {
+			return binary.BigEndian.Uint16(options[i+2 : i+4]), true
+		}
+		i += length
+	}
+	return 0, false
+}
@@ -0,0 +1,109 @@
+package tcp
+
+import (
+	"net"
+	"ustack/internal/utils"
+	"ustack/pkg/ip"
+	"ustack/pkg/stack"
+)
+
+// segment 收到的TCP段
+type segment struct {
+	header  *Header
+	payload []byte
+	src     [4]byte
+	dst     [4]byte
+}
+
+// parseSegment 校验并解析协议栈分发的TCP段
+func parseSegment(pkt *stack.Packet) (*segment, error) {
+	h := pkt.IPHeader
+	if err := VerifyChecksum(pkt.Payload, h.SourceIP, h.DestinationIP); err != nil {
+		return nil, err
+	}
+
+	header := &Header{}
+	if err := header.Unmarshal(pkt.Payload); err != nil {
+		return nil, err
+	}
+
+	return &segment{
+		header:  header,
+		payload: pkt.Payload[header.HeaderLength():],
+		src:     h.SourceIP,
+		dst:     h.DestinationIP,
+	}, nil
+}
+
+// length 返回段占用的序列号空间（数据 + SYN + FIN）
+func (s *segment) length() uint32 {
+	n := uint32(len(s.payload))
+	if s.header.HasFlag(FlagSYN) {
+		n++
+	}
+	if s.header.HasFlag(FlagFIN) {
+		n++
+	}
+	return n
+}
+
+// 序列号比较（RFC 793 3.3，按模2^32比较）
+func seqLT(a, b uint32) bool  { return int32(a-b) < 0 }
+func seqLEQ(a, b uint32) bool { return int32(a-b) <= 0 }
+func seqGT(a, b uint32) bool  { return int32(a-b) > 0 }
+func seqGEQ(a, b uint32) bool { return int32(a-b) >= 0 }
+
+// seqMax 返回两个序列号中较大的一个
+func seqMax(a, b uint32) uint32 {
+	if seqGT(a, b) {
+		return a
+	}
+	return b
+}
+
+// seqInWindow 检查seq是否在[start, start+size)内
+func seqInWindow(seq, start, size uint32) bool {
+	return seq-start < size
+}
+
+// sendReset 为无法处理的段回复RST（RFC 793 3.4）
+func sendReset(s *stack.Stack, seg *segment) {
+	if seg.header.HasFlag(FlagRST) {
+		return
+	}
+
+	var reply *Header
+	if seg.header.HasFlag(FlagACK) {
+		reply = NewHeader(seg.header.DestinationPort, seg.header.SourcePort, seg.header.Acknowledgment, 0, FlagRST, 0)
+	} else {
+		reply = NewHeader(seg.header.DestinationPort, seg.header.SourcePort, 0,
+			seg.header.SequenceNumber+seg.length(), FlagRST|FlagACK, 0)
+	}
+
+	r, err := s.FindRoute(seg.src)
+	if err != nil {
+		return
+	}
+	r.LocalIP = seg.dst
+
+	data, err := reply.MarshalSegment(nil, r.LocalIP, r.RemoteIP)
+	if err != nil {
+		return
+	}
+	if err := s.WritePacket(r, ip.ProtocolTCP, data, stack.WriteOptions{DontFragment: true}); err != nil {
+		utils.DefaultLogger.Debug("TCP: failed to send RST to %s: %v", net.IP(seg.src[:]), err)
+	}
+}
+
+func init() {
+	stack.RegisterUnknownDestinationHandler(ip.ProtocolTCP, handleUnknownDestination)
+}
+
+// handleUnknownDestination 对发往未监听端口的段回复RST
+func handleUnknownDestination(s *stack.Stack, pkt *stack.Packet) {
+	seg, err := parseSegment(pkt)
+	if err != nil {
+		return
+	}
+	sendReset(s, seg)
+}
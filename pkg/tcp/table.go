@@ -0,0 +1,61 @@
+package tcp
+
+import (
+	"sort"
+	"ustack/pkg/ip"
+	"ustack/pkg/stack"
+)
+
+// ConnectionInfo 连接表中一条连接的快照（同ss/netstat的输出）
+type ConnectionInfo struct {
+	ID         stack.TransportEndpointID // 四元组，监听的远端为零值
+	State      string                    // 连接状态
+	SendQueued int                       // 发送缓冲区中未被确认的字节数
+	RecvQueued int                       // 已交付但应用尚未读取的字节数（ReadBuffered）
+}
+
+// RangeConnections 遍历协议栈上的所有TCP连接（含监听），fn返回false时停止
+//
+// 连接表即协议栈的TCP端点分发表：按四元组哈希分片加锁，每个收到的段先按完整四元组查找，
+// 未命中时回退到监听的通配表项。遍历逐个分片进行，不阻塞收发，期间建立或关闭的连接不保证可见。
+func RangeConnections(s *stack.Stack, fn func(*Connection) bool) {
+	s.RangeTransportEndpoints(ip.ProtocolTCP, func(_ stack.TransportEndpointID, ep stack.TransportEndpoint) bool {
+		c, ok := ep.(*Connection)
+		if !ok {
+			return true
+		}
+		return fn(c)
+	})
+}
+
+// Connections 返回协议栈上所有TCP连接的快照，按本地端口、状态和四元组排序，用于诊断
+func Connections(s *stack.Stack) []ConnectionInfo {
+	var infos []ConnectionInfo
+	RangeConnections(s, func(c *Connection) bool {
+		infos = append(infos, c.Info())
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.ID.LocalPort != b.ID.LocalPort {
+			return a.ID.LocalPort < b.ID.LocalPort
+		}
+		if a.State != b.State {
+			return a.State < b.State
+		}
+		return a.ID.String() < b.ID.String()
+	})
+	return infos
+}
+
+// Info 返回连接的快照
+func (c *Connection) Info() ConnectionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnectionInfo{
+		ID:         c.id,
+		State:      c.state,
+		SendQueued: len(c.sndBuf),
+		RecvQueued: c.rcvUnread,
+	}
+}
@@ -0,0 +1,333 @@
+package traceroute
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ping"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+const (
+	// 默认参数（同Linux traceroute）
+	DefaultMaxHops = 30
+	DefaultProbes  = 3
+	DefaultTimeout = 5 * time.Second
+	DefaultPort    = 33434
+
+	// 探测报文的数据长度
+	probeDataLength = 32
+)
+
+// Method 探测方式
+type Method string
+
+const (
+	MethodUDP  Method = "udp"  // 发往递增的高端口的UDP数据报，以端口不可达结束
+	MethodICMP Method = "icmp" // ICMP Echo Request，以Echo Reply结束
+)
+
+// Probe 单次探测的结果
+type Probe struct {
+	From    [4]byte       // 回复方地址
+	RTT     time.Duration // 往返时间
+	Timeout bool          // 超时未收到回复
+	Type    uint8         // 回复的ICMP类型
+	Code    uint8         // 回复的ICMP代码
+
+	// 不可达标注（同traceroute：!H !N !P !F-<mtu> !X等），到达目标时为空
+	Annotation string
+}
+
+// Hop 一跳的探测结果
+type Hop struct {
+	TTL    int
+	Probes []Probe
+}
+
+// String 返回与traceroute -n相同格式的一行
+func (h *Hop) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%2d ", h.TTL)
+
+	var last [4]byte
+	printed := false
+	for _, p := range h.Probes {
+		if p.Timeout {
+			b.WriteString(" *")
+			continue
+		}
+		if !printed || p.From != last {
+			fmt.Fprintf(&b, " %s", net.IP(p.From[:]))
+			last = p.From
+			printed = true
+		}
+		fmt.Fprintf(&b, "  %.3f ms", float64(p.RTT)/float64(time.Millisecond))
+		if p.Annotation != "" {
+			b.WriteString(" " + p.Annotation)
+		}
+	}
+	return b.String()
+}
+
+// Tracer 按递增的TTL发送探测报文，根据ICMP超时和不可达报文列出路径上的路由器
+type Tracer struct {
+	Method   Method        // 探测方式，默认MethodUDP
+	FirstTTL int           // 起始TTL，0表示1
+	MaxHops  int           // 最大跳数，0表示DefaultMaxHops
+	Probes   int           // 每跳探测次数，0表示DefaultProbes
+	Timeout  time.Duration // 每次探测的等待时间，0表示DefaultTimeout
+	Port     uint16        // UDP探测的起始目标端口，0表示DefaultPort
+
+	// OnHop 每完成一跳时调用（在Run的goroutine中）
+	OnHop func(h *Hop)
+
+	stack *stack.Stack
+}
+
+// NewTracer 创建使用默认参数的Tracer
+func NewTracer(s *stack.Stack) *Tracer {
+	return &Tracer{
+		Method:  MethodUDP,
+		MaxHops: DefaultMaxHops,
+		Probes:  DefaultProbes,
+		Timeout: DefaultTimeout,
+		Port:    DefaultPort,
+		stack:   s,
+	}
+}
+
+// response 归一化后的探测回复
+type response struct {
+	from   [4]byte
+	typ    uint8
+	code   uint8
+	mtu    uint16
+	at     time.Time
+	reply  bool // 目标的直接回复（Echo Reply或UDP数据报）
+	target bool // 是否来自目标
+}
+
+// prober 一种探测方式
+type prober interface {
+	// send 以指定TTL发送第seq个探测报文，返回用于匹配回复的键
+	send(seq int, ttl uint8) (uint16, error)
+	// wait 等待与key匹配的回复
+	wait(ctx context.Context, key uint16, timeout time.Duration) (*response, bool)
+	close()
+}
+
+// Run 探测到dst的路径，直到到达目标、收到不可达、达到最大跳数或ctx取消
+func (t *Tracer) Run(ctx context.Context, dst [4]byte) ([]Hop, error) {
+	maxHops := t.MaxHops
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+	probes := t.Probes
+	if probes <= 0 {
+		probes = DefaultProbes
+	}
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	first := t.FirstTTL
+	if first <= 0 {
+		first = 1
+	}
+	if first > maxHops || maxHops > 255 {
+		return nil, fmt.Errorf("invalid TTL range: %d-%d", first, maxHops)
+	}
+
+	p, err := t.newProber(dst)
+	if err != nil {
+		return nil, err
+	}
+	defer p.close()
+
+	var hops []Hop
+	seq := 0
+	for ttl := first; ttl <= maxHops; ttl++ {
+		hop := Hop{TTL: ttl}
+		done := false
+
+		for i := 0; i < probes; i++ {
+			sent := time.Now()
+			key, err := p.send(seq, uint8(ttl))
+			seq++
+			if err != nil {
+				return hops, err
+			}
+
+			r, ok := p.wait(ctx, key, timeout)
+			if ctx.Err() != nil {
+				return hops, nil
+			}
+			if !ok {
+				hop.Probes = append(hop.Probes, Probe{Timeout: true})
+				continue
+			}
+
+			probe := Probe{From: r.from, RTT: r.at.Sub(sent), Type: r.typ, Code: r.code}
+			if !r.reply {
+				probe.Annotation = annotation(r)
+			}
+			hop.Probes = append(hop.Probes, probe)
+
+			// 到达目标或收到不可达时结束（超时报文表示还未到达）
+			if r.target || r.typ == icmp.TypeDestUnreach {
+				done = true
+			}
+		}
+
+		hops = append(hops, hop)
+		if t.OnHop != nil {
+			t.OnHop(&hops[len(hops)-1])
+		}
+		if done {
+			break
+		}
+	}
+	return hops, nil
+}
+
+// annotation 返回差错回复的标注，超时和目标的端口不可达没有标注
+func annotation(r *response) string {
+	if r.typ != icmp.TypeDestUnreach {
+		return ""
+	}
+	switch r.code {
+	case icmp.CodePortUnreach:
+		if r.target {
+			return ""
+		}
+		return "!p"
+	case icmp.CodeNetUnreach, icmp.CodeNetUnknown:
+		return "!N"
+	case icmp.CodeHostUnreach, icmp.CodeHostUnknown:
+		return "!H"
+	case icmp.CodeProtoUnreach:
+		return "!P"
+	case icmp.CodeFragNeeded:
+		return fmt.Sprintf("!F-%d", r.mtu)
+	case icmp.CodeSourceRouteFailed:
+		return "!S"
+	case icmp.CodeNetProhibited, icmp.CodeHostProhibited, icmp.CodeAdminProhibited:
+		return "!X"
+	}
+	return fmt.Sprintf("!<%d>", r.code)
+}
+
+func (t *Tracer) newProber(dst [4]byte) (prober, error) {
+	switch t.Method {
+	case MethodICMP:
+		ep, err := ping.NewEndpoint(t.stack)
+		if err != nil {
+			return nil, err
+		}
+		return &icmpProber{ep: ep, dst: dst}, nil
+	case MethodUDP, "":
+		ep := udp.NewEndpoint(t.stack, 0)
+		ep.RecvErrors = true
+		if err := ep.Bind(stack.FullAddress{}); err != nil {
+			ep.Close()
+			return nil, err
+		}
+		port := t.Port
+		if port == 0 {
+			port = DefaultPort
+		}
+		return &udpProber{ep: ep, dst: dst, port: port}, nil
+	}
+	return nil, fmt.Errorf("unknown traceroute method: %s", t.Method)
+}
+
+// udpProber 发往递增目标端口的UDP探测
+type udpProber struct {
+	ep   *udp.Endpoint
+	dst  [4]byte
+	port uint16
+}
+
+func (p *udpProber) send(seq int, ttl uint8) (uint16, error) {
+	port := p.port + uint16(seq)
+	p.ep.TTL = ttl
+	_, err := p.ep.SendTo(make([]byte, probeDataLength), &stack.FullAddress{IP: p.dst, Port: port})
+	return port, err
+}
+
+func (p *udpProber) wait(ctx context.Context, key uint16, timeout time.Duration) (*response, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-timer.C:
+			return nil, false
+		case err := <-p.ep.Errors():
+			// 忽略之前超时的探测迟到的差错
+			if err.ID.RemoteAddress != p.dst || err.ID.RemotePort != key {
+				continue
+			}
+			return &response{
+				from:   err.From,
+				typ:    err.Type,
+				code:   err.Code,
+				mtu:    err.MTU,
+				at:     time.Now(),
+				target: err.From == p.dst,
+			}, true
+		}
+	}
+}
+
+func (p *udpProber) close() {
+	p.ep.Close()
+}
+
+// icmpProber ICMP Echo探测，按序列号匹配
+type icmpProber struct {
+	ep  *ping.Endpoint
+	dst [4]byte
+}
+
+func (p *icmpProber) send(seq int, ttl uint8) (uint16, error) {
+	key := uint16(seq + 1)
+	return key, p.ep.Send(p.dst, key, make([]byte, probeDataLength), ttl)
+}
+
+func (p *icmpProber) wait(ctx context.Context, key uint16, timeout time.Duration) (*response, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-timer.C:
+			return nil, false
+		case r := <-p.ep.Replies():
+			if r.Sequence != key {
+				continue
+			}
+			resp := &response{from: r.From, at: r.Received, target: r.From == p.dst}
+			if r.Err == nil {
+				resp.typ = icmp.TypeEchoReply
+				resp.reply = true
+			} else {
+				resp.typ, resp.code, resp.mtu = r.Err.Type, r.Err.Code, r.Err.MTU
+			}
+			return resp, true
+		}
+	}
+}
+
+func (p *icmpProber) close() {
+	p.ep.Close()
+}
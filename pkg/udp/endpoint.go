@@ -0,0 +1,536 @@
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"ustack/internal/utils"
+	"ustack/pkg/ip"
+	"ustack/pkg/stack"
+)
+
+const (
+	// 默认接收队列长度（数据报个数）
+	DefaultReceiveQueueLength = 256
+
+	// 单个数据报的最大载荷
+	MaxPayloadLength = 65535 - ip.IPHeaderLength - UDPHeaderLength
+)
+
+var (
+	// ErrClosed 端点已关闭
+	ErrClosed = errors.New("UDP endpoint closed")
+
+	// ErrNotConnected 端点未连接且未指定目标地址
+	ErrNotConnected = errors.New("UDP endpoint not connected")
+
+	// ErrAlreadyBound 端点已绑定
+	ErrAlreadyBound = errors.New("UDP endpoint already bound")
+
+	// ErrBroadcastDisabled 未启用Broadcast选项时向广播地址发送（同SO_BROADCAST）
+	ErrBroadcastDisabled = errors.New("UDP broadcast not enabled")
+
+	// ErrCanceled 接收被取消
+	ErrCanceled = errors.New("UDP receive canceled")
+)
+
+const (
+	// 多播默认TTL（同IP_MULTICAST_TTL）
+	DefaultMulticastTTL = 1
+)
+
+// groupKey 端点加入的多播组
+type groupKey struct {
+	group [4]byte
+	nicID int
+}
+
+// Datagram 收到的数据报
+type Datagram struct {
+	From    stack.FullAddress // 发送方地址
+	Payload []byte            // 数据
+}
+
+// Stats 端点统计
+type Stats struct {
+	Received uint64 // 入队的数据报数
+	Dropped  uint64 // 队列满丢弃的数据报数
+	Sent     uint64 // 发送的数据报数
+
+	ChecksumErrors  uint64 // 校验和错误丢弃的数据报数
+	MalformedErrors uint64 // 长度字段错误或过短而丢弃的数据报数
+	ICMPErrors      uint64 // 报告给应用的ICMP差错数
+}
+
+// Endpoint UDP端点
+type Endpoint struct {
+	mu sync.Mutex
+
+	stack *stack.Stack
+	id    stack.TransportEndpointID
+
+	bound     bool
+	connected bool
+	remote    stack.FullAddress
+
+	// 接收队列
+	queue     chan Datagram
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// 发送选项，需在使用端点前设置
+	TTL          uint8 // 单播TTL，0表示使用默认值
+	MulticastTTL uint8 // 多播TTL，0表示使用DefaultMulticastTTL
+	MulticastNIC int   // 多播发送网卡，0表示自动选择
+	Broadcast    bool  // 允许向广播地址发送
+	BroadcastNIC int   // 广播发送网卡，0表示自动选择（如DHCP客户端指定网卡，同SO_BINDTODEVICE）
+	DontFragment bool  // 禁止分片，超过路径MTU时返回stack.ErrMessageTooLong（同IP_PMTUDISC_DO）
+	RecvErrors   bool  // 将所有ICMP差错（含软错误）放入差错队列（同IP_RECVERR）
+
+	// 绑定选项，需在Bind前设置
+	ReuseAddr bool // 都设置时多个端点可绑定同一端口（同SO_REUSEADDR）
+	ReusePort bool // 都设置时多个端点可绑定同一端口，单播按四元组哈希分担（同SO_REUSEPORT）
+
+	// 加入的多播组
+	groups map[groupKey]bool
+
+	// 待报告的ICMP差错（同SO_ERROR），由下一次收发返回
+	pendingError error
+	errReady     chan struct{}
+
+	// 启用RecvErrors时的差错队列
+	errQueue chan *stack.TransportError
+
+	// 统计
+	received atomic.Uint64
+	dropped  atomic.Uint64
+	sent     atomic.Uint64
+
+	checksumErrors  atomic.Uint64
+	malformedErrors atomic.Uint64
+	icmpErrors      atomic.Uint64
+
+	// 日志
+	logger *utils.Logger
+}
+
+// NewEndpoint 创建新的UDP端点，queueLength为0时使用默认接收队列长度
+func NewEndpoint(s *stack.Stack, queueLength int) *Endpoint {
+	if queueLength <= 0 {
+		queueLength = DefaultReceiveQueueLength
+	}
+
+	return &Endpoint{
+		stack:    s,
+		queue:    make(chan Datagram, queueLength),
+		closed:   make(chan struct{}),
+		groups:   make(map[groupKey]bool),
+		errReady: make(chan struct{}, 1),
+		errQueue: make(chan *stack.TransportError, queueLength),
+		logger:   utils.DefaultLogger,
+	}
+}
+
+// Bind 绑定本地地址，端口为0时分配临时端口，地址为零值时接收所有本地地址的数据报
+func (e *Endpoint) Bind(addr stack.FullAddress) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.isClosed() {
+		return ErrClosed
+	}
+	if e.bound {
+		return ErrAlreadyBound
+	}
+	return e.bindLocked(addr)
+}
+
+func (e *Endpoint) bindLocked(addr stack.FullAddress) error {
+	if addr.IP != [4]byte{} && !e.stack.IsLocalAddress(addr.IP) {
+		return fmt.Errorf("cannot bind to non-local address %s", addr)
+	}
+
+	id, err := e.stack.RegisterTransportEndpointWithFlags(ip.ProtocolUDP, stack.TransportEndpointID{
+		LocalAddress: addr.IP,
+		LocalPort:    addr.Port,
+	}, e, e.portFlags())
+	if err != nil {
+		return err
+	}
+
+	e.id = id
+	e.bound = true
+	e.logger.Debug("UDP endpoint bound: %s", stack.FullAddress{IP: id.LocalAddress, Port: id.LocalPort})
+	return nil
+}
+
+// Connect 设置默认对端，之后只接收来自该对端的数据报
+func (e *Endpoint) Connect(addr stack.FullAddress) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.isClosed() {
+		return ErrClosed
+	}
+	if addr.Port == 0 {
+		return fmt.Errorf("invalid remote port 0")
+	}
+
+	r, err := e.stack.FindRoute(addr.IP)
+	if err != nil {
+		return err
+	}
+
+	if !e.bound {
+		if err := e.bindLocked(stack.FullAddress{}); err != nil {
+			return err
+		}
+	}
+
+	id := e.id
+	if id.LocalAddress == [4]byte{} {
+		id.LocalAddress = r.LocalIP
+	}
+	id.RemoteAddress = addr.IP
+	id.RemotePort = addr.Port
+
+	if id != e.id {
+		if _, err := e.stack.RegisterTransportEndpointWithFlags(ip.ProtocolUDP, id, e, e.portFlags()); err != nil {
+			return err
+		}
+		e.stack.UnregisterTransportEndpoint(ip.ProtocolUDP, e.id, e)
+		e.id = id
+	}
+
+	e.connected = true
+	e.remote = addr
+	return nil
+}
+
+// portFlags 返回绑定端口的共享选项
+func (e *Endpoint) portFlags() stack.PortFlags {
+	return stack.PortFlags{ReuseAddr: e.ReuseAddr, ReusePort: e.ReusePort}
+}
+
+// Send 向已连接的对端发送数据
+func (e *Endpoint) Send(payload []byte) (int, error) {
+	return e.SendTo(payload, nil)
+}
+
+// SendTo 向指定地址发送数据，to为nil时发送到已连接的对端
+func (e *Endpoint) SendTo(payload []byte, to *stack.FullAddress) (int, error) {
+	if len(payload) > MaxPayloadLength {
+		return 0, fmt.Errorf("UDP payload too large: %d bytes", len(payload))
+	}
+
+	e.mu.Lock()
+	if e.isClosed() {
+		e.mu.Unlock()
+		return 0, ErrClosed
+	}
+	if err := e.takeErrorLocked(); err != nil {
+		e.mu.Unlock()
+		return 0, err
+	}
+
+	var dst stack.FullAddress
+	switch {
+	case to != nil:
+		dst = *to
+	case e.connected:
+		dst = e.remote
+	default:
+		e.mu.Unlock()
+		return 0, ErrNotConnected
+	}
+	if dst.Port == 0 {
+		e.mu.Unlock()
+		return 0, fmt.Errorf("invalid destination port 0")
+	}
+
+	if !e.bound {
+		if err := e.bindLocked(stack.FullAddress{}); err != nil {
+			e.mu.Unlock()
+			return 0, err
+		}
+	}
+	id := e.id
+	ttl := e.TTL
+	allowBroadcast := e.Broadcast
+	broadcastNIC := e.BroadcastNIC
+	multicastTTL := e.MulticastTTL
+	multicastNIC := e.MulticastNIC
+	dontFragment := e.DontFragment
+	e.mu.Unlock()
+
+	var r *stack.RouteInfo
+	var err error
+	switch {
+	case ip.IsMulticast(dst.IP):
+		r, err = e.stack.FindMulticastRoute(multicastNIC, dst.IP)
+		ttl = multicastTTL
+		if ttl == 0 {
+			ttl = DefaultMulticastTTL
+		}
+	case e.stack.IsBroadcastAddress(dst.IP):
+		if !allowBroadcast {
+			return 0, ErrBroadcastDisabled
+		}
+		r, err = e.stack.FindMulticastRoute(broadcastNIC, dst.IP)
+	default:
+		r, err = e.stack.FindRoute(dst.IP)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if id.LocalAddress != [4]byte{} {
+		r.LocalIP = id.LocalAddress
+	}
+
+	packet := NewPacket(id.LocalPort, dst.Port, payload)
+	data, err := packet.Marshal(r.LocalIP, dst.IP)
+	if err != nil {
+		return 0, err
+	}
+
+	// 默认不超过路径MTU时设置DF以发现路径MTU，超过时按路径MTU分片（同IP_PMTUDISC_WANT）
+	opts := stack.WriteOptions{TTL: ttl, DontFragment: dontFragment}
+	if !ip.IsMulticast(dst.IP) && !e.stack.IsBroadcastAddress(dst.IP) && ip.IPHeaderLength+len(data) <= r.MTU() {
+		opts.DontFragment = true
+	}
+
+	if err := e.stack.WritePacket(r, ip.ProtocolUDP, data, opts); err != nil {
+		return 0, err
+	}
+
+	e.sent.Add(1)
+	return len(payload), nil
+}
+
+// RecvFrom 阻塞接收一个数据报，期间收到ICMP差错时返回该差错（如stack.ErrConnectionRefused）
+func (e *Endpoint) RecvFrom() ([]byte, stack.FullAddress, error) {
+	return e.RecvFromUntil(nil)
+}
+
+// RecvFromUntil 同RecvFrom，cancel关闭时返回ErrCanceled（用于实现读截止时间）
+func (e *Endpoint) RecvFromUntil(cancel <-chan struct{}) ([]byte, stack.FullAddress, error) {
+	for {
+		select {
+		case <-cancel:
+			return nil, stack.FullAddress{}, ErrCanceled
+		case d := <-e.queue:
+			return d.Payload, d.From, nil
+		case <-e.errReady:
+			e.mu.Lock()
+			err := e.takeErrorLocked()
+			e.mu.Unlock()
+			if err != nil {
+				return nil, stack.FullAddress{}, err
+			}
+		case <-e.closed:
+			// 关闭后仍返回队列中剩余的数据报
+			select {
+			case d := <-e.queue:
+				return d.Payload, d.From, nil
+			default:
+				return nil, stack.FullAddress{}, ErrClosed
+			}
+		}
+	}
+}
+
+// HandleError 处理协议栈分发的ICMP差错
+//
+// 与Linux一致，只向已连接的端点报告硬错误（如端口不可达），启用DontFragment时
+// 分片需要也视为硬错误；未连接的端点无法确定差错对应哪次发送，忽略差错（RFC 1122 4.1.3.3）。
+func (e *Endpoint) HandleError(err *stack.TransportError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	queued := false
+	if e.RecvErrors {
+		select {
+		case e.errQueue <- err:
+			queued = true
+		default:
+		}
+	}
+
+	hard := err.Hard || (e.DontFragment && errors.Is(err, stack.ErrMessageTooLong))
+	if !hard || !e.connected || err.ID.RemoteAddress != e.remote.IP || err.ID.RemotePort != e.remote.Port {
+		if queued {
+			e.icmpErrors.Add(1)
+		}
+		return
+	}
+
+	e.pendingError = err
+	e.icmpErrors.Add(1)
+	select {
+	case e.errReady <- struct{}{}:
+	default:
+	}
+}
+
+// Errors 返回差错队列，仅在启用RecvErrors时有差错入队
+//
+// 未连接的端点可据此得知每个数据报触发的差错（如traceroute的超时和端口不可达），
+// 差错的ID和Header标识触发差错的数据报。
+func (e *Endpoint) Errors() <-chan *stack.TransportError {
+	return e.errQueue
+}
+
+// takeErrorLocked 取出并清除待报告的差错
+func (e *Endpoint) takeErrorLocked() error {
+	err := e.pendingError
+	e.pendingError = nil
+	return err
+}
+
+// HandlePacket 处理协议栈分发的数据报
+func (e *Endpoint) HandlePacket(pkt *stack.Packet) {
+	if err := VerifyChecksum(pkt.Payload, pkt.IPHeader.SourceIP, pkt.IPHeader.DestinationIP); err != nil {
+		if errors.Is(err, ErrBadChecksum) {
+			e.checksumErrors.Add(1)
+		} else {
+			e.malformedErrors.Add(1)
+		}
+		e.logger.Debug("UDP: dropping datagram from %s: %v", net.IP(pkt.IPHeader.SourceIP[:]), err)
+		return
+	}
+
+	// 校验和为0时VerifyChecksum不检查长度，由Unmarshal发现格式错误
+	packet := &Packet{}
+	if err := packet.Unmarshal(pkt.Payload); err != nil {
+		e.malformedErrors.Add(1)
+		e.logger.Debug("UDP: dropping datagram from %s: %v", net.IP(pkt.IPHeader.SourceIP[:]), err)
+		return
+	}
+
+	d := Datagram{
+		From:    stack.FullAddress{IP: pkt.IPHeader.SourceIP, Port: packet.SourcePort},
+		Payload: packet.Payload,
+	}
+
+	select {
+	case e.queue <- d:
+		e.received.Add(1)
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// JoinGroup 加入多播组，nicID为0时自动选择网卡
+func (e *Endpoint) JoinGroup(group [4]byte, nicID int) error {
+	if !ip.IsMulticast(group) {
+		return fmt.Errorf("%s is not a multicast address", net.IP(group[:]))
+	}
+	if nicID == 0 {
+		r, err := e.stack.FindMulticastRoute(0, group)
+		if err != nil {
+			return err
+		}
+		nicID = r.NIC.ID
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.isClosed() {
+		return ErrClosed
+	}
+	key := groupKey{group: group, nicID: nicID}
+	if e.groups[key] {
+		return nil
+	}
+	if err := e.stack.JoinGroup(nicID, group); err != nil {
+		return err
+	}
+	e.groups[key] = true
+	return nil
+}
+
+// LeaveGroup 离开多播组，nicID为0时离开所有网卡上的该组
+func (e *Endpoint) LeaveGroup(group [4]byte, nicID int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	found := false
+	for key := range e.groups {
+		if key.group != group || (nicID != 0 && key.nicID != nicID) {
+			continue
+		}
+		found = true
+		delete(e.groups, key)
+		if err := e.stack.LeaveGroup(key.nicID, group); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("not a member of %s", net.IP(group[:]))
+	}
+	return nil
+}
+
+// LocalAddress 返回绑定的本地地址
+func (e *Endpoint) LocalAddress() stack.FullAddress {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return stack.FullAddress{IP: e.id.LocalAddress, Port: e.id.LocalPort}
+}
+
+// RemoteAddress 返回已连接的对端地址
+func (e *Endpoint) RemoteAddress() (stack.FullAddress, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.remote, e.connected
+}
+
+// Stats 返回端点统计
+func (e *Endpoint) Stats() Stats {
+	return Stats{
+		Received: e.received.Load(),
+		Dropped:  e.dropped.Load(),
+		Sent:     e.sent.Load(),
+
+		ChecksumErrors:  e.checksumErrors.Load(),
+		MalformedErrors: e.malformedErrors.Load(),
+		ICMPErrors:      e.icmpErrors.Load(),
+	}
+}
+
+// Close 关闭端点并释放端口
+func (e *Endpoint) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		if e.bound {
+			e.stack.UnregisterTransportEndpoint(ip.ProtocolUDP, e.id, e)
+		}
+		for key := range e.groups {
+			_ = e.stack.LeaveGroup(key.nicID, key.group)
+			delete(e.groups, key)
+		}
+	})
+	return nil
+}
+
+func (e *Endpoint) isClosed() bool {
+	select {
+	case <-e.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// String 返回端点的字符串表示
+func (e *Endpoint) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return fmt.Sprintf("UDP Endpoint: %s", e.id)
+}
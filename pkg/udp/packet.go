@@ -2,6 +2,7 @@ package udp
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"ustack/internal/utils"
 )
@@ -11,6 +12,14 @@ const (
 	UDPHeaderLength = 8
 )
 
+var (
+	// ErrBadLength 长度字段与数据不符
+	ErrBadLength = errors.New("UDP length mismatch")
+
+	// ErrBadChecksum 校验和错误
+	ErrBadChecksum = errors.New("UDP checksum mismatch")
+)
+
 // Packet UDP数据包结构
 type Packet struct {
 	SourcePort      uint16 // 源端口
@@ -20,8 +29,8 @@ type Packet struct {
 	Payload         []byte // 数据载荷
 }
 
-// Marshal 将UDP数据包序列化为字节数组
-func (p *Packet) Marshal() ([]byte, error) {
+// Marshal 将UDP数据包序列化为字节数组，校验和包含IPv4伪头部
+func (p *Packet) Marshal(srcIP, dstIP [4]byte) ([]byte, error) {
 	// 计算总长度（头部8字节 + 数据）
 	totalLength := UDPHeaderLength + len(p.Payload)
 	if totalLength > 65535 {
@@ -45,8 +54,12 @@ func (p *Packet) Marshal() ([]byte, error) {
 	// 数据载荷
 	copy(data[8:], p.Payload)
 
-	// 计算校验和（这里简化处理，实际应该包含伪头部）
-	p.Checksum = utils.CalculateChecksum(data)
+	// 计算校验和，结果为0时发送0xFFFF（0表示未计算校验和）
+	p.Length = uint16(totalLength)
+	p.Checksum = utils.CalculateUDPChecksum(data, srcIP[:], dstIP[:])
+	if p.Checksum == 0 {
+		p.Checksum = 0xFFFF
+	}
 	binary.BigEndian.PutUint16(data[6:8], p.Checksum)
 
 	return data, nil
@@ -70,11 +83,37 @@ func (p *Packet) Unmarshal(data []byte) error {
 	// 校验和
 	p.Checksum = binary.BigEndian.Uint16(data[6:8])
 
+	// 长度字段必须覆盖头部且不超过实际数据，多余的数据视为填充
+	if int(p.Length) < UDPHeaderLength || int(p.Length) > len(data) {
+		return fmt.Errorf("%w: length field %d, have %d bytes", ErrBadLength, p.Length, len(data))
+	}
+
 	// 数据载荷
-	payloadLength := len(data) - UDPHeaderLength
+	payloadLength := int(p.Length) - UDPHeaderLength
 	p.Payload = make([]byte, payloadLength)
-	copy(p.Payload, data[8:])
+	copy(p.Payload, data[8:p.Length])
+
+	return nil
+}
 
+// VerifyChecksum 使用IPv4伪头部校验UDP数据报，校验和为0表示发送方未计算，视为有效
+func VerifyChecksum(data []byte, srcIP, dstIP [4]byte) error {
+	if len(data) < UDPHeaderLength {
+		return fmt.Errorf("UDP packet too short: %d bytes", len(data))
+	}
+
+	if binary.BigEndian.Uint16(data[6:8]) == 0 {
+		return nil
+	}
+
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length < UDPHeaderLength || length > len(data) {
+		return fmt.Errorf("%w: length field %d, have %d bytes", ErrBadLength, length, len(data))
+	}
+
+	if utils.CalculateUDPChecksum(data[:length], srcIP[:], dstIP[:]) != 0 {
+		return ErrBadChecksum
+	}
 	return nil
 }
 
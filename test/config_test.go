@@ -0,0 +1,189 @@
+package test
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"ustack/internal/config"
+	"ustack/internal/utils"
+	"ustack/pkg/gonet"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+	"ustack/pkg/udp"
+)
+
+// parseConfig 按命令行参数解析配置
+func parseConfig(args ...string) (config.Config, error) {
+	cfg := config.Default()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg.RegisterFlags(fs)
+	err := cfg.Parse(fs, args)
+	return cfg, err
+}
+
+func TestConfigFileAndFlags(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "net.yaml")
+	os.WriteFile(yamlFile, []byte(`
+link: pipe
+address: 10.1.0.1/16
+peer: 10.1.0.2
+dns: [10.1.0.2, "10.1.0.3:5353"]
+mtu: 1400
+log_level: warn
+tcp:
+  congestion: cubic
+  receive_window: 32768
+  mtu_probing: false
+`), 0o644)
+
+	// 命令行参数优先于文件，未出现在文件中的字段保持默认值
+	cfg, err := parseConfig("-config", yamlFile, "-mtu", "1280", "extra")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := config.Config{
+		File: yamlFile, Link: config.LinkPipe, Address: "10.1.0.1/16", Peer: "10.1.0.2",
+		DNS: []string{"10.1.0.2", "10.1.0.3:5353"}, MAC: "02:00:00:00:00:01", MTU: 1280, LogLevel: "warn",
+		TCP: config.TCPConfig{Congestion: tcp.CongestionCubic, ReceiveWindow: 32768},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Expected %+v, got %+v", want, cfg)
+	}
+
+	jsonFile := filepath.Join(dir, "net.json")
+	os.WriteFile(jsonFile, []byte(`{"link": "tap", "interface": "tap1", "gateway": "127.0.0.254", "tcp": {"congestion": "reno"}}`), 0o644)
+	if cfg, err = parseConfig("-config", jsonFile); err != nil {
+		t.Fatalf("Parse JSON failed: %v", err)
+	}
+	if cfg.Link != config.LinkTAP || cfg.Interface != "tap1" || cfg.Gateway != "127.0.0.254" || !cfg.TCP.MTUProbing {
+		t.Errorf("Unexpected JSON config %+v", cfg)
+	}
+
+	for _, tt := range []struct {
+		name string
+		file string
+		args []string
+		err  string
+	}{
+		{"UnknownField", `{"linc": "tap"}`, nil, "unknown field"},
+		{"UnknownLink", "link: serial\n", nil, "unknown link type"},
+		{"PipeWithoutPeer", "link: pipe\n", nil, "requires a peer"},
+		{"BadAddress", "address: 10.0.0.1\n", nil, "invalid address"},
+		{"BadCongestion", "", []string{"-cc", "vegas"}, "congestion control"},
+		{"BadWindow", "tcp:\n  receive_window: 100000\n", nil, "receive window"},
+		{"BadLogLevel", "log_level: loud\n", nil, "log level"},
+		{"BadDNS", "", []string{"-dns", "10.0.0.53,ns.example.com"}, "invalid DNS server"},
+		{"BadSniffFilter", "sniff_filter: tcp port http\n", nil, "bad filter"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".yaml")
+			if strings.HasPrefix(tt.file, "{") {
+				name = filepath.Join(dir, tt.name+".json")
+			}
+			os.WriteFile(name, []byte(tt.file), 0o644)
+			_, err := parseConfig(append([]string{"-config", name}, tt.args...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestConfigOpenPipe(t *testing.T) {
+	cfg, err := parseConfig("-link", "pipe", "-addr", "10.0.0.1/24", "-peer", "10.0.0.2", "-cc", "cubic", "-rcvwnd", "8192", "-log", "error")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	n, err := cfg.Open(utils.NewLogger(utils.INFO))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer n.Close()
+	if n.Peer == nil || n.LocalIP != hostAIP {
+		t.Fatalf("Expected simulated peer and local address 10.0.0.1, got %v %v", n.Peer, n.LocalIP)
+	}
+
+	// 模拟主机可达，TCP选项应用于双方的连接
+	lc := &gonet.ListenConfig{Control: cfg.ControlTCP}
+	l, err := lc.ListenTCP(n.Peer, stack.FullAddress{Port: 7})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			io.Copy(c, c)
+			c.Close()
+		}
+	}()
+
+	var dialed *tcp.Connection
+	d := &gonet.Dialer{Stack: n.Stack, Control: func(ep *tcp.Connection) {
+		cfg.ControlTCP(ep)
+		dialed = ep
+	}}
+	c, err := d.Dial("tcp", "10.0.0.2:7")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+	if dialed.Congestion != tcp.CongestionCubic || dialed.ReceiveWindow != 8192 {
+		t.Errorf("Expected TCP options to be applied, got %q %d", dialed.Congestion, dialed.ReceiveWindow)
+	}
+
+	payload := testPayload(100 * 1024)
+	go c.Write(payload)
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(c, got); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("Echo through the pipe link failed: %v", err)
+	}
+}
+
+func TestConfigOpenPipeDHCP(t *testing.T) {
+	if _, err := parseConfig("-link", "pipe", "-addr", "dhcp", "-peer", "10.0.0.2"); err == nil {
+		t.Errorf("Expected error for a peer without prefix length")
+	}
+
+	cfg, err := parseConfig("-link", "pipe", "-addr", "dhcp", "-peer", "10.0.0.2/24", "-log", "error")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	n, err := cfg.Open(utils.NewLogger(utils.INFO))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer n.Close()
+
+	// 模拟主机分配子网中的地址并作为默认网关
+	if n.DHCP == nil || n.PeerDHCP == nil || n.LocalIP != [4]byte{10, 0, 0, 1} || !n.Stack.IsLocalAddress(n.LocalIP) {
+		t.Fatalf("Expected address 10.0.0.1 from the peer's DHCP server, got %v", n.LocalIP)
+	}
+	r, err := n.Stack.FindRoute([4]byte{192, 0, 2, 1})
+	if err != nil || r.NextHop != hostBIP {
+		t.Errorf("Expected default route via the peer, got %+v (%v)", r, err)
+	}
+
+	ep := udp.NewEndpoint(n.Peer, 0)
+	defer ep.Close()
+	if err := ep.Bind(stack.FullAddress{Port: 9}); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	sender := udp.NewEndpoint(n.Stack, 0)
+	defer sender.Close()
+	if _, err := sender.SendTo([]byte("hello"), &stack.FullAddress{IP: hostBIP, Port: 9}); err != nil {
+		t.Fatalf("SendTo failed: %v", err)
+	}
+	if payload, from := recvWithTimeout(t, ep); string(payload) != "hello" || from.IP != n.LocalIP {
+		t.Errorf("Unexpected datagram %q from %v", payload, from)
+	}
+}
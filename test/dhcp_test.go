@@ -0,0 +1,444 @@
+package test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"ustack/pkg/dhcp"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+var (
+	dhcpLeaseIP   = [4]byte{10, 0, 0, 50}
+	dhcpDNSServer = [4]byte{10, 0, 0, 53}
+)
+
+// dhcpStub 只分配一个地址的DHCP服务器桩，记录收到的消息
+type dhcpStub struct {
+	ep        *udp.Endpoint
+	leaseTime uint32
+	serverID  [4]byte
+
+	mu       sync.Mutex
+	received []*dhcp.Message
+	nak      bool // 对续租请求回复NAK
+}
+
+func newDHCPStub(t *testing.T, s *stack.Stack, leaseTime uint32) *dhcpStub {
+	t.Helper()
+
+	ep := udp.NewEndpoint(s, 0)
+	ep.Broadcast = true
+	if err := ep.Bind(stack.FullAddress{Port: dhcp.ServerPort}); err != nil {
+		t.Fatalf("Failed to bind DHCP server port: %v", err)
+	}
+	stub := &dhcpStub{ep: ep, leaseTime: leaseTime, serverID: s.Addresses(1)[0].IP}
+	t.Cleanup(func() { ep.Close() })
+	go stub.serve()
+	return stub
+}
+
+func (d *dhcpStub) serve() {
+	for {
+		data, _, err := d.ep.RecvFrom()
+		if err != nil {
+			return
+		}
+		req := &dhcp.Message{}
+		if err := req.Unmarshal(data); err != nil || req.Op != dhcp.OpRequest {
+			continue
+		}
+
+		d.mu.Lock()
+		d.received = append(d.received, req)
+		nak := d.nak && req.ClientIP != [4]byte{}
+		d.mu.Unlock()
+
+		var replyType uint8
+		switch req.Type() {
+		case dhcp.TypeDiscover:
+			replyType = dhcp.TypeOffer
+		case dhcp.TypeRequest:
+			replyType = dhcp.TypeAck
+			if nak {
+				replyType = dhcp.TypeNak
+			}
+		default:
+			continue
+		}
+
+		reply := dhcp.NewMessage(replyType, req.XID, req.ClientMAC)
+		reply.Op = dhcp.OpReply
+		reply.Flags = req.Flags
+		reply.SetIPOption(dhcp.OptionServerID, d.serverID)
+		if replyType != dhcp.TypeNak {
+			reply.YourIP = dhcpLeaseIP
+			reply.SetIPOption(dhcp.OptionSubnetMask, [4]byte{255, 255, 255, 0})
+			reply.SetIPListOption(dhcp.OptionRouter, [][4]byte{routerIP})
+			reply.SetIPListOption(dhcp.OptionDNSServer, [][4]byte{dhcpDNSServer})
+			reply.SetUint32Option(dhcp.OptionLeaseTime, d.leaseTime)
+		}
+		out, _ := reply.Marshal()
+
+		// 已有地址的客户端单播回复，否则广播
+		dst := [4]byte{255, 255, 255, 255}
+		if req.ClientIP != [4]byte{} {
+			dst = req.ClientIP
+		}
+		d.ep.SendTo(out, &stack.FullAddress{IP: dst, Port: dhcp.ClientPort})
+	}
+}
+
+// messages 返回收到的指定类型的消息
+func (d *dhcpStub) messages(msgType uint8) []*dhcp.Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var msgs []*dhcp.Message
+	for _, m := range d.received {
+		if m.Type() == msgType {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// newDHCPClientStack 创建尚未配置地址的客户端协议栈和连接在同一管道上的服务器桩
+func newDHCPClientStack(t *testing.T, leaseTime uint32) (*stack.Stack, *dhcpStub) {
+	t.Helper()
+
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	client := stack.New()
+	if err := client.AddNIC(1, a); err != nil {
+		t.Fatalf("Failed to add NIC: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server := newStack(t, b, hostBIP)
+	return client, newDHCPStub(t, server, leaseTime)
+}
+
+func TestDHCPMessageRoundTrip(t *testing.T) {
+	m := dhcp.NewMessage(dhcp.TypeRequest, 0x12345678, hostAMAC)
+	m.Flags = dhcp.FlagBroadcast
+	m.SetIPOption(dhcp.OptionRequestedIP, dhcpLeaseIP)
+	m.SetIPListOption(dhcp.OptionDNSServer, [][4]byte{dhcpDNSServer, routerIP})
+	m.SetUint32Option(dhcp.OptionLeaseTime, 3600)
+
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(data) != 300 {
+		t.Errorf("Expected message padded to 300 bytes, got %d", len(data))
+	}
+
+	got := &dhcp.Message{}
+	if err := got.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if got.Type() != dhcp.TypeRequest || got.XID != 0x12345678 || got.ClientMAC != hostAMAC || got.Flags != dhcp.FlagBroadcast {
+		t.Errorf("Header mismatch: %s", got)
+	}
+	if ip, ok := got.IPOption(dhcp.OptionRequestedIP); !ok || ip != dhcpLeaseIP {
+		t.Errorf("Expected requested IP %v, got %v", dhcpLeaseIP, ip)
+	}
+	if dns := got.IPListOption(dhcp.OptionDNSServer); len(dns) != 2 || dns[1] != routerIP {
+		t.Errorf("Unexpected DNS servers: %v", dns)
+	}
+	if v, ok := got.Uint32Option(dhcp.OptionLeaseTime); !ok || v != 3600 {
+		t.Errorf("Expected lease time 3600, got %d", v)
+	}
+
+	// 没有magic cookie的BOOTP报文
+	data[236] = 0
+	if err := got.Unmarshal(data); err != dhcp.ErrBadCookie {
+		t.Errorf("Expected ErrBadCookie, got %v", err)
+	}
+}
+
+func TestDHCPClientAcquireAndRenew(t *testing.T) {
+	s, stub := newDHCPClientStack(t, 2)
+
+	client := dhcp.NewClient(s, 1)
+	client.RetransmitTimeout = 200 * time.Millisecond
+	bound := make(chan *dhcp.Lease, 4)
+	client.OnBound = func(l *dhcp.Lease) { bound <- l }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease, err := client.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	<-bound
+
+	if lease.Address != dhcpLeaseIP || lease.PrefixLength != 24 || lease.Server != hostBIP {
+		t.Errorf("Unexpected lease: %s", lease)
+	}
+	if lease.Duration != 2*time.Second || lease.RenewalTime != time.Second || lease.RebindingTime != 1750*time.Millisecond {
+		t.Errorf("Unexpected lease timers: %s, T1 %s, T2 %s", lease.Duration, lease.RenewalTime, lease.RebindingTime)
+	}
+	if len(stub.messages(dhcp.TypeDiscover)) == 0 || len(stub.messages(dhcp.TypeRequest)) != 1 {
+		t.Errorf("Expected DISCOVER and one REQUEST before the lease was bound")
+	}
+
+	// 地址、默认路由和DNS服务器已配置到协议栈
+	if !s.IsLocalAddress(dhcpLeaseIP) {
+		t.Errorf("Leased address not configured: %v", s.Addresses(1))
+	}
+	r, err := s.FindRoute([4]byte{192, 0, 2, 1})
+	if err != nil || r.NextHop != routerIP || r.LocalIP != dhcpLeaseIP {
+		t.Errorf("Expected default route via router, got %+v (%v)", r, err)
+	}
+	if dns := s.DNSServers(); len(dns) != 1 || dns[0] != dhcpDNSServer {
+		t.Errorf("Expected DNS server %v, got %v", dhcpDNSServer, dns)
+	}
+
+	// T1时单播续租
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx) }()
+	select {
+	case renewed := <-bound:
+		if !renewed.Acquired.After(lease.Acquired) {
+			t.Errorf("Renewed lease not refreshed")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Lease was not renewed")
+	}
+	renews := stub.messages(dhcp.TypeRequest)[1:]
+	if len(renews) == 0 || renews[0].ClientIP != dhcpLeaseIP || renews[0].Option(dhcp.OptionServerID) != nil {
+		t.Errorf("Expected renewal REQUEST with ciaddr and no server identifier")
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if !s.IsLocalAddress(dhcpLeaseIP) {
+		t.Errorf("Address should stay configured after Run returns")
+	}
+
+	// 释放后撤销配置
+	if err := client.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	waitFor(t, "RELEASE", func() bool { return len(stub.messages(dhcp.TypeRelease)) == 1 })
+	if s.IsLocalAddress(dhcpLeaseIP) || len(s.Routes()) != 0 || len(s.DNSServers()) != 0 {
+		t.Errorf("Configuration not removed: %v, %v", s.Addresses(1), s.Routes())
+	}
+}
+
+func TestDHCPClientNak(t *testing.T) {
+	s, stub := newDHCPClientStack(t, 2)
+
+	client := dhcp.NewClient(s, 1)
+	client.RetransmitTimeout = 200 * time.Millisecond
+	lost := make(chan *dhcp.Lease, 1)
+	client.OnLost = func(l *dhcp.Lease) { lost <- l }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// 续租被拒绝时撤销配置并重新获取
+	stub.mu.Lock()
+	stub.nak = true
+	stub.mu.Unlock()
+	go client.Run(ctx)
+
+	select {
+	case l := <-lost:
+		if l.Address != dhcpLeaseIP {
+			t.Errorf("Unexpected lost lease: %s", l)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Lease was not lost after NAK")
+	}
+	waitFor(t, "new lease", func() bool {
+		l := client.Lease()
+		return l != nil && s.IsLocalAddress(l.Address)
+	})
+	if n := len(stub.messages(dhcp.TypeDiscover)); n < 2 {
+		t.Errorf("Expected client to restart with DISCOVER, got %d", n)
+	}
+}
+
+func TestDHCPClientRebind(t *testing.T) {
+	eps := newHub(t, hostAMAC, hostBMAC, [6]byte{0x02, 0, 0, 0, 0, 0x21})
+	s := stack.New()
+	if err := s.AddNIC(1, eps[0]); err != nil {
+		t.Fatalf("Failed to add NIC: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	primary := newDHCPStub(t, newStack(t, eps[1], hostBIP), 2)
+
+	client := dhcp.NewClient(s, 1)
+	client.RetransmitTimeout = 200 * time.Millisecond
+	bound := make(chan *dhcp.Lease, 4)
+	client.OnBound = func(l *dhcp.Lease) { bound <- l }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	<-bound
+
+	// 原服务器不再回复，续租超时后由另一台服务器应答广播的REBINDING请求
+	primary.ep.Close()
+	backupIP := [4]byte{10, 0, 0, 3}
+	newDHCPStub(t, newStack(t, eps[2], backupIP), 2)
+	go client.Run(ctx)
+
+	select {
+	case l := <-bound:
+		if l.Server != backupIP {
+			t.Errorf("Expected lease from %v after rebinding, got %s", backupIP, l)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Lease was not rebound")
+	}
+}
+
+// newHub 创建把帧转发到所有其他端口的集线器，返回各主机一侧的端点
+func newHub(t *testing.T, macs ...[6]byte) []link.Endpoint {
+	t.Helper()
+
+	hosts := make([]link.Endpoint, len(macs))
+	ports := make([]*link.PipeEndpoint, len(macs))
+	for i, mac := range macs {
+		portMAC := mac
+		portMAC[0] |= 0x80
+		hosts[i], ports[i] = link.NewPipe(mac, portMAC, 1500)
+	}
+	for i, port := range ports {
+		i := i
+		port.Attach(func(frame []byte) {
+			for j, out := range ports {
+				if j != i {
+					out.WriteFrame(frame)
+				}
+			}
+		})
+		t.Cleanup(func() { port.Close() })
+	}
+	return hosts
+}
+
+func TestDHCPServerPool(t *testing.T) {
+	macs := [][6]byte{hostBMAC, {0x02, 0, 0, 0, 0, 0x11}, {0x02, 0, 0, 0, 0, 0x12}, {0x02, 0, 0, 0, 0, 0x13}, {0x02, 0, 0, 0, 0, 0x14}}
+	eps := newHub(t, macs...)
+	server := newStack(t, eps[0], hostBIP)
+
+	reserved := [4]byte{10, 0, 0, 200}
+	leaseFile := filepath.Join(t.TempDir(), "leases.json")
+	newServer := func() *dhcp.Server {
+		srv := dhcp.NewServer(server, 1, [4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 3})
+		srv.Routers = [][4]byte{hostBIP}
+		srv.DNSServers = [][4]byte{hostBIP}
+		srv.LeaseTime = time.Minute
+		srv.Reservations = map[[6]byte][4]byte{macs[3]: reserved}
+		srv.LeaseFile = leaseFile
+		if err := srv.Start(); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		return srv
+	}
+	srv := newServer()
+	defer func() { srv.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 模拟主机各自获取地址
+	clients := make([]*dhcp.Client, 3)
+	leases := make([]*dhcp.Lease, 3)
+	for i := range clients {
+		s := stack.New()
+		if err := s.AddNIC(1, eps[i+1]); err != nil {
+			t.Fatalf("Failed to add NIC: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		clients[i] = dhcp.NewClient(s, 1)
+		clients[i].RetransmitTimeout = 200 * time.Millisecond
+	}
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *dhcp.Client) {
+			defer wg.Done()
+			var err error
+			if leases[i], err = c.Acquire(ctx); err != nil {
+				t.Errorf("Client %d: Acquire failed: %v", i, err)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// 池中的10.0.0.2是服务器自己的地址，两个动态客户端得到.1和.3，固定分配的客户端得到.200
+	got := map[[4]byte]bool{}
+	for i, l := range leases {
+		got[l.Address] = true
+		if l.PrefixLength != 24 || l.Server != hostBIP || len(l.Routers) != 1 || l.Routers[0] != hostBIP || l.Duration != time.Minute {
+			t.Errorf("Client %d: unexpected lease %s", i, l)
+		}
+	}
+	if leases[2].Address != reserved || !got[[4]byte{10, 0, 0, 1}] || !got[[4]byte{10, 0, 0, 3}] {
+		t.Errorf("Unexpected addresses: %v %v %v", leases[0].Address, leases[1].Address, leases[2].Address)
+	}
+	if n := len(srv.Bindings()); n != 3 {
+		t.Errorf("Expected 3 bindings, got %d", n)
+	}
+
+	// 池已满时新客户端得不到地址，其他客户端释放后可以重用
+	late := stack.New()
+	if err := late.AddNIC(1, eps[4]); err != nil {
+		t.Fatalf("Failed to add NIC: %v", err)
+	}
+	t.Cleanup(func() { late.Close() })
+	lateClient := dhcp.NewClient(late, 1)
+	lateClient.RetransmitTimeout = 100 * time.Millisecond
+	shortCtx, shortCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	if _, err := lateClient.Acquire(shortCtx); err != context.DeadlineExceeded {
+		t.Errorf("Expected no address from a full pool, got %v", err)
+	}
+	shortCancel()
+
+	if err := clients[0].Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	lateLease, err := lateClient.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire after release failed: %v", err)
+	}
+	if lateLease.Address != leases[0].Address {
+		t.Errorf("Expected released address %v, got %v", leases[0].Address, lateLease.Address)
+	}
+
+	// 重启服务器后从租约文件恢复，同一客户端续租得到原地址
+	srv.Close()
+	srv = newServer()
+	restored := false
+	for _, b := range srv.Bindings() {
+		restored = restored || (b.MAC == macs[2] && b.Address == leases[1].Address)
+	}
+	if !restored {
+		t.Fatalf("Leases not restored from file: %+v", srv.Bindings())
+	}
+	lease, err := clients[1].Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Acquire after restart failed: %v", err)
+	}
+	if lease.Address != leases[1].Address {
+		t.Errorf("Expected %v after restart, got %v", leases[1].Address, lease.Address)
+	}
+}
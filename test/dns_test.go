@@ -0,0 +1,604 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"ustack/pkg/dns"
+	"ustack/pkg/gonet"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+// dnsStub 按固定记录回答的DNS服务器桩，不跟随CNAME，记录每个域名收到的查询次数
+type dnsStub struct {
+	records  []dns.Resource
+	truncate map[string]bool // 通过UDP查询时返回截断回复的域名
+	rcode    uint8           // 非0时所有查询都返回该错误码
+	silent   bool            // 不回复
+
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+var dnsStubSOA = dns.Resource{
+	Name: "example.com.", Type: dns.TypeSOA, Class: dns.ClassINET, TTL: 300,
+	MName: "ns.example.com.", RName: "admin.example.com.", Serial: 1,
+	Refresh: 3600, Retry: 600, Expire: 86400, Minimum: 60,
+}
+
+func newDNSStubRecords() []dns.Resource {
+	return []dns.Resource{
+		{Name: "www.example.com.", Type: dns.TypeCNAME, TTL: 60, Target: "web.example.com."},
+		{Name: "web.example.com.", Type: dns.TypeA, TTL: 60, A: [4]byte{10, 0, 0, 80}},
+		{Name: "web.example.com.", Type: dns.TypeA, TTL: 30, A: [4]byte{10, 0, 0, 81}},
+		{Name: "host-b.example.com.", Type: dns.TypeA, TTL: 60, A: hostBIP},
+		{Name: "big.example.com.", Type: dns.TypeA, TTL: 60, A: [4]byte{10, 0, 0, 99}},
+		{Name: "_http._tcp.example.com.", Type: dns.TypeSRV, TTL: 60, Priority: 10, Weight: 5, Port: 8080, Target: "web.example.com."},
+		{Name: "_http._tcp.example.com.", Type: dns.TypeSRV, TTL: 60, Priority: 0, Weight: 1, Port: 80, Target: "www.example.com."},
+		{Name: "example.com.", Type: dns.TypeTXT, TTL: 60, Text: []string{"v=spf1 ", "-all"}},
+		{Name: "80.0.0.10.in-addr.arpa.", Type: dns.TypePTR, TTL: 60, Target: "web.example.com."},
+	}
+}
+
+// startDNSStub 在协议栈s的port端口上通过UDP和TCP提供服务
+func startDNSStub(t *testing.T, s *stack.Stack, port uint16, stub *dnsStub) {
+	t.Helper()
+	stub.queries = make(map[string]int)
+	for i := range stub.records {
+		stub.records[i].Class = dns.ClassINET
+	}
+
+	ep := udp.NewEndpoint(s, 0)
+	if err := ep.Bind(stack.FullAddress{Port: port}); err != nil {
+		t.Fatalf("Failed to bind DNS stub: %v", err)
+	}
+	t.Cleanup(func() { ep.Close() })
+	go func() {
+		for {
+			data, from, err := ep.RecvFrom()
+			if err != nil {
+				return
+			}
+			if reply := stub.answer(data, true); reply != nil {
+				ep.SendTo(reply, &from)
+			}
+		}
+	}()
+
+	ln, err := gonet.ListenTCP(s, stack.FullAddress{Port: port})
+	if err != nil {
+		t.Fatalf("Failed to listen on DNS stub: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(c, length[:]); err != nil {
+					return
+				}
+				data := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(c, data); err != nil {
+					return
+				}
+				if reply := stub.answer(data, false); reply != nil {
+					c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}()
+		}
+	}()
+}
+
+func (stub *dnsStub) answer(data []byte, overUDP bool) []byte {
+	query := &dns.Message{}
+	if err := query.Unmarshal(data); err != nil || len(query.Questions) != 1 {
+		return nil
+	}
+	q := query.Questions[0]
+
+	stub.mu.Lock()
+	stub.queries[q.Name]++
+	stub.mu.Unlock()
+	if stub.silent {
+		return nil
+	}
+
+	reply := &dns.Message{
+		ID:               query.ID,
+		Response:         true,
+		Authoritative:    true,
+		RecursionDesired: query.RecursionDesired,
+		RCode:            stub.rcode,
+		Questions:        query.Questions,
+	}
+	if stub.rcode == dns.RCodeSuccess {
+		if overUDP && stub.truncate[q.Name] {
+			reply.Truncated = true
+		} else {
+			found := false
+			for _, rr := range stub.records {
+				if rr.Name != q.Name {
+					continue
+				}
+				found = true
+				if rr.Type == q.Type || rr.Type == dns.TypeCNAME {
+					reply.Answers = append(reply.Answers, rr)
+				}
+			}
+			if !found {
+				reply.RCode = dns.RCodeNameError
+			}
+			if len(reply.Answers) == 0 {
+				reply.Authorities = []dns.Resource{dnsStubSOA}
+			}
+		}
+	}
+
+	out, err := reply.Marshal()
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (stub *dnsStub) count(name string) int {
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	return stub.queries[name]
+}
+
+func TestDNSMessageRoundTrip(t *testing.T) {
+	msg := &dns.Message{
+		ID:                 0x1234,
+		Response:           true,
+		Authoritative:      true,
+		RecursionDesired:   true,
+		RecursionAvailable: true,
+		Questions:          []dns.Question{{Name: "www.example.com.", Type: dns.TypeA, Class: dns.ClassINET}},
+		Answers: []dns.Resource{
+			{Name: "www.example.com.", Type: dns.TypeCNAME, Class: dns.ClassINET, TTL: 60, Target: "web.example.com."},
+			{Name: "web.example.com.", Type: dns.TypeA, Class: dns.ClassINET, TTL: 60, A: [4]byte{10, 0, 0, 80}},
+			{Name: "web.example.com.", Type: dns.TypeAAAA, Class: dns.ClassINET, TTL: 60, AAAA: [16]byte{0: 0xfd, 15: 1}},
+			{Name: "_http._tcp.example.com.", Type: dns.TypeSRV, Class: dns.ClassINET, TTL: 60, Priority: 1, Weight: 2, Port: 80, Target: "web.example.com."},
+			{Name: "example.com.", Type: dns.TypeTXT, Class: dns.ClassINET, TTL: 60, Text: []string{"hello", "world"}},
+		},
+		Authorities: []dns.Resource{dnsStubSOA},
+	}
+
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	// 重复的后缀只出现一次，SRV目标不压缩（RFC 2782）
+	if n := bytes.Count(data, []byte("\x07example\x03com\x00")); n != 2 {
+		t.Errorf("example.com. encoded %d times, want 2 with compression", n)
+	}
+
+	got := &dns.Message{}
+	if err := got.Unmarshal(data); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("Round trip mismatch:\n got %v\nwant %v", got, msg)
+	}
+
+	for _, n := range []int{0, 11, 30, len(data) - 1} {
+		if err := (&dns.Message{}).Unmarshal(data[:n]); err == nil {
+			t.Errorf("Unmarshal of %d-byte prefix succeeded", n)
+		}
+	}
+}
+
+func TestDNSResolverLookups(t *testing.T) {
+	sa, sb := newStackPair(t)
+	stub := &dnsStub{records: newDNSStubRecords(), truncate: map[string]bool{"big.example.com.": true}}
+	startDNSStub(t, sb, dns.Port, stub)
+
+	r := dns.NewResolver(sa)
+	r.Servers = []stack.FullAddress{{IP: hostBIP}}
+	r.Timeout = 500 * time.Millisecond
+	ctx := context.Background()
+
+	// CNAME只在应答中给出时再查询其目标
+	addrs, err := r.LookupHost(ctx, "WWW.example.com")
+	if err != nil {
+		t.Fatalf("LookupHost failed: %v", err)
+	}
+	if want := [][4]byte{{10, 0, 0, 80}, {10, 0, 0, 81}}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("LookupHost = %v, want %v", addrs, want)
+	}
+	if cname, err := r.LookupCNAME(ctx, "www.example.com"); err != nil || cname != "web.example.com." {
+		t.Errorf("LookupCNAME = %q, %v", cname, err)
+	}
+
+	// 缓存的应答不再查询服务器
+	if n := stub.count("www.example.com."); n != 1 {
+		t.Errorf("www.example.com queried %d times, want 1", n)
+	}
+	if answers, err := r.Query(ctx, "web.example.com", dns.TypeA); err != nil || answers[0].TTL > 30 {
+		t.Errorf("Cached query = %v, %v; want TTL clamped to 30", answers, err)
+	}
+
+	// 否定应答按SOA缓存
+	for i := 0; i < 2; i++ {
+		if _, err := r.LookupHost(ctx, "missing.example.com"); !errors.Is(err, dns.ErrNotFound) {
+			t.Fatalf("LookupHost(missing) error = %v, want ErrNotFound", err)
+		}
+	}
+	if n := stub.count("missing.example.com."); n != 1 {
+		t.Errorf("missing.example.com queried %d times, want 1", n)
+	}
+	if _, err := r.LookupIPv6(ctx, "web.example.com"); !errors.Is(err, dns.ErrNoData) {
+		t.Errorf("LookupIPv6 error = %v, want ErrNoData", err)
+	}
+
+	// 截断的UDP回复改用TCP
+	if addrs, err := r.LookupHost(ctx, "big.example.com"); err != nil || len(addrs) != 1 || addrs[0] != [4]byte{10, 0, 0, 99} {
+		t.Errorf("LookupHost(big) = %v, %v", addrs, err)
+	}
+
+	srvs, err := r.LookupSRV(ctx, "http", "tcp", "example.com")
+	if err != nil || len(srvs) != 2 || srvs[0].Port != 80 || srvs[1].Port != 8080 {
+		t.Errorf("LookupSRV = %v, %v", srvs, err)
+	}
+	if txts, err := r.LookupTXT(ctx, "example.com"); err != nil || !reflect.DeepEqual(txts, []string{"v=spf1 -all"}) {
+		t.Errorf("LookupTXT = %q, %v", txts, err)
+	}
+	if names, err := r.LookupAddr(ctx, [4]byte{10, 0, 0, 80}); err != nil || !reflect.DeepEqual(names, []string{"web.example.com."}) {
+		t.Errorf("LookupAddr = %q, %v", names, err)
+	}
+
+	// 地址字面量和localhost不查询服务器
+	if addrs, err := r.LookupHost(ctx, "localhost"); err != nil || addrs[0] != [4]byte{127, 0, 0, 1} {
+		t.Errorf("LookupHost(localhost) = %v, %v", addrs, err)
+	}
+	if addrs, err := r.LookupHost(ctx, "10.1.2.3"); err != nil || addrs[0] != [4]byte{10, 1, 2, 3} {
+		t.Errorf("LookupHost(10.1.2.3) = %v, %v", addrs, err)
+	}
+}
+
+func TestDNSResolverRetry(t *testing.T) {
+	sa, sb := newStackPair(t)
+	silent := &dnsStub{silent: true}
+	failing := &dnsStub{rcode: dns.RCodeServerFailure}
+	good := &dnsStub{records: newDNSStubRecords()}
+	startDNSStub(t, sb, 5301, silent)
+	startDNSStub(t, sb, 5302, failing)
+	startDNSStub(t, sb, 5303, good)
+
+	r := dns.NewResolver(sa)
+	r.Timeout = 100 * time.Millisecond
+	r.Servers = []stack.FullAddress{{IP: hostBIP, Port: 5301}, {IP: hostBIP, Port: 5302}, {IP: hostBIP, Port: 5303}}
+
+	addrs, err := r.LookupHost(context.Background(), "host-b.example.com")
+	if err != nil || len(addrs) != 1 || addrs[0] != hostBIP {
+		t.Fatalf("LookupHost = %v, %v", addrs, err)
+	}
+	for _, stub := range []*dnsStub{silent, failing, good} {
+		if n := stub.count("host-b.example.com."); n != 1 {
+			t.Errorf("Server queried %d times, want 1", n)
+		}
+	}
+
+	// 全部失败时报告最后一个错误
+	r.Servers = r.Servers[:2]
+	if _, err := r.LookupHost(context.Background(), "web.example.com"); !errors.Is(err, dns.ErrServerFailure) {
+		t.Errorf("LookupHost error = %v, want ErrServerFailure", err)
+	}
+	if n := silent.count("web.example.com."); n != dns.DefaultAttempts {
+		t.Errorf("Silent server queried %d times, want %d", n, dns.DefaultAttempts)
+	}
+
+	// 没有配置服务器时使用协议栈的DNS服务器
+	r.Servers = nil
+	if _, err := r.LookupHost(context.Background(), "other.example.com"); !errors.Is(err, dns.ErrNoServers) {
+		t.Errorf("LookupHost error = %v, want ErrNoServers", err)
+	}
+}
+
+func TestDialerResolver(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startDNSStub(t, sb, dns.Port, &dnsStub{records: newDNSStubRecords()})
+
+	ln, err := gonet.ListenTCP(sb, stack.FullAddress{Port: 8080})
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			c.Write([]byte("hello"))
+			c.Close()
+		}
+	}()
+
+	sa.SetDNSServers([][4]byte{hostBIP})
+	d := &gonet.Dialer{Stack: sa, Resolver: dns.NewResolver(sa)}
+	c, err := d.Dial("tcp", "host-b.example.com:8080")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer c.Close()
+	if got := c.RemoteAddr().String(); got != net.JoinHostPort("10.0.0.2", "8080") {
+		t.Errorf("RemoteAddr = %s", got)
+	}
+	if data, err := io.ReadAll(c); err != nil || string(data) != "hello" {
+		t.Errorf("Read = %q, %v", data, err)
+	}
+
+	if _, err := d.Dial("tcp", "missing.example.com:8080"); !errors.Is(err, dns.ErrNotFound) {
+		t.Errorf("Dial(missing) error = %v, want ErrNotFound", err)
+	}
+	d.Resolver = nil
+	if _, err := d.Dial("tcp", "host-b.example.com:8080"); err == nil {
+		t.Error("Dial without resolver succeeded")
+	}
+}
+
+const dnsTestZone = `; 测试区域
+$ORIGIN example.test.
+$TTL 1h
+@        IN  SOA ns admin.example.test. (
+             2024010101 ; serial
+             1h 10m 1w
+             60 )       ; minimum
+         IN  NS  ns
+ns           A   10.0.0.2
+host-b   300 IN  A   10.0.0.2
+         IN 300  AAAA fd00::2
+www          CNAME host-b
+alias        CNAME www.example.test.
+_http._tcp   SRV 10 5 8080 host-b
+             SRV 20 1 8080 www
+info         TXT "hello; world" "say \"hi\""
+`
+
+// newDNSTestServer 在sb上运行加载了example.test和反向区域的DNS服务器
+func newDNSTestServer(t *testing.T, sb *stack.Stack, extra ...dns.Resource) *dns.Server {
+	t.Helper()
+	zone, err := dns.ParseZone(strings.NewReader(dnsTestZone), "")
+	if err != nil {
+		t.Fatalf("ParseZone failed: %v", err)
+	}
+	if len(extra) > 0 {
+		if zone, err = dns.NewZone(append(zone.Records, extra...)); err != nil {
+			t.Fatalf("NewZone failed: %v", err)
+		}
+	}
+	reverse, err := dns.ParseZone(strings.NewReader(`
+$ORIGIN 0.0.10.in-addr.arpa.
+@ SOA ns.example.test. admin.example.test. 1 3600 600 86400 60
+2 PTR host-b.example.test.
+`), "")
+	if err != nil {
+		t.Fatalf("ParseZone failed: %v", err)
+	}
+
+	srv := dns.NewServer(sb)
+	srv.AddZone(zone)
+	srv.AddZone(reverse)
+	if err := srv.Start(stack.FullAddress{}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// dnsExchange 通过UDP向hostB的DNS服务器发送一个查询并返回回复
+func dnsExchange(t *testing.T, s *stack.Stack, query *dns.Message) *dns.Message {
+	t.Helper()
+	ep := udp.NewEndpoint(s, 0)
+	defer ep.Close()
+	data, err := query.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if _, err := ep.SendTo(data, &stack.FullAddress{IP: hostBIP, Port: dns.Port}); err != nil {
+		t.Fatalf("SendTo failed: %v", err)
+	}
+	payload, _ := recvWithTimeout(t, ep)
+	reply := &dns.Message{}
+	if err := reply.Unmarshal(payload); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	return reply
+}
+
+func TestDNSZoneParse(t *testing.T) {
+	zone, err := dns.ParseZone(strings.NewReader(dnsTestZone), "")
+	if err != nil {
+		t.Fatalf("ParseZone failed: %v", err)
+	}
+	if zone.Origin != "example.test." || len(zone.Records) != 10 {
+		t.Fatalf("Origin %s with %d records, want example.test. with 10", zone.Origin, len(zone.Records))
+	}
+
+	soa := zone.SOA()
+	if soa.MName != "ns.example.test." || soa.Serial != 2024010101 || soa.Refresh != 3600 ||
+		soa.Retry != 600 || soa.Expire != 604800 || soa.Minimum != 60 || soa.TTL != 3600 {
+		t.Errorf("Unexpected SOA %v", soa.String())
+	}
+	want := []string{
+		"example.test. 3600 NS ns.example.test.",
+		"ns.example.test. 3600 A 10.0.0.2",
+		"host-b.example.test. 300 A 10.0.0.2",
+		"host-b.example.test. 300 AAAA fd00::2",
+		"www.example.test. 3600 CNAME host-b.example.test.",
+		"alias.example.test. 3600 CNAME www.example.test.",
+		"_http._tcp.example.test. 3600 SRV 10 5 8080 host-b.example.test.",
+		"_http._tcp.example.test. 3600 SRV 20 1 8080 www.example.test.",
+		`info.example.test. 3600 TXT "hello; world" "say \"hi\""`,
+	}
+	for i, w := range want {
+		if got := zone.Records[i+1].String(); got != w {
+			t.Errorf("Record %d = %s, want %s", i+1, got, w)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, zone, err string
+	}{
+		{"NoSOA", "$ORIGIN a.test.\nwww A 10.0.0.1\n", "no SOA"},
+		{"OutOfZone", "$ORIGIN a.test.\n@ SOA ns admin 1 1 1 1 1\nwww.b.test. A 10.0.0.1\n", "outside zone"},
+		{"CNAMEConflict", "$ORIGIN a.test.\n@ SOA ns admin 1 1 1 1 1\nwww CNAME a.test.\nwww A 10.0.0.1\n", "CNAME and other"},
+		{"RelativeWithoutOrigin", "@ SOA ns admin 1 1 1 1 1\n", "without $ORIGIN"},
+		{"BadType", "$ORIGIN a.test.\n@ SOA ns admin 1 1 1 1 1\nwww HINFO a b\n", "line 3: unsupported record type"},
+		{"BadAddress", "$ORIGIN a.test.\n@ SOA ns admin 1 1 1 1 1\nwww A 10.0.0\n", "invalid IPv4"},
+		{"Unbalanced", "$ORIGIN a.test.\n@ SOA ns admin ( 1 1 1 1 1\n", "unbalanced"},
+		{"BadTTL", "$TTL 1x\n", "invalid TTL"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dns.ParseZone(strings.NewReader(tt.zone), "")
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestDNSServerAnswers(t *testing.T) {
+	sa, sb := newStackPair(t)
+	var many []dns.Resource
+	for i := 1; i <= 40; i++ {
+		many = append(many, dns.Resource{Name: "many.example.test.", Type: dns.TypeA, TTL: 60, A: [4]byte{10, 0, 1, byte(i)}})
+	}
+	newDNSTestServer(t, sb, many...)
+
+	sa.SetDNSServers([][4]byte{hostBIP})
+	r := dns.NewResolver(sa)
+	ctx := context.Background()
+
+	// 区域内的CNAME链在一个回复中给出
+	if addrs, err := r.LookupHost(ctx, "alias.example.test"); err != nil || len(addrs) != 1 || addrs[0] != hostBIP {
+		t.Errorf("LookupHost(alias) = %v, %v", addrs, err)
+	}
+	if addrs, err := r.LookupIPv6(ctx, "host-b.example.test"); err != nil || len(addrs) != 1 || addrs[0] != [16]byte{0: 0xfd, 15: 2} {
+		t.Errorf("LookupIPv6 = %v, %v", addrs, err)
+	}
+	if names, err := r.LookupAddr(ctx, hostBIP); err != nil || len(names) != 1 || names[0] != "host-b.example.test." {
+		t.Errorf("LookupAddr = %v, %v", names, err)
+	}
+	if txts, err := r.LookupTXT(ctx, "info.example.test"); err != nil || len(txts) != 1 || txts[0] != `hello; worldsay "hi"` {
+		t.Errorf("LookupTXT = %q, %v", txts, err)
+	}
+
+	// 超过512字节的回复被截断，解析器改用TCP取得全部记录
+	if addrs, err := r.LookupHost(ctx, "many.example.test"); err != nil || len(addrs) != 40 {
+		t.Errorf("LookupHost(many) returned %d addresses, %v", len(addrs), err)
+	}
+
+	// SRV目标的地址放在附加段
+	reply := dnsExchange(t, sa, dns.NewQuery(1, "_http._tcp.example.test", dns.TypeSRV))
+	if !reply.Authoritative || len(reply.Answers) != 2 || len(reply.Additionals) != 2 {
+		t.Errorf("SRV reply: %v", reply)
+	}
+
+	// 不存在的域名回复NXDOMAIN，权威段的SOA TTL取MINIMUM
+	reply = dnsExchange(t, sa, dns.NewQuery(2, "missing.example.test", dns.TypeA))
+	if reply.RCode != dns.RCodeNameError || !reply.Authoritative || len(reply.Authorities) != 1 ||
+		reply.Authorities[0].Type != dns.TypeSOA || reply.Authorities[0].TTL != 60 {
+		t.Errorf("NXDOMAIN reply: %v", reply)
+	}
+	if _, err := r.LookupHost(ctx, "missing.example.test"); !errors.Is(err, dns.ErrNotFound) {
+		t.Errorf("LookupHost(missing) error = %v, want ErrNotFound", err)
+	}
+
+	// 空非终端节点和没有请求类型的记录回复NOERROR且没有应答
+	for _, name := range []string{"_tcp.example.test", "ns.example.test"} {
+		reply = dnsExchange(t, sa, dns.NewQuery(3, name, dns.TypeAAAA))
+		if reply.RCode != dns.RCodeSuccess || len(reply.Answers) != 0 || len(reply.Authorities) != 1 {
+			t.Errorf("NODATA reply for %s: %v", name, reply)
+		}
+	}
+
+	// 区域外的查询被拒绝
+	reply = dnsExchange(t, sa, dns.NewQuery(4, "example.com", dns.TypeA))
+	if reply.RCode != dns.RCodeRefused || reply.Authoritative {
+		t.Errorf("Out-of-zone reply: %v", reply)
+	}
+}
+
+func TestDNSServerLoad(t *testing.T) {
+	sa, sb := newStackPair(t)
+	var hosts []dns.Resource
+	for i := 0; i < 50; i++ {
+		hosts = append(hosts, dns.Resource{Name: fmt.Sprintf("h%d.example.test.", i), Type: dns.TypeA, TTL: 60, A: [4]byte{10, 0, 2, byte(i)}})
+	}
+	newDNSTestServer(t, sb, hosts...)
+
+	const workers, queries = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+
+		// UDP：每个解析器有自己的缓存，每个名字都会查询服务器
+		go func() {
+			defer wg.Done()
+			r := dns.NewResolver(sa)
+			r.Servers = []stack.FullAddress{{IP: hostBIP}}
+			for i := 0; i < queries; i++ {
+				addrs, err := r.LookupHost(context.Background(), fmt.Sprintf("h%d.example.test", i))
+				if err != nil || len(addrs) != 1 || addrs[0] != [4]byte{10, 0, 2, byte(i)} {
+					errs <- fmt.Errorf("UDP lookup h%d = %v, %v", i, addrs, err)
+					return
+				}
+			}
+		}()
+
+		// TCP：同一连接上连续发送查询
+		go func(w int) {
+			defer wg.Done()
+			c, err := gonet.Dial(sa, "tcp", "10.0.0.2:53")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer c.Close()
+			for i := 0; i < queries; i++ {
+				query := dns.NewQuery(uint16(w*queries+i), fmt.Sprintf("h%d.example.test", i), dns.TypeA)
+				data, _ := query.Marshal()
+				if _, err := c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(data))), data...)); err != nil {
+					errs <- err
+					return
+				}
+				var length [2]byte
+				if _, err := io.ReadFull(c, length[:]); err != nil {
+					errs <- err
+					return
+				}
+				data = make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(c, data); err != nil {
+					errs <- err
+					return
+				}
+				reply := &dns.Message{}
+				if err := reply.Unmarshal(data); err != nil || reply.ID != query.ID || len(reply.Answers) != 1 {
+					errs <- fmt.Errorf("TCP query h%d: %v %v", i, reply, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
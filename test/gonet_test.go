@@ -0,0 +1,438 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"ustack/pkg/gonet"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+	"ustack/pkg/udp"
+)
+
+// connectTCPConn 创建包装为net.Conn的客户端连接并发送SYN
+func connectTCPConn(s *stack.Stack, addr stack.FullAddress) (*gonet.TCPConn, error) {
+	ep := tcp.NewConnection(s)
+	ep.ReadBuffered = true
+	c := gonet.NewTCPConn(ep)
+	if err := ep.Connect(addr); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialTCPConn 创建客户端连接并等待握手结束
+func dialTCPConn(t *testing.T, s *stack.Stack, addr stack.FullAddress) *gonet.TCPConn {
+	t.Helper()
+
+	c, err := connectTCPConn(s, addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	waitFor(t, "handshake", func() bool { return c.Endpoint().State() != tcp.StateSynSent })
+	return c
+}
+
+// listenGonet 在协议栈上监听port
+func listenGonet(t *testing.T, s *stack.Stack, port uint16) *gonet.TCPListener {
+	t.Helper()
+
+	l, err := gonet.ListenTCP(s, stack.FullAddress{Port: port})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestTCPConnEcho(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 7)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	c := dialTCPConn(t, sa, stack.FullAddress{IP: hostBIP, Port: 7})
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	payload := testPayload(200 * 1024)
+	go func() {
+		c.Write(payload)
+		c.CloseWrite()
+	}()
+
+	// 对端收到FIN后关闭，本端读到io.EOF
+	echoed, err := io.ReadAll(bufio.NewReader(c))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(echoed, payload) {
+		t.Errorf("Echoed data mismatch: got %d bytes, want %d", len(echoed), len(payload))
+	}
+	if n, err := c.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Expected io.EOF after FIN, got %d, %v", n, err)
+	}
+
+	if got := c.RemoteAddr().String(); got != "10.0.0.2:7" {
+		t.Errorf("Unexpected remote address %s", got)
+	}
+	if a, ok := l.Addr().(*net.TCPAddr); !ok || a.Port != 7 {
+		t.Errorf("Unexpected listener address %v", l.Addr())
+	}
+}
+
+func TestTCPConnDeadline(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 80)
+
+	c := dialTCPConn(t, sa, stack.FullAddress{IP: hostBIP, Port: 80})
+	defer c.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, err = c.Read(make([]byte, 16))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Deadline fired after %v", elapsed)
+	}
+
+	// 清除截止时间后恢复正常读取
+	c.SetReadDeadline(time.Time{})
+	server.Write([]byte("ping"))
+	buf := make([]byte, 16)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Errorf("Expected ping, got %q, %v", buf[:n], err)
+	}
+
+	// 本地关闭后读写返回net.ErrClosed
+	c.Close()
+	if _, err := c.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected net.ErrClosed, got %v", err)
+	}
+	if _, err := c.Write(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestTCPConnBackpressure(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 9000)
+
+	c := dialTCPConn(t, sa, stack.FullAddress{IP: hostBIP, Port: 9000})
+	defer c.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	// 接收方不读取：接收窗口和发送缓冲区填满后Write阻塞
+	payload := testPayload(1024 * 1024)
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write(payload)
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		t.Fatalf("Write returned before the receiver read anything: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if queued := c.Endpoint().SendQueued(); queued > gonet.DefaultSendBufferSize {
+		t.Errorf("Send buffer exceeded its limit: %d bytes", queued)
+	}
+
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatalf("ReadFull failed: %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Errorf("Received data mismatch")
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write failed: %v", err)
+	}
+}
+
+func TestTCPConnReset(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	// 对端没有监听，连接被拒绝后读写返回错误
+	c := dialTCPConn(t, sa, stack.FullAddress{IP: hostBIP, Port: 81})
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, stack.ErrConnectionRefused) {
+		t.Errorf("Expected connection refused, got %v", err)
+	}
+	if _, err := c.Write([]byte("x")); !errors.Is(err, stack.ErrConnectionRefused) {
+		t.Errorf("Expected connection refused, got %v", err)
+	}
+}
+
+func TestHTTPOverTCPConn(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 8080)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s %d", r.Method, r.URL.Path, len(body))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: (&gonet.Dialer{Stack: sa}).DialContext,
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	// 同一个持久连接上的多个请求
+	for i := 0; i < 3; i++ {
+		body := strings.Repeat("x", 10000*i)
+		resp, err := client.Post("http://10.0.0.2:8080/upload", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := fmt.Sprintf("POST /upload %d", len(body)); string(got) != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestUDPPacketConn(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	server, err := gonet.ListenUDP(sb, stack.FullAddress{Port: 53})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer server.Close()
+
+	// 通过net.PacketConn接口回显
+	go func(pc net.PacketConn) {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(bytes.ToUpper(buf[:n]), from)
+		}
+	}(server)
+
+	var client net.PacketConn
+	client, err = gonet.ListenUDP(sa, stack.FullAddress{})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	to := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 53}
+	if _, err := client.WriteTo([]byte("hello"), to); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	buf := make([]byte, 1500)
+	n, from, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if string(buf[:n]) != "HELLO" || from.String() != "10.0.0.2:53" {
+		t.Errorf("Unexpected reply %q from %v", buf[:n], from)
+	}
+
+	// 已连接的UDPConn作为net.Conn使用
+	conn, err := gonet.DialUDP(sa, stack.FullAddress{IP: hostBIP, Port: 53})
+	if err != nil {
+		t.Fatalf("DialUDP failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprint(conn, "world")
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "WORLD" {
+		t.Errorf("Expected WORLD, got %q, %v", buf[:n], err)
+	}
+	if conn.RemoteAddr().String() != "10.0.0.2:53" {
+		t.Errorf("Unexpected remote address %v", conn.RemoteAddr())
+	}
+
+	if _, err := client.WriteTo([]byte("x"), &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53}); err == nil {
+		t.Errorf("Expected error writing to an IPv6 address")
+	}
+}
+
+func TestUDPConnDeadlineAndDrops(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	receiver := gonet.NewUDPConn(udp.NewEndpoint(sb, 2))
+	defer receiver.Close()
+	if err := receiver.Endpoint().Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	receiver.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := receiver.ReadFrom(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+	receiver.SetReadDeadline(time.Time{})
+
+	// 队列长度为2，多余的数据报被丢弃并计数
+	sender, err := gonet.DialUDP(sa, stack.FullAddress{IP: hostBIP, Port: 9000})
+	if err != nil {
+		t.Fatalf("DialUDP failed: %v", err)
+	}
+	defer sender.Close()
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(sender, "datagram %d", i)
+	}
+	waitFor(t, "drops", func() bool {
+		st := receiver.Stats()
+		return st.Received == 2 && st.Dropped == 3
+	})
+
+	// 读取缓冲区较小时截断
+	buf := make([]byte, 4)
+	if n, _, err := receiver.ReadFrom(buf); err != nil || string(buf[:n]) != "data" {
+		t.Errorf("Expected truncated datagram, got %q, %v", buf[:n], err)
+	}
+
+	// 关闭后先返回队列中剩余的数据报
+	receiver.Close()
+	receiver.ReadFrom(buf)
+	if _, _, err := receiver.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 80)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := gonet.Dial(sa, "tcp4", "10.0.0.2:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// 握手完成后才返回，本地端口为临时端口
+	c := conn.(*gonet.TCPConn)
+	if state := c.Endpoint().State(); state != tcp.StateEstablished {
+		t.Errorf("Expected ESTABLISHED after Dial, got %s", state)
+	}
+	if port := conn.LocalAddr().(*net.TCPAddr).Port; port < stack.EphemeralPortFirst || port > stack.EphemeralPortLast {
+		t.Errorf("Expected ephemeral local port, got %d", port)
+	}
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprint(conn, "hello")
+	buf := make([]byte, 16)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("Expected hello, got %q, %v", buf[:n], err)
+	}
+
+	// 两个连接使用不同的临时端口
+	other, err := gonet.Dial(sa, "tcp", "10.0.0.2:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer other.Close()
+	if other.LocalAddr().String() == conn.LocalAddr().String() {
+		t.Errorf("Connections share local address %s", conn.LocalAddr())
+	}
+
+	udpConn, err := gonet.Dial(sa, "udp", "10.0.0.2:53")
+	if err != nil {
+		t.Fatalf("Dial udp failed: %v", err)
+	}
+	defer udpConn.Close()
+	if _, ok := udpConn.(*gonet.UDPConn); !ok {
+		t.Errorf("Expected *gonet.UDPConn, got %T", udpConn)
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	if _, err := gonet.Dial(sa, "tcp", "10.0.0.2:81"); !errors.Is(err, stack.ErrConnectionRefused) {
+		t.Errorf("Expected connection refused, got %v", err)
+	}
+	if _, err := gonet.Dial(sa, "tcp", "192.168.1.1:80"); err == nil {
+		t.Errorf("Expected error dialing without a route")
+	}
+	if _, err := gonet.Dial(sa, "tcp", "example.com:80"); err == nil {
+		t.Errorf("Expected error for a host name")
+	}
+	d := &gonet.Dialer{Stack: sa, LocalAddr: &stack.FullAddress{IP: hostBIP, Port: 4000}}
+	if _, err := d.Dial("tcp", "10.0.0.2:80"); err == nil {
+		t.Errorf("Expected error binding to a non-local address")
+	}
+	var unknown net.UnknownNetworkError
+	if _, err := gonet.Dial(sa, "tcp6", "10.0.0.2:80"); !errors.As(err, &unknown) {
+		t.Errorf("Expected unknown network error, got %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	// 对端丢弃所有帧，SYN得不到回复
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	b.Attach(func([]byte) {})
+	sa := newStack(t, a, hostAIP)
+	sa.AddNeighbor(hostBIP, hostBMAC)
+
+	d := &gonet.Dialer{Stack: sa, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := d.Dial("tcp", "10.0.0.2:80")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Dial took %v", elapsed)
+	}
+
+	// ctx取消
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := gonet.DialContext(ctx, sa, "tcp", "10.0.0.2:80"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,547 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"ustack/pkg/gonet"
+	uhttp "ustack/pkg/http"
+	"ustack/pkg/stack"
+)
+
+// countingListener 统计接受的连接数
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return c, err
+}
+
+// startHTTPServer 在协议栈上启动HTTP服务端
+func startHTTPServer(t *testing.T, s *stack.Stack, port uint16, handler uhttp.Handler) *countingListener {
+	t.Helper()
+
+	l := &countingListener{Listener: listenGonet(t, s, port)}
+	srv := &uhttp.Server{Handler: handler, ReadTimeout: 5 * time.Second}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l
+}
+
+// testMux 回显请求信息的路由
+func testMux() *uhttp.ServeMux {
+	mux := uhttp.NewServeMux()
+	mux.HandleFunc("GET /hello", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		fmt.Fprintf(w, "hello %s", r.Query().Get("name"))
+	})
+	mux.HandleFunc("POST /echo", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			uhttp.Error(w, err.Error(), uhttp.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %d %s", r.Method, r.Path, r.ContentLength, body)
+	})
+	mux.HandleFunc("/stream/", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		for i := 0; i < 64; i++ {
+			w.Write(bytes.Repeat([]byte{byte('a' + i%26)}, 1024))
+		}
+	})
+	return mux
+}
+
+// dialHTTP 建立到服务端的原始连接
+func dialHTTP(t *testing.T, s *stack.Stack, addr string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	c, err := gonet.Dial(s, "tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c, bufio.NewReader(c)
+}
+
+// readHTTPResponse 读取一个完整的响应
+func readHTTPResponse(t *testing.T, br *bufio.Reader, method string) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := http.ReadResponse(br, &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestHTTPServerSplitRequest(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testMux())
+	c, br := dialHTTP(t, sa, "10.0.0.2:80")
+
+	// 每个字节单独成段
+	req := "POST /echo HTTP/1.1\r\nHost: test\r\nContent-Length: 5\r\n\r\nhello"
+	for i := 0; i < len(req); i++ {
+		if _, err := c.Write([]byte{req[i]}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	resp, body := readHTTPResponse(t, br, "POST")
+	if resp.StatusCode != 200 || body != "POST /echo 5 hello" {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+	if resp.ContentLength != int64(len(body)) || resp.Close {
+		t.Errorf("Expected Content-Length %d and keep-alive, got %d close=%v", len(body), resp.ContentLength, resp.Close)
+	}
+	if resp.Header.Get("Date") == "" {
+		t.Errorf("Missing Date header")
+	}
+}
+
+func TestHTTPServerPipelining(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testMux())
+	c, br := dialHTTP(t, sa, "10.0.0.2:80")
+
+	// 三个请求一次写出，第二个使用分块编码
+	reqs := "GET /hello?name=a HTTP/1.1\r\nHost: test\r\n\r\n" +
+		"POST /echo HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n4;ext=1\r\ndefg\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+		"GET /hello?name=c HTTP/1.1\r\nHost: test\r\n\r\n"
+	if _, err := io.WriteString(c, reqs); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	for _, want := range []struct{ method, body string }{
+		{"GET", "hello a"},
+		{"POST", "POST /echo -1 abcdefg"},
+		{"GET", "hello c"},
+	} {
+		resp, body := readHTTPResponse(t, br, want.method)
+		if resp.StatusCode != 200 || body != want.body {
+			t.Errorf("Expected %q, got %d %q", want.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestHTTPServerChunkedResponse(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testMux())
+
+	// HTTP/1.1下长度未知的大响应使用分块编码
+	c, br := dialHTTP(t, sa, "10.0.0.2:80")
+	io.WriteString(c, "GET /stream/x HTTP/1.1\r\nHost: test\r\n\r\n")
+	resp, body := readHTTPResponse(t, br, "GET")
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" || len(body) != 64*1024 {
+		t.Errorf("Expected 64 KiB chunked body, got %v %d", resp.TransferEncoding, len(body))
+	}
+
+	// HTTP/1.0不支持分块编码，以关闭连接结束响应
+	c, br = dialHTTP(t, sa, "10.0.0.2:80")
+	io.WriteString(c, "GET /stream/x HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	resp, body = readHTTPResponse(t, br, "GET")
+	if len(resp.TransferEncoding) != 0 || !resp.Close || len(body) != 64*1024 {
+		t.Errorf("Expected 64 KiB body ended by close, got %v close=%v %d", resp.TransferEncoding, resp.Close, len(body))
+	}
+
+	// HEAD只返回头部
+	c, br = dialHTTP(t, sa, "10.0.0.2:80")
+	io.WriteString(c, "HEAD /hello?name=x HTTP/1.1\r\nHost: test\r\n\r\nGET /hello?name=y HTTP/1.1\r\nHost: test\r\n\r\n")
+	resp, body = readHTTPResponse(t, br, "HEAD")
+	if resp.ContentLength != 7 || body != "" {
+		t.Errorf("Expected HEAD with Content-Length 7 and no body, got %d %q", resp.ContentLength, body)
+	}
+	if _, body = readHTTPResponse(t, br, "GET"); body != "hello y" {
+		t.Errorf("Expected response after HEAD, got %q", body)
+	}
+}
+
+func TestHTTPServerKeepAlive(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := startHTTPServer(t, sb, 80, testMux())
+
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{DialContext: (&gonet.Dialer{Stack: sa}).DialContext},
+	}
+	defer client.CloseIdleConnections()
+
+	for i := 0; i < 5; i++ {
+		data := strings.Repeat("x", 5000*i)
+		resp, err := client.Post("http://10.0.0.2/echo", "text/plain", strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("Request %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := fmt.Sprintf("POST /echo %d %s", len(data), data); string(body) != want {
+			t.Errorf("Request %d: unexpected body of %d bytes", i, len(body))
+		}
+	}
+	if n := l.accepted.Load(); n != 1 {
+		t.Errorf("Expected one persistent connection, got %d", n)
+	}
+
+	// HTTP/1.0默认响应后关闭
+	c, br := dialHTTP(t, sa, "10.0.0.2:80")
+	io.WriteString(c, "GET /hello HTTP/1.0\r\n\r\n")
+	if resp, _ := readHTTPResponse(t, br, "GET"); !resp.Close {
+		t.Errorf("Expected HTTP/1.0 response to close the connection")
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("Expected EOF after HTTP/1.0 response, got %v", err)
+	}
+}
+
+func TestHTTPServerExpectContinue(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testMux())
+	c, br := dialHTTP(t, sa, "10.0.0.2:80")
+
+	io.WriteString(c, "POST /echo HTTP/1.1\r\nHost: test\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\n")
+	line, err := br.ReadString('\n')
+	if err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("Expected 100 Continue, got %q (%v)", line, err)
+	}
+	br.ReadString('\n')
+	io.WriteString(c, "abc")
+	if _, body := readHTTPResponse(t, br, "POST"); body != "POST /echo 3 abc" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestHTTPServerErrors(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testMux())
+
+	tests := []struct {
+		name   string
+		req    string
+		status int
+		close  bool
+	}{
+		{"NotFound", "GET /missing HTTP/1.1\r\nHost: t\r\n\r\n", 404, false},
+		{"MethodNotAllowed", "DELETE /echo HTTP/1.1\r\nHost: t\r\n\r\n", 405, false},
+		{"UncleanPath", "GET /a/../hello HTTP/1.1\r\nHost: t\r\n\r\n", 301, false},
+		{"MalformedRequestLine", "GET /hello\r\n\r\n", 400, true},
+		{"MissingHost", "GET /hello HTTP/1.1\r\n\r\n", 400, true},
+		{"BadHeader", "GET /hello HTTP/1.1\r\nHost : t\r\n\r\n", 400, true},
+		{"LineFolding", "GET /hello HTTP/1.1\r\nHost: t\r\n X: y\r\n\r\n", 400, true},
+		{"Smuggling", "POST /echo HTTP/1.1\r\nHost: t\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n", 400, true},
+		{"BadChunkedBody", "POST /echo HTTP/1.1\r\nHost: t\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", 400, true},
+		{"UnknownEncoding", "POST /echo HTTP/1.1\r\nHost: t\r\nTransfer-Encoding: gzip\r\n\r\n", 501, true},
+		{"Version", "GET /hello HTTP/2.0\r\nHost: t\r\n\r\n", 505, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, br := dialHTTP(t, sa, "10.0.0.2:80")
+			io.WriteString(c, tt.req)
+			resp, _ := readHTTPResponse(t, br, "GET")
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, resp.StatusCode)
+			}
+			if tt.close && !resp.Close {
+				t.Errorf("Expected connection to be closed")
+			}
+			switch tt.status {
+			case 405:
+				if allow := resp.Header.Get("Allow"); allow != "POST" {
+					t.Errorf("Expected Allow: POST, got %q", allow)
+				}
+			case 301:
+				if loc := resp.Header.Get("Location"); loc != "/hello" {
+					t.Errorf("Expected redirect to /hello, got %q", loc)
+				}
+			}
+		})
+	}
+}
+
+// testClientMux 在testMux基础上增加重定向和慢响应
+func testClientMux() *uhttp.ServeMux {
+	mux := testMux()
+	mux.HandleFunc("/redirect/", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.Path, "/redirect/"))
+		uhttp.Redirect(w, r, "/echo", code)
+	})
+	mux.HandleFunc("GET /loop", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		uhttp.Redirect(w, r, "/loop", uhttp.StatusFound)
+	})
+	mux.HandleFunc("GET /echo", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.Path)
+	})
+	mux.HandleFunc("GET /slow", func(w uhttp.ResponseWriter, r *uhttp.Request) {
+		time.Sleep(500 * time.Millisecond)
+		io.WriteString(w, "late")
+	})
+	return mux
+}
+
+// readClientBody 读取并关闭响应体
+func readClientBody(t *testing.T, resp *uhttp.Response) string {
+	t.Helper()
+
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+	return string(body)
+}
+
+func TestHTTPClientRequests(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := startHTTPServer(t, sb, 80, testClientMux())
+	client := &uhttp.Client{Stack: sa, Timeout: 5 * time.Second}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("http://10.0.0.2/hello?name=client")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if body := readClientBody(t, resp); resp.StatusCode != 200 || resp.Status != "200 OK" || body != "hello client" {
+		t.Errorf("Unexpected response %q %q", resp.Status, body)
+	}
+	if resp.ContentLength != 12 || resp.Header.Get("Date") == "" {
+		t.Errorf("Expected Content-Length 12 and Date, got %d %q", resp.ContentLength, resp.Header.Get("Date"))
+	}
+
+	// 长度已知的消息体使用Content-Length
+	resp, err = client.Post("http://10.0.0.2/echo", "text/plain", strings.NewReader("abc"))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	if body := readClientBody(t, resp); body != "POST /echo 3 abc" {
+		t.Errorf("Unexpected body %q", body)
+	}
+
+	// 长度未知的消息体使用分块编码
+	data := strings.Repeat("y", 10000)
+	req, _ := uhttp.NewRequest("POST", "http://10.0.0.2/echo", io.MultiReader(strings.NewReader(data), strings.NewReader("z")))
+	req.Header.Set("X-Test", "1")
+	if resp, err = client.Do(req); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if body := readClientBody(t, resp); body != "POST /echo -1 "+data+"z" {
+		t.Errorf("Unexpected chunked echo of %d bytes", len(body))
+	}
+
+	// 分块编码的响应跨越多个TCP段
+	if resp, err = client.Get("http://10.0.0.2/stream/x"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if body := readClientBody(t, resp); !resp.Chunked || resp.ContentLength != -1 || len(body) != 64*1024 {
+		t.Errorf("Expected 64 KiB chunked body, got chunked=%v %d", resp.Chunked, len(body))
+	}
+
+	// HEAD没有消息体，连接仍可复用
+	if resp, err = client.Head("http://10.0.0.2/hello"); err != nil {
+		t.Fatalf("Head failed: %v", err)
+	}
+	if body := readClientBody(t, resp); resp.ContentLength != 6 || body != "" {
+		t.Errorf("Expected HEAD with Content-Length 6 and no body, got %d %q", resp.ContentLength, body)
+	}
+
+	if n := l.accepted.Load(); n != 1 {
+		t.Errorf("Expected one persistent connection, got %d", n)
+	}
+}
+
+func TestHTTPClientRedirect(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testClientMux())
+	client := &uhttp.Client{Stack: sa, Timeout: 5 * time.Second}
+	defer client.CloseIdleConnections()
+
+	// 302改为GET，307保留方法和消息体
+	for _, tt := range []struct {
+		code int
+		want string
+	}{
+		{302, "GET /echo"},
+		{303, "GET /echo"},
+		{307, "POST /echo 4 data"},
+		{308, "POST /echo 4 data"},
+	} {
+		resp, err := client.Post(fmt.Sprintf("http://10.0.0.2/redirect/%d", tt.code), "text/plain", strings.NewReader("data"))
+		if err != nil {
+			t.Fatalf("Post %d failed: %v", tt.code, err)
+		}
+		if body := readClientBody(t, resp); body != tt.want || resp.Request.URL.Path != "/echo" {
+			t.Errorf("%d: expected %q at /echo, got %q at %s", tt.code, tt.want, body, resp.Request.URL.Path)
+		}
+	}
+
+	// 超过重定向次数返回错误
+	client.MaxRedirects = 3
+	if _, err := client.Get("http://10.0.0.2/loop"); err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Errorf("Expected redirect limit error, got %v", err)
+	}
+
+	// 不跟随时直接返回3xx
+	client.MaxRedirects = -1
+	resp, err := client.Get("http://10.0.0.2/loop")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	readClientBody(t, resp)
+	if resp.StatusCode != 302 || resp.Header.Get("Location") != "/loop" {
+		t.Errorf("Expected 302 to /loop, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	sa, sb := newStackPair(t)
+	startHTTPServer(t, sb, 80, testClientMux())
+	client := &uhttp.Client{Stack: sa, Timeout: 100 * time.Millisecond}
+	defer client.CloseIdleConnections()
+
+	start := time.Now()
+	_, err := client.Get("http://10.0.0.2/slow")
+	var uerr *url.Error
+	if !errors.As(err, &uerr) || !uerr.Timeout() {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Errorf("Timeout took too long: %v", d)
+	}
+
+	// 超时后客户端仍可继续使用
+	client.Timeout = 5 * time.Second
+	resp, err := client.Get("http://10.0.0.2/hello?name=again")
+	if err != nil {
+		t.Fatalf("Get after timeout failed: %v", err)
+	}
+	if body := readClientBody(t, resp); body != "hello again" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+// getWithHeader 发送带额外头部的请求并读取完整响应
+func getWithHeader(t *testing.T, client *uhttp.Client, method, rawURL string, header map[string]string) (*uhttp.Response, string) {
+	t.Helper()
+
+	req, err := uhttp.NewRequest(method, rawURL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, rawURL, err)
+	}
+	return resp, readClientBody(t, resp)
+}
+
+func TestHTTPFileServer(t *testing.T) {
+	root := t.TempDir()
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = byte(i * 7 % 251)
+	}
+	os.WriteFile(filepath.Join(root, "big.bin"), data, 0o644)
+	os.WriteFile(filepath.Join(root, "notes"), []byte("plain text notes\n"), 0o644)
+	os.Mkdir(filepath.Join(root, "site"), 0o755)
+	os.WriteFile(filepath.Join(root, "site", "index.html"), []byte("<html>site</html>"), 0o644)
+	os.Mkdir(filepath.Join(root, "empty"), 0o755)
+	os.WriteFile(filepath.Join(root, "empty", "a<b>.txt"), []byte("x"), 0o644)
+
+	sa, sb := newStackPair(t)
+	mux := uhttp.NewServeMux()
+	mux.Handle("GET /", uhttp.FileServer(root))
+	startHTTPServer(t, sb, 80, mux)
+	client := &uhttp.Client{Stack: sa, Timeout: 30 * time.Second}
+	defer client.CloseIdleConnections()
+
+	// 多兆字节的完整文件
+	resp, body := getWithHeader(t, client, "GET", "http://10.0.0.2/big.bin", nil)
+	if resp.StatusCode != 200 || body != string(data) {
+		t.Fatalf("Expected 4 MiB file, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" || resp.Header.Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("Unexpected headers %v", resp.Header)
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("Missing validators: ETag=%q Last-Modified=%q", etag, lastModified)
+	}
+
+	// 内容类型按扩展名或内容确定
+	if resp, _ = getWithHeader(t, client, "GET", "http://10.0.0.2/notes", nil); resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Expected sniffed text/plain, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	// HEAD只返回头部
+	resp, body = getWithHeader(t, client, "HEAD", "http://10.0.0.2/big.bin", nil)
+	if resp.StatusCode != 200 || resp.ContentLength != int64(len(data)) || body != "" {
+		t.Errorf("Expected HEAD with Content-Length %d, got %d %d %q", len(data), resp.StatusCode, resp.ContentLength, body)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		header map[string]string
+		status int
+		body   string
+		crange string
+	}{
+		{"Range", map[string]string{"Range": "bytes=100-199"}, 206, string(data[100:200]), fmt.Sprintf("bytes 100-199/%d", len(data))},
+		{"OpenRange", map[string]string{"Range": "bytes=4194300-"}, 206, string(data[4194300:]), fmt.Sprintf("bytes 4194300-4194303/%d", len(data))},
+		{"SuffixRange", map[string]string{"Range": "bytes=-10"}, 206, string(data[len(data)-10:]), fmt.Sprintf("bytes %d-%d/%d", len(data)-10, len(data)-1, len(data))},
+		{"Unsatisfiable", map[string]string{"Range": "bytes=5000000-"}, 416, "", fmt.Sprintf("bytes */%d", len(data))},
+		{"MultipleRanges", map[string]string{"Range": "bytes=0-1,5-6"}, 200, string(data), ""},
+		{"IfRangeMatch", map[string]string{"Range": "bytes=0-3", "If-Range": etag}, 206, string(data[:4]), fmt.Sprintf("bytes 0-3/%d", len(data))},
+		{"IfRangeStale", map[string]string{"Range": "bytes=0-3", "If-Range": `"stale"`}, 200, string(data), ""},
+		{"IfNoneMatch", map[string]string{"If-None-Match": `"other", ` + etag}, 304, "", ""},
+		{"IfModifiedSince", map[string]string{"If-Modified-Since": lastModified}, 304, "", ""},
+		{"ModifiedSince", map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"}, 200, string(data), ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := getWithHeader(t, client, "GET", "http://10.0.0.2/big.bin", tt.header)
+			if resp.StatusCode != tt.status || (tt.status != 416 && body != tt.body) {
+				t.Errorf("Expected %d with %d bytes, got %d with %d bytes", tt.status, len(tt.body), resp.StatusCode, len(body))
+			}
+			if crange := resp.Header.Get("Content-Range"); crange != tt.crange {
+				t.Errorf("Expected Content-Range %q, got %q", tt.crange, crange)
+			}
+		})
+	}
+
+	// 目录返回index.html，没有时返回转义后的文件列表
+	if resp, body = getWithHeader(t, client, "GET", "http://10.0.0.2/site/", nil); body != "<html>site</html>" {
+		t.Errorf("Expected index.html, got %d %q", resp.StatusCode, body)
+	}
+	if resp, body = getWithHeader(t, client, "GET", "http://10.0.0.2/empty/", nil); !strings.Contains(body, `<a href="a%3Cb%3E.txt">a&lt;b&gt;.txt</a> 1`) {
+		t.Errorf("Unexpected directory listing %q", body)
+	}
+	client.MaxRedirects = -1
+	if resp, _ = getWithHeader(t, client, "GET", "http://10.0.0.2/site", nil); resp.StatusCode != 301 || resp.Header.Get("Location") != "site/" {
+		t.Errorf("Expected redirect to site/, got %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp, _ = getWithHeader(t, client, "GET", "http://10.0.0.2/missing", nil); resp.StatusCode != 404 {
+		t.Errorf("Expected 404, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,235 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+// writeRawIPv4 从原始链路端发送一个IPv4报文
+func writeRawIPv4(t *testing.T, ep link.Endpoint, dstMAC [6]byte, h *ip.Header, payload []byte) {
+	t.Helper()
+
+	h.TotalLength = uint16(h.HeaderLength() + len(payload))
+	header, err := h.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal IP header: %v", err)
+	}
+	frame, err := eth.NewFrame(ep.MACAddress(), dstMAC, eth.EtherTypeIPv4, append(header, payload...)).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal frame: %v", err)
+	}
+	if err := ep.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+}
+
+// readRawIPv4 从原始链路端读取下一个指定协议的IPv4报文
+func readRawIPv4(t *testing.T, frames chan []byte, protocol uint8, timeout time.Duration) (*ip.Header, []byte, bool) {
+	t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case data := <-frames:
+			f := &eth.Frame{}
+			if err := f.Unmarshal(data); err != nil || f.EtherType != eth.EtherTypeIPv4 {
+				continue
+			}
+			h := &ip.Header{}
+			if err := h.Unmarshal(f.Payload); err != nil || h.Protocol != protocol {
+				continue
+			}
+			return h, f.Payload[h.HeaderLength():h.TotalLength], true
+		case <-deadline:
+			return nil, nil, false
+		}
+	}
+}
+
+func TestICMPEchoResponder(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	newStack(t, a, hostAIP).AddNeighbor(hostBIP, hostBMAC)
+
+	frames := make(chan []byte, 16)
+	b.Attach(func(frame []byte) { frames <- frame })
+
+	request, err := icmp.NewEchoRequest(0x4242, 7, []byte("ping payload")).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal echo request: %v", err)
+	}
+	writeRawIPv4(t, b, hostAMAC, ip.NewHeader(hostBIP, hostAIP, ip.ProtocolICMP, 0), request)
+
+	h, payload, ok := readRawIPv4(t, frames, ip.ProtocolICMP, 2*time.Second)
+	if !ok {
+		t.Fatalf("Timed out waiting for echo reply")
+	}
+	if h.SourceIP != hostAIP || h.DestinationIP != hostBIP {
+		t.Errorf("Echo reply has wrong addresses: %s", h)
+	}
+
+	reply := &icmp.Packet{}
+	if err := reply.Unmarshal(payload); err != nil {
+		t.Fatalf("Failed to unmarshal echo reply: %v", err)
+	}
+	if !reply.IsEchoReply() || reply.ID != 0x4242 || reply.Sequence != 7 {
+		t.Errorf("Unexpected reply: %s", reply)
+	}
+	if !bytes.Equal(reply.Data, []byte("ping payload")) {
+		t.Errorf("Echo reply data mismatch: %q", reply.Data)
+	}
+
+	// 校验和错误的请求不会得到回复
+	request[2] ^= 0xff
+	writeRawIPv4(t, b, hostAMAC, ip.NewHeader(hostBIP, hostAIP, ip.ProtocolICMP, 0), request)
+	if _, _, ok := readRawIPv4(t, frames, ip.ProtocolICMP, 200*time.Millisecond); ok {
+		t.Errorf("Echo request with bad checksum must be dropped")
+	}
+}
+
+func TestICMPUnmarshalChecksum(t *testing.T) {
+	data, err := icmp.NewEchoRequest(1, 1, []byte("abc")).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	p := &icmp.Packet{}
+	if err := p.Unmarshal(data); err != nil {
+		t.Fatalf("Valid packet rejected: %v", err)
+	}
+
+	data[len(data)-1] ^= 0x01
+	if err := p.Unmarshal(data); err == nil {
+		t.Errorf("Expected checksum error")
+	}
+}
+
+func TestICMPErrorRateLimit(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	s := newStack(t, a, hostAIP)
+	s.AddNeighbor(hostBIP, hostBMAC)
+	s.SetICMPRateLimit(1, 2)
+
+	frames := make(chan []byte, 16)
+	b.Attach(func(frame []byte) { frames <- frame })
+
+	for i := 0; i < 5; i++ {
+		datagram, _ := udp.NewPacket(4000, 7777, []byte{byte(i)}).Marshal(hostBIP, hostAIP)
+		writeRawIPv4(t, b, hostAMAC, ip.NewHeader(hostBIP, hostAIP, ip.ProtocolUDP, 0), datagram)
+	}
+
+	count := 0
+	for {
+		if _, _, ok := readRawIPv4(t, frames, ip.ProtocolICMP, 300*time.Millisecond); !ok {
+			break
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 ICMP errors within the burst, got %d", count)
+	}
+}
+
+func TestICMPRateLimiter(t *testing.T) {
+	l := icmp.NewRateLimiter(100, 3)
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Fatalf("Burst token %d should be allowed", i)
+		}
+	}
+	if l.Allow() {
+		t.Errorf("Bucket should be empty")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !l.Allow() {
+		t.Errorf("Tokens should refill over time")
+	}
+}
+
+func TestICMPErrorConstruction(t *testing.T) {
+	datagram, _ := udp.NewPacket(5000, 53, bytes.Repeat([]byte{0xaa}, 100)).Marshal(hostAIP, hostBIP)
+	h := ip.NewHeader(hostAIP, hostBIP, ip.ProtocolUDP, uint16(ip.IPHeaderLength+len(datagram)))
+	h.Flags = ip.FlagDF
+	header, _ := h.Marshal()
+	original := append(header, datagram...)
+
+	data, err := icmp.NewFragNeeded(1400, original).Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if len(data) != 8+ip.IPHeaderLength+icmp.ErrorQuoteLength {
+		t.Errorf("Error message should quote the IP header and 8 bytes, got %d bytes", len(data))
+	}
+
+	msg := &icmp.Packet{}
+	if err := msg.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if !msg.IsError() || msg.NextHopMTU() != 1400 {
+		t.Errorf("Unexpected message: %s, MTU %d", msg, msg.NextHopMTU())
+	}
+
+	quoted, transport, err := msg.OriginalDatagram()
+	if err != nil {
+		t.Fatalf("Failed to parse original datagram: %v", err)
+	}
+	if quoted.SourceIP != hostAIP || quoted.DestinationIP != hostBIP || quoted.Protocol != ip.ProtocolUDP {
+		t.Errorf("Quoted header mismatch: %s", quoted)
+	}
+	if !bytes.Equal(transport, datagram[:icmp.ErrorQuoteLength]) {
+		t.Errorf("Quoted transport header mismatch: %x", transport)
+	}
+
+	if icmp.NewTimeExceeded(icmp.CodeTTLExceeded, original).NextHopMTU() != 0 {
+		t.Errorf("Time Exceeded must not carry an MTU")
+	}
+}
+
+func TestUDPConnectionRefused(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	ep := udp.NewEndpoint(sa, 0)
+	defer ep.Close()
+	if err := ep.Connect(stack.FullAddress{IP: hostBIP, Port: 7777}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := ep.RecvFrom()
+		errCh <- err
+	}()
+
+	if _, err := ep.Send([]byte("anyone there?")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, stack.ErrConnectionRefused) {
+			t.Fatalf("Expected connection refused, got %v", err)
+		}
+		var terr *stack.TransportError
+		if !errors.As(err, &terr) || !terr.Hard || terr.From != hostBIP {
+			t.Errorf("Unexpected transport error: %+v", terr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for ICMP error")
+	}
+
+	if ep.Stats().ICMPErrors != 1 {
+		t.Errorf("Expected 1 ICMP error, got %d", ep.Stats().ICMPErrors)
+	}
+	// 差错只报告一次
+	if _, err := ep.Send([]byte("again")); err != nil {
+		t.Errorf("Pending error should have been cleared, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+)
+
+func TestIPIDGeneratorUnique(t *testing.T) {
+	g := ip.NewIDGenerator()
+	src := [4]byte{10, 0, 0, 1}
+	dst := [4]byte{10, 0, 0, 2}
+
+	seen := make(map[uint16]bool)
+	for i := 0; i < 1000; i++ {
+		id := g.Next(src, dst, ip.ProtocolUDP)
+		if seen[id] {
+			t.Fatalf("Duplicate IP identification %d after %d packets", id, i)
+		}
+		seen[id] = true
+	}
+
+	// 批量分配的标识互不重叠
+	first := g.Reserve(src, dst, ip.ProtocolUDP, 10)
+	next := g.Next(src, dst, ip.ProtocolUDP)
+	if uint16(next-first) < 10 {
+		t.Errorf("Reserved block overlaps: first=%d next=%d", first, next)
+	}
+}
+
+func TestIPFragment(t *testing.T) {
+	payload := make([]byte, 3000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	h := ip.NewHeader([4]byte{10, 0, 0, 1}, [4]byte{10, 0, 0, 2}, ip.ProtocolUDP, 0)
+	h.Identification = 0x1234
+
+	fragments, err := ip.Fragment(h, payload, 1500)
+	if err != nil {
+		t.Fatalf("Failed to fragment: %v", err)
+	}
+	if len(fragments) != 3 {
+		t.Fatalf("Expected 3 fragments, got %d", len(fragments))
+	}
+
+	var reassembled []byte
+	for i, frag := range fragments {
+		fh := &ip.Header{}
+		if err := fh.Unmarshal(frag); err != nil {
+			t.Fatalf("Failed to unmarshal fragment %d: %v", i, err)
+		}
+		if fh.Identification != 0x1234 {
+			t.Errorf("Fragment %d has identification %d", i, fh.Identification)
+		}
+		if int(fh.TotalLength) != len(frag) || len(frag) > 1500 {
+			t.Errorf("Fragment %d has bad length %d", i, fh.TotalLength)
+		}
+		if int(fh.FragmentOffset)*8 != len(reassembled) {
+			t.Errorf("Fragment %d has offset %d, expected %d", i, fh.FragmentOffset*8, len(reassembled))
+		}
+		more := fh.Flags&ip.FlagMF != 0
+		if more != (i < len(fragments)-1) {
+			t.Errorf("Fragment %d has wrong MF flag", i)
+		}
+		reassembled = append(reassembled, frag[ip.IPHeaderLength:]...)
+	}
+	if !bytes.Equal(reassembled, payload) {
+		t.Errorf("Reassembled payload mismatch")
+	}
+
+	h.Flags |= ip.FlagDF
+	if _, err := ip.Fragment(h, payload, 1500); err == nil {
+		t.Errorf("Expected error when fragmenting with DF set")
+	}
+}
+
+func TestStackWritePacketAssignsID(t *testing.T) {
+	macA := [6]byte{0x02, 0, 0, 0, 0, 0x01}
+	macB := [6]byte{0x02, 0, 0, 0, 0, 0x02}
+	a, b := link.NewPipe(macA, macB, 1500)
+
+	frames := make(chan []byte, 16)
+	b.Attach(func(frame []byte) { frames <- frame })
+
+	s := stack.New()
+	defer s.Close()
+	if err := s.AddNIC(1, a); err != nil {
+		t.Fatalf("Failed to add NIC: %v", err)
+	}
+	if err := s.AddAddress(1, [4]byte{10, 0, 0, 1}, 24); err != nil {
+		t.Fatalf("Failed to add address: %v", err)
+	}
+	s.AddNeighbor([4]byte{10, 0, 0, 2}, macB)
+
+	r, err := s.FindRoute([4]byte{10, 0, 0, 2})
+	if err != nil {
+		t.Fatalf("Failed to find route: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.WritePacket(r, ip.ProtocolUDP, make([]byte, 2000), stack.WriteOptions{}); err != nil {
+			t.Fatalf("Failed to write packet: %v", err)
+		}
+	}
+
+	var ids []uint16
+	for i := 0; i < 4; i++ {
+		select {
+		case data := <-frames:
+			frame := &eth.Frame{}
+			if err := frame.Unmarshal(data); err != nil {
+				t.Fatalf("Failed to unmarshal frame: %v", err)
+			}
+			if frame.DestinationMAC != macB {
+				t.Errorf("Frame sent to wrong MAC: %x", frame.DestinationMAC)
+			}
+			h := &ip.Header{}
+			if err := h.Unmarshal(frame.Payload); err != nil {
+				t.Fatalf("Failed to unmarshal IP header: %v", err)
+			}
+			ids = append(ids, h.Identification)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for fragment %d", i)
+		}
+	}
+
+	if ids[0] != ids[1] || ids[2] != ids[3] {
+		t.Errorf("Fragments of one packet must share identification: %v", ids)
+	}
+	if ids[0] == ids[2] {
+		t.Errorf("Consecutive packets must use different identifications: %v", ids)
+	}
+}
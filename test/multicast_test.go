@@ -0,0 +1,177 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/igmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+var mdnsGroup = [4]byte{224, 0, 0, 251}
+
+func TestUDPMulticastDelivery(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	receiver := udp.NewEndpoint(sb, 0)
+	defer receiver.Close()
+	if err := receiver.Bind(stack.FullAddress{Port: 5353}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	sender := udp.NewEndpoint(sa, 0)
+	defer sender.Close()
+	dst := stack.FullAddress{IP: mdnsGroup, Port: 5353}
+
+	if err := receiver.JoinGroup(mdnsGroup, 0); err != nil {
+		t.Fatalf("Failed to join group: %v", err)
+	}
+	if !sb.IsMember(1, mdnsGroup) {
+		t.Fatalf("Stack should be a member of the group")
+	}
+
+	if _, err := sender.SendTo([]byte("announce"), &dst); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	payload, from := recvWithTimeout(t, receiver)
+	if !bytes.Equal(payload, []byte("announce")) {
+		t.Errorf("Payload mismatch: %q", payload)
+	}
+	if from.IP != hostAIP {
+		t.Errorf("Unexpected source: %s", from)
+	}
+
+	receiver.Close()
+	if sb.IsMember(1, mdnsGroup) {
+		t.Errorf("Closing the endpoint should leave the group")
+	}
+}
+
+func TestUDPBroadcastRequiresOption(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	receiver := udp.NewEndpoint(sb, 0)
+	defer receiver.Close()
+	if err := receiver.Bind(stack.FullAddress{Port: 9999}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	// 同一索引分片中绑定到其他端口的端点收不到
+	other := udp.NewEndpoint(sb, 0)
+	defer other.Close()
+	if err := other.Bind(stack.FullAddress{Port: 9999 + 64}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	sender := udp.NewEndpoint(sa, 0)
+	defer sender.Close()
+	subnet := stack.FullAddress{IP: [4]byte{10, 0, 0, 255}, Port: 9999}
+	if _, err := sender.SendTo([]byte("x"), &subnet); !errors.Is(err, udp.ErrBroadcastDisabled) {
+		t.Fatalf("Expected ErrBroadcastDisabled, got %v", err)
+	}
+
+	sender.Broadcast = true
+	for _, dst := range []stack.FullAddress{subnet, {IP: [4]byte{255, 255, 255, 255}, Port: 9999}} {
+		dst := dst
+		if _, err := sender.SendTo([]byte("hello"), &dst); err != nil {
+			t.Fatalf("Failed to send broadcast to %s: %v", dst, err)
+		}
+		payload, _ := recvWithTimeout(t, receiver)
+		if !bytes.Equal(payload, []byte("hello")) {
+			t.Errorf("Payload mismatch: %q", payload)
+		}
+	}
+	if st := other.Stats(); st.Received != 0 {
+		t.Errorf("Endpoint on another port received %d broadcasts", st.Received)
+	}
+}
+
+// readIGMP 从原始链路端读取下一个IGMP消息
+func readIGMP(t *testing.T, frames chan []byte) (*eth.Frame, *ip.Header, *igmp.Message) {
+	t.Helper()
+
+	for {
+		select {
+		case data := <-frames:
+			f := &eth.Frame{}
+			if err := f.Unmarshal(data); err != nil {
+				t.Fatalf("Failed to unmarshal frame: %v", err)
+			}
+			h := &ip.Header{}
+			if err := h.Unmarshal(f.Payload); err != nil || h.Protocol != ip.ProtocolIGMP {
+				continue
+			}
+			msg := &igmp.Message{}
+			if err := msg.Unmarshal(f.Payload[h.HeaderLength():h.TotalLength]); err != nil {
+				t.Fatalf("Failed to unmarshal IGMP: %v", err)
+			}
+			return f, h, msg
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for IGMP message")
+		}
+	}
+}
+
+func TestIGMPReportsAndQueries(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	s := newStack(t, a, hostAIP)
+
+	frames := make(chan []byte, 16)
+	b.Attach(func(frame []byte) { frames <- frame })
+
+	// 默认以IGMPv3报告加入
+	if err := s.JoinGroup(1, mdnsGroup); err != nil {
+		t.Fatalf("Failed to join: %v", err)
+	}
+	f, h, msg := readIGMP(t, frames)
+	if msg.Type != igmp.TypeV3MembershipReport || len(msg.Records) != 1 ||
+		msg.Records[0].Type != igmp.RecordChangeToExclude || msg.Records[0].Multicast != mdnsGroup {
+		t.Fatalf("Expected IGMPv3 join report, got %s", msg)
+	}
+	if h.TTL != 1 || !bytes.Equal(h.Options, ip.RouterAlertOption) {
+		t.Errorf("IGMP must use TTL 1 and Router Alert, got TTL %d options %x", h.TTL, h.Options)
+	}
+	if h.DestinationIP != igmp.V3ReportsGroup || f.DestinationMAC != eth.IPv4MulticastMAC(igmp.V3ReportsGroup) {
+		t.Errorf("IGMPv3 report sent to wrong destination: %s", h)
+	}
+
+	// 收到IGMPv2查询后切换到v2兼容模式
+	query := &igmp.Message{Type: igmp.TypeMembershipQuery, MaxRespCode: 1}
+	queryData, _ := query.Marshal()
+	qh := ip.NewHeader(hostBIP, igmp.AllHostsGroup, ip.ProtocolIGMP, uint16(ip.IPHeaderLength+len(queryData)))
+	qh.TTL = 1
+	header, _ := qh.Marshal()
+	frame, _ := eth.NewFrame(hostBMAC, eth.IPv4MulticastMAC(igmp.AllHostsGroup), eth.EtherTypeIPv4,
+		append(header, queryData...)).Marshal()
+	if err := b.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write query: %v", err)
+	}
+
+	for {
+		_, h, msg = readIGMP(t, frames)
+		if msg.Type == igmp.TypeV2MembershipReport {
+			break
+		}
+	}
+	if msg.GroupAddress != mdnsGroup || h.DestinationIP != mdnsGroup {
+		t.Errorf("IGMPv2 report for wrong group: %s", msg)
+	}
+
+	if err := s.LeaveGroup(1, mdnsGroup); err != nil {
+		t.Fatalf("Failed to leave: %v", err)
+	}
+	for {
+		_, h, msg = readIGMP(t, frames)
+		if msg.Type == igmp.TypeV2LeaveGroup {
+			break
+		}
+	}
+	if h.DestinationIP != igmp.AllRoutersGroup || msg.GroupAddress != mdnsGroup {
+		t.Errorf("IGMPv2 leave sent incorrectly: %s", msg)
+	}
+}
@@ -0,0 +1,357 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/link"
+	"ustack/pkg/ndp"
+	"ustack/pkg/stack"
+)
+
+func TestNDPAddressDerivation(t *testing.T) {
+	mac := [6]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	// fe80::211:22ff:fe33:4455
+	expected := [16]byte{0xfe, 0x80, 8: 0x02, 0x11, 0x22, 0xff, 0xfe, 0x33, 0x44, 0x55}
+	if got := ndp.LinkLocalAddress(mac); got != expected {
+		t.Errorf("Link-local address mismatch: %x", got)
+	}
+
+	// ff02::1:ff33:4455
+	solicited := ndp.SolicitedNodeAddress(expected)
+	expectedSolicited := [16]byte{0xff, 0x02, 11: 0x01, 0xff, 0x33, 0x44, 0x55}
+	if solicited != expectedSolicited {
+		t.Errorf("Solicited-node address mismatch: %x", solicited)
+	}
+
+	if got := ndp.MulticastMAC(solicited); got != [6]byte{0x33, 0x33, 0xff, 0x33, 0x44, 0x55} {
+		t.Errorf("Multicast MAC mismatch: %x", got)
+	}
+}
+
+func TestNDPMessageRoundTrip(t *testing.T) {
+	src := ndp.LinkLocalAddress([6]byte{0x02, 0, 0, 0, 0, 0x01})
+	dst := ndp.AllNodesAddress
+	prefix := [16]byte{0x20, 0x01, 0x0d, 0xb8}
+
+	ra := &ndp.Message{
+		Type:           ndp.TypeRouterAdvertisement,
+		CurHopLimit:    64,
+		RouterLifetime: 1800,
+		Options: []ndp.Option{
+			ndp.NewLinkAddressOption(ndp.OptionSourceLinkAddress, [6]byte{0x02, 0, 0, 0, 0, 0x01}),
+			ndp.NewMTUOption(1400),
+			ndp.NewPrefixInfoOption(ndp.PrefixInfo{
+				PrefixLength:      64,
+				Flags:             ndp.PrefixFlagOnLink | ndp.PrefixFlagAutonomous,
+				ValidLifetime:     86400,
+				PreferredLifetime: 14400,
+				Prefix:            prefix,
+			}),
+		},
+	}
+
+	data, err := ra.Marshal(src, dst)
+	if err != nil {
+		t.Fatalf("Failed to marshal RA: %v", err)
+	}
+	if !ndp.VerifyChecksum(data, src, dst) {
+		t.Errorf("RA checksum does not verify")
+	}
+
+	parsed := &ndp.Message{}
+	if err := parsed.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to unmarshal RA: %v", err)
+	}
+	if parsed.RouterLifetime != 1800 || parsed.CurHopLimit != 64 {
+		t.Errorf("RA fields mismatch: %s", parsed)
+	}
+	if mtu, ok := parsed.MTU(); !ok || mtu != 1400 {
+		t.Errorf("Expected MTU 1400, got %d", mtu)
+	}
+	prefixes := parsed.Prefixes()
+	if len(prefixes) != 1 || !bytes.Equal(prefixes[0].Prefix[:], prefix[:]) {
+		t.Errorf("Prefix option mismatch: %+v", prefixes)
+	}
+}
+
+// ndpLink 将两个NDP端点异步连接起来
+type ndpLink struct {
+	endpoints []*ndp.Endpoint
+}
+
+func (l *ndpLink) sender(from int) ndp.SendFunc {
+	return func(src, dst [16]byte, _ [6]byte, data []byte) error {
+		for i, ep := range l.endpoints {
+			if i == from {
+				continue
+			}
+			go ep.HandleMessage(src, dst, ndp.HopLimit, data)
+		}
+		return nil
+	}
+}
+
+func newNDPEndpoints(link *ndpLink, macs ...[6]byte) []*ndp.Endpoint {
+	for i, mac := range macs {
+		ep := ndp.NewEndpoint(ndp.Config{
+			MAC:                    mac,
+			DupAddrDetectTransmits: 1,
+			RetransTimer:           50 * time.Millisecond,
+		}, link.sender(i))
+		link.endpoints = append(link.endpoints, ep)
+	}
+	return link.endpoints
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestNDPDuplicateAddressDetection(t *testing.T) {
+	link := &ndpLink{}
+	eps := newNDPEndpoints(link, [6]byte{0x02, 0, 0, 0, 0, 0x01}, [6]byte{0x02, 0, 0, 0, 0, 0x02})
+	defer eps[0].Stop()
+	defer eps[1].Stop()
+
+	eps[0].Start()
+	addr := ndp.LinkLocalAddress([6]byte{0x02, 0, 0, 0, 0, 0x01})
+	waitFor(t, "address to become preferred", func() bool { return eps[0].HasAddress(addr) })
+
+	// 第二个端点尝试使用同一地址
+	duplicate := make(chan [16]byte, 1)
+	eps[1].OnDuplicateAddress = func(a [16]byte) { duplicate <- a }
+	eps[1].AddAddress(addr, 64)
+
+	select {
+	case got := <-duplicate:
+		if got != addr {
+			t.Errorf("Unexpected duplicate address: %x", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Duplicate address was not detected")
+	}
+}
+
+func TestNDPAddressResolutionAndSLAAC(t *testing.T) {
+	macA := [6]byte{0x02, 0, 0, 0, 0, 0x0a}
+	macB := [6]byte{0x02, 0, 0, 0, 0, 0x0b}
+	link := &ndpLink{}
+	eps := newNDPEndpoints(link, macA, macB)
+	defer eps[0].Stop()
+	defer eps[1].Stop()
+
+	eps[0].Start()
+	eps[1].Start()
+	addrA := ndp.LinkLocalAddress(macA)
+	addrB := ndp.LinkLocalAddress(macB)
+	waitFor(t, "link-local addresses", func() bool { return eps[0].HasAddress(addrA) && eps[1].HasAddress(addrB) })
+
+	// A 解析 B 的链路层地址
+	eps[0].Resolve(addrB)
+	waitFor(t, "address resolution", func() bool {
+		mac, ok := eps[0].Resolve(addrB)
+		return ok && mac == macB
+	})
+
+	// 路由器通告前缀，B 自动配置全局地址
+	routerMAC := [6]byte{0x02, 0, 0, 0, 0, 0x99}
+	routerAddr := ndp.LinkLocalAddress(routerMAC)
+	prefix := [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x01}
+	ra := &ndp.Message{
+		Type:           ndp.TypeRouterAdvertisement,
+		CurHopLimit:    32,
+		RouterLifetime: 600,
+		Options: []ndp.Option{
+			ndp.NewLinkAddressOption(ndp.OptionSourceLinkAddress, routerMAC),
+			ndp.NewPrefixInfoOption(ndp.PrefixInfo{
+				PrefixLength:      64,
+				Flags:             ndp.PrefixFlagOnLink | ndp.PrefixFlagAutonomous,
+				ValidLifetime:     3600,
+				PreferredLifetime: 1800,
+				Prefix:            prefix,
+			}),
+		},
+	}
+	data, err := ra.Marshal(routerAddr, ndp.AllNodesAddress)
+	if err != nil {
+		t.Fatalf("Failed to marshal RA: %v", err)
+	}
+	if err := eps[1].HandleMessage(routerAddr, ndp.AllNodesAddress, ndp.HopLimit, data); err != nil {
+		t.Fatalf("Failed to handle RA: %v", err)
+	}
+
+	global := ndp.AutoconfAddress(prefix, macB)
+	waitFor(t, "SLAAC address", func() bool { return eps[1].HasAddress(global) })
+
+	if router, ok := eps[1].DefaultRouter(); !ok || router != routerAddr {
+		t.Errorf("Default router not learned")
+	}
+	if eps[1].CurHopLimit != 32 {
+		t.Errorf("Expected hop limit 32, got %d", eps[1].CurHopLimit)
+	}
+
+	// 跳数限制不是255的消息必须被丢弃
+	if err := eps[1].HandleMessage(routerAddr, ndp.AllNodesAddress, 64, data); err == nil {
+		t.Errorf("Expected error for hop limit != 255")
+	}
+}
+
+func TestNDPAddressLifetimes(t *testing.T) {
+	mac := [6]byte{0x02, 0, 0, 0, 0, 0x0c}
+	sources := make(chan [16]byte, 16)
+	ep := ndp.NewEndpoint(ndp.Config{
+		MAC:                    mac,
+		DupAddrDetectTransmits: 1,
+		RetransTimer:           50 * time.Millisecond,
+	}, func(src, _ [16]byte, _ [6]byte, data []byte) error {
+		if data[0] == ndp.TypeNeighborSolicitation && src != ndp.UnspecifiedAddress {
+			sources <- src
+		}
+		return nil
+	})
+	defer ep.Stop()
+	ep.Start()
+
+	// 第一个前缀的地址立即废弃并在1秒后过期，第二个前缀的地址保持首选
+	routerMAC := [6]byte{0x02, 0, 0, 0, 0, 0x99}
+	routerAddr := ndp.LinkLocalAddress(routerMAC)
+	deprecated := [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x01}
+	preferred := [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x02}
+	ra := &ndp.Message{
+		Type: ndp.TypeRouterAdvertisement,
+		Options: []ndp.Option{
+			ndp.NewPrefixInfoOption(ndp.PrefixInfo{
+				PrefixLength:  64,
+				Flags:         ndp.PrefixFlagOnLink | ndp.PrefixFlagAutonomous,
+				ValidLifetime: 1,
+				Prefix:        deprecated,
+			}),
+			ndp.NewPrefixInfoOption(ndp.PrefixInfo{
+				PrefixLength:      64,
+				Flags:             ndp.PrefixFlagOnLink | ndp.PrefixFlagAutonomous,
+				ValidLifetime:     3600,
+				PreferredLifetime: 1800,
+				Prefix:            preferred,
+			}),
+		},
+	}
+	data, err := ra.Marshal(routerAddr, ndp.AllNodesAddress)
+	if err != nil {
+		t.Fatalf("Failed to marshal RA: %v", err)
+	}
+	if err := ep.HandleMessage(routerAddr, ndp.AllNodesAddress, ndp.HopLimit, data); err != nil {
+		t.Fatalf("Failed to handle RA: %v", err)
+	}
+
+	oldAddr := ndp.AutoconfAddress(deprecated, mac)
+	newAddr := ndp.AutoconfAddress(preferred, mac)
+	waitFor(t, "SLAAC addresses", func() bool { return ep.HasAddress(oldAddr) && ep.HasAddress(newAddr) })
+
+	// 地址解析使用未废弃的全局地址作为源地址
+	target := [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	ep.Resolve(target)
+	select {
+	case src := <-sources:
+		if src != newAddr {
+			t.Errorf("Expected source %s, got %s", net.IP(newAddr[:]), net.IP(src[:]))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No neighbor solicitation sent")
+	}
+
+	// 超过有效期限后地址不再可用
+	waitFor(t, "address to expire", func() bool { return !ep.HasAddress(oldAddr) })
+	if !ep.HasAddress(newAddr) {
+		t.Errorf("Preferred address should stay valid")
+	}
+}
+
+func TestNDPOverStack(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	sa := newStack(t, a, hostAIP)
+	sb := newStack(t, b, hostBIP)
+
+	config := ndp.Config{DupAddrDetectTransmits: 1, RetransTimer: 50 * time.Millisecond}
+	for _, s := range []*stack.Stack{sa, sb} {
+		if err := s.EnableIPv6(1, config); err != nil {
+			t.Fatalf("EnableIPv6 failed: %v", err)
+		}
+	}
+	if err := sa.EnableIPv6(1, config); err == nil {
+		t.Errorf("Enabling IPv6 twice succeeded")
+	}
+
+	addrA := ndp.LinkLocalAddress(hostAMAC)
+	addrB := ndp.LinkLocalAddress(hostBMAC)
+	waitFor(t, "link-local addresses", func() bool {
+		return len(sa.IPv6Addresses(1)) == 1 && len(sb.IPv6Addresses(1)) == 1
+	})
+	if got := sa.IPv6Addresses(1)[0].Address; got != addrA {
+		t.Errorf("Link-local address = %x, want %x", got, addrA)
+	}
+
+	// 邻居请求和通告经由两端的IPv6输入输出路径
+	sa.NDP(1).Resolve(addrB)
+	waitFor(t, "address resolution", func() bool {
+		mac, ok := sa.NDP(1).Resolve(addrB)
+		return ok && mac == hostBMAC
+	})
+
+	// 从B一侧的链路注入路由器通告，A自动配置全局地址
+	routerMAC := [6]byte{0x02, 0, 0, 0, 0, 0x99}
+	routerAddr := ndp.LinkLocalAddress(routerMAC)
+	prefix := [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0x02}
+	ra := &ndp.Message{
+		Type:           ndp.TypeRouterAdvertisement,
+		RouterLifetime: 600,
+		Options: []ndp.Option{
+			ndp.NewLinkAddressOption(ndp.OptionSourceLinkAddress, routerMAC),
+			ndp.NewPrefixInfoOption(ndp.PrefixInfo{
+				PrefixLength:      64,
+				Flags:             ndp.PrefixFlagOnLink | ndp.PrefixFlagAutonomous,
+				ValidLifetime:     3600,
+				PreferredLifetime: 1800,
+				Prefix:            prefix,
+			}),
+		},
+	}
+	data, err := ra.Marshal(routerAddr, ndp.AllNodesAddress)
+	if err != nil {
+		t.Fatalf("Failed to marshal RA: %v", err)
+	}
+	packet := make([]byte, 40, 40+len(data))
+	packet[0] = 6 << 4
+	binary.BigEndian.PutUint16(packet[4:6], uint16(len(data)))
+	packet[6] = ndp.ProtocolICMPv6
+	packet[7] = ndp.HopLimit
+	copy(packet[8:24], routerAddr[:])
+	copy(packet[24:40], ndp.AllNodesAddress[:])
+	frame, _ := eth.NewFrame(routerMAC, ndp.MulticastMAC(ndp.AllNodesAddress), eth.EtherTypeIPv6, append(packet, data...)).Marshal()
+	b.WriteFrame(frame)
+
+	global := ndp.AutoconfAddress(prefix, hostAMAC)
+	waitFor(t, "SLAAC address", func() bool {
+		for _, addr := range sa.IPv6Addresses(1) {
+			if addr.Address == global {
+				return true
+			}
+		}
+		return false
+	})
+	if router, ok := sa.NDP(1).DefaultRouter(); !ok || router != routerAddr {
+		t.Errorf("Default router not learned")
+	}
+}
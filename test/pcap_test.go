@@ -0,0 +1,373 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"ustack/internal/utils"
+	"ustack/pkg/eth"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/pcap"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+// syncBuffer 可以被多个goroutine同时写入的缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// captureOnFailure 记录ep收发的帧，测试失败时写到USTACK_CAPTURE_DIR（默认为临时目录）下的pcapng文件，
+// 可以直接用Wireshark打开
+func captureOnFailure(t *testing.T, ep link.Endpoint) link.Endpoint {
+	t.Helper()
+
+	buf := &syncBuffer{}
+	w, err := pcap.NewNgWriter(buf, t.Name(), 0)
+	if err != nil {
+		t.Fatalf("NewNgWriter failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		dir := os.Getenv("USTACK_CAPTURE_DIR")
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		name := filepath.Join(dir, strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())+".pcapng")
+		if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+			t.Logf("Failed to write capture: %v", err)
+			return
+		}
+		t.Logf("Traffic captured to %s", name)
+	})
+	return pcap.NewCapture(ep, w)
+}
+
+func TestPcapWriterRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 123456789)
+	packets := []*pcap.Packet{
+		{Timestamp: start, Data: []byte("first frame"), Direction: pcap.DirectionInbound},
+		{Timestamp: start.Add(time.Millisecond), Data: []byte("second"), Direction: pcap.DirectionOutbound},
+		{Timestamp: start.Add(time.Second), Data: bytes.Repeat([]byte{0xab}, 100)},
+	}
+
+	for _, tt := range []struct {
+		name      string
+		newWriter func(io.Writer) (pcap.PacketWriter, error)
+		precision time.Duration
+		direction bool
+	}{
+		{"pcap", func(w io.Writer) (pcap.PacketWriter, error) { return pcap.NewWriter(w, 64) }, time.Microsecond, false},
+		{"pcapng", func(w io.Writer) (pcap.PacketWriter, error) { return pcap.NewNgWriter(w, "eth0", 64) }, time.Nanosecond, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := tt.newWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter failed: %v", err)
+			}
+			for _, p := range packets {
+				if err := w.WritePacket(p); err != nil {
+					t.Fatalf("WritePacket failed: %v", err)
+				}
+			}
+
+			r, err := pcap.OpenReader(&buf)
+			if err != nil {
+				t.Fatalf("OpenReader failed: %v", err)
+			}
+			if r.LinkType() != pcap.LinkTypeEthernet {
+				t.Errorf("LinkType = %d", r.LinkType())
+			}
+			for i, want := range packets {
+				p, err := r.ReadPacket()
+				if err != nil {
+					t.Fatalf("ReadPacket %d failed: %v", i, err)
+				}
+				data := want.Data
+				if len(data) > 64 {
+					data = data[:64]
+				}
+				if !bytes.Equal(p.Data, data) || p.Length != len(want.Data) {
+					t.Errorf("Packet %d: %d of %d bytes, want %d of %d", i, len(p.Data), p.Length, len(data), len(want.Data))
+				}
+				if !p.Timestamp.Equal(want.Timestamp.Truncate(tt.precision)) {
+					t.Errorf("Packet %d timestamp %v, want %v", i, p.Timestamp, want.Timestamp)
+				}
+				if tt.direction && p.Direction != want.Direction {
+					t.Errorf("Packet %d direction %d, want %d", i, p.Direction, want.Direction)
+				}
+			}
+			if _, err := r.ReadPacket(); err != io.EOF {
+				t.Errorf("Expected io.EOF, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPcapngReaderBigEndian(t *testing.T) {
+	be := binary.BigEndian
+	block := func(blockType uint32, body []byte) []byte {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		length := uint32(12 + len(body))
+		b := be.AppendUint32(be.AppendUint32(nil, blockType), length)
+		return be.AppendUint32(append(b, body...), length)
+	}
+
+	var file []byte
+	shb := be.AppendUint32(nil, 0x1a2b3c4d)
+	shb = be.AppendUint64(be.AppendUint32(shb, 1<<16), ^uint64(0))
+	file = append(file, block(0x0a0d0d0a, shb)...)
+	// 接口描述块：以太网，快照长度4，时间戳精度2^-10秒
+	idb := be.AppendUint32(be.AppendUint32(nil, pcap.LinkTypeEthernet<<16), 4)
+	idb = append(be.AppendUint32(idb, 9<<16|1), 0x8a, 0, 0, 0)
+	file = append(file, block(1, idb)...)
+	// 未知类型的块被跳过
+	file = append(file, block(0x0bad, []byte("skip"))...)
+	// 增强分组块：时间戳1.5秒
+	epb := be.AppendUint32(be.AppendUint32(be.AppendUint32(nil, 0), 0), 1536)
+	epb = append(be.AppendUint32(be.AppendUint32(epb, 3), 3), "abc"...)
+	file = append(file, block(6, epb)...)
+	// 简单分组块按快照长度截断
+	file = append(file, block(3, append(be.AppendUint32(nil, 6), "abcdef"...))...)
+
+	r, err := pcap.OpenReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatalf("OpenReader failed: %v", err)
+	}
+	p, err := r.ReadPacket()
+	if err != nil || string(p.Data) != "abc" || !p.Timestamp.Equal(time.Unix(1, 5e8)) {
+		t.Fatalf("Unexpected enhanced packet %+v (%v)", p, err)
+	}
+	p, err = r.ReadPacket()
+	if err != nil || string(p.Data) != "abcd" || p.Length != 6 {
+		t.Fatalf("Unexpected simple packet %+v (%v)", p, err)
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	// 尾部长度不一致
+	bad := append([]byte(nil), file...)
+	bad[len(bad)-1] ^= 0xff
+	r, _ = pcap.OpenReader(bytes.NewReader(bad))
+	r.ReadPacket()
+	if _, err := r.ReadPacket(); err == nil || err == io.EOF {
+		t.Errorf("Expected length mismatch error, got %v", err)
+	}
+}
+
+func TestCaptureEndpointReplay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.pcapng")
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	capture, err := pcap.CreateCapture(a, file)
+	if err != nil {
+		t.Fatalf("CreateCapture failed: %v", err)
+	}
+	sa := newStack(t, capture, hostAIP)
+	sb := newStack(t, b, hostBIP)
+	sa.AddNeighbor(hostBIP, hostBMAC)
+	sb.AddNeighbor(hostAIP, hostAMAC)
+
+	// hostB发送请求，hostA回复
+	server := udp.NewEndpoint(sa, 0)
+	defer server.Close()
+	if err := server.Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	client := udp.NewEndpoint(sb, 0)
+	defer client.Close()
+	client.SendTo([]byte("ping"), &stack.FullAddress{IP: hostAIP, Port: 9000})
+	payload, from := recvWithTimeout(t, server)
+	server.SendTo([]byte("pong:"+string(payload)), &from)
+	if reply, _ := recvWithTimeout(t, client); string(reply) != "pong:ping" {
+		t.Fatalf("Unexpected reply %q", reply)
+	}
+	sa.Close()
+	if err := capture.Err(); err != nil {
+		t.Fatalf("Capture error: %v", err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	r, err := pcap.OpenReader(f)
+	if err != nil {
+		t.Fatalf("OpenReader failed: %v", err)
+	}
+	var directions []pcap.Direction
+	for {
+		p, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadPacket failed: %v", err)
+		}
+		directions = append(directions, p.Direction)
+	}
+	if len(directions) != 2 || directions[0] != pcap.DirectionInbound || directions[1] != pcap.DirectionOutbound {
+		t.Fatalf("Captured directions %v, want [inbound outbound]", directions)
+	}
+
+	// 回放时只交付收到的帧
+	ep, err := pcap.OpenReplay(file, hostAMAC, 1500)
+	if err != nil {
+		t.Fatalf("OpenReplay failed: %v", err)
+	}
+	s := newStack(t, ep, hostAIP)
+	u := udp.NewEndpoint(s, 0)
+	defer u.Close()
+	if err := u.Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	ep.Start()
+	if payload, _ := recvWithTimeout(t, u); string(payload) != "ping" {
+		t.Errorf("Replayed %q, want ping", payload)
+	}
+	if err := ep.Err(); err != nil {
+		t.Errorf("Replay error: %v", err)
+	}
+}
+
+// writePcap 按pcap格式（小端、微秒精度）写出帧
+func writePcap(w io.Writer, start time.Time, gap time.Duration, frames ...[]byte) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], 65535)
+	binary.LittleEndian.PutUint32(hdr[20:24], pcap.LinkTypeEthernet)
+	w.Write(hdr)
+
+	for i, frame := range frames {
+		ts := start.Add(time.Duration(i) * gap)
+		rec := make([]byte, 16)
+		binary.LittleEndian.PutUint32(rec[0:4], uint32(ts.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:8], uint32(ts.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:12], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(rec[12:16], uint32(len(frame)))
+		w.Write(rec)
+		w.Write(frame)
+	}
+}
+
+// udpFrame 构造从hostB发往hostA的UDP帧
+func udpFrame(t *testing.T, dstPort uint16, payload string) []byte {
+	t.Helper()
+
+	data, err := udp.NewPacket(5000, dstPort, []byte(payload)).Marshal(hostBIP, hostAIP)
+	if err != nil {
+		t.Fatalf("Failed to marshal UDP packet: %v", err)
+	}
+	h := ip.NewHeader(hostBIP, hostAIP, ip.ProtocolUDP, uint16(ip.IPHeaderLength+len(data)))
+	header, _ := h.Marshal()
+	frame, _ := eth.NewFrame(hostBMAC, hostAMAC, eth.EtherTypeIPv4, append(header, data...)).Marshal()
+	return frame
+}
+
+func TestPcapReplay(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Unix(1700000000, 0)
+	writePcap(&buf, start, 50*time.Millisecond, udpFrame(t, 9000, "one"), udpFrame(t, 9000, "two"), udpFrame(t, 9000, "three"))
+
+	r, err := pcap.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	p, err := r.ReadPacket()
+	if err != nil || !p.Timestamp.Equal(start) || p.Length != len(p.Data) {
+		t.Fatalf("Unexpected first packet %+v (%v)", p, err)
+	}
+	if _, err := pcap.NewReader(strings.NewReader(strings.Repeat("x", 24))); err != pcap.ErrBadMagic {
+		t.Errorf("Expected ErrBadMagic, got %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "capture.pcap")
+	os.WriteFile(file, buf.Bytes(), 0o644)
+	ep, err := pcap.OpenReplay(file, hostAMAC, 1500)
+	if err != nil {
+		t.Fatalf("OpenReplay failed: %v", err)
+	}
+	s := newStack(t, ep, hostAIP)
+	u := udp.NewEndpoint(s, 0)
+	defer u.Close()
+	if err := u.Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+
+	began := time.Now()
+	ep.Start()
+	for _, want := range []string{"one", "two", "three"} {
+		payload, from := recvWithTimeout(t, u)
+		if string(payload) != want || from.IP != hostBIP {
+			t.Errorf("Expected %q from %v, got %q from %v", want, hostBIP, payload, from.IP)
+		}
+	}
+	// 按原始时间间隔回放
+	if d := time.Since(began); d < 90*time.Millisecond {
+		t.Errorf("Replay finished too fast: %v", d)
+	}
+	select {
+	case <-ep.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Replay did not finish")
+	}
+	if err := ep.Err(); err != nil {
+		t.Errorf("Unexpected replay error: %v", err)
+	}
+}
+
+func TestConfigCapture(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.pcap")
+	cfg, err := parseConfig("-link", "pipe", "-addr", "10.0.0.1/24", "-peer", "10.0.0.2", "-capture", file)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	n, err := cfg.Open(utils.NewLogger(utils.INFO))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	ep := udp.NewEndpoint(n.Stack, 0)
+	ep.SendTo([]byte("hello"), &stack.FullAddress{IP: hostBIP, Port: 9})
+	ep.Close()
+	n.Close()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	r, err := pcap.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	if p, err := r.ReadPacket(); err != nil || len(p.Data) < 14 {
+		t.Errorf("Expected a captured frame, got %+v (%v)", p, err)
+	}
+}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"ustack/pkg/arp"
+	"ustack/pkg/eth"
+	"ustack/pkg/link"
+	"ustack/pkg/ping"
+	"ustack/pkg/stack"
+)
+
+func TestARPRequestReply(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	newStack(t, a, hostAIP)
+
+	frames := make(chan []byte, 4)
+	b.Attach(func(frame []byte) { frames <- frame })
+
+	request, _ := arp.NewRequest(hostBMAC, hostBIP, hostAIP).Marshal()
+	frame, _ := eth.NewFrame(hostBMAC, eth.BroadcastMAC, eth.EtherTypeARP, request).Marshal()
+	if err := b.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	select {
+	case data := <-frames:
+		f := &eth.Frame{}
+		if err := f.Unmarshal(data); err != nil || f.EtherType != eth.EtherTypeARP {
+			t.Fatalf("Expected ARP frame, got %v (%v)", f, err)
+		}
+		reply := &arp.Packet{}
+		if err := reply.Unmarshal(f.Payload); err != nil {
+			t.Fatalf("Failed to unmarshal ARP: %v", err)
+		}
+		if !reply.IsReply() || reply.SenderIP != hostAIP || reply.SenderMAC != hostAMAC ||
+			reply.TargetIP != hostBIP || reply.TargetMAC != hostBMAC || f.DestinationMAC != hostBMAC {
+			t.Errorf("Unexpected reply: %s", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for ARP reply")
+	}
+}
+
+func TestARPResolutionFailure(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	s := newStack(t, a, hostAIP)
+
+	requests := make(chan *arp.Packet, 8)
+	b.Attach(func(data []byte) {
+		f := &eth.Frame{}
+		p := &arp.Packet{}
+		if f.Unmarshal(data) == nil && f.EtherType == eth.EtherTypeARP && p.Unmarshal(f.Payload) == nil {
+			requests <- p
+		}
+	})
+
+	// 对端不应答，重试MaxARPProbes次后放弃
+	ep, err := ping.NewEndpoint(s)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	defer ep.Close()
+	if err := ep.Send(hostBIP, 1, nil, 0); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	for i := 0; i < stack.MaxARPProbes; i++ {
+		select {
+		case p := <-requests:
+			if !p.IsRequest() || p.TargetIP != hostBIP || p.SenderIP != hostAIP {
+				t.Errorf("Unexpected ARP packet: %s", p)
+			}
+		case <-time.After(2 * stack.ARPRetransmitTimeout):
+			t.Fatalf("Timed out waiting for ARP request %d", i+1)
+		}
+	}
+	waitForTimeout(t, "neighbor removal", 2*stack.ARPRetransmitTimeout, func() bool { return len(s.Neighbors()) == 0 })
+	select {
+	case p := <-requests:
+		t.Errorf("Unexpected extra ARP packet: %s", p)
+	default:
+	}
+}
+
+func TestPingResolvesAndReplies(t *testing.T) {
+	// 不配置静态邻居，通过ARP解析
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	sa := newStack(t, a, hostAIP)
+	sb := newStack(t, b, hostBIP)
+
+	p := ping.NewPinger(sa)
+	p.Count = 3
+	p.Interval = 10 * time.Millisecond
+	p.Size = 100
+	p.Timeout = time.Second
+
+	var replies []ping.Reply
+	p.OnReply = func(r *ping.Reply) { replies = append(replies, *r) }
+
+	st, err := p.Run(context.Background(), hostBIP)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if st.Transmitted != 3 || st.Received != 3 || st.PacketLoss() != 0 {
+		t.Errorf("Unexpected statistics: %+v", st)
+	}
+	for i, r := range replies {
+		if r.Sequence != uint16(i+1) || r.From != hostBIP || r.Size != 108 || r.TTL != stack.DefaultTTL || len(r.Payload) != 100 {
+			t.Errorf("Unexpected reply %d: %+v", i, r)
+		}
+	}
+	if st.MinRTT > st.AvgRTT || st.AvgRTT > st.MaxRTT {
+		t.Errorf("Inconsistent RTTs: %+v", st)
+	}
+	if !strings.Contains(st.String(), "3 packets transmitted, 3 received, 0% packet loss") {
+		t.Errorf("Unexpected summary:\n%s", st)
+	}
+
+	for _, s := range []*stack.Stack{sa, sb} {
+		n := s.Neighbors()
+		if len(n) != 1 || !n[0].Resolved || n[0].Static {
+			t.Errorf("Expected one resolved dynamic neighbor, got %+v", n)
+		}
+	}
+}
+
+func TestPingLoss(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	// 对端地址不存在，全部丢失
+	p := ping.NewPinger(sa)
+	p.Count = 2
+	p.Interval = 10 * time.Millisecond
+	p.Timeout = 100 * time.Millisecond
+
+	st, err := p.Run(context.Background(), [4]byte{10, 0, 0, 3})
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if st.Transmitted != 2 || st.Received != 0 || st.PacketLoss() != 100 {
+		t.Errorf("Unexpected statistics: %+v", st)
+	}
+	if s := st.String(); !strings.Contains(s, "100% packet loss") || strings.Contains(s, "rtt") {
+		t.Errorf("Unexpected summary:\n%s", s)
+	}
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+	"ustack/pkg/udp"
+)
+
+// smallMTUHop 返回模拟一段小MTU链路的转发函数
+//
+// 不超过pathMTU的帧透明转发；对超过pathMTU的DF报文，
+// sendICMP为true时回复分片需要，否则静默丢弃（模拟ICMP黑洞）。
+func smallMTUHop(pathMTU int, sendICMP bool) func(frame []byte, out, back link.Endpoint) {
+	return func(frame []byte, out, back link.Endpoint) {
+		f := &eth.Frame{}
+		if err := f.Unmarshal(frame); err != nil {
+			return
+		}
+		h := &ip.Header{}
+		if err := h.Unmarshal(f.Payload); err != nil || int(h.TotalLength) <= pathMTU {
+			out.WriteFrame(frame)
+			return
+		}
+		if h.Flags&ip.FlagDF == 0 || !sendICMP {
+			return
+		}
+
+		msg, _ := icmp.NewFragNeeded(uint16(pathMTU), f.Payload[:h.TotalLength]).Marshal()
+		reply := ip.NewHeader(routerIP, h.SourceIP, ip.ProtocolICMP, uint16(ip.IPHeaderLength+len(msg)))
+		header, _ := reply.Marshal()
+		data, _ := eth.NewFrame(routerMAC, f.SourceMAC, eth.EtherTypeIPv4, append(header, msg...)).Marshal()
+		back.WriteFrame(data)
+	}
+}
+
+func TestTCPPathMTUDiscovery(t *testing.T) {
+	sa, sb := newBridgedPair(t, smallMTUHop(1400, true))
+
+	client := transferTCP(t, sa, sb, testPayload(64*1024), 5*time.Second)
+
+	if mtu, _ := sa.PathMTU(hostBIP); mtu != 1400 {
+		t.Errorf("Expected path MTU 1400, got %d", mtu)
+	}
+	if mss := client.MSS(); mss != 1360 {
+		t.Errorf("Expected MSS 1360 after PMTU discovery, got %d", mss)
+	}
+}
+
+func TestTCPBlackHoleDetection(t *testing.T) {
+	// 中间链路丢弃大包且不回复ICMP，依靠超时检测降低MSS
+	sa, sb := newBridgedPair(t, smallMTUHop(1400, false))
+
+	client := transferTCP(t, sa, sb, testPayload(32*1024), 10*time.Second)
+
+	if mss := client.MSS(); mss > 1360 {
+		t.Errorf("Expected MSS to be reduced below the black hole, got %d", mss)
+	}
+	if mtu, _ := sa.PathMTU(hostBIP); mtu != 1500 {
+		t.Errorf("Black hole detection must not change the path MTU cache, got %d", mtu)
+	}
+}
+
+func TestTCPPacketizationLayerPMTUD(t *testing.T) {
+	// 黑洞检测减半MSS后，探测段在减半的MSS与丢失的段大小之间搜索，找回接近路径允许的MSS
+	sa, sb := newBridgedPair(t, smallMTUHop(1400, false))
+
+	client := transferTCP(t, sa, sb, testPayload(512*1024), 20*time.Second)
+
+	if mss := client.MSS(); mss > 1360 || mss < 1360-tcp.MTUProbeThreshold {
+		t.Errorf("Expected probing to raise MSS close to 1360, got %d", mss)
+	}
+}
+
+func TestUDPPathMTUDiscovery(t *testing.T) {
+	sa, sb := newBridgedPair(t, smallMTUHop(1400, true))
+
+	receiver := udp.NewEndpoint(sb, 0)
+	defer receiver.Close()
+	if err := receiver.Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	sender := udp.NewEndpoint(sa, 0)
+	defer sender.Close()
+	sender.DontFragment = true
+	if err := sender.Connect(stack.FullAddress{IP: hostBIP, Port: 9000}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	// 第一个大数据报被中间节点丢弃，ICMP更新路径MTU并报告给已连接的端点
+	large := make([]byte, 1450)
+	if _, err := sender.Send(large); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	waitFor(t, "path MTU update", func() bool {
+		mtu, _ := sa.PathMTU(hostBIP)
+		return mtu == 1400
+	})
+
+	// 待报告的差错或本地超过路径MTU都返回ErrMessageTooLong
+	for i := 0; i < 2; i++ {
+		if _, err := sender.Send(large); !errors.Is(err, stack.ErrMessageTooLong) {
+			t.Fatalf("Expected ErrMessageTooLong, got %v", err)
+		}
+	}
+
+	// 不超过路径MTU的数据报正常送达
+	small := make([]byte, 1400-ip.IPHeaderLength-udp.UDPHeaderLength)
+	if _, err := sender.Send(small); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	payload, _ := recvWithTimeout(t, receiver)
+	if len(payload) != len(small) {
+		t.Errorf("Expected %d bytes, got %d", len(small), len(payload))
+	}
+}
+
+func TestPathMTUIgnoresBogusValues(t *testing.T) {
+	sa, _ := newBridgedPair(t, smallMTUHop(200, true))
+
+	sender := udp.NewEndpoint(sa, 0)
+	defer sender.Close()
+	sender.DontFragment = true
+	dst := stack.FullAddress{IP: hostBIP, Port: 9000}
+	if _, err := sender.SendTo(make([]byte, 1000), &dst); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	// 低于MinPathMTU的值被提升到MinPathMTU
+	waitFor(t, "path MTU update", func() bool {
+		mtu, _ := sa.PathMTU(hostBIP)
+		return mtu == stack.MinPathMTU
+	})
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestRouteCacheInvalidation(t *testing.T) {
+	sa, _ := newBridgedPair(t, smallMTUHop(1400, true))
+
+	r, err := sa.FindRoute(hostBIP)
+	if err != nil {
+		t.Fatalf("FindRoute failed: %v", err)
+	}
+	if !sa.RouteValid(r) {
+		t.Fatalf("Fresh route is not valid")
+	}
+
+	// 删除不存在的路由不影响缓存，路由表变化使其失效
+	sa.RemoveRoute(stack.Route{Destination: [4]byte{192, 168, 0, 0}, PrefixLength: 16, NIC: 1})
+	if !sa.RouteValid(r) {
+		t.Errorf("Route invalidated by a no-op change")
+	}
+	sa.AddRoute(stack.Route{Destination: [4]byte{192, 168, 0, 0}, PrefixLength: 16, Gateway: routerIP, NIC: 1})
+	if sa.RouteValid(r) {
+		t.Errorf("Route still valid after a route was added")
+	}
+
+	// 路径MTU变化同样使缓存失效
+	if r, err = sa.FindRoute(hostBIP); err != nil {
+		t.Fatalf("FindRoute failed: %v", err)
+	}
+	sender := udp.NewEndpoint(sa, 0)
+	defer sender.Close()
+	sender.DontFragment = true
+	dst := stack.FullAddress{IP: hostBIP, Port: 9000}
+	if _, err := sender.SendTo(make([]byte, 1450), &dst); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	waitFor(t, "route invalidation", func() bool { return !sa.RouteValid(r) })
+	if r, _ = sa.FindRoute(hostBIP); r.MTU() != 1400 {
+		t.Errorf("Expected MTU 1400 after PMTU update, got %d", r.MTU())
+	}
+}
@@ -0,0 +1,210 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"ustack/pkg/gonet"
+	"ustack/pkg/ip"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+	"ustack/pkg/udp"
+)
+
+func TestEphemeralPortRange(t *testing.T) {
+	sa, _ := newStackPair(t)
+	if err := sa.Ports().SetEphemeralRange(40000, 40003); err != nil {
+		t.Fatalf("Failed to set range: %v", err)
+	}
+	if err := sa.Ports().SetEphemeralRange(5, 4); err == nil {
+		t.Errorf("Expected error for an inverted range")
+	}
+
+	seen := make(map[uint16]bool)
+	var eps []*udp.Endpoint
+	for i := 0; i < 4; i++ {
+		ep := udp.NewEndpoint(sa, 0)
+		defer ep.Close()
+		if err := ep.Bind(stack.FullAddress{}); err != nil {
+			t.Fatalf("Bind %d failed: %v", i, err)
+		}
+		port := ep.LocalAddress().Port
+		if port < 40000 || port > 40003 || seen[port] {
+			t.Errorf("Unexpected ephemeral port %d", port)
+		}
+		seen[port] = true
+		eps = append(eps, ep)
+	}
+
+	// 范围耗尽
+	extra := udp.NewEndpoint(sa, 0)
+	defer extra.Close()
+	if err := extra.Bind(stack.FullAddress{}); !errors.Is(err, stack.ErrNoFreePort) {
+		t.Fatalf("Expected ErrNoFreePort, got %v", err)
+	}
+
+	// 关闭后端口重新可用
+	freed := eps[2].LocalAddress().Port
+	eps[2].Close()
+	if err := extra.Bind(stack.FullAddress{}); err != nil || extra.LocalAddress().Port != freed {
+		t.Errorf("Expected port %d to be reused, got %d (%v)", freed, extra.LocalAddress().Port, err)
+	}
+	if n := len(sa.Ports().Reserved(ip.ProtocolUDP)); n != 4 {
+		t.Errorf("Expected 4 reserved UDP ports, got %d", n)
+	}
+}
+
+func TestEphemeralPortSharedAcrossDestinations(t *testing.T) {
+	sa, sb := newStackPair(t)
+	if err := sa.Ports().SetEphemeralRange(40000, 40000); err != nil {
+		t.Fatalf("Failed to set range: %v", err)
+	}
+	for _, port := range []uint16{80, 81} {
+		listenGonet(t, sb, port)
+	}
+
+	// 只有一个临时端口，已连接的端点只要四元组不同即可共用
+	a, err := gonet.Dial(sa, "tcp", "10.0.0.2:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer a.Close()
+	b, err := gonet.Dial(sa, "tcp", "10.0.0.2:81")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer b.Close()
+	if a.LocalAddr().String() != "10.0.0.1:40000" || b.LocalAddr().String() != "10.0.0.1:40000" {
+		t.Errorf("Expected both connections on port 40000, got %s and %s", a.LocalAddr(), b.LocalAddr())
+	}
+
+	// 相同的四元组不能再分配
+	if _, err := gonet.Dial(sa, "tcp", "10.0.0.2:80"); !errors.Is(err, stack.ErrNoFreePort) {
+		t.Errorf("Expected ErrNoFreePort, got %v", err)
+	}
+}
+
+func TestTCPReuseAddr(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	l := listenGonet(t, sb, 8000)
+	client, err := gonet.Dial(sa, "tcp", "10.0.0.2:8000")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	server, err := l.AcceptTCP()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	l.Close()
+
+	// 已建立的连接仍占用端口，ReuseAddr也不能绑定
+	established := tcp.NewConnection(sb)
+	established.ReuseAddr = true
+	if err := established.Bind(stack.FullAddress{Port: 8000}); !errors.Is(err, stack.ErrPortInUse) {
+		t.Errorf("Expected ErrPortInUse with ReuseAddr while established, got %v", err)
+	}
+
+	// 服务端先关闭，连接进入TIME_WAIT并继续占用端口
+	server.Close()
+	client.Close()
+	waitFor(t, "TIME_WAIT", func() bool { return server.Endpoint().State() == tcp.StateTimeWait })
+
+	ep := tcp.NewConnection(sb)
+	if err := ep.Bind(stack.FullAddress{Port: 8000}); !errors.Is(err, stack.ErrPortInUse) {
+		t.Errorf("Expected ErrPortInUse while in TIME_WAIT, got %v", err)
+	}
+	ep.ReuseAddr = true
+	if err := ep.Bind(stack.FullAddress{Port: 8000}); err != nil {
+		t.Errorf("Expected bind with ReuseAddr to succeed, got %v", err)
+	}
+	ep.Close()
+}
+
+func TestTCPReusePort(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	counts := make([]chan *tcp.Connection, 2)
+	for i := range counts {
+		accepted := make(chan *tcp.Connection, 32)
+		listener := tcp.NewConnection(sb)
+		listener.ReusePort = true
+		listener.OnAccept = func(c *tcp.Connection) { accepted <- c }
+		if err := listener.Bind(stack.FullAddress{Port: 9000}); err != nil {
+			t.Fatalf("Bind %d failed: %v", i, err)
+		}
+		if err := listener.Listen(); err != nil {
+			t.Fatalf("Listen %d failed: %v", i, err)
+		}
+		defer listener.Close()
+		counts[i] = accepted
+	}
+
+	// 未设置ReusePort的监听冲突
+	other := tcp.NewConnection(sb)
+	if err := other.Bind(stack.FullAddress{Port: 9000}); !errors.Is(err, stack.ErrPortInUse) {
+		t.Errorf("Expected ErrPortInUse, got %v", err)
+	}
+
+	// 新连接按四元组哈希分配到两个监听
+	for i := 0; i < 32; i++ {
+		conn, err := gonet.Dial(sa, "tcp", "10.0.0.2:9000")
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		defer conn.Close()
+	}
+	deadline := time.After(2 * time.Second)
+	got := make([]int, 2)
+	for got[0]+got[1] < 32 {
+		select {
+		case <-counts[0]:
+			got[0]++
+		case <-counts[1]:
+			got[1]++
+		case <-deadline:
+			t.Fatalf("Timed out waiting for connections, got %v", got)
+		}
+	}
+	if got[0] == 0 || got[1] == 0 {
+		t.Errorf("Expected connections on both listeners, got %v", got)
+	}
+}
+
+func TestUDPReuseAddr(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	var eps []*udp.Endpoint
+	for i := 0; i < 2; i++ {
+		ep := udp.NewEndpoint(sa, 0)
+		defer ep.Close()
+		ep.ReuseAddr = true
+		if err := ep.Bind(stack.FullAddress{Port: 5353}); err != nil {
+			t.Fatalf("Bind %d failed: %v", i, err)
+		}
+		eps = append(eps, ep)
+	}
+
+	plain := udp.NewEndpoint(sa, 0)
+	defer plain.Close()
+	if err := plain.Bind(stack.FullAddress{Port: 5353}); !errors.Is(err, stack.ErrPortInUse) {
+		t.Errorf("Expected ErrPortInUse without ReuseAddr, got %v", err)
+	}
+}
+
+func TestPortReleaseUnreserved(t *testing.T) {
+	m := stack.NewPortManager()
+	id := stack.TransportEndpointID{
+		LocalAddress:  [4]byte{10, 0, 0, 1},
+		LocalPort:     40000,
+		RemoteAddress: [4]byte{10, 0, 0, 2},
+		RemotePort:    80,
+	}
+
+	// 释放从未占用的四元组不产生影响
+	m.Release(ip.ProtocolTCP, id, nil)
+	if n := len(m.Reserved(ip.ProtocolTCP)); n != 0 {
+		t.Errorf("Expected no reserved ports, got %d", n)
+	}
+}
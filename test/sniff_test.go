@@ -0,0 +1,166 @@
+package test
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"ustack/internal/utils"
+	"ustack/pkg/arp"
+	"ustack/pkg/eth"
+	"ustack/pkg/gonet"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/sniff"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+)
+
+// ipFrame 将传输层数据封装为hostA发往hostB的帧
+func ipFrame(t *testing.T, protocol uint8, data []byte) []byte {
+	t.Helper()
+
+	h := ip.NewHeader(hostAIP, hostBIP, protocol, uint16(ip.IPHeaderLength+len(data)))
+	header, err := h.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal IP header: %v", err)
+	}
+	frame, _ := eth.NewFrame(hostAMAC, hostBMAC, eth.EtherTypeIPv4, append(header, data...)).Marshal()
+	return frame
+}
+
+func TestSniffDecode(t *testing.T) {
+	syn, _ := tcp.NewHeader(40000, 80, 1, 0, tcp.FlagSYN, 65535).Marshal()
+	echo, _ := icmp.NewEchoRequest(7, 1, []byte("ping")).Marshal()
+	who, _ := arp.NewRequest(hostAMAC, hostAIP, hostBIP).Marshal()
+	arpFrame, _ := eth.NewFrame(hostAMAC, eth.BroadcastMAC, eth.EtherTypeARP, who).Marshal()
+
+	frames := map[string][]byte{
+		"syn":  ipFrame(t, ip.ProtocolTCP, append(syn, "data"...)),
+		"udp":  udpFrame(t, 53, "query"), // hostB:5000 -> hostA:53
+		"icmp": ipFrame(t, ip.ProtocolICMP, echo),
+		"arp":  arpFrame,
+	}
+
+	p := sniff.Decode(frames["syn"])
+	if p.Err != nil || p.TCP == nil || p.Payload != 4 {
+		t.Fatalf("Decode(syn) = %+v", p)
+	}
+	summary := p.String()
+	for _, want := range []string{"IP Header: 10.0.0.1 -> 10.0.0.2", "TCP Header: 40000 -> 80", "Flags: [SYN ]", "Payload: 4 bytes"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Summary %q missing %q", summary, want)
+		}
+	}
+	if s := sniff.Decode(frames["arp"]).String(); !strings.HasPrefix(s, "ARP Request: who-has 10.0.0.2") {
+		t.Errorf("ARP summary = %q", s)
+	}
+	if s := sniff.Decode(frames["syn"][:40]).String(); !strings.Contains(s, "Error:") {
+		t.Errorf("Truncated summary = %q", s)
+	}
+
+	for _, tt := range []struct {
+		expr string
+		want string // 匹配的帧，按frames的键排序
+	}{
+		{"", "arp icmp syn udp"},
+		{"tcp", "syn"},
+		{"udp or icmp", "icmp udp"},
+		{"not ip", "arp"},
+		{"host 10.0.0.2", "arp icmp syn udp"},
+		{"dst host 10.0.0.2", "arp icmp syn"},
+		{"src host 10.0.0.2", "udp"},
+		{"port 80 or 53", "syn udp"},
+		{"tcp port 53", ""},
+		{"udp dst port 53", "udp"},
+		{"src port 5000 and src host 10.0.0.2", "udp"},
+		{"!(tcp || arp) && ip", "icmp udp"},
+		{"ICMP and not (port 80)", "icmp"},
+	} {
+		f, err := sniff.ParseFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		var matched []string
+		for _, name := range []string{"arp", "icmp", "syn", "udp"} {
+			if f.Match(sniff.Decode(frames[name])) {
+				matched = append(matched, name)
+			}
+		}
+		if got := strings.Join(matched, " "); got != tt.want {
+			t.Errorf("Filter %q matched %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"tcp and", "(udp", "host", "port 99999", "host example", "tcp host 10.0.0.1", "udp )", "foo"} {
+		if _, err := sniff.ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestSniffEndpoint(t *testing.T) {
+	out := &syncBuffer{}
+	logger := utils.NewLogger(utils.INFO)
+	logger.SetOutput(out)
+
+	filter, err := sniff.ParseFilter("tcp port 8080")
+	if err != nil {
+		t.Fatalf("ParseFilter failed: %v", err)
+	}
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	sniffer := sniff.NewEndpoint(a, filter)
+	sniffer.SetLogger(logger)
+	sa := newStack(t, captureOnFailure(t, sniffer), hostAIP)
+	sb := newStack(t, b, hostBIP)
+	sa.AddNeighbor(hostBIP, hostBMAC)
+	sb.AddNeighbor(hostAIP, hostAMAC)
+
+	// 不匹配的帧不记录
+	sniffer.WriteFrame(udpFrame(t, 8080, "x"))
+	if logged := out.Bytes(); len(logged) != 0 {
+		t.Errorf("Unmatched frame was logged: %q", logged)
+	}
+
+	ln, err := gonet.ListenTCP(sb, stack.FullAddress{Port: 8080})
+	if err != nil {
+		t.Fatalf("ListenTCP failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			c.Write([]byte("hello"))
+			c.Close()
+		}
+	}()
+
+	c, err := (&gonet.Dialer{Stack: sa}).Dial("tcp", "10.0.0.2:8080")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if data, err := io.ReadAll(c); err != nil || string(data) != "hello" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+	c.Close()
+
+	// 握手的前两个报文依次是发出的SYN和收到的SYN-ACK
+	lines := strings.Split(strings.TrimSpace(string(out.Bytes())), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("Expected the handshake to be logged, got %q", lines)
+	}
+	for i, want := range []string{"out IP Header: 10.0.0.1 -> 10.0.0.2", "in IP Header: 10.0.0.2 -> 10.0.0.1"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("Line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+	if !strings.Contains(lines[0], "Flags: [SYN ]") || !strings.Contains(lines[1], "Flags: [SYN ACK ]") {
+		t.Errorf("Handshake lines %q", lines[:2])
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "[INFO] ") || !strings.Contains(line, "TCP Header:") {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+}
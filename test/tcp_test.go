@@ -0,0 +1,414 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/gonet"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/tcp"
+)
+
+// tcpReceiver 收集连接上收到的数据
+type tcpReceiver struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (r *tcpReceiver) append(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data = append(r.data, data...)
+}
+
+func (r *tcpReceiver) bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.data...)
+}
+
+// listenTCP 在协议栈上监听端口，接受的连接通过通道返回
+func listenTCP(t *testing.T, s *stack.Stack, port uint16, setup func(*tcp.Connection)) chan *tcp.Connection {
+	t.Helper()
+
+	accepted := make(chan *tcp.Connection, 16)
+	listener := tcp.NewConnection(s)
+	listener.OnAccept = func(c *tcp.Connection) {
+		if setup != nil {
+			setup(c)
+		}
+		accepted <- c
+	}
+	if err := listener.Bind(stack.FullAddress{Port: port}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+	if err := listener.Listen(); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return accepted
+}
+
+// testPayload 生成可校验内容的测试数据
+func testPayload(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+// transferTCP 从sa向sb发送数据并等待对端完整收到
+func transferTCP(t *testing.T, sa, sb *stack.Stack, payload []byte, timeout time.Duration) *tcp.Connection {
+	t.Helper()
+
+	received := &tcpReceiver{}
+	listenTCP(t, sb, 80, func(c *tcp.Connection) {
+		c.OnDataReceived = received.append
+	})
+
+	client := tcp.NewConnection(sa)
+	if err := client.Connect(stack.FullAddress{IP: hostBIP, Port: 80}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if err := client.Send(payload); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	waitForTimeout(t, "data transfer", timeout, func() bool { return len(received.bytes()) >= len(payload) })
+	if !bytes.Equal(received.bytes(), payload) {
+		t.Fatalf("Received data mismatch")
+	}
+	return client
+}
+
+// waitForTimeout 在指定时间内等待条件成立
+func waitForTimeout(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTCPEchoAndClose(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	accepted := listenTCP(t, sb, 7, func(c *tcp.Connection) {
+		c.OnDataReceived = func(data []byte) {
+			if err := c.Send(data); err != nil {
+				t.Errorf("Echo failed: %v", err)
+			}
+		}
+	})
+
+	echoed := &tcpReceiver{}
+	client := tcp.NewConnection(sa)
+	client.OnDataReceived = echoed.append
+	if err := client.Connect(stack.FullAddress{IP: hostBIP, Port: 7}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	// 连接建立前写入的数据在握手完成后发送
+	payload := testPayload(200 * 1024)
+	if err := client.Send(payload); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	var server *tcp.Connection
+	select {
+	case server = <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for accept")
+	}
+	if server.RemoteAddress() != client.LocalAddress() {
+		t.Errorf("Address mismatch: server sees %s, client is %s", server.RemoteAddress(), client.LocalAddress())
+	}
+
+	waitFor(t, "echo", func() bool { return len(echoed.bytes()) >= len(payload) })
+	if !bytes.Equal(echoed.bytes(), payload) {
+		t.Fatalf("Echoed data mismatch")
+	}
+
+	// 主动关闭方进入TIME_WAIT，被动关闭方最终CLOSED
+	client.Close()
+	waitFor(t, "CLOSE_WAIT", func() bool { return server.State() == tcp.StateCloseWait })
+	server.Close()
+	waitFor(t, "server close", func() bool { return server.State() == tcp.StateClosed })
+	waitFor(t, "TIME_WAIT", func() bool { return client.State() == tcp.StateTimeWait })
+	if server.Err() != nil || client.Err() != nil {
+		t.Errorf("Unexpected errors: server %v, client %v", server.Err(), client.Err())
+	}
+}
+
+func TestTCPConnectionRefused(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	// 对端没有监听该端口，回复RST
+	client := tcp.NewConnection(sa)
+	if err := client.Connect(stack.FullAddress{IP: hostBIP, Port: 8080}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	waitFor(t, "refusal", func() bool { return client.State() == tcp.StateClosed })
+	if !errors.Is(client.Err(), stack.ErrConnectionRefused) {
+		t.Errorf("Expected connection refused, got %v", client.Err())
+	}
+}
+
+func TestTCPCongestionControl(t *testing.T) {
+	for _, cc := range []string{tcp.CongestionReno, tcp.CongestionCubic} {
+		t.Run(cc, func(t *testing.T) {
+			// 中间节点丢弃A发往B的每第30个TCP数据段
+			var segments atomic.Int64
+			sa, sb := newBridgedPair(t, func(frame []byte, out, _ link.Endpoint) {
+				f := &eth.Frame{}
+				h := &ip.Header{}
+				if f.Unmarshal(frame) == nil && f.DestinationMAC == hostBMAC && h.Unmarshal(f.Payload) == nil &&
+					h.Protocol == ip.ProtocolTCP && int(h.TotalLength) > ip.IPHeaderLength+tcp.TCPHeaderLength &&
+					segments.Add(1)%30 == 0 {
+					return
+				}
+				out.WriteFrame(frame)
+			})
+
+			received := &tcpReceiver{}
+			listenTCP(t, sb, 80, func(c *tcp.Connection) {
+				c.OnDataReceived = received.append
+			})
+			client := tcp.NewConnection(sa)
+			client.Congestion = cc
+			if err := client.Connect(stack.FullAddress{IP: hostBIP, Port: 80}); err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			defer client.Close()
+
+			// 丢包触发快速重传和窗口减小，数据仍须完整按序到达
+			payload := testPayload(512 * 1024)
+			if err := client.Send(payload); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			waitForTimeout(t, "data transfer", 20*time.Second, func() bool { return len(received.bytes()) >= len(payload) })
+			if !bytes.Equal(received.bytes(), payload) {
+				t.Fatalf("Received data mismatch")
+			}
+		})
+	}
+
+	// 未知算法和超出范围的窗口在连接前报错
+	sa, _ := newStackPair(t)
+	for _, setup := range []func(*tcp.Connection){
+		func(c *tcp.Connection) { c.Congestion = "vegas" },
+		func(c *tcp.Connection) { c.ReceiveWindow = 1 << 20 },
+	} {
+		c := tcp.NewConnection(sa)
+		setup(c)
+		if err := c.Connect(stack.FullAddress{IP: hostBIP, Port: 80}); err == nil {
+			c.Close()
+			t.Errorf("Expected Connect to reject invalid options")
+		}
+	}
+}
+
+func TestTCPZeroWindowPersist(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 9000)
+
+	// 重传上限很小，零窗口探测若计入重传次数，连接会在接收方停顿期间被放弃
+	ep := tcp.NewConnection(sa)
+	ep.RetransmitLimit = 2
+	c := gonet.NewTCPConn(ep)
+	if err := ep.Connect(stack.FullAddress{IP: hostBIP, Port: 9000}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer server.Close()
+
+	payload := testPayload(256 * 1024)
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write(payload)
+		written <- err
+	}()
+
+	// 停顿时间超过按RTO退避重传RetransmitLimit次所需的时间
+	time.Sleep(4 * time.Second)
+	if state := ep.State(); state != tcp.StateEstablished || ep.Err() != nil {
+		t.Fatalf("Connection aborted during zero window: %s (%v)", state, ep.Err())
+	}
+
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, received); err != nil {
+		t.Fatalf("ReadFull failed: %v", err)
+	}
+	if !bytes.Equal(received, payload) {
+		t.Errorf("Received data mismatch")
+	}
+	if err := <-written; err != nil {
+		t.Errorf("Write failed: %v", err)
+	}
+}
+
+// tableEndpoint 只用于占据分发表的端点
+type tableEndpoint struct{}
+
+func (tableEndpoint) HandlePacket(*stack.Packet) {}
+
+func TestTCPConnectionTable(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 80)
+
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := gonet.Dial(sa, "tcp", "10.0.0.2:80")
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer c.Close()
+		if _, err := l.Accept(); err != nil {
+			t.Fatalf("Accept failed: %v", err)
+		}
+		clients = append(clients, c)
+	}
+
+	// 未读取的数据计入RecvQueued
+	if _, err := clients[0].Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	local := clients[0].LocalAddr().(*net.TCPAddr)
+	waitFor(t, "unread data", func() bool {
+		for _, info := range tcp.Connections(sb) {
+			if int(info.ID.RemotePort) == local.Port {
+				return info.RecvQueued == 5
+			}
+		}
+		return false
+	})
+
+	infos := tcp.Connections(sb)
+	if len(infos) != 4 {
+		t.Fatalf("Expected 4 connections, got %d: %v", len(infos), infos)
+	}
+	if infos[0].State != tcp.StateEstablished || infos[3].State != tcp.StateListen {
+		t.Errorf("Unexpected order: %v", infos)
+	}
+	if infos[3].ID != (stack.TransportEndpointID{LocalPort: 80}) {
+		t.Errorf("Unexpected listener id %s", infos[3].ID)
+	}
+	for _, info := range infos[:3] {
+		if info.ID.LocalAddress != [4]byte{10, 0, 0, 2} || info.ID.RemoteAddress != [4]byte{10, 0, 0, 1} {
+			t.Errorf("Unexpected connection id %s", info.ID)
+		}
+	}
+
+	// 提前停止遍历
+	n := 0
+	tcp.RangeConnections(sb, func(*tcp.Connection) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Expected iteration to stop after 1 connection, got %d", n)
+	}
+}
+
+func TestTCPConnectionTableConcurrent(t *testing.T) {
+	sa, sb := newStackPair(t)
+	l := listenGonet(t, sb, 80)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	// 大量表项分布在各分片中，并发注册、收发和遍历
+	const workers, perWorker = 8, 2000
+	var wg sync.WaitGroup
+	ids := make([][]stack.TransportEndpointID, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id := stack.TransportEndpointID{
+					LocalAddress:  [4]byte{10, 0, 0, 1},
+					LocalPort:     8080,
+					RemoteAddress: [4]byte{192, 168, byte(w), byte(i)},
+					RemotePort:    uint16(1024 + i),
+				}
+				if _, err := sa.RegisterTransportEndpoint(ip.ProtocolTCP, id, tableEndpoint{}); err != nil {
+					t.Errorf("Register %s failed: %v", id, err)
+					return
+				}
+				ids[w] = append(ids[w], id)
+			}
+		}(w)
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := gonet.Dial(sa, "tcp", "10.0.0.2:80")
+			if err != nil {
+				t.Errorf("Dial failed: %v", err)
+				return
+			}
+			defer c.Close()
+			msg := testPayload(32 * 1024)
+			go c.Write(msg)
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(c, got); err != nil || !bytes.Equal(got, msg) {
+				t.Errorf("Echo failed: %v", err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			sa.RangeTransportEndpoints(ip.ProtocolTCP, func(stack.TransportEndpointID, stack.TransportEndpoint) bool { return true })
+		}
+	}()
+	wg.Wait()
+
+	count := 0
+	sa.RangeTransportEndpoints(ip.ProtocolTCP, func(_ stack.TransportEndpointID, ep stack.TransportEndpoint) bool {
+		if _, ok := ep.(tableEndpoint); ok {
+			count++
+		}
+		return true
+	})
+	if count != workers*perWorker {
+		t.Errorf("Expected %d endpoints, got %d", workers*perWorker, count)
+	}
+
+	for _, list := range ids {
+		for _, id := range list {
+			sa.UnregisterTransportEndpoint(ip.ProtocolTCP, id, tableEndpoint{})
+		}
+	}
+	if n := len(sa.Ports().Reserved(ip.ProtocolTCP)); n > 8 {
+		t.Errorf("Expected reservations to be released, %d left", n)
+	}
+}
@@ -0,0 +1,197 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"ustack/pkg/icmp"
+	"ustack/pkg/link"
+	"ustack/pkg/ping"
+	"ustack/pkg/stack"
+	"ustack/pkg/traceroute"
+	"ustack/pkg/udp"
+)
+
+// newRouterChain 创建经过len(mtus)-1台路由器相连的两个协议栈
+//
+// 第i段链路为10.0.i.0/24，MTU为mtus[i]；路由器左侧地址为.254，右侧地址为.1。
+// 本机为10.0.0.1，目标为10.0.n.2，两端的默认路由指向相邻路由器。
+func newRouterChain(t *testing.T, mtus ...int) (*stack.Stack, *stack.Stack, [4]byte) {
+	t.Helper()
+
+	n := len(mtus) - 1
+	mac := func(node, nic int) [6]byte { return [6]byte{0x02, 0, 0, byte(node), 0, byte(nic)} }
+	addr := func(subnet int, host byte) [4]byte { return [4]byte{10, 0, byte(subnet), host} }
+
+	a, left := link.NewPipe(mac(0, 1), mac(1, 1), mtus[0])
+	sa := newStack(t, a, hostAIP)
+	sa.AddRoute(stack.Route{Gateway: addr(0, 254), NIC: 1})
+
+	for i := 1; i <= n; i++ {
+		right, next := link.NewPipe(mac(i, 2), mac(i+1, 1), mtus[i])
+
+		r := newStack(t, left, addr(i-1, 254))
+		if err := r.AddNIC(2, right); err != nil {
+			t.Fatalf("Failed to add NIC: %v", err)
+		}
+		if err := r.AddAddress(2, addr(i, 1), 24); err != nil {
+			t.Fatalf("Failed to add address: %v", err)
+		}
+		r.SetForwarding(true)
+		for j := 0; j < i-1; j++ {
+			r.AddRoute(stack.Route{Destination: addr(j, 0), PrefixLength: 24, Gateway: addr(i-1, 1), NIC: 1})
+		}
+		if i < n {
+			r.AddRoute(stack.Route{Gateway: addr(i, 254), NIC: 2})
+		}
+		left = next
+	}
+
+	dst := addr(n, 2)
+	sb := newStack(t, left, dst)
+	sb.AddRoute(stack.Route{Gateway: addr(n, 1), NIC: 1})
+	return sa, sb, dst
+}
+
+// runTracer 以较短的超时运行traceroute
+func runTracer(t *testing.T, s *stack.Stack, method traceroute.Method, dst [4]byte) []traceroute.Hop {
+	t.Helper()
+
+	tr := traceroute.NewTracer(s)
+	tr.Method = method
+	tr.MaxHops = 8
+	tr.Probes = 2
+	tr.Timeout = time.Second
+
+	hops, err := tr.Run(context.Background(), dst)
+	if err != nil {
+		t.Fatalf("Traceroute failed: %v", err)
+	}
+	return hops
+}
+
+func TestTracerouteUDP(t *testing.T) {
+	sa, _, dst := newRouterChain(t, 1500, 1500, 1500, 1500)
+
+	hops := runTracer(t, sa, traceroute.MethodUDP, dst)
+
+	expected := [][4]byte{{10, 0, 0, 254}, {10, 0, 1, 254}, {10, 0, 2, 254}, dst}
+	if len(hops) != len(expected) {
+		t.Fatalf("Expected %d hops, got %d: %+v", len(expected), len(hops), hops)
+	}
+	for i, hop := range hops {
+		for _, p := range hop.Probes {
+			if p.Timeout || p.From != expected[i] || p.Annotation != "" {
+				t.Errorf("Unexpected probe at hop %d: %+v", hop.TTL, p)
+			}
+		}
+	}
+	last := hops[len(hops)-1].Probes[0]
+	if last.Type != icmp.TypeDestUnreach || last.Code != icmp.CodePortUnreach {
+		t.Errorf("Expected port unreachable from the target, got %+v", last)
+	}
+	if s := hops[0].String(); !strings.HasPrefix(s, " 1  10.0.0.254  ") || strings.Count(s, "ms") != 2 {
+		t.Errorf("Unexpected hop line: %q", s)
+	}
+}
+
+func TestTracerouteICMP(t *testing.T) {
+	sa, _, dst := newRouterChain(t, 1500, 1500, 1500)
+
+	hops := runTracer(t, sa, traceroute.MethodICMP, dst)
+
+	if len(hops) != 3 {
+		t.Fatalf("Expected 3 hops, got %d: %+v", len(hops), hops)
+	}
+	if p := hops[1].Probes[0]; p.From != [4]byte{10, 0, 1, 254} || p.Type != icmp.TypeTimeExceeded {
+		t.Errorf("Expected time exceeded from the second router, got %+v", p)
+	}
+	if p := hops[2].Probes[0]; p.From != dst || p.Type != icmp.TypeEchoReply || p.Annotation != "" {
+		t.Errorf("Expected echo reply from the target, got %+v", p)
+	}
+}
+
+func TestTracerouteNetUnreachable(t *testing.T) {
+	// 唯一的路由器没有到目标的路由，TTL耗尽先于路由查找
+	sa, _, _ := newRouterChain(t, 1500, 1500)
+
+	hops := runTracer(t, sa, traceroute.MethodUDP, [4]byte{192, 168, 9, 9})
+
+	if len(hops) != 2 {
+		t.Fatalf("Expected trace to stop at the second hop, got %+v", hops)
+	}
+	if p := hops[0].Probes[0]; p.Type != icmp.TypeTimeExceeded {
+		t.Errorf("Expected time exceeded at the first hop, got %+v", p)
+	}
+	if p := hops[1].Probes[0]; p.From != [4]byte{10, 0, 0, 254} || p.Annotation != "!N" {
+		t.Errorf("Expected !N from the router, got %+v", p)
+	}
+}
+
+func TestForwardingDecrementsTTL(t *testing.T) {
+	sa, _, dst := newRouterChain(t, 1500, 1500, 1500)
+
+	ep, err := ping.NewEndpoint(sa)
+	if err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	defer ep.Close()
+
+	// 回复经过两台路由器
+	if err := ep.Send(dst, 1, []byte("hello"), 0); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	select {
+	case r := <-ep.Replies():
+		if r.Err != nil || r.From != dst || r.TTL != stack.DefaultTTL-2 {
+			t.Errorf("Unexpected reply: %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for echo reply")
+	}
+
+	// TTL为2的请求在第二台路由器耗尽
+	if err := ep.Send(dst, 2, nil, 2); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	select {
+	case r := <-ep.Replies():
+		if r.Err == nil || r.From != [4]byte{10, 0, 1, 254} || r.ErrorString() != "Time to live exceeded" {
+			t.Errorf("Expected time exceeded, got %+v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for time exceeded")
+	}
+}
+
+func TestForwardingFragNeeded(t *testing.T) {
+	// 第二段链路的MTU较小
+	sa, sb, dst := newRouterChain(t, 1500, 1300, 1500)
+
+	receiver := udp.NewEndpoint(sb, 0)
+	defer receiver.Close()
+	if err := receiver.Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	sender := udp.NewEndpoint(sa, 0)
+	defer sender.Close()
+	sender.DontFragment = true
+	if _, err := sender.SendTo(make([]byte, 1400), &stack.FullAddress{IP: dst, Port: 9000}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	waitFor(t, "path MTU update", func() bool {
+		mtu, _ := sa.PathMTU(dst)
+		return mtu == 1300
+	})
+
+	// 不超过路径MTU的数据报正常送达
+	if _, err := sender.SendTo(make([]byte, 1200), &stack.FullAddress{IP: dst, Port: 9000}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	payload, _ := recvWithTimeout(t, receiver)
+	if len(payload) != 1200 {
+		t.Errorf("Expected 1200 bytes, got %d", len(payload))
+	}
+}
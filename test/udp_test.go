@@ -0,0 +1,331 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+	"ustack/pkg/eth"
+	"ustack/pkg/icmp"
+	"ustack/pkg/ip"
+	"ustack/pkg/link"
+	"ustack/pkg/stack"
+	"ustack/pkg/udp"
+)
+
+var (
+	hostAIP  = [4]byte{10, 0, 0, 1}
+	hostBIP  = [4]byte{10, 0, 0, 2}
+	hostAMAC = [6]byte{0x02, 0, 0, 0, 0, 0x01}
+	hostBMAC = [6]byte{0x02, 0, 0, 0, 0, 0x02}
+
+	routerIP  = [4]byte{10, 0, 0, 254}
+	routerMAC = [6]byte{0x02, 0, 0, 0, 0, 0xfe}
+)
+
+// newStack 在链路端点上创建一个配置了单个地址的协议栈
+func newStack(t *testing.T, ep link.Endpoint, addr [4]byte) *stack.Stack {
+	t.Helper()
+
+	s := stack.New()
+	if err := s.AddNIC(1, ep); err != nil {
+		t.Fatalf("Failed to add NIC: %v", err)
+	}
+	if err := s.AddAddress(1, addr, 24); err != nil {
+		t.Fatalf("Failed to add address: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// newStackPair 创建两个通过管道相连的协议栈，测试失败时保存管道上的流量
+func newStackPair(t *testing.T) (*stack.Stack, *stack.Stack) {
+	t.Helper()
+
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	sa := newStack(t, captureOnFailure(t, a), hostAIP)
+	sb := newStack(t, b, hostBIP)
+	sa.AddNeighbor(hostBIP, hostBMAC)
+	sb.AddNeighbor(hostAIP, hostAMAC)
+	return sa, sb
+}
+
+// newBridgedPair 创建两个经过中间节点相连的协议栈，测试失败时保存A一侧链路上的流量
+//
+// 中间节点收到的每个帧交给forward：out通往帧的目标主机，back通往发送方（用于回复ICMP差错），
+// forward不转发即模拟丢包。
+func newBridgedPair(t *testing.T, forward func(frame []byte, out, back link.Endpoint)) (*stack.Stack, *stack.Stack) {
+	t.Helper()
+
+	a, bridgeA := link.NewPipe(hostAMAC, routerMAC, 1500)
+	bridgeB, b := link.NewPipe(routerMAC, hostBMAC, 1500)
+	sa := newStack(t, captureOnFailure(t, a), hostAIP)
+	sb := newStack(t, b, hostBIP)
+	sa.AddNeighbor(hostBIP, hostBMAC)
+	sb.AddNeighbor(hostAIP, hostAMAC)
+
+	bridgeA.Attach(func(frame []byte) { forward(frame, bridgeB, bridgeA) })
+	bridgeB.Attach(func(frame []byte) { forward(frame, bridgeA, bridgeB) })
+	return sa, sb
+}
+
+// recvWithTimeout 在超时时间内接收一个数据报
+func recvWithTimeout(t *testing.T, ep *udp.Endpoint) ([]byte, stack.FullAddress) {
+	t.Helper()
+
+	type result struct {
+		payload []byte
+		from    stack.FullAddress
+		err     error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		payload, from, err := ep.RecvFrom()
+		ch <- result{payload, from, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("RecvFrom failed: %v", r.err)
+		}
+		return r.payload, r.from
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for datagram")
+	}
+	return nil, stack.FullAddress{}
+}
+
+func TestUDPEndpointSendRecv(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	server := udp.NewEndpoint(sb, 0)
+	defer server.Close()
+	if err := server.Bind(stack.FullAddress{Port: 53}); err != nil {
+		t.Fatalf("Failed to bind server: %v", err)
+	}
+
+	client := udp.NewEndpoint(sa, 0)
+	defer client.Close()
+	if err := client.Connect(stack.FullAddress{IP: hostBIP, Port: 53}); err != nil {
+		t.Fatalf("Failed to connect client: %v", err)
+	}
+
+	local := client.LocalAddress()
+	if local.IP != hostAIP || local.Port < stack.EphemeralPortFirst {
+		t.Errorf("Unexpected client local address: %s", local)
+	}
+
+	if _, err := client.Send([]byte("query")); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	payload, from := recvWithTimeout(t, server)
+	if !bytes.Equal(payload, []byte("query")) {
+		t.Errorf("Payload mismatch: %q", payload)
+	}
+	if from != local {
+		t.Errorf("Expected datagram from %s, got %s", local, from)
+	}
+
+	if _, err := server.SendTo([]byte("answer"), &from); err != nil {
+		t.Fatalf("Failed to reply: %v", err)
+	}
+	payload, _ = recvWithTimeout(t, client)
+	if !bytes.Equal(payload, []byte("answer")) {
+		t.Errorf("Reply mismatch: %q", payload)
+	}
+}
+
+func TestUDPBindConflict(t *testing.T) {
+	sa, _ := newStackPair(t)
+
+	first := udp.NewEndpoint(sa, 0)
+	defer first.Close()
+	if err := first.Bind(stack.FullAddress{Port: 5000}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	second := udp.NewEndpoint(sa, 0)
+	defer second.Close()
+	if err := second.Bind(stack.FullAddress{IP: hostAIP, Port: 5000}); !errors.Is(err, stack.ErrPortInUse) {
+		t.Errorf("Expected ErrPortInUse, got %v", err)
+	}
+
+	// 关闭后端口可以重新绑定
+	first.Close()
+	if err := second.Bind(stack.FullAddress{IP: hostAIP, Port: 5000}); err != nil {
+		t.Errorf("Failed to rebind after close: %v", err)
+	}
+}
+
+func TestUDPReceiveQueueBounded(t *testing.T) {
+	sa, sb := newStackPair(t)
+
+	server := udp.NewEndpoint(sb, 2)
+	defer server.Close()
+	if err := server.Bind(stack.FullAddress{Port: 9000}); err != nil {
+		t.Fatalf("Failed to bind: %v", err)
+	}
+
+	client := udp.NewEndpoint(sa, 0)
+	defer client.Close()
+	dst := stack.FullAddress{IP: hostBIP, Port: 9000}
+	for i := 0; i < 5; i++ {
+		if _, err := client.SendTo([]byte{byte(i)}, &dst); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+	}
+
+	waitFor(t, "datagrams to be queued or dropped", func() bool {
+		st := server.Stats()
+		return st.Received+st.Dropped == 5
+	})
+	if st := server.Stats(); st.Received != 2 || st.Dropped != 3 {
+		t.Errorf("Unexpected stats: %+v", st)
+	}
+}
+
+func TestUDPPortUnreachable(t *testing.T) {
+	a, b := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	newStack(t, a, hostAIP).AddNeighbor(hostBIP, hostBMAC)
+
+	frames := make(chan []byte, 4)
+	b.Attach(func(frame []byte) { frames <- frame })
+
+	// 从原始链路端发送一个到未绑定端口的数据报
+	datagram, err := udp.NewPacket(4000, 7777, []byte("hello")).Marshal(hostBIP, hostAIP)
+	if err != nil {
+		t.Fatalf("Failed to marshal UDP: %v", err)
+	}
+	h := ip.NewHeader(hostBIP, hostAIP, ip.ProtocolUDP, uint16(ip.IPHeaderLength+len(datagram)))
+	header, _ := h.Marshal()
+	frame, _ := eth.NewFrame(hostBMAC, hostAMAC, eth.EtherTypeIPv4, append(header, datagram...)).Marshal()
+	if err := b.WriteFrame(frame); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	select {
+	case data := <-frames:
+		f := &eth.Frame{}
+		if err := f.Unmarshal(data); err != nil {
+			t.Fatalf("Failed to unmarshal frame: %v", err)
+		}
+		rh := &ip.Header{}
+		if err := rh.Unmarshal(f.Payload); err != nil {
+			t.Fatalf("Failed to unmarshal IP: %v", err)
+		}
+		if rh.Protocol != ip.ProtocolICMP || rh.DestinationIP != hostBIP {
+			t.Fatalf("Expected ICMP to %v, got %s", hostBIP, rh)
+		}
+		msg := &icmp.Packet{}
+		if err := msg.Unmarshal(f.Payload[ip.IPHeaderLength:]); err != nil {
+			t.Fatalf("Failed to unmarshal ICMP: %v", err)
+		}
+		if msg.Type != icmp.TypeDestUnreach || msg.Code != icmp.CodePortUnreach {
+			t.Errorf("Expected port unreachable, got %s", msg)
+		}
+		if !bytes.Equal(msg.Data, append(header, datagram[:8]...)) {
+			t.Errorf("ICMP error must quote the IP header and first 8 bytes")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for ICMP port unreachable")
+	}
+}
+
+func TestUDPChecksumPseudoHeader(t *testing.T) {
+	packet := udp.NewPacket(1234, 53, []byte("checksum"))
+	data, err := packet.Marshal(hostAIP, hostBIP)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if err := udp.VerifyChecksum(data, hostAIP, hostBIP); err != nil {
+		t.Errorf("Checksum should verify: %v", err)
+	}
+
+	// 伪头部参与校验：地址不同则校验失败
+	if err := udp.VerifyChecksum(data, hostAIP, [4]byte{10, 0, 0, 3}); !errors.Is(err, udp.ErrBadChecksum) {
+		t.Errorf("Expected ErrBadChecksum, got %v", err)
+	}
+
+	// 校验和为0表示未计算，IPv4下视为有效
+	data[6], data[7] = 0, 0
+	if err := udp.VerifyChecksum(data, hostAIP, [4]byte{10, 0, 0, 3}); err != nil {
+		t.Errorf("Zero checksum should be accepted: %v", err)
+	}
+}
+
+func TestUDPChecksumZeroTransmittedAsFFFF(t *testing.T) {
+	// 先计算一次校验和，再把它作为载荷，使新的校验和计算结果为0
+	probe := udp.NewPacket(1000, 2000, []byte{0, 0})
+	if _, err := probe.Marshal(hostAIP, hostBIP); err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	packet := udp.NewPacket(1000, 2000, []byte{byte(probe.Checksum >> 8), byte(probe.Checksum)})
+	data, err := packet.Marshal(hostAIP, hostBIP)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if packet.Checksum != 0xFFFF || data[6] != 0xFF || data[7] != 0xFF {
+		t.Errorf("Zero checksum must be transmitted as 0xFFFF, got 0x%04x", packet.Checksum)
+	}
+	if err := udp.VerifyChecksum(data, hostAIP, hostBIP); err != nil {
+		t.Errorf("0xFFFF checksum should verify: %v", err)
+	}
+}
+
+func TestUDPUnmarshalLength(t *testing.T) {
+	data, err := udp.NewPacket(1, 2, []byte("abcd")).Marshal(hostAIP, hostBIP)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	// 链路层填充的多余字节不属于载荷
+	padded := append(append([]byte{}, data...), 0, 0, 0)
+	packet := &udp.Packet{}
+	if err := packet.Unmarshal(padded); err != nil {
+		t.Fatalf("Failed to unmarshal padded datagram: %v", err)
+	}
+	if !bytes.Equal(packet.Payload, []byte("abcd")) {
+		t.Errorf("Payload should be trimmed to Length: %q", packet.Payload)
+	}
+
+	// 截断的数据报必须报错
+	if err := packet.Unmarshal(data[:len(data)-1]); !errors.Is(err, udp.ErrBadLength) {
+		t.Errorf("Expected ErrBadLength, got %v", err)
+	}
+}
+
+func TestUDPMalformedStats(t *testing.T) {
+	a, _ := link.NewPipe(hostAMAC, hostBMAC, 1500)
+	s := newStack(t, a, hostAIP)
+	ep := udp.NewEndpoint(s, 0)
+	defer ep.Close()
+
+	data, err := udp.NewPacket(1, 2, []byte("abcd")).Marshal(hostBIP, hostAIP)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	deliver := func(payload []byte) {
+		ep.HandlePacket(&stack.Packet{
+			IPHeader: ip.NewHeader(hostBIP, hostAIP, ip.ProtocolUDP, uint16(ip.IPHeaderLength+len(payload))),
+			Payload:  payload,
+		})
+	}
+
+	// 校验和错误
+	bad := append([]byte{}, data...)
+	bad[len(bad)-1] ^= 0xff
+	deliver(bad)
+
+	// 长度字段超出数据：带校验和时由VerifyChecksum发现，校验和为0时由Unmarshal发现
+	deliver(data[:len(data)-1])
+	noChecksum := append([]byte{}, data[:len(data)-1]...)
+	noChecksum[6], noChecksum[7] = 0, 0
+	deliver(noChecksum)
+
+	if st := ep.Stats(); st.ChecksumErrors != 1 || st.MalformedErrors != 2 || st.Received != 0 {
+		t.Errorf("Stats = %+v, want 1 checksum and 2 malformed errors", st)
+	}
+}